@@ -3,47 +3,117 @@ package cmd
 import (
 	"bytes"
 	"fmt"
-	"github.com/avorty/spito/cmd/cmdApi"
 	"github.com/avorty/spito/cmd/guiApi"
 	"github.com/avorty/spito/internal/checker"
 	daemontracker "github.com/avorty/spito/pkg"
+	"github.com/avorty/spito/pkg/api"
 	"github.com/avorty/spito/pkg/package_conflict"
 	"github.com/avorty/spito/pkg/path"
 	"github.com/avorty/spito/pkg/shared"
 	"github.com/avorty/spito/pkg/vrct"
-	"github.com/avorty/spito/pkg/vrct/vrctFs"
 	"github.com/godbus/dbus/v5"
 	"github.com/spf13/cobra"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
-func finalizeExecution(runtimeData shared.ImportLoopData, guiMode bool) {
-	var rulesToRevert []vrctFs.Rule
-	for _, rule := range runtimeData.RulesHistory {
-		rulesToRevert = append(rulesToRevert, vrctFs.Rule{
-			Url:          rule.Url,
-			NameOrScript: rule.NameOrScript,
-			IsScript:     rule.IsScript,
-		})
-	}
+// finalizeExecution applies changes of the rules and returns the revert number and paths of changed files
+func finalizeExecution(runtimeData *shared.ImportLoopData, guiMode bool) (int, []string) {
+	appliedRules, err := checker.ResolveAppliedConflicts(runtimeData)
+	handleError(err)
 
-	revertNum, err := runtimeData.VRCT.Apply(rulesToRevert)
-	if err != nil {
-		err = runtimeData.VRCT.Revert()
+	logPackageTransaction(*runtimeData)
+	handleError(checker.ExecutePackageTransaction(runtimeData))
+
+	revertNum, applyErr := runtimeData.VRCT.Apply(checker.GetRulesToRevert(runtimeData.RulesHistory))
+	if applyErr != nil {
+		err = checker.RevertFailedApply(runtimeData)
 		runtimeData.InfoApi.Error("unfortunately the rule couldn't be applied. Reverting changes...")
 		handleError(err)
+		printErrorAndExit(applyErr)
 	}
+	appliedRules.Register(runtimeData.RulesHistory, revertNum)
+	handleError(appliedRules.Save())
+	// Daemons are changed once the packages are installed and the units are written
+	handleError(checker.ExecuteDaemonOperations(runtimeData, revertNum))
 
 	if guiMode {
 		shared.DBusMethodP(runtimeData.DbusConn, "Success", "cannot send success message", revertNum)
-	} else {
+	} else if !isJsonOutput() {
 		revertCommand := fmt.Sprintf("spito revert %d", revertNum)
 		runtimeData.InfoApi.Log("In order to revert changes, use this command:", revertCommand)
 	}
 
+	return revertNum, runtimeData.VRCT.ChangedFiles()
+}
+
+// logPackageTransaction shows packages, repositories and keys which are going to be changed by the rules
+func logPackageTransaction(runtimeData shared.ImportLoopData) {
+	transaction := checker.GetPackageTransaction(&runtimeData)
+	if isJsonOutput() || transaction.IsEmpty() {
+		return
+	}
+
+	if len(transaction.ImportKeys) > 0 {
+		runtimeData.InfoApi.Log("Keys to import:", strings.Join(transaction.ImportKeys, " "))
+	}
+	if len(transaction.RemoveRepositories) > 0 {
+		runtimeData.InfoApi.Log("Repositories to remove:", strings.Join(transaction.RemoveRepositories, " "))
+	}
+	if len(transaction.AddRepositories) > 0 {
+		runtimeData.InfoApi.Log("Repositories to add:", strings.Join(transaction.AddRepositories, " "))
+	}
+	if len(transaction.Remove) > 0 {
+		runtimeData.InfoApi.Log("Packages to remove:", strings.Join(transaction.Remove, " "))
+	}
+	if len(transaction.Install) > 0 {
+		runtimeData.InfoApi.Log("Packages to install:", strings.Join(transaction.Install, " "))
+	}
+}
+
+// deleteRuntimeTemp is deferred by commands executing rules
+func deleteRuntimeTemp(runtimeData shared.ImportLoopData) {
+	if err := runtimeData.DeleteRuntimeTemp(); err != nil {
+		printErrorAndExit(fmt.Errorf("failed to remove temporary VRCT files, "+
+			"you should remove them manually in /tmp or reboot your device: %w", err))
+	}
+}
+
+const notApplicableMessage = "The rule is not applicable to this system, nothing has been changed"
+
+// reportRuleResult shows the result returned by the rule and returns false
+// if the rule didn't make any changes which could be applied
+func reportRuleResult(runtimeData shared.ImportLoopData, ruleResult checker.RuleResult) bool {
+	if runtimeData.GuiMode {
+		resultJson, err := ruleResult.Json()
+		handleError(err)
+		shared.DBusMethodP(runtimeData.DbusConn, "RuleResult", "cannot send rule result to gui", resultJson)
+	}
+
+	switch ruleResult.Status {
+	case checker.RuleNotApplicable:
+		runtimeData.InfoApi.Log(notApplicableMessage)
+		return false
+	case checker.RuleSkipped, checker.RuleAlreadySatisfied:
+		runtimeData.InfoApi.Log(fmt.Sprintf("Rule result: %s, nothing has been changed", ruleResult.String()))
+		return false
+	case checker.RuleFailed:
+		runtimeData.InfoApi.Error("Rule did not pass:", ruleResult.String())
+	default:
+		if ruleResult.Message != "" {
+			runtimeData.InfoApi.Log("Rule result:", ruleResult.String())
+		}
+	}
+
+	if ruleResult.Data != nil && !runtimeData.GuiMode && !isJsonOutput() {
+		resultJson, err := ruleResult.Json()
+		handleError(err)
+		runtimeData.InfoApi.Log("Rule result data:", resultJson)
+	}
+	return true
 }
 
 var checkFileCmd = &cobra.Command{
@@ -53,19 +123,20 @@ var checkFileCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		inputPath := args[0]
 
-		runtimeData := getInitialRuntimeData(cmd)
-		defer func() {
-			if err := runtimeData.DeleteRuntimeTemp(); err != nil {
-				fmt.Printf("Failed to remove temporary VRCT files"+
-					"\n You should remove them manually in /tmp or reboot your device \n%s", err.Error())
-				os.Exit(1)
+		confirmRuleExecution(cmd, func(options []string) (checker.RuleSummary, error) {
+			fileAbsolutePath, err := filepath.Abs(inputPath)
+			if err != nil {
+				return checker.RuleSummary{}, err
 			}
-		}()
+			return checker.GetScriptSummary(fileAbsolutePath, options)
+		})
+
+		runtimeData := getInitialRuntimeData(cmd)
+		defer deleteRuntimeTemp(runtimeData)
 
 		script, err := os.ReadFile(inputPath)
 		if err != nil {
-			fmt.Printf("Failed to read file %s\n", inputPath)
-			os.Exit(1)
+			printErrorAndExit(fmt.Errorf("failed to read file %s: %w", inputPath, err))
 		}
 
 		fileAbsolutePath, err := filepath.Abs(inputPath)
@@ -78,14 +149,15 @@ var checkFileCmd = &cobra.Command{
 		handleError(err)
 		panicIfEnvironment(runtimeData, &ruleConf, "file", inputPath)
 
-		doesRulePass, err := checker.CheckRuleScript(&runtimeData, string(script), filepath.Dir(fileAbsolutePath))
-		if err != nil {
-			panic(err)
-		}
+		ruleResult, err := checker.CheckRuleScript(&runtimeData, string(script), filepath.Dir(fileAbsolutePath))
+		handleError(err)
 
-		if doesRulePass {
-			finalizeExecution(runtimeData, false)
+		report := checker.NewCheckReport(fileAbsolutePath, ruleResult)
+		if reportRuleResult(runtimeData, ruleResult) && ruleResult.Status == checker.RulePassed {
+			report.Packages = checker.GetPackageTransaction(&runtimeData)
+			report.SetApplied(finalizeExecution(&runtimeData, false))
 		}
+		printResult(cmd, report)
 	},
 }
 
@@ -94,20 +166,22 @@ var checkCmd = &cobra.Command{
 	Short: "Check whether your machine pass rule",
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		runtimeData := getInitialRuntimeData(cmd)
 		identifierOrPath := args[0]
 		ruleName := args[1]
 
 		isPath, err := path.PathExists(identifierOrPath)
 		handleError(err)
 
-		defer func() {
-			if err := runtimeData.DeleteRuntimeTemp(); err != nil {
-				fmt.Printf("Failed to remove temporary VRCT files"+
-					"\n You should remove them manually in /tmp or reboot your device \n%s", err.Error())
-				os.Exit(1)
+		confirmRuleExecution(cmd, func(options []string) (checker.RuleSummary, error) {
+			rulesetLocation, err := checker.NewRulesetLocation(identifierOrPath, isPath)
+			if err != nil {
+				return checker.RuleSummary{}, err
 			}
-		}()
+			return checker.GetRuleSummary(&rulesetLocation, ruleName, options)
+		})
+
+		runtimeData := getInitialRuntimeData(cmd)
+		defer deleteRuntimeTemp(runtimeData)
 
 		rulesetLocation, err := checker.NewRulesetLocation(identifierOrPath, isPath)
 		handleError(err)
@@ -118,31 +192,36 @@ var checkCmd = &cobra.Command{
 		handleError(err)
 		panicIfEnvironment(runtimeData, &ruleConf, identifierOrPath, ruleName)
 
-		var doesRulePass bool
+		var ruleResult checker.RuleResult
 		if isPath {
-			doesRulePass, err = checker.CheckRuleByPath(&runtimeData, identifierOrPath, ruleName)
+			ruleResult, err = checker.CheckRuleByPath(&runtimeData, identifierOrPath, ruleName)
 		} else {
-			doesRulePass, err = checker.CheckRuleByIdentifier(&runtimeData, identifierOrPath, ruleName)
+			ruleResult, err = checker.CheckRuleByIdentifier(&runtimeData, identifierOrPath, ruleName)
 		}
 		handleError(err)
 
-		if runtimeData.GuiMode {
-			err := runtimeData.DbusConn.AddMatchSignal(
-				dbus.WithMatchObjectPath(shared.DBusObjectPath()),
-				dbus.WithMatchInterface(shared.DBusInterfaceId()),
-				dbus.WithMatchSender(shared.DBusInterfaceId()))
-			if err != nil {
-				panic(err)
+		report := checker.NewCheckReport(rulesetLocation.GetIdentifier()+"@"+ruleName, ruleResult)
+		// Changes of the failed rule are never applied, like in checkFileCmd
+		doesRulePass := reportRuleResult(runtimeData, ruleResult) && ruleResult.Status == checker.RulePassed
+		if !doesRulePass {
+			if runtimeData.GuiMode && ruleResult.Status == checker.RuleFailed {
+				shared.DBusMethodP(runtimeData.DbusConn, "CheckFinished", "cannot connect to gui", false)
 			}
-			shared.DBusMethodP(runtimeData.DbusConn, "CheckFinished", "cannot connect to gui", doesRulePass)
-			replyChan := make(chan *dbus.Signal)
-			runtimeData.DbusConn.Signal(replyChan)
-			reply := <-replyChan
-			if reply.Name != shared.DBusInterfaceId()+".Confirm" {
+			printResult(cmd, report)
+			return
+		}
+
+		if runtimeData.GuiMode {
+			isConfirmed := askGuiForConfirmation(runtimeData.DbusConn, func() {
+				shared.DBusMethodP(runtimeData.DbusConn, "CheckFinished", "cannot connect to gui", true)
+			})
+			if !isConfirmed {
 				os.Exit(0)
 			}
 		}
-		finalizeExecution(runtimeData, runtimeData.GuiMode)
+		report.Packages = checker.GetPackageTransaction(&runtimeData)
+		report.SetApplied(finalizeExecution(&runtimeData, runtimeData.GuiMode))
+		printResult(cmd, report)
 	},
 }
 
@@ -158,6 +237,13 @@ func getInitialRuntimeData(cmd *cobra.Command) shared.ImportLoopData {
 		options = nil
 	}
 
+	root, err := cmd.Flags().GetString("root")
+	if err != nil {
+		root = "/"
+	}
+	root, err = getRoot(root)
+	handleError(err)
+
 	var infoApi shared.InfoInterface
 	var dbusConn *dbus.Conn
 
@@ -167,17 +253,19 @@ func getInitialRuntimeData(cmd *cobra.Command) shared.ImportLoopData {
 			panic(err)
 		}
 
-		infoApi = guiApi.InfoApi{
+		infoApi = newLogger(guiApi.InfoApi{
 			BusObject: shared.DBusObject(dbusConn),
-		}
+		})
 	} else {
-		infoApi = cmdApi.InfoApi{}
+		infoApi = getInfoApi()
 	}
 
-	ruleVRCT, err := vrct.NewRuleVRCT()
+	ruleVRCT, err := vrct.NewRuleVRCTWithRoot(root)
 	if err != nil {
 		panic(err)
 	}
+	api.UseRoot(root)
+	usePrivilegedHelper()
 
 	return shared.ImportLoopData{
 		VRCT:           *ruleVRCT,
@@ -192,6 +280,23 @@ func getInitialRuntimeData(cmd *cobra.Command) shared.ImportLoopData {
 	}
 }
 
+// getRoot checks whether root directory exists and returns its absolute path
+func getRoot(root string) (string, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return "", err
+	}
+	if !rootInfo.IsDir() {
+		return "", fmt.Errorf("root %s is not a directory", root)
+	}
+	return root, nil
+}
+
 func detach(cmd *cobra.Command) {
 	isDetached, err := cmd.Flags().GetBool("detached")
 	if err != nil {
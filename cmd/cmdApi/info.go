@@ -31,12 +31,10 @@ func (_ InfoApi) Important(args ...any) {
 
 func intoPrintArray(prefix string, args []any) []any {
 	result := make([]any, len(args)+1)
+	result[0] = "[" + prefix + "]"
 
 	for i, e := range args {
-		if i == 0 {
-			result[i] = "[" + prefix + "]"
-		}
-		result[i] = fmt.Sprint(e)
+		result[i+1] = fmt.Sprint(e)
 	}
 
 	return result
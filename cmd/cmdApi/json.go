@@ -0,0 +1,60 @@
+package cmdApi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/avorty/spito/pkg/shared"
+	"os"
+)
+
+// JsonInfoApi prints every message as a single line json object, so the output can be parsed by other tools
+type JsonInfoApi struct{}
+
+type JsonMessage struct {
+	Type    string `json:"type"`
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+// JsonObject is a structured value printed by the command, e.g. its result
+type JsonObject struct {
+	Type    string `json:"type"`
+	Command string `json:"command"`
+	Value   any    `json:"value"`
+}
+
+func (_ JsonInfoApi) Log(args ...any) {
+	printJson(JsonMessage{Type: "log", Message: shared.JoinMessage(args...)})
+}
+
+func (_ JsonInfoApi) Debug(args ...any) {
+	printJson(JsonMessage{Type: "debug", Message: shared.JoinMessage(args...)})
+}
+
+func (_ JsonInfoApi) Error(args ...any) {
+	printJson(JsonMessage{Type: "error", Message: shared.JoinMessage(args...)})
+}
+
+func (_ JsonInfoApi) Warn(args ...any) {
+	printJson(JsonMessage{Type: "warn", Message: shared.JoinMessage(args...)})
+}
+
+func (_ JsonInfoApi) Important(args ...any) {
+	printJson(JsonMessage{Type: "important", Message: shared.JoinMessage(args...)})
+}
+
+// PrintEntry is used by shared.Logger, so the rule printing the message has a separate field
+func (_ JsonInfoApi) PrintEntry(entry shared.LogEntry) {
+	printJson(JsonMessage{Type: entry.Level.String(), Rule: entry.Rule, Message: entry.Message})
+}
+
+// Object prints the value of the given type, e.g. "result" or "summary", produced by the command
+func (_ JsonInfoApi) Object(objectType string, command string, value any) {
+	printJson(JsonObject{Type: objectType, Command: command, Value: value})
+}
+
+func printJson(value any) {
+	if err := json.NewEncoder(os.Stdout).Encode(value); err != nil {
+		fmt.Fprintln(os.Stderr, "cannot print json output:", err)
+	}
+}
@@ -16,28 +16,24 @@ var envFileCmd = &cobra.Command{
 		runtimeData := getInitialRuntimeData(cmd)
 		envScriptPath := args[0]
 
-		defer func() {
-			if err := runtimeData.DeleteRuntimeTemp(); err != nil {
-				fmt.Printf("Failed to remove temporary VRCT files"+
-					"\n You should remove them manually in /tmp or reboot your device \n%s", err.Error())
-				os.Exit(1)
-			}
-		}()
+		defer deleteRuntimeTemp(runtimeData)
 
 		envScriptPathAbs, err := filepath.Abs(envScriptPath)
 		if err != nil {
-			fmt.Printf("Failed to convert %s to absolute path\n", envScriptPath)
-			fmt.Println(err.Error())
-			os.Exit(1)
+			printErrorAndExit(fmt.Errorf("failed to convert %s to absolute path: %w", envScriptPath, err))
 		}
 
 		envScript, err := os.ReadFile(envScriptPathAbs)
 		handleError(err)
 
-		err = checker.ApplyEnvironmentScript(&runtimeData, string(envScript), envScriptPathAbs)
+		revertNum, err := checker.ApplyEnvironmentScript(&runtimeData, string(envScript), envScriptPathAbs)
 		handleError(err)
 
-		fmt.Printf("Successfully applied %s environment\n", envScriptPath)
+		printResult(cmd, checker.EnvironmentReport{
+			Environment:  envScriptPathAbs,
+			RevertNumber: revertNum,
+			ChangedFiles: runtimeData.VRCT.ChangedFiles(),
+		}, fmt.Sprintf("Successfully applied %s environment", envScriptPath))
 	},
 }
 
@@ -50,17 +46,15 @@ var envCmd = &cobra.Command{
 		identifierOrPath := args[0]
 		envName := args[1]
 
-		defer func() {
-			if err := runtimeData.DeleteRuntimeTemp(); err != nil {
-				fmt.Printf("Failed to remove temporary VRCT files"+
-					"\n You should remove them manually in /tmp or reboot your device \n%s", err.Error())
-				os.Exit(1)
-			}
-		}()
+		defer deleteRuntimeTemp(runtimeData)
 
-		err := checker.ApplyEnvironmentByIdentifier(&runtimeData, identifierOrPath, envName)
+		revertNum, err := checker.ApplyEnvironmentByIdentifier(&runtimeData, identifierOrPath, envName)
 		handleError(err)
 
-		fmt.Printf("Successfully applied %s environment\n", envName)
+		printResult(cmd, checker.EnvironmentReport{
+			Environment:  identifierOrPath + "@" + envName,
+			RevertNumber: revertNum,
+			ChangedFiles: runtimeData.VRCT.ChangedFiles(),
+		}, fmt.Sprintf("Successfully applied %s environment", envName))
 	},
 }
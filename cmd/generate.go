@@ -3,7 +3,6 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"github.com/avorty/spito/cmd/cmdApi"
 	"github.com/avorty/spito/pkg/path"
 	"github.com/avorty/spito/pkg/shared"
 	"github.com/spf13/cobra"
@@ -70,7 +69,7 @@ func handleGenerate(cmd *cobra.Command, args []string) {
 	_, err = configFile.Write(configFileContents)
 	handleError(err)
 
-	infoApi := cmdApi.InfoApi{}
+	infoApi := getInfoApi()
 	infoApi.Log(fmt.Sprintf("Successfully created rule '%s'", rulePath))
 }
 
@@ -1,7 +1,6 @@
 package guiApi
 
 import (
-	"fmt"
 	"github.com/avorty/spito/pkg/shared"
 	"github.com/godbus/dbus/v5"
 )
@@ -33,6 +32,6 @@ func (i InfoApi) Important(args ...any) {
 // Most of the time we ignore potential error because it is not really important
 // and our app can work even if error is thrown
 func sendToDbusMethod(busObject dbus.BusObject, logType string, values ...any) error {
-	call := busObject.Call(shared.DBusMethodName("Info"), 0, logType, fmt.Sprint(values...))
+	call := busObject.Call(shared.DBusMethodName("Info"), 0, logType, shared.JoinMessage(values...))
 	return call.Err
 }
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/privileged"
+	"github.com/avorty/spito/pkg/userinfo"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const helperIdleTimeout = 5 * time.Minute
+
+var helperCmd = &cobra.Command{
+	Use:    privileged.HelperCommand,
+	Short:  "Performs privileged operations of the rules executed by a regular user, it's started with pkexec by spito itself",
+	Args:   cobra.NoArgs,
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		// main.go switches to the regular user, the helper is the only part of spito running as root
+		userinfo.ChangeToRoot()
+
+		// The user is taken from the process which has started pkexec, neither arguments nor the environment
+		// can be trusted, because pkexec leaves them to the user
+		uid, err := userinfo.GetParentUid()
+		if err != nil {
+			printErrorAndExit(fmt.Errorf("cannot determine the user allowed to use the helper: %w", err))
+		}
+		userinfo.SetRegularUser(uid)
+		checker.UseHelperStateDirectory()
+		socketPath := privileged.DefaultSocketPath(uid)
+
+		idleTimeout, err := cmd.Flags().GetDuration("idle-timeout")
+		handleError(err)
+
+		aurHelper, err := cmd.Flags().GetString("aur-helper")
+		handleError(err)
+		if aurHelper != "" {
+			api.AurBackend.Helper = aurHelper
+		}
+		reviewCommand, err := cmd.Flags().GetString("aur-review-command")
+		handleError(err)
+		if reviewCommand != "" {
+			api.AurBackend.UseReviewCommand(reviewCommand)
+		}
+
+		listener, err := privileged.Listen(socketPath, uid)
+		handleError(err)
+		defer os.Remove(socketPath)
+
+		helper := privileged.Helper{
+			AllowedUid:         uid,
+			ResolvePermissions: checker.GetTrustedRulePermissions,
+			StagingDirectory:   privileged.StagingDirectory,
+			NewBackends: func(root string) (api.PackageManager, api.InitManager) {
+				return api.PacmanPackageManager{Root: root}, api.SystemInitManager{Root: root}
+			},
+			IdleTimeout: idleTimeout,
+			InfoApi:     getInfoApi(),
+		}
+		handleError(helper.Serve(listener))
+	},
+}
+
+var userCommandCmd = &cobra.Command{
+	Use:                api.UserCommandHelper + " {uid} {gid} {command} [arguments...]",
+	Short:              "Starts the command as the regular user, it's used by spito run with sudo to reach services of the user",
+	Args:               cobra.MinimumNArgs(3),
+	Hidden:             true,
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		uid, err := strconv.Atoi(args[0])
+		handleError(err)
+		gid, err := strconv.Atoi(args[1])
+		handleError(err)
+
+		err = api.RunUserCommand(uid, gid, args[2], args[3:]...)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		handleError(err)
+	},
+}
+
+// usePrivilegedHelper makes privileged operations of the rules performed by the helper, if spito isn't run as root
+func usePrivilegedHelper() {
+	checker.PrivilegedHelper = privileged.NewClientIfUnprivileged()
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/avorty/spito/internal/checker"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List applied changes which can be reverted",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		history, err := checker.GetHistory()
+		handleError(err)
+
+		printResult(cmd, history)
+		if isJsonOutput() {
+			return
+		}
+
+		infoApi := getInfoApi()
+		if len(history) == 0 {
+			infoApi.Log("There are no changes to revert")
+		}
+		for _, entry := range history {
+			rules := "local rule file"
+			if len(entry.Rules) > 0 {
+				rules = strings.Join(entry.Rules, ", ")
+			}
+			infoApi.Log(fmt.Sprintf("%d: %s", entry.RevertNum, rules))
+		}
+	},
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+// lintResult is printed by lint command with json output
+type lintResult struct {
+	Valid  bool               `json:"valid"`
+	Issues checker.LintIssues `json:"issues"`
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [ruleset path or rule file]",
+	Short: "Validate ruleset config and rules without executing them",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lintPath := "."
+		if len(args) == 1 {
+			lintPath = args[0]
+		}
+
+		fileInfo, err := os.Stat(lintPath)
+		handleError(err)
+
+		var issues checker.LintIssues
+		if fileInfo.IsDir() {
+			issues, err = checker.LintRuleset(lintPath)
+			handleError(err)
+		} else {
+			script, err := os.ReadFile(lintPath)
+			handleError(err)
+			issues = checker.LintScript(filepath.Base(lintPath), string(script), shared.RuleConfigLayout{})
+		}
+
+		if isJsonOutput() {
+			printResult(cmd, lintResult{Valid: !issues.HasErrors(), Issues: append(checker.LintIssues{}, issues...)})
+		} else {
+			for _, issue := range issues {
+				fmt.Println(issue.String())
+			}
+		}
+
+		if issues.HasErrors() {
+			os.Exit(1)
+		}
+		if len(issues) == 0 && !isJsonOutput() {
+			fmt.Println("No issues found")
+		}
+	},
+}
@@ -3,7 +3,6 @@ package cmd
 import (
 	"encoding/json"
 	"errors"
-	"github.com/avorty/spito/cmd/cmdApi"
 	"github.com/avorty/spito/pkg/path"
 	"github.com/avorty/spito/pkg/shared"
 	"github.com/spf13/cobra"
@@ -32,6 +31,13 @@ type LocalToken struct {
 	Path  string `json:"path"`
 }
 
+// loginResult is printed by login command with json output
+type loginResult struct {
+	LoggedIn  bool    `json:"loggedIn"`
+	Local     bool    `json:"local"`
+	ExpiresAt *string `json:"expiresAt,omitempty"`
+}
+
 type TokenStorageLayout struct {
 	GlobalToken string       `json:"globalToken"`
 	LocalKeys   []LocalToken `json:"localKeys"`
@@ -66,8 +72,7 @@ func onLoginCommand(cmd *cobra.Command, args []string) {
 	if !responseData.Valid && responseData.ExpiresAt != nil {
 		err = httpResponse.Body.Close()
 		handleError(err)
-		cmdApi.InfoApi{}.Warn("your token has expired. Please generate a new token and run this command again")
-		os.Exit(1)
+		printErrorAndExit(errors.New("your token has expired. Please generate a new token and run this command again"))
 
 	} else if !responseData.Valid {
 		err = httpResponse.Body.Close()
@@ -116,7 +121,11 @@ func onLoginCommand(cmd *cobra.Command, args []string) {
 	err = os.WriteFile(secretFilePath, bsonOutput, path.FilePermissions)
 	handleError(err)
 
-	cmdApi.InfoApi{}.Log("successfully logged into the spito store")
+	printResult(cmd, loginResult{
+		LoggedIn:  true,
+		Local:     isLoggingInLocally,
+		ExpiresAt: responseData.ExpiresAt,
+	}, "successfully logged into the spito store")
 }
 
 var loginCommand = &cobra.Command{
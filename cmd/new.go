@@ -12,7 +12,6 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/avorty/spito/cmd/cmdApi"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/spf13/cobra"
@@ -153,7 +152,7 @@ var newRulesetCommand = &cobra.Command{
 		err = os.Mkdir(rulesetName+"/"+"rules", 0700)
 		handleError(err)
 
-		infoApi := cmdApi.InfoApi{}
+		infoApi := getInfoApi()
 		infoApi.Log(fmt.Sprintf("Successfully created new ruleset '%s'", rulesetName))
 	},
 }
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/avorty/spito/cmd/cmdApi"
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+const (
+	textOutput = "text"
+	jsonOutput = "json"
+)
+
+// outputFormat is set by the global --output flag
+var outputFormat = textOutput
+
+// logLevel is set by the global --log-level, --verbose and --quiet flags
+var logLevel = shared.LevelLog
+
+// logFile is nil if it couldn't be opened
+var logFile *shared.LogFile
+
+// setupOutput is executed before every command
+func setupOutput(cmd *cobra.Command, _ []string) error {
+	if err := setOutputFormat(cmd); err != nil {
+		return err
+	}
+	if err := setLogLevel(cmd); err != nil {
+		return err
+	}
+
+	var err error
+	logFile, err = shared.OpenLogFile(shared.LogFilePath)
+	if err != nil {
+		getInfoApi().Warn("cannot open the log file:", err.Error())
+	}
+	checker.FetchInfoApi = getInfoApi()
+	api.AurBackend.InfoApi = getInfoApi()
+	return nil
+}
+
+func setOutputFormat(cmd *cobra.Command) error {
+	format, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	if format != textOutput && format != jsonOutput {
+		return fmt.Errorf("unknown output format '%s', use %s or %s", format, textOutput, jsonOutput)
+	}
+	outputFormat = format
+	return nil
+}
+
+func setLogLevel(cmd *cobra.Command) error {
+	isVerbose, err := cmd.Flags().GetBool("verbose")
+	if err != nil {
+		return err
+	}
+	isQuiet, err := cmd.Flags().GetBool("quiet")
+	if err != nil {
+		return err
+	}
+	if isVerbose && isQuiet {
+		return errors.New("--verbose and --quiet cannot be used together")
+	}
+
+	switch {
+	case cmd.Flags().Changed("log-level"):
+		levelName, err := cmd.Flags().GetString("log-level")
+		if err != nil {
+			return err
+		}
+		logLevel, err = shared.ParseLogLevel(levelName)
+		return err
+	case isVerbose:
+		logLevel = shared.LevelDebug
+	case isQuiet:
+		logLevel = shared.LevelWarn
+	}
+	return nil
+}
+
+func isJsonOutput() bool {
+	return outputFormat == jsonOutput
+}
+
+func getInfoApi() shared.InfoInterface {
+	if isJsonOutput() {
+		return newLogger(cmdApi.JsonInfoApi{})
+	}
+	return newLogger(cmdApi.InfoApi{})
+}
+
+// newLogger filters messages printed to the output using the log level and writes them to the log file
+func newLogger(output shared.InfoInterface) shared.Logger {
+	return shared.Logger{
+		Output: output,
+		Level:  logLevel,
+		File:   logFile,
+	}
+}
+
+// printObject prints the structured value of the given type in json output, otherwise it does nothing
+func printObject(cmd *cobra.Command, objectType string, value any) {
+	if isJsonOutput() {
+		commandName := strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" ")
+		cmdApi.JsonInfoApi{}.Object(objectType, commandName, value)
+	}
+}
+
+// printResult prints the result of the command as json object or logs the given messages in text output
+func printResult(cmd *cobra.Command, result any, messages ...any) {
+	if isJsonOutput() {
+		printObject(cmd, "result", result)
+		return
+	}
+	if len(messages) > 0 {
+		getInfoApi().Log(messages...)
+	}
+}
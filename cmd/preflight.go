@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/avorty/spito/cmd/cmdApi"
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/shared/option"
+	"github.com/godbus/dbus/v5"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+// confirmRuleExecution prints what the rule is going to do and asks for confirmation if the rule is unsafe
+// or requires root privileges. It is done only by the not detached process, because the detached one
+// doesn't have access to the terminal
+func confirmRuleExecution(cmd *cobra.Command, getSummary func(options []string) (checker.RuleSummary, error)) {
+	isDetached, err := cmd.Flags().GetBool("detached")
+	handleError(err)
+	if isDetached {
+		return
+	}
+
+	isExecutedByGui, err := cmd.Flags().GetBool("gui-child-mode")
+	handleError(err)
+	assumeYes, err := cmd.Flags().GetBool("yes")
+	handleError(err)
+	options, err := cmd.Flags().GetStringArray("options")
+	handleError(err)
+
+	summary, err := getSummary(options)
+	handleError(err)
+
+	isConfirmationNeeded := summary.RequiresConfirmation() && !assumeYes
+
+	if isExecutedByGui {
+		dbusConn, err := dbus.SessionBus()
+		handleError(err)
+
+		summaryJson, err := json.Marshal(summary)
+		handleError(err)
+		sendSummary := func() {
+			shared.DBusMethodP(dbusConn, "RuleSummary", "cannot send rule summary to gui", string(summaryJson), isConfirmationNeeded)
+		}
+
+		if !isConfirmationNeeded {
+			sendSummary()
+		} else if !askGuiForConfirmation(dbusConn, sendSummary) {
+			os.Exit(0)
+		}
+		return
+	}
+
+	if isJsonOutput() {
+		printObject(cmd, "summary", summary)
+		if isConfirmationNeeded {
+			printErrorAndExit(errors.New("the rule requires confirmation, which cannot be asked with json output, use --yes"))
+		}
+		return
+	}
+
+	printRuleSummary(cmdApi.InfoApi{}, summary)
+	if root, err := cmd.Flags().GetString("root"); err == nil && root != "/" {
+		cmdApi.InfoApi{}.Log("Changes will be applied to the system mounted in", root)
+	}
+	if isConfirmationNeeded {
+		fmt.Print("Do you want to continue? [y/N]: ")
+		answer := strings.ToLower(strings.TrimSpace(getStringFromStdin(bufio.NewScanner(os.Stdin))))
+		if answer != "y" && answer != "yes" {
+			cmdApi.InfoApi{}.Log("Aborted")
+			os.Exit(0)
+		}
+	}
+}
+
+func printRuleSummary(infoApi shared.InfoInterface, summary checker.RuleSummary) {
+	infoApi.Important(fmt.Sprintf("Rule '%s' from '%s' is going to be executed", summary.Name, summary.Identifier))
+
+	if summary.Description != "" {
+		infoApi.Log("Description:", summary.Description)
+	}
+	if summary.Environment {
+		infoApi.Log("It is an environment, applying it will revert currently applied one")
+	}
+	if summary.Sudo {
+		infoApi.Warn("It requires root privileges")
+	}
+	if summary.Unsafe {
+		infoApi.Warn("It is unsafe, it can execute any shell command")
+	}
+	if len(summary.SudoDependencies) > 0 {
+		infoApi.Warn("Its dependencies require root privileges:", strings.Join(summary.SudoDependencies, ", "))
+	}
+	if len(summary.UnsafeDependencies) > 0 {
+		infoApi.Warn("Its dependencies are unsafe:", strings.Join(summary.UnsafeDependencies, ", "))
+	}
+	if len(summary.Options) > 0 {
+		infoApi.Log("Options:", formatOptions(summary.Options))
+	}
+	if len(summary.Dependencies) > 0 {
+		infoApi.Log("Dependencies:", strings.Join(summary.Dependencies, ", "))
+	}
+	// Replaced rules are reverted only after the rule passes, when its changes are applied
+	for _, conflict := range summary.AppliedConflicts {
+		if conflict.IsReplacement {
+			infoApi.Warn(fmt.Sprintf("%s, it will be reverted if the rule passes", conflict.String()))
+		} else {
+			infoApi.Warn(fmt.Sprintf("%s, revert it first, otherwise changes of the rule won't be applied", conflict.String()))
+		}
+	}
+}
+
+func formatOptions(options []option.Option) string {
+	formattedOptions := make([]string, len(options))
+
+	for i, ruleOption := range options {
+		switch {
+		case ruleOption.Type == option.Struct:
+			formattedOptions[i] = fmt.Sprintf("%s={%s}", ruleOption.Name, formatOptions(ruleOption.Options))
+		case ruleOption.DefaultValue == nil:
+			formattedOptions[i] = ruleOption.Name + " (unset)"
+		default:
+			formattedOptions[i] = fmt.Sprintf("%s=%v", ruleOption.Name, ruleOption.DefaultValue)
+		}
+	}
+
+	return strings.Join(formattedOptions, ", ")
+}
+
+// askGuiForConfirmation executes ask function and returns true only if gui responds with Confirm signal
+func askGuiForConfirmation(dbusConn *dbus.Conn, ask func()) bool {
+	err := dbusConn.AddMatchSignal(
+		dbus.WithMatchObjectPath(shared.DBusObjectPath()),
+		dbus.WithMatchInterface(shared.DBusInterfaceId()),
+		dbus.WithMatchSender(shared.DBusInterfaceId()))
+	if err != nil {
+		panic(err)
+	}
+
+	replyChan := make(chan *dbus.Signal)
+	dbusConn.Signal(replyChan)
+	defer dbusConn.RemoveSignal(replyChan)
+
+	ask()
+	reply := <-replyChan
+	return reply.Name == shared.DBusInterfaceId()+".Confirm"
+}
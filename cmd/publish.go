@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
-	"github.com/avorty/spito/cmd/cmdApi"
 	"github.com/avorty/spito/internal/checker"
 	"github.com/avorty/spito/pkg/path"
 	"github.com/avorty/spito/pkg/shared"
@@ -187,7 +186,7 @@ func onPublishCommand(cmd *cobra.Command, args []string) {
 		printErrorAndExit(errors.New("error during publishing"))
 	}
 
-	cmdApi.InfoApi{}.Log("successfully published the ruleset!")
+	printResult(cmd, requestBody, "successfully published the ruleset!")
 }
 
 var publishCommand = &cobra.Command{
@@ -1,7 +1,8 @@
 package cmd
 
 import (
-	"github.com/avorty/spito/cmd/cmdApi"
+	"github.com/avorty/spito/internal/tester"
+	"github.com/avorty/spito/pkg/api"
 	"github.com/avorty/spito/pkg/shared"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
@@ -16,8 +17,7 @@ func handleError(errorToBePrinted error) {
 }
 
 func printErrorAndExit(errorToBePrinted error) {
-	var infoApi cmdApi.InfoApi
-	infoApi.Error(errorToBePrinted.Error())
+	getInfoApi().Error(errorToBePrinted.Error())
 	os.Exit(1)
 }
 
@@ -32,6 +32,8 @@ func readConfigFile(rulesetPath string, output *shared.ConfigFileLayout) {
 var rootCmd = &cobra.Command{
 	Use:   "spito",
 	Short: "spito is powerful config management system",
+	// PersistentPreRunE is executed by all subcommands
+	PersistentPreRunE: setupOutput,
 	Run: func(cmd *cobra.Command, args []string) {
 		err := cmd.Help()
 		if err != nil {
@@ -54,11 +56,26 @@ func init() {
 	envCmd.AddCommand(envFileCmd)
 
 	rootCmd.AddCommand(revertCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(helperCmd)
+	rootCmd.AddCommand(userCommandCmd)
 	rootCmd.AddCommand(newRulesetCommand)
 	rootCmd.AddCommand(generateRuleCommand)
 	rootCmd.AddCommand(generateShortCommand)
 	rootCmd.AddCommand(loginCommand)
 	rootCmd.AddCommand(publishCommand)
+	rootCmd.AddCommand(trustCmd)
+	rootCmd.AddCommand(testCmd)
+	rootCmd.AddCommand(lintCmd)
+	trustCmd.AddCommand(trustAddCmd)
+	trustCmd.AddCommand(trustListCmd)
+	trustCmd.AddCommand(trustRemoveCmd)
+
+	rootCmd.PersistentFlags().String("output", textOutput, "Output format: text or json")
+	rootCmd.PersistentFlags().String("log-level", shared.LevelLog.String(), "Minimal level of printed messages: debug, log, important, warn or error")
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Prints debug messages")
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "Prints only warnings and errors")
 
 	checkFileCmd.Flags().Bool("gui-child-mode", false, "Tells app that it is executed by gui")
 	checkCmd.Flags().Bool("gui-child-mode", false, "Tells app that it is executed by gui")
@@ -67,8 +84,22 @@ func init() {
 	checkFileCmd.Flags().Bool("detached", false, "Doesn't execute itself")
 	checkCmd.Flags().Bool("detached", false, "Doesn't execute itself")
 	revertCmd.Flags().Bool("detached", false, "Doesn't execute itself")
+	checkFileCmd.Flags().BoolP("yes", "y", false, "Doesn't ask for confirmation before executing unsafe or root rules")
+	checkCmd.Flags().BoolP("yes", "y", false, "Doesn't ask for confirmation before executing unsafe or root rules")
+	checkFileCmd.Flags().String("root", "/", "Applies changes to the system mounted in the given directory")
+	checkCmd.Flags().String("root", "/", "Applies changes to the system mounted in the given directory")
+
+	serveCmd.Flags().Bool("system", false, "Registers the service on the system bus instead of the session bus")
 
 	newRulesetCommand.Flags().BoolP("non-interactive", "y", false, "If true assume default values for spito.yaml")
 	loginCommand.Flags().BoolP("local", "l", false, "If true, save login credentials inside a spito ruleset")
 	publishCommand.Flags().BoolP("local", "l", false, "If true, get login token from a local ruleset")
+	trustAddCmd.Flags().String("ruleset", "", "Pins given ruleset to the added key")
+	trustAddCmd.Flags().String("key", "", "Fingerprint of the key to pin, required if the file contains many keys")
+	trustRemoveCmd.Flags().String("ruleset", "", "Unpins given ruleset")
+	testCmd.Flags().StringP("format", "f", string(tester.TapFormat), "Format of the report: tap or junit")
+	testCmd.Flags().String("report", "", "Writes the report to the file instead of stdout")
+	helperCmd.Flags().String("aur-helper", "", "AUR helper (yay or paru) installing AUR packages, defaults to $"+api.AurHelperEnv)
+	helperCmd.Flags().String("aur-review-command", "", "Command reviewing PKGBUILDs of AUR packages, defaults to $"+api.AurReviewCommandEnv)
+	helperCmd.Flags().Duration("idle-timeout", helperIdleTimeout, "Stops the helper when it hasn't received any request for this time, 0 means never")
 }
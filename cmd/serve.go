@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"github.com/avorty/spito/internal/service"
+	"github.com/godbus/dbus/v5"
+	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [--system]",
+	Short: "Run spito as a D-Bus service, which can be driven by the gui and other frontends",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		useSystemBus, err := cmd.Flags().GetBool("system")
+		handleError(err)
+
+		var conn *dbus.Conn
+		if useSystemBus {
+			conn, err = dbus.ConnectSystemBus()
+		} else {
+			conn, err = dbus.ConnectSessionBus()
+		}
+		handleError(err)
+		defer conn.Close()
+
+		usePrivilegedHelper()
+		_, err = service.Serve(conn, logFile)
+		handleError(err)
+		getInfoApi().Log("Serving", service.BusName, "on D-Bus")
+
+		stopSignals := make(chan os.Signal, 1)
+		signal.Notify(stopSignals, os.Interrupt, syscall.SIGTERM)
+		<-stopSignals
+	},
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/avorty/spito/internal/tester"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var testCmd = &cobra.Command{
+	Use:   "test [ruleset path]",
+	Short: "Run *_test.lua tests of the ruleset in a simulated system",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rulesetPath := "."
+		if len(args) == 1 {
+			rulesetPath = args[0]
+		}
+
+		format, err := cmd.Flags().GetString("format")
+		handleError(err)
+		reportPath, err := cmd.Flags().GetString("report")
+		handleError(err)
+
+		results, err := tester.RunRuleset(rulesetPath)
+		handleError(err)
+
+		if len(results) == 0 {
+			printErrorAndExit(errors.New("no tests were found, test files have to end with " + tester.TestFileSuffix))
+		}
+
+		// In json output the results are printed as an object, so the report is written only to the file
+		if reportPath != "" {
+			report, err := os.Create(reportPath)
+			handleError(err)
+			handleError(tester.WriteReport(report, tester.Format(format), results))
+			handleError(report.Close())
+		} else if !isJsonOutput() {
+			handleError(tester.WriteReport(os.Stdout, tester.Format(format), results))
+		}
+		printResult(cmd, results)
+
+		for _, result := range results {
+			if !result.Passed {
+				os.Exit(1)
+			}
+		}
+	},
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/pkg/trust"
+	"github.com/spf13/cobra"
+	"os"
+	"slices"
+)
+
+var trustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "Manage keys trusted to sign rulesets",
+}
+
+var trustAddCmd = &cobra.Command{
+	Use:   "add [--ruleset identifier [--key fingerprint]] {key_file}",
+	Short: "Trust ssh or openpgp public key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rulesetIdentifier, err := cmd.Flags().GetString("ruleset")
+		handleError(err)
+
+		rawKey, err := os.ReadFile(args[0])
+		handleError(err)
+
+		store, err := trust.LoadStore(trust.DefaultStorePath)
+		handleError(err)
+
+		newKeys, err := store.AddKey(rawKey)
+		handleError(err)
+
+		infoApi := getInfoApi()
+		for _, key := range newKeys {
+			infoApi.Log(fmt.Sprintf("Trusted %s key %s", key.Type, key.Fingerprint))
+		}
+
+		if rulesetIdentifier != "" {
+			fingerprint, err := cmd.Flags().GetString("key")
+			handleError(err)
+			pinnedKey, err := chooseKeyToPin(newKeys, fingerprint)
+			handleError(err)
+
+			rulesetIdentifier = checker.NormalizeIdentifier(rulesetIdentifier)
+			store.Pin(rulesetIdentifier, pinnedKey.Fingerprint)
+			infoApi.Log(fmt.Sprintf("Pinned ruleset %s to the key %s", rulesetIdentifier, pinnedKey.Fingerprint))
+		}
+
+		handleError(store.Save())
+	},
+}
+
+// chooseKeyToPin returns the key with given fingerprint, it can be omitted only if the file contains a single key
+func chooseKeyToPin(keys []trust.TrustedKey, fingerprint string) (trust.TrustedKey, error) {
+	if fingerprint == "" {
+		if len(keys) != 1 {
+			return trust.TrustedKey{}, fmt.Errorf("the file contains %d keys, choose the one to pin using --key", len(keys))
+		}
+		return keys[0], nil
+	}
+
+	keyIndex := slices.IndexFunc(keys, func(key trust.TrustedKey) bool {
+		return key.Fingerprint == fingerprint
+	})
+	if keyIndex == -1 {
+		return trust.TrustedKey{}, fmt.Errorf("the file doesn't contain the key %s", fingerprint)
+	}
+	return keys[keyIndex], nil
+}
+
+var trustListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List trusted keys and pinned rulesets",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := trust.LoadStore(trust.DefaultStorePath)
+		handleError(err)
+
+		if isJsonOutput() {
+			printResult(cmd, store)
+			return
+		}
+
+		infoApi := getInfoApi()
+		if len(store.Keys) == 0 {
+			infoApi.Log("There are no trusted keys")
+		}
+		for _, key := range store.Keys {
+			infoApi.Log(fmt.Sprintf("%s %s %s", key.Type, key.Fingerprint, key.Comment))
+		}
+
+		for rulesetIdentifier, fingerprint := range store.Pins {
+			infoApi.Log(fmt.Sprintf("%s is pinned to %s", rulesetIdentifier, fingerprint))
+		}
+	},
+}
+
+var trustRemoveCmd = &cobra.Command{
+	Use:   "remove {fingerprint} | --ruleset identifier",
+	Short: "Stop trusting the key or unpin the ruleset",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rulesetIdentifier, err := cmd.Flags().GetString("ruleset")
+		handleError(err)
+
+		if rulesetIdentifier == "" && len(args) == 0 {
+			printErrorAndExit(fmt.Errorf("provide fingerprint of the key or --ruleset flag"))
+		}
+
+		store, err := trust.LoadStore(trust.DefaultStorePath)
+		handleError(err)
+
+		infoApi := getInfoApi()
+		if rulesetIdentifier != "" {
+			rulesetIdentifier = checker.NormalizeIdentifier(rulesetIdentifier)
+			store.Unpin(rulesetIdentifier)
+			infoApi.Log(fmt.Sprintf("Unpinned ruleset %s", rulesetIdentifier))
+		}
+
+		if len(args) == 1 {
+			handleError(store.RemoveKey(args[0]))
+			infoApi.Log(fmt.Sprintf("Removed key %s", args[0]))
+		}
+
+		handleError(store.Save())
+	},
+}
@@ -1,7 +1,9 @@
 package checker
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 
 	"github.com/avorty/spito/pkg/api"
 	"github.com/avorty/spito/pkg/shared"
@@ -10,44 +12,119 @@ import (
 	luar "layeh.com/gopher-luar"
 )
 
+var ErrShellInSimulation = errors.New("shell commands cannot be executed while changes are only simulated")
+
 // Every cmdApi needs to be attached here to be available:
 func attachApi(importLoopData *shared.ImportLoopData, ruleConf *shared.RuleConfigLayout, L *lua.LState) {
 	apiNamespace := newLuaNamespace()
+	packageManager, initManager := getRuleBackends(importLoopData, ruleConf)
 
-	apiNamespace.AddField("pkg", getPackageNamespace(importLoopData, L))
+	apiNamespace.AddField("pkg", getPackageNamespace(importLoopData, packageManager, L))
 	apiNamespace.AddField("sys", getSysInfoNamespace(L))
-	apiNamespace.AddField("daemon", getDaemonApiNamespace(importLoopData, L))
+	apiNamespace.AddField("daemon", getDaemonApiNamespace(importLoopData, initManager, L))
 	apiNamespace.AddField("fs", getFsNamespace(importLoopData, L))
 	apiNamespace.AddField("info", getInfoNamespace(importLoopData, L))
 	apiNamespace.AddField("git", getGitNamespace(importLoopData, L))
+	apiNamespace.AddField("result", getResultNamespace(L))
 
-	if ruleConf.Unsafe {
+	if ruleConf.Unsafe && importLoopData.Simulated {
+		apiNamespace.AddField("sh", getSimulatedShNamespace(L))
+	} else if ruleConf.Unsafe {
 		apiNamespace.AddField("sh", getShNamespace(L))
 	}
 
 	apiNamespace.setGlobal(L, "api")
 }
 
-func getPackageNamespace(importLoopData *shared.ImportLoopData, L *lua.LState) lua.LValue {
+func getPackageNamespace(importLoopData *shared.ImportLoopData, packageManager api.PackageManager, L *lua.LState) lua.LValue {
 	pkgNamespace := newLuaNamespace()
-	pkgNamespace.AddFn("get", api.GetPackage)
+	rule := importLoopData.CurrentRule
+	pkgNamespace.AddFn("get", func(name string) (api.Package, error) {
+		return packageManager.GetPackage(name)
+	})
+	pkgNamespace.AddFn("satisfies", func(name string, constraint string) (bool, error) {
+		return api.PackageSatisfies(packageManager, name, constraint)
+	})
+	// The filter is optional, all installed packages are listed without it
+	pkgNamespace.AddFn("listInstalled", func(filter ...string) ([]api.Package, error) {
+		packageFilter, err := api.ParsePackageFilter(strings.Join(filter, ""))
+		if err != nil {
+			return nil, err
+		}
+		return packageManager.ListInstalledPackages(packageFilter)
+	})
+	pkgNamespace.AddFn("search", func(term string) ([]api.Package, error) {
+		return packageManager.SearchPackages(term)
+	})
+	pkgNamespace.AddFn("owner", func(filePath string) (api.Package, error) {
+		return packageManager.GetFileOwner(filePath)
+	})
+	pkgNamespace.AddFn("files", func(name string) ([]string, error) {
+		return packageManager.GetPackageFiles(name)
+	})
+	pkgNamespace.AddFn("isExplicit", func(name string) (bool, error) {
+		installedPackage, err := packageManager.GetPackage(name)
+		return installedPackage.IsExplicit(), err
+	})
+	pkgNamespace.AddFn("updatesAvailable", func() ([]api.Package, error) {
+		return packageManager.GetAvailableUpdates()
+	})
+	// Packages are only requested here, they are installed and removed together when the changes are applied
 	pkgNamespace.AddFn("install", func(packagesToInstall ...string) error {
+		if err := checkPackagesPermission(packageManager); err != nil {
+			return err
+		}
 		for _, packageToCheck := range packagesToInstall {
-			err := importLoopData.PackageTracker.AddPackage(packageToCheck)
+			if _, _, err := api.ParsePackageString(packageToCheck); err != nil {
+				return err
+			}
+			err := importLoopData.PackageTracker.AddPackage(packageToCheck, rule)
 			if err != nil {
 				return err
 			}
 		}
-		return api.InstallPackages(packagesToInstall...)
+		return nil
 	})
 	pkgNamespace.AddFn("remove", func(packagesToRemove ...string) error {
+		if err := checkPackagesPermission(packageManager); err != nil {
+			return err
+		}
 		for _, packageToCheck := range packagesToRemove {
-			err := importLoopData.PackageTracker.RemovePackage(packageToCheck)
+			err := importLoopData.PackageTracker.RemovePackage(packageToCheck, rule)
 			if err != nil {
 				return err
 			}
 		}
-		return api.RemovePackages(packagesToRemove...)
+		return nil
+	})
+	// Repositories and keys are changed before packages are installed, so packages from added repositories can be requested
+	pkgNamespace.AddFn("addRepository", func(name string, repository api.Repository) error {
+		if err := checkRepositoryPermission(packageManager); err != nil {
+			return err
+		}
+		repository.Name = name
+		if err := repository.Validate(); err != nil {
+			return err
+		}
+		return importLoopData.PackageTracker.AddRepository(name, repository.String(), rule)
+	})
+	pkgNamespace.AddFn("removeRepository", func(name string) error {
+		if err := checkRepositoryPermission(packageManager); err != nil {
+			return err
+		}
+		return importLoopData.PackageTracker.RemoveRepository(name, rule)
+	})
+	// The source is optional, the key is received from the default keyserver without it
+	pkgNamespace.AddFn("importKey", func(fingerprint string, source ...string) error {
+		if err := checkPackagesPermission(packageManager); err != nil {
+			return err
+		}
+		fingerprint, err := api.NormalizeKeyFingerprint(fingerprint)
+		if err != nil {
+			return err
+		}
+		importLoopData.PackageTracker.ImportKey(fingerprint, strings.Join(source, ""), rule)
+		return nil
 	})
 
 	return pkgNamespace.createTable(L)
@@ -61,22 +138,48 @@ func getSysInfoNamespace(L *lua.LState) lua.LValue {
 	sysInfoNamespace.AddFn("getInitSystem", api.GetInitSystem)
 	sysInfoNamespace.AddFn("getRandomLetters", api.GetRandomLetters)
 	sysInfoNamespace.AddFn("getEnv", api.GetEnv)
+	sysInfoNamespace.AddFn("getFacts", func() api.Facts {
+		return api.FactsBackend()
+	})
 
 	return sysInfoNamespace.createTable(L)
 }
 
-func getDaemonApiNamespace(importLoopData *shared.ImportLoopData, L *lua.LState) lua.LValue {
+func getDaemonApiNamespace(importLoopData *shared.ImportLoopData, initManager api.InitManager, L *lua.LState) lua.LValue {
 	daemonNamespace := newLuaNamespace()
 
-	daemonApi := api.DaemonApi{ImportLoopData: importLoopData}
+	daemonApi := api.DaemonApi{
+		ImportLoopData: importLoopData,
+		InitManager:    initManager,
+		Rule:           importLoopData.CurrentRule,
+	}
 
 	daemonNamespace.AddFn("start", daemonApi.StartDaemon)
 	daemonNamespace.AddFn("stop", daemonApi.StopDaemon)
 	daemonNamespace.AddFn("restart", daemonApi.RestartDaemon)
 	daemonNamespace.AddFn("enable", daemonApi.EnableDaemon)
 	daemonNamespace.AddFn("disable", daemonApi.DisableDaemon)
+	daemonNamespace.AddFn("mask", daemonApi.MaskDaemon)
+	daemonNamespace.AddFn("unmask", daemonApi.UnmaskDaemon)
 
-	daemonNamespace.AddFn("get", api.GetDaemon)
+	daemonNamespace.AddFn("get", daemonApi.GetDaemon)
+	daemonNamespace.AddFn("isFailed", daemonApi.IsDaemonFailed)
+
+	// Sections are lua tables, their order in the written files doesn't depend on the order of the table
+	daemonNamespace.AddFn("createUnit", func(unitName string, sections *lua.LTable, scope ...string) error {
+		unit, err := getUnitFile(sections)
+		if err != nil {
+			return err
+		}
+		return daemonApi.CreateUnit(unitName, unit, scope...)
+	})
+	daemonNamespace.AddFn("dropIn", func(unitName string, dropInName string, sections *lua.LTable, scope ...string) error {
+		dropIn, err := getUnitFile(sections)
+		if err != nil {
+			return err
+		}
+		return daemonApi.CreateDropIn(unitName, dropInName, dropIn, scope...)
+	})
 
 	return daemonNamespace.createTable(L)
 }
@@ -148,6 +251,21 @@ func getShNamespace(L *lua.LState) lua.LValue {
 	return shellNamespace.createTable(L)
 }
 
+// getSimulatedShNamespace is used when changes aren't applied to the real system,
+// shell commands cannot be simulated, so they always fail
+func getSimulatedShNamespace(L *lua.LState) lua.LValue {
+	shellNamespace := newLuaNamespace()
+
+	shellNamespace.AddFn("command", func(script string) (string, error) {
+		return "", ErrShellInSimulation
+	})
+	shellNamespace.AddFn("exec", func(command string) error {
+		return ErrShellInSimulation
+	})
+
+	return shellNamespace.createTable(L)
+}
+
 type LuaNamespace struct {
 	functions map[string]interface{}
 	fields    map[string]lua.LValue
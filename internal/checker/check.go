@@ -4,7 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/avorty/spito/pkg/shared"
-	"github.com/avorty/spito/pkg/userinfo"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -39,6 +39,7 @@ func (r RulesHistory) Push(url string, name string, isInProgress bool) {
 func (r RulesHistory) SetProgress(url string, name string, isInProgress bool) {
 	rule := r[url+name]
 	rule.IsInProgress = isInProgress
+	r[url+name] = rule
 }
 
 func anyToError(val any) error {
@@ -51,32 +52,49 @@ func anyToError(val any) error {
 	return fmt.Errorf("panic: %+v", val)
 }
 
-func CheckRuleByPath(importLoopData *shared.ImportLoopData, rulesetPath string, ruleName string) (bool, error) {
-	return checkAndProcessPanics(importLoopData, func(errChan chan error) (bool, error) {
+func CheckRuleByPath(importLoopData *shared.ImportLoopData, rulesetPath string, ruleName string) (RuleResult, error) {
+	return checkAndProcessPanics(importLoopData, func(errChan chan error) (RuleResult, error) {
 		return _internalCheckRule(importLoopData, rulesetPath, ruleName, nil, true), nil
 	})
 }
 
-func CheckRuleByIdentifier(importLoopData *shared.ImportLoopData, identifier string, ruleName string) (bool, error) {
-	return checkAndProcessPanics(importLoopData, func(errChan chan error) (bool, error) {
+func CheckRuleByIdentifier(importLoopData *shared.ImportLoopData, identifier string, ruleName string) (RuleResult, error) {
+	return checkAndProcessPanics(importLoopData, func(errChan chan error) (RuleResult, error) {
 		return _internalCheckRule(importLoopData, identifier, ruleName, nil, false), nil
 	})
 }
 
-func CheckRuleScript(importLoopData *shared.ImportLoopData, script string, scriptDirectory string) (bool, error) {
-	return checkAndProcessPanics(importLoopData, func(errChan chan error) (bool, error) {
+func CheckRuleScript(importLoopData *shared.ImportLoopData, script string, scriptDirectory string) (RuleResult, error) {
+	return checkAndProcessPanics(importLoopData, func(errChan chan error) (RuleResult, error) {
 		importLoopData.RulesHistory.Push(scriptDirectory, script, true, true)
+		defer useRuleContext(importLoopData, vrctFs.Rule{Url: scriptDirectory, NameOrScript: script, IsScript: true})()
 
 		// TODO: implement preprocessing instead of hard coding ruleConf
 		ruleConf := shared.RuleConfigLayout{}
-		script, err := processScript(script, &ruleConf)
+		processedScript, err := processScript(script, &ruleConf)
 		if err != nil {
-			return false, err
+			return RuleResult{}, err
 		}
 
-		L, err := GetLuaState(script, importLoopData, &ruleConf, scriptDirectory)
+		notApplicableResult, err := checkApplicability(importLoopData, &ruleConf, scriptDirectory)
 		if err != nil {
-			return false, err
+			return RuleResult{}, err
+		}
+		if notApplicableResult != nil {
+			importLoopData.RulesHistory.Remove(scriptDirectory, script)
+			return *notApplicableResult, nil
+		}
+
+		if err := registerRelations(importLoopData.RulesHistory, scriptDirectory, script, &ruleConf); err != nil {
+			return RuleResult{}, err
+		}
+		if err := usePrivileges(importLoopData, &ruleConf); err != nil {
+			return RuleResult{}, err
+		}
+
+		L, err := GetLuaState(processedScript, importLoopData, &ruleConf, scriptDirectory)
+		if err != nil {
+			return RuleResult{}, err
 		}
 		defer L.Close()
 
@@ -91,13 +109,13 @@ func handleErrorAndPanic(errChan chan error, err error) {
 	}
 }
 
-func checkAndProcessPanics(
+func checkAndProcessPanics[T any](
 	importLoopData *shared.ImportLoopData,
-	checkFunc func(errChan chan error) (bool, error),
-) (bool, error) {
+	checkFunc func(errChan chan error) (T, error),
+) (T, error) {
 
 	errChan := importLoopData.ErrChan
-	doesRulePassChan := make(chan bool)
+	doesRulePassChan := make(chan T)
 	go func() {
 		defer func() {
 			r := recover()
@@ -117,7 +135,8 @@ func checkAndProcessPanics(
 	case doesRulePass := <-doesRulePassChan:
 		return doesRulePass, nil
 	case err := <-errChan:
-		return false, err
+		var zero T
+		return zero, err
 	}
 }
 
@@ -129,7 +148,7 @@ func _internalCheckRule(
 	ruleName string,
 	previousRuleConf *shared.RuleConfigLayout,
 	isPath bool,
-) bool {
+) RuleResult {
 	rulesetLocation, err := NewRulesetLocation(identifierOrPath, isPath)
 	if err != nil {
 		importLoopData.ErrChan <- err
@@ -145,11 +164,13 @@ func _internalCheckRule(
 			errChan <- errors.New("ERROR: Dependencies creates infinity loop")
 			panic(nil)
 		} else {
-			return true
+			return RuleResult{Status: RulePassed}
 		}
 	}
 	rulesHistory.Push(identifier, ruleName, true, false)
 
+	defer useRuleContext(importLoopData, vrctFs.Rule{Url: identifier, NameOrScript: ruleName})()
+
 	lockfilePath := filepath.Join(rulesetLocation.GetRulesetPath(), shared.LockFilename)
 	_, lockfileErr := os.ReadFile(lockfilePath)
 
@@ -167,16 +188,6 @@ func _internalCheckRule(
 		panic(nil)
 	}
 
-	for _, dependencyString := range dependencies.Dependencies[ruleName] {
-		importLoopData.InfoApi.Log(fmt.Sprintf("Checking requirements for the dependency '%s'", dependencyString))
-		rulesetName, ruleName, _ := strings.Cut(dependencyString, "@")
-		doesDependencyPass := _internalCheckRule(importLoopData, rulesetName, ruleName, previousRuleConf, false)
-		if !doesDependencyPass {
-			errChan <- errors.New(fmt.Sprintf("Rule %s did not pass requirements", ruleName))
-			return false
-		}
-	}
-
 	script, err := getScript(&rulesetLocation, ruleName)
 	if err != nil {
 		errChan <- errors.New("Failed to read script called: " + ruleName + " from " + identifier + "\n" + err.Error() + "\n")
@@ -203,14 +214,38 @@ func _internalCheckRule(
 		}
 	}
 
-	isRunAsRoot, err := userinfo.IsRoot()
+	notApplicableResult, err := checkApplicability(importLoopData, &ruleConf, identifier+"@"+ruleName)
 	if err != nil {
 		errChan <- err
 		panic(nil)
 	}
+	if notApplicableResult != nil {
+		// Not applicable rule doesn't change anything, so it can't be registered as applied nor reverted
+		rulesHistory.Remove(identifier, ruleName)
+		return *notApplicableResult
+	}
+
+	if err := registerRelations(*rulesHistory, identifier, ruleName, &ruleConf); err != nil {
+		errChan <- err
+		panic(nil)
+	}
 
-	if ruleConf.Sudo && !isRunAsRoot {
-		errChan <- errors.New("tried to execute a spito rule that requires root privileges (run check with sudo -E)")
+	for _, dependencyString := range dependencies.Dependencies[ruleName] {
+		importLoopData.InfoApi.Log(fmt.Sprintf("Checking requirements for the dependency '%s'", dependencyString))
+		rulesetName, dependencyRuleName, _ := strings.Cut(dependencyString, "@")
+		dependencyResult := _internalCheckRule(importLoopData, rulesetName, dependencyRuleName, previousRuleConf, false)
+		switch dependencyResult.Status {
+		case RuleNotApplicable, RuleSkipped:
+			importLoopData.InfoApi.Log(fmt.Sprintf("Skipping the dependency '%s', %s", dependencyString, dependencyResult.String()))
+			continue
+		case RuleFailed:
+			errChan <- errors.New(fmt.Sprintf("Rule %s did not pass requirements: %s", dependencyRuleName, dependencyResult.String()))
+			return dependencyResult
+		}
+	}
+
+	if err := usePrivileges(importLoopData, &ruleConf); err != nil {
+		errChan <- err
 		panic(nil)
 	}
 
@@ -221,11 +256,49 @@ func _internalCheckRule(
 		errChan <- err
 		panic(nil)
 	}
+	defer L.Close()
 
-	doesRulePass, err := ExecuteLuaMain(L)
+	ruleResult, err := ExecuteLuaMain(L)
 	if err != nil {
 		errChan <- err
 		panic(nil)
 	}
-	return doesRulePass
+	return ruleResult
+}
+
+// useRuleContext makes the rule current and messages printed and files created until the returned function
+// is called attributed to it
+func useRuleContext(importLoopData *shared.ImportLoopData, rule vrctFs.Rule) (restore func()) {
+	previousInfoApi, previousRule := importLoopData.InfoApi, importLoopData.CurrentRule
+	previousFileRule := importLoopData.VRCT.Fs.CurrentRule()
+	importLoopData.InfoApi = shared.WithRuleContext(previousInfoApi, getRuleContext(rule.Url, rule.NameOrScript, rule.IsScript))
+	importLoopData.CurrentRule = rule
+	importLoopData.VRCT.Fs.SetCurrentRule(&rule)
+	return func() {
+		importLoopData.InfoApi, importLoopData.CurrentRule = previousInfoApi, previousRule
+		importLoopData.VRCT.Fs.SetCurrentRule(previousFileRule)
+	}
+}
+
+// getRuleContext returns "ruleset@rule" reference or the directory of the rule file
+func getRuleContext(url string, nameOrScript string, isScript bool) string {
+	if isScript {
+		return url
+	}
+	return url + "@" + nameOrScript
+}
+
+// checkApplicability compares targeting decorators of the rule with the platform,
+// it returns the result only if the rule is not applicable
+func checkApplicability(importLoopData *shared.ImportLoopData, ruleConf *shared.RuleConfigLayout, ruleDisplayName string) (*RuleResult, error) {
+	reason, err := getNotApplicableReason(ruleConf)
+	if err != nil {
+		return &RuleResult{}, err
+	}
+	if reason == "" {
+		return nil, nil
+	}
+
+	importLoopData.InfoApi.Log(fmt.Sprintf("Rule '%s' is not applicable: %s", ruleDisplayName, reason))
+	return &RuleResult{Status: RuleNotApplicable, Message: reason}, nil
 }
@@ -0,0 +1,288 @@
+package checker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/avorty/spito/pkg/path"
+	"github.com/avorty/spito/pkg/shared"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var AppliedRulesPath = filepath.Join(shared.LocalStateSpitoPath, "applied-rules.json")
+
+// AppliedRule is a rule whose changes are applied to the system and can be reverted using RevertNum
+type AppliedRule struct {
+	// Rule is normalized "ruleset@rule" reference
+	Rule      string   `json:"rule"`
+	RevertNum int      `json:"revertNumber"`
+	Conflicts []string `json:"conflicts,omitempty"`
+	Replaces  []string `json:"replaces,omitempty"`
+}
+
+type AppliedRules []AppliedRule
+
+// RuleConflict describes the rule which cannot be applied together with the other one
+type RuleConflict struct {
+	Rule      string
+	OtherRule string
+	// IsReplacement is true when one of the rules declares it replaces the other one
+	IsReplacement bool
+	// AppliedRevertNum is set if OtherRule is already applied, -1 otherwise
+	AppliedRevertNum int
+}
+
+func (c RuleConflict) String() string {
+	relation := "conflicts with"
+	if c.IsReplacement {
+		relation = "replaces"
+	}
+	if c.AppliedRevertNum == -1 {
+		return fmt.Sprintf("%s %s %s", c.Rule, relation, c.OtherRule)
+	}
+	return fmt.Sprintf("%s %s applied %s (spito revert %d)", c.Rule, relation, c.OtherRule, c.AppliedRevertNum)
+}
+
+func ReadAppliedRules() (AppliedRules, error) {
+	if err := path.CreateIfNotExists(AppliedRulesPath, "[]"); err != nil {
+		return nil, err
+	}
+
+	appliedRulesRaw, err := os.ReadFile(AppliedRulesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedRules := AppliedRules{}
+	err = json.Unmarshal(appliedRulesRaw, &appliedRules)
+	return appliedRules, err
+}
+
+func (a *AppliedRules) Save() error {
+	newContent, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(AppliedRulesPath, newContent, path.FilePermissions)
+}
+
+// Register saves rules executed in this run as applied, the same rule applied before is overwritten
+func (a *AppliedRules) Register(rulesHistory shared.RulesHistory, revertNum int) {
+	for _, rule := range rulesHistory {
+		if rule.IsScript {
+			continue
+		}
+		ruleReference := getRuleReference(rule.Url, rule.NameOrScript)
+
+		*a = slices.DeleteFunc(*a, func(appliedRule AppliedRule) bool {
+			return appliedRule.Rule == ruleReference
+		})
+		*a = append(*a, AppliedRule{
+			Rule:      ruleReference,
+			RevertNum: revertNum,
+			Conflicts: rule.Conflicts,
+			Replaces:  rule.Replaces,
+		})
+	}
+}
+
+// RemoveReverted removes rules whose changes were reverted
+func (a *AppliedRules) RemoveReverted(revertNum int) {
+	*a = slices.DeleteFunc(*a, func(appliedRule AppliedRule) bool {
+		return appliedRule.RevertNum == revertNum
+	})
+}
+
+// FindConflicts returns applied rules which conflict with rules executed in this run
+func (a *AppliedRules) FindConflicts(rulesHistory shared.RulesHistory) []RuleConflict {
+	var conflicts []RuleConflict
+
+	for _, rule := range rulesHistory {
+		ruleReference := getHistoryRuleReference(rule)
+
+		for _, appliedRule := range *a {
+			if appliedRule.Rule == ruleReference {
+				continue
+			}
+			conflict, isConflict := getRelation(
+				ruleReference, rule.Conflicts, rule.Replaces,
+				appliedRule.Rule, appliedRule.Conflicts, appliedRule.Replaces,
+			)
+			if isConflict {
+				conflict.AppliedRevertNum = appliedRule.RevertNum
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// RevertConflicts reverts changes of the applied rules which are in conflict,
+// all changes made together with the conflicting rule are reverted
+func (a *AppliedRules) RevertConflicts(infoApi shared.InfoInterface, conflicts []RuleConflict) error {
+	var revertedNums []int
+
+	for _, conflict := range conflicts {
+		if conflict.AppliedRevertNum == -1 || slices.Contains(revertedNums, conflict.AppliedRevertNum) {
+			continue
+		}
+		infoApi.Log(fmt.Sprintf("Reverting %s, because %s", conflict.OtherRule, conflict.String()))
+
+		// Packages, daemons and the state of environments are reverted too, like by spito revert
+		if _, err := RevertChanges(infoApi, conflict.AppliedRevertNum); err != nil {
+			return err
+		}
+
+		a.RemoveReverted(conflict.AppliedRevertNum)
+		revertedNums = append(revertedNums, conflict.AppliedRevertNum)
+	}
+
+	return a.Save()
+}
+
+// registerRelations saves conflicts and replaces of the rule, so rules executed later are checked against them.
+// It returns error if the rule conflicts with any rule executed in the current run
+func registerRelations(
+	rulesHistory shared.RulesHistory,
+	url string,
+	nameOrScript string,
+	ruleConf *shared.RuleConfigLayout,
+) error {
+	rulesHistory.SetRelations(
+		url, nameOrScript,
+		normalizeRuleReferences(ruleConf.Conflicts, url),
+		normalizeRuleReferences(ruleConf.Replaces, url),
+	)
+
+	conflicts := findConflictsInHistory(rulesHistory, rulesHistory[url+nameOrScript])
+	if len(conflicts) > 0 {
+		return fmt.Errorf("rules cannot be applied together: %s", FormatConflicts(conflicts))
+	}
+	return nil
+}
+
+func FormatConflicts(conflicts []RuleConflict) string {
+	formattedConflicts := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		formattedConflicts[i] = conflict.String()
+	}
+	return strings.Join(formattedConflicts, ", ")
+}
+
+// findConflictsInHistory checks if the rule conflicts with any rule executed in the current run
+func findConflictsInHistory(rulesHistory shared.RulesHistory, rule shared.Rule) []RuleConflict {
+	ruleReference := getHistoryRuleReference(rule)
+
+	var conflicts []RuleConflict
+	for _, otherRule := range rulesHistory {
+		otherReference := getHistoryRuleReference(otherRule)
+		if otherReference == ruleReference {
+			continue
+		}
+
+		conflict, isConflict := getRelation(
+			ruleReference, rule.Conflicts, rule.Replaces,
+			otherReference, otherRule.Conflicts, otherRule.Replaces,
+		)
+		if isConflict {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	return conflicts
+}
+
+// getRelation checks if the rule can be applied together with the other one,
+// declarations of both rules are considered, but only the rule replacing the other one is treated as replacement
+func getRelation(rule string, conflicts, replaces []string, otherRule string, otherConflicts, otherReplaces []string) (RuleConflict, bool) {
+	conflict := RuleConflict{Rule: rule, OtherRule: otherRule, AppliedRevertNum: -1}
+
+	switch {
+	case slices.Contains(replaces, otherRule):
+		conflict.IsReplacement = true
+	case slices.Contains(conflicts, otherRule), slices.Contains(otherReplaces, rule), slices.Contains(otherConflicts, rule):
+	default:
+		return RuleConflict{}, false
+	}
+
+	return conflict, true
+}
+
+func getRuleReference(rulesetIdentifier string, ruleName string) string {
+	return rulesetIdentifier + "@" + ruleName
+}
+
+func getHistoryRuleReference(rule shared.Rule) string {
+	if rule.IsScript {
+		return rule.Url
+	}
+	return getRuleReference(rule.Url, rule.NameOrScript)
+}
+
+// normalizeRuleReferences converts references to the form used by RulesHistory, so they can be compared,
+// rule name without ruleset points to the ruleset of the rule declaring it
+func normalizeRuleReferences(references []string, rulesetIdentifier string) []string {
+	normalizedReferences := make([]string, 0, len(references))
+
+	for _, reference := range references {
+		rulesetReference, ruleName, found := strings.Cut(reference, "@")
+		if !found {
+			normalizedReferences = append(normalizedReferences, getRuleReference(rulesetIdentifier, reference))
+			continue
+		}
+
+		if strings.HasPrefix(rulesetReference, ".") && filepath.IsAbs(rulesetIdentifier) {
+			// Relative path is resolved from the local ruleset declaring it
+			rulesetReference = filepath.Join(rulesetIdentifier, rulesetReference)
+		} else if strings.HasPrefix(rulesetReference, "/") || strings.HasPrefix(rulesetReference, ".") || strings.HasPrefix(rulesetReference, "~") {
+			if err := path.ExpandTilde(&rulesetReference); err == nil {
+				rulesetReference, _ = filepath.Abs(rulesetReference)
+			}
+		} else {
+			rulesetReference = NormalizeIdentifier(rulesetReference)
+		}
+		normalizedReferences = append(normalizedReferences, getRuleReference(rulesetReference, ruleName))
+	}
+
+	return normalizedReferences
+}
+
+// ResolveAppliedConflicts reverts applied rules replaced by rules executed in this run.
+// It returns error if any applied rule conflicts with them, such rule has to be reverted first
+func ResolveAppliedConflicts(importLoopData *shared.ImportLoopData) (AppliedRules, error) {
+	appliedRules, err := ReadAppliedRules()
+	if err != nil {
+		return nil, err
+	}
+
+	var replacements, conflicts []RuleConflict
+	for _, conflict := range appliedRules.FindConflicts(importLoopData.RulesHistory) {
+		if conflict.IsReplacement {
+			replacements = append(replacements, conflict)
+		} else {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return appliedRules, fmt.Errorf("%w: %s", ErrConflictingRules, FormatConflicts(conflicts))
+	}
+
+	return appliedRules, appliedRules.RevertConflicts(importLoopData.InfoApi, replacements)
+}
+
+var ErrConflictingRules = errors.New("rule conflicts with already applied rules, revert them first")
+
+// ForgetRevertedRules removes rules reverted by the revert number from applied rules
+func ForgetRevertedRules(revertNum int) error {
+	appliedRules, err := ReadAppliedRules()
+	if err != nil {
+		return err
+	}
+	appliedRules.RemoveReverted(revertNum)
+	return appliedRules.Save()
+}
@@ -0,0 +1,63 @@
+package checker
+
+import (
+	"fmt"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+)
+
+// RevertDaemonChanges restores active, enabled and masked state which the daemons had before the rules changed them.
+// Daemons which don't exist anymore, e.g. their packages have been removed, are skipped
+func RevertDaemonChanges(infoApi shared.InfoInterface, root string, changes []vrctFs.DaemonChange) error {
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		initManager := getRevertInitManager(change.Scope, change.Rule, root)
+
+		daemon, err := initManager.GetDaemon(change.Name)
+		if err != nil {
+			infoApi.Debug(fmt.Sprintf("Daemon %s cannot be restored: %v", change.Name, err))
+			continue
+		}
+
+		// Masked daemon has to be unmasked before it can be enabled or started, it's masked again once it's stopped
+		if daemon.IsMasked && !change.WasMasked {
+			err = initManager.UnmaskDaemon(change.Name)
+		}
+		if err == nil && daemon.IsEnabled != change.WasEnabled {
+			err = toggleDaemon(change.WasEnabled, change.Name, initManager.EnableDaemon, initManager.DisableDaemon)
+		}
+		if err == nil && daemon.IsActive != change.WasActive {
+			err = toggleDaemon(change.WasActive, change.Name, initManager.StartDaemon, initManager.StopDaemon)
+		}
+		if err == nil && !daemon.IsMasked && change.WasMasked {
+			err = initManager.MaskDaemon(change.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot restore the state of the daemon %s: %w", change.Name, err)
+		}
+	}
+	return nil
+}
+
+func toggleDaemon(turnOn bool, daemonName string, on func(string) error, off func(string) error) error {
+	if turnOn {
+		return on(daemonName)
+	}
+	return off(daemonName)
+}
+
+// getRevertInitManager returns init manager which restores the daemon or unit changed by the rule,
+// like getRevertPackageManager it uses the privileged helper for a regular user.
+// Services of the user are restored by the user's service manager, which doesn't need the helper
+func getRevertInitManager(scope string, rule vrctFs.Rule, root string) api.InitManager {
+	if scope == string(api.UserScope) {
+		return api.UserInitManagerBackend
+	}
+	if PrivilegedHelper == nil {
+		return api.InitManagerBackend
+	}
+
+	permissions, _ := GetRulePermissions(rule)
+	return newRuleBackend(rule, root, permissions)
+}
@@ -0,0 +1,453 @@
+package checker
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	decoratorStart       = "#!["
+	decoratorEnd         = "]"
+	decoratorPunctuation = "()[]{},=:;?"
+)
+
+type decoratorTokenKind uint
+
+const (
+	wordToken decoratorTokenKind = iota
+	stringToken
+	punctuationToken
+)
+
+type decoratorToken struct {
+	kind decoratorTokenKind
+	// raw is the token exactly as it is written, value has quotes and escape sequences resolved
+	raw   string
+	value string
+	// start and end are offsets of the token inside the lexed source
+	start int
+	end   int
+}
+
+func (t decoratorToken) isPunctuation(punctuation string) bool {
+	return t.kind == punctuationToken && t.value == punctuation
+}
+
+// DecoratorSyntaxError describes invalid decorator, Line and Column are counted from 1
+type DecoratorSyntaxError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *DecoratorSyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+func newDecoratorSyntaxError(source string, offset int, format string, args ...any) *DecoratorSyntaxError {
+	line, column := getPosition(source, offset)
+	return &DecoratorSyntaxError{
+		Line:    line,
+		Column:  column,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func getPosition(source string, offset int) (int, int) {
+	beforeOffset := source[:min(offset, len(source))]
+	line := strings.Count(beforeOffset, "\n") + 1
+	column := utf8.RuneCountInString(beforeOffset[strings.LastIndex(beforeOffset, "\n")+1:]) + 1
+	return line, column
+}
+
+type decoratorLexer struct {
+	source string
+	offset int
+}
+
+// next returns false as second value when the end of source is reached
+func (l *decoratorLexer) next() (decoratorToken, bool, error) {
+	if err := l.skipWhitespacesAndComments(); err != nil {
+		return decoratorToken{}, false, err
+	}
+	if l.offset >= len(l.source) {
+		return decoratorToken{}, false, nil
+	}
+
+	start := l.offset
+	char := l.source[start]
+
+	switch {
+	case strings.IndexByte(decoratorPunctuation, char) != -1:
+		l.offset++
+		return l.newToken(punctuationToken, start, string(char)), true, nil
+	case char == '"' || char == '\'':
+		value, err := l.readString(char)
+		if err != nil {
+			return decoratorToken{}, false, err
+		}
+		return l.newToken(stringToken, start, value), true, nil
+	}
+
+	for l.offset < len(l.source) && isWordChar(l.source[l.offset:]) {
+		_, size := utf8.DecodeRuneInString(l.source[l.offset:])
+		l.offset += size
+	}
+	return l.newToken(wordToken, start, l.source[start:l.offset]), true, nil
+}
+
+func (l *decoratorLexer) newToken(kind decoratorTokenKind, start int, value string) decoratorToken {
+	return decoratorToken{
+		kind:  kind,
+		raw:   l.source[start:l.offset],
+		value: value,
+		start: start,
+		end:   l.offset,
+	}
+}
+
+// isWordChar is used only after the first character of the word, so apostrophes and dashes inside not quoted
+// text are literal, e.g. #![description(Don't break foo--bar)]. A comment starts only between tokens
+func isWordChar(text string) bool {
+	char, _ := utf8.DecodeRuneInString(text)
+	return !unicode.IsSpace(char) &&
+		!strings.ContainsRune(decoratorPunctuation, char) &&
+		char != '"'
+}
+
+func (l *decoratorLexer) skipWhitespacesAndComments() error {
+	for l.offset < len(l.source) {
+		rest := l.source[l.offset:]
+		char, size := utf8.DecodeRuneInString(rest)
+
+		switch {
+		case unicode.IsSpace(char):
+			l.offset += size
+		case strings.HasPrefix(rest, "--[["):
+			commentEnd := strings.Index(rest, "]]")
+			if commentEnd == -1 {
+				return newDecoratorSyntaxError(l.source, l.offset, "unclosed comment")
+			}
+			l.offset += commentEnd + len("]]")
+		case strings.HasPrefix(rest, "--"):
+			lineEnd := strings.IndexByte(rest, '\n')
+			if lineEnd == -1 {
+				lineEnd = len(rest)
+			}
+			l.offset += lineEnd
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *decoratorLexer) readString(quote byte) (string, error) {
+	start := l.offset
+	var value strings.Builder
+	l.offset++
+
+	for l.offset < len(l.source) {
+		char := l.source[l.offset]
+		switch char {
+		case quote:
+			l.offset++
+			return value.String(), nil
+		case '\n':
+			return "", newDecoratorSyntaxError(l.source, start, "unclosed string")
+		case '\\':
+			if l.offset+1 >= len(l.source) {
+				return "", newDecoratorSyntaxError(l.source, start, "unclosed string")
+			}
+			escaped, ok := escapeSequences[l.source[l.offset+1]]
+			if !ok {
+				return "", newDecoratorSyntaxError(l.source, l.offset, "unknown escape sequence: \\%c", l.source[l.offset+1])
+			}
+			value.WriteByte(escaped)
+			l.offset += 2
+		default:
+			value.WriteByte(char)
+			l.offset++
+		}
+	}
+	return "", newDecoratorSyntaxError(l.source, start, "unclosed string")
+}
+
+var escapeSequences = map[byte]byte{
+	'"':  '"',
+	'\'': '\'',
+	'\\': '\\',
+	'n':  '\n',
+	't':  '\t',
+}
+
+var closingBrackets = map[string]string{"(": ")", "[": "]", "{": "}"}
+
+// parseDecorator parses decorator which starts at the given offset of the script,
+// returns offset right after the end of the decorator
+func parseDecorator(script string, start int) (RawDecorator, int, error) {
+	lexer := decoratorLexer{source: script, offset: start + len(decoratorStart)}
+	unclosedErr := newDecoratorSyntaxError(script, start, "unclosed decorator, missing '%s'", decoratorEnd)
+
+	nameToken, ok, err := lexer.next()
+	if err != nil {
+		return RawDecorator{}, 0, err
+	}
+	if !ok {
+		return RawDecorator{}, 0, unclosedErr
+	}
+	if nameToken.kind != wordToken {
+		return RawDecorator{}, 0, newDecoratorSyntaxError(script, nameToken.start, "expected decorator name, found '%s'", nameToken.raw)
+	}
+
+	decoratorType, err := GetDecoratorType(nameToken.value)
+	if err != nil {
+		return RawDecorator{}, 0, newDecoratorSyntaxError(script, nameToken.start, "%s", err.Error())
+	}
+
+	line, column := getPosition(script, start)
+	decorator := RawDecorator{
+		Type:   decoratorType,
+		Line:   line,
+		Column: column,
+	}
+
+	token, ok, err := lexer.next()
+	if err != nil {
+		return RawDecorator{}, 0, err
+	}
+	if !ok {
+		return RawDecorator{}, 0, unclosedErr
+	}
+
+	if token.isPunctuation("(") {
+		contentStart := token.end
+		var expectedClosings []string
+
+		for {
+			token, ok, err = lexer.next()
+			if err != nil {
+				return RawDecorator{}, 0, err
+			}
+			if !ok {
+				return RawDecorator{}, 0, unclosedErr
+			}
+			if token.kind != punctuationToken {
+				decorator.tokens = append(decorator.tokens, token)
+				continue
+			}
+
+			if closing, isOpening := closingBrackets[token.value]; isOpening {
+				expectedClosings = append(expectedClosings, closing)
+			} else if strings.Contains(")]}", token.value) {
+				if len(expectedClosings) == 0 && token.value == ")" {
+					break
+				}
+				if len(expectedClosings) == 0 {
+					return RawDecorator{}, 0, newDecoratorSyntaxError(script, token.start, "unexpected '%s'", token.value)
+				}
+				if expected := expectedClosings[len(expectedClosings)-1]; expected != token.value {
+					return RawDecorator{}, 0, newDecoratorSyntaxError(script, token.start, "expected '%s', found '%s'", expected, token.value)
+				}
+				expectedClosings = expectedClosings[:len(expectedClosings)-1]
+			}
+			decorator.tokens = append(decorator.tokens, token)
+		}
+
+		decorator.Content = strings.TrimSpace(script[contentStart:token.start])
+		decorator.source = script
+		decorator.contentEnd = token.start
+
+		token, ok, err = lexer.next()
+		if err != nil {
+			return RawDecorator{}, 0, err
+		}
+		if !ok {
+			return RawDecorator{}, 0, unclosedErr
+		}
+	}
+
+	if !token.isPunctuation(decoratorEnd) {
+		return RawDecorator{}, 0, newDecoratorSyntaxError(script, token.start, "expected '%s', found '%s'", decoratorEnd, token.raw)
+	}
+
+	return decorator, token.end, nil
+}
+
+type DecoratorValue struct {
+	Text   string
+	IsList bool
+	List   []DecoratorValue
+}
+
+type DecoratorArguments struct {
+	Positional []DecoratorValue
+	Named      map[string]DecoratorValue
+}
+
+type argumentsParser struct {
+	source string
+	tokens []decoratorToken
+	// endOffset is used for errors reported at the end of arguments
+	endOffset int
+	position  int
+}
+
+func parseDecoratorArguments(source string, tokens []decoratorToken, endOffset int) (DecoratorArguments, error) {
+	parser := argumentsParser{
+		source:    source,
+		tokens:    tokens,
+		endOffset: endOffset,
+	}
+	return parser.parseArguments()
+}
+
+func (p *argumentsParser) peek(distance int) (decoratorToken, bool) {
+	if p.position+distance >= len(p.tokens) {
+		return decoratorToken{}, false
+	}
+	return p.tokens[p.position+distance], true
+}
+
+func (p *argumentsParser) unexpectedToken(token decoratorToken, ok bool, expected string) error {
+	if !ok {
+		return newDecoratorSyntaxError(p.source, p.endOffset, "expected %s, found end of arguments", expected)
+	}
+	return newDecoratorSyntaxError(p.source, token.start, "expected %s, found '%s'", expected, token.raw)
+}
+
+func (p *argumentsParser) parseArguments() (DecoratorArguments, error) {
+	arguments := DecoratorArguments{Named: make(map[string]DecoratorValue)}
+
+	for p.position < len(p.tokens) {
+		nameToken, _ := p.peek(0)
+		equalToken, hasEqual := p.peek(1)
+
+		if nameToken.kind == wordToken && hasEqual && equalToken.isPunctuation("=") {
+			p.position += 2
+			value, err := p.parseValue()
+			if err != nil {
+				return arguments, err
+			}
+			if _, exists := arguments.Named[nameToken.value]; exists {
+				return arguments, newDecoratorSyntaxError(p.source, nameToken.start, "argument '%s' is specified more than once", nameToken.value)
+			}
+			arguments.Named[nameToken.value] = value
+		} else {
+			value, err := p.parseValue()
+			if err != nil {
+				return arguments, err
+			}
+			arguments.Positional = append(arguments.Positional, value)
+		}
+
+		token, ok := p.peek(0)
+		if !ok {
+			break
+		}
+		if !token.isPunctuation(",") {
+			return arguments, p.unexpectedToken(token, ok, "','")
+		}
+		p.position++
+	}
+
+	return arguments, nil
+}
+
+func (p *argumentsParser) parseValue() (DecoratorValue, error) {
+	token, ok := p.peek(0)
+
+	switch {
+	case ok && token.kind == stringToken:
+		p.position++
+		return DecoratorValue{Text: token.value}, nil
+	case ok && token.kind == wordToken:
+		// Not quoted text is allowed to contain spaces, e.g. #![description(Installs neovim)]
+		lastToken := token
+		for nextToken, ok := p.peek(0); ok && nextToken.kind == wordToken; nextToken, ok = p.peek(0) {
+			lastToken = nextToken
+			p.position++
+		}
+		return DecoratorValue{Text: p.source[token.start:lastToken.end]}, nil
+	case ok && token.isPunctuation("["):
+		p.position++
+		return p.parseList()
+	}
+
+	return DecoratorValue{}, p.unexpectedToken(token, ok, "value")
+}
+
+func (p *argumentsParser) parseList() (DecoratorValue, error) {
+	list := DecoratorValue{IsList: true}
+
+	for {
+		if token, ok := p.peek(0); ok && token.isPunctuation("]") {
+			p.position++
+			return list, nil
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return list, err
+		}
+		list.List = append(list.List, value)
+
+		token, ok := p.peek(0)
+		if ok && token.isPunctuation(",") {
+			p.position++
+			continue
+		}
+		if !ok || !token.isPunctuation("]") {
+			return list, p.unexpectedToken(token, ok, "',' or ']'")
+		}
+	}
+}
+
+// appendDecoratorValues reads values of decorator which takes a list, e.g. #![distro(arch, manjaro)] or #![arch([x86_64, aarch64])]
+func appendDecoratorValues(targets []string, decorator RawDecorator) ([]string, error) {
+	arguments, err := decorator.Arguments()
+	if err != nil {
+		return targets, err
+	}
+	if len(arguments.Named) > 0 {
+		return targets, fmt.Errorf("%d:%d: decorator doesn't accept named arguments", decorator.Line, decorator.Column)
+	}
+
+	newTargets := flattenDecoratorValues(arguments.Positional)
+	if len(newTargets) == 0 {
+		return targets, fmt.Errorf("%d:%d: decorator requires at least one value", decorator.Line, decorator.Column)
+	}
+
+	return append(targets, newTargets...), nil
+}
+
+func flattenDecoratorValues(values []DecoratorValue) []string {
+	var result []string
+	for _, value := range values {
+		if value.IsList {
+			result = append(result, flattenDecoratorValues(value.List)...)
+			continue
+		}
+		result = append(result, value.Text)
+	}
+	return result
+}
+
+// tokenize splits the whole source into tokens
+func tokenize(source string) ([]decoratorToken, error) {
+	lexer := decoratorLexer{source: source}
+	var tokens []decoratorToken
+
+	for {
+		token, ok, err := lexer.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return tokens, nil
+		}
+		tokens = append(tokens, token)
+	}
+}
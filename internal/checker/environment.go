@@ -3,6 +3,7 @@ package checker
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/avorty/spito/pkg/path"
 	"github.com/avorty/spito/pkg/shared"
 	"github.com/avorty/spito/pkg/vrct/vrctFs"
@@ -12,6 +13,7 @@ import (
 
 var EnvironmentDataPath = filepath.Join(shared.LocalStateSpitoPath, "environment-data.json")
 var NotEnvironmentErr = errors.New("called rule is not an environment")
+var NotApplicableEnvironmentErr = errors.New("environment is not applicable to this system, cannot apply")
 
 type AppliedEnvironments []*AppliedEnvironment
 type AppliedEnvironment struct {
@@ -53,7 +55,7 @@ func (e *AppliedEnvironments) SetAsApplied(envIdentifierOrPath string, revertNum
 		}
 		if env.IdentifierOrPath == envIdentifierOrPath {
 			foundEnv = true
-			env.IsApplied = false
+			env.IsApplied = true
 			env.RevertNum = revertNum
 		}
 	}
@@ -68,22 +70,31 @@ func (e *AppliedEnvironments) SetAsApplied(envIdentifierOrPath string, revertNum
 	})
 }
 
+// SetReverted marks the environment applied together with the revert number as not applied anymore
+func (e *AppliedEnvironments) SetReverted(revertNum int) {
+	for _, env := range *e {
+		if env.RevertNum == revertNum {
+			env.IsApplied = false
+		}
+	}
+}
+
+// forgetRevertedEnvironment updates the state of environments after the revert number was reverted
+func forgetRevertedEnvironment(revertNum int) error {
+	appliedEnvironments, err := ReadAppliedEnvironments()
+	if err != nil {
+		return err
+	}
+	appliedEnvironments.SetReverted(revertNum)
+	return appliedEnvironments.Save()
+}
+
 func (e *AppliedEnvironments) RevertOther(importLoopData *shared.ImportLoopData, envIdentifierOrPath string) error {
 	for _, env := range *e {
 		if env.IdentifierOrPath == envIdentifierOrPath || !env.IsApplied {
 			continue
 		}
-		revertSteps, err := vrctFs.NewRevertSteps()
-		if err != nil {
-			return err
-		}
-
-		if err := revertSteps.Deserialize(env.RevertNum); err != nil {
-			return err
-		}
-
-		err = revertSteps.Apply(GetRevertRuleFn(importLoopData.InfoApi))
-		if err != nil {
+		if _, err := RevertChanges(importLoopData.InfoApi, env.RevertNum); err != nil {
 			return err
 		}
 
@@ -93,92 +104,103 @@ func (e *AppliedEnvironments) RevertOther(importLoopData *shared.ImportLoopData,
 	return nil
 }
 
-func ApplyEnvironmentByIdentifier(importLoopData *shared.ImportLoopData, identifierOrPath string, envName string) error {
-	doesEnvPass, err := checkAndProcessPanics(importLoopData, func(errChan chan error) (bool, error) {
+// ApplyEnvironmentByIdentifier applies the environment and returns the number which can be used to revert it
+func ApplyEnvironmentByIdentifier(importLoopData *shared.ImportLoopData, identifierOrPath string, envName string) (int, error) {
+	envResult, err := checkAndProcessPanics(importLoopData, func(errChan chan error) (RuleResult, error) {
 		rulesetLocation, err := NewRulesetLocation(identifierOrPath, false)
 		if err != nil {
-			return false, err
+			return RuleResult{}, err
 		}
 		rulesetConfiguration, err := GetRulesetConf(&rulesetLocation)
 		if err != nil {
-			return false, err
+			return RuleResult{}, err
 		}
 
 		ruleConf, err := rulesetConfiguration.GetRuleConf(envName)
 		if err != nil {
-			return false, err
+			return RuleResult{}, err
 		}
 
 		if !ruleConf.Environment {
-			return false, NotEnvironmentErr
+			return RuleResult{}, NotEnvironmentErr
 		}
 		return _internalCheckRule(importLoopData, identifierOrPath, envName, nil, false), nil
 	})
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if envResult.Status == RuleNotApplicable {
+		return 0, NotApplicableEnvironmentErr
 	}
-	if !doesEnvPass {
-		return errors.New("environment didn't passed, cannot apply")
+	if envResult.Status != RulePassed && envResult.Status != RuleAlreadySatisfied {
+		return 0, fmt.Errorf("environment didn't passed, cannot apply (%s)", envResult)
 	}
 
 	return applyEnvironment(importLoopData, identifierOrPath)
 }
 
-func ApplyEnvironmentScript(importLoopData *shared.ImportLoopData, script string, scriptPath string) error {
-	doesEnvPass, err := checkAndProcessPanics(importLoopData, func(errChan chan error) (bool, error) {
+// ApplyEnvironmentScript applies the environment and returns the number which can be used to revert it
+func ApplyEnvironmentScript(importLoopData *shared.ImportLoopData, script string, scriptPath string) (int, error) {
+	envResult, err := checkAndProcessPanics(importLoopData, func(errChan chan error) (RuleResult, error) {
 		importLoopData.RulesHistory.Push(scriptPath, script, true, true)
+		defer useRuleContext(importLoopData, vrctFs.Rule{Url: scriptPath, NameOrScript: script, IsScript: true})()
 
 		ruleConf := shared.RuleConfigLayout{}
-		var err error
-		script, err = processScript(script, &ruleConf)
+		processedScript, err := processScript(script, &ruleConf)
 		if err != nil {
-			return false, err
+			return RuleResult{}, err
 		}
 		if !ruleConf.Environment {
-			return false, NotEnvironmentErr
+			return RuleResult{}, NotEnvironmentErr
+		}
+
+		notApplicableResult, err := checkApplicability(importLoopData, &ruleConf, scriptPath)
+		if err != nil || notApplicableResult != nil {
+			return *notApplicableResult, err
+		}
+
+		if err := registerRelations(importLoopData.RulesHistory, scriptPath, script, &ruleConf); err != nil {
+			return RuleResult{}, err
+		}
+		if err := usePrivileges(importLoopData, &ruleConf); err != nil {
+			return RuleResult{}, err
 		}
 
-		L, err := GetLuaState(script, importLoopData, &ruleConf, filepath.Dir(scriptPath))
+		L, err := GetLuaState(processedScript, importLoopData, &ruleConf, filepath.Dir(scriptPath))
 		if err != nil {
-			return false, err
+			return RuleResult{}, err
 		}
 
 		return ExecuteLuaMain(L)
 	})
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if envResult.Status == RuleNotApplicable {
+		return 0, NotApplicableEnvironmentErr
 	}
-	if !doesEnvPass {
-		return errors.New("the environment has not passed, cannot apply")
+	if envResult.Status != RulePassed && envResult.Status != RuleAlreadySatisfied {
+		return 0, fmt.Errorf("the environment has not passed, cannot apply (%s)", envResult)
 	}
 
 	return applyEnvironment(importLoopData, scriptPath)
 }
 
-func applyEnvironment(importLoopData *shared.ImportLoopData, identifierOrPath string) error {
+func applyEnvironment(importLoopData *shared.ImportLoopData, identifierOrPath string) (int, error) {
 	appliedEnvironments, err := ReadAppliedEnvironments()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if err := appliedEnvironments.RevertOther(importLoopData, identifierOrPath); err != nil {
-		return err
+		return 0, err
 	}
 
-	var rulesHistory []vrctFs.Rule
-	for _, rule := range importLoopData.RulesHistory {
-		rulesHistory = append(rulesHistory, vrctFs.Rule{
-			Url:          rule.Url,
-			NameOrScript: rule.NameOrScript,
-			IsScript:     rule.IsScript,
-		})
-	}
-
-	revertNum, err := importLoopData.VRCT.Apply(rulesHistory)
+	revertNum, err := ApplyChanges(importLoopData)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	appliedEnvironments.SetAsApplied(identifierOrPath, revertNum)
-	return appliedEnvironments.Save()
+	return revertNum, appliedEnvironments.Save()
 }
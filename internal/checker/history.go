@@ -0,0 +1,43 @@
+package checker
+
+import (
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"slices"
+)
+
+// HistoryEntry describes changes applied together, they can be reverted using RevertNum
+type HistoryEntry struct {
+	RevertNum int `json:"revertNumber"`
+	// Rules are empty if changes were made by local rule files
+	Rules []string `json:"rules"`
+}
+
+// GetHistory returns changes which can be reverted, sorted from the oldest one
+func GetHistory() ([]HistoryEntry, error) {
+	revertNums, err := vrctFs.GetRevertNums()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(revertNums)
+
+	appliedRules, err := ReadAppliedRules()
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]HistoryEntry, len(revertNums))
+	for i, revertNum := range revertNums {
+		history[i] = HistoryEntry{
+			RevertNum: revertNum,
+			Rules:     []string{},
+		}
+		for _, appliedRule := range appliedRules {
+			if appliedRule.RevertNum == revertNum {
+				history[i].Rules = append(history[i].Rules, appliedRule.Rule)
+			}
+		}
+		slices.Sort(history[i].Rules)
+	}
+
+	return history, nil
+}
@@ -39,17 +39,17 @@ func GetLuaState(script string, importLoopData *shared.ImportLoopData, ruleConf
 	return L, L.DoString(script)
 }
 
-func ExecuteLuaMain(L *lua.LState) (bool, error) {
+func ExecuteLuaMain(L *lua.LState) (RuleResult, error) {
 	err := L.CallByParam(lua.P{
 		Fn:      L.GetGlobal("main"),
 		Protect: true,
-		NRet:    1,
+		NRet:    2,
 	})
 	if err != nil {
-		return false, err
+		return RuleResult{}, err
 	}
 
-	return bool(L.Get(-1).(lua.LBool)), nil
+	return getRuleResult(L.Get(-2), L.Get(-1))
 }
 
 func ExecuteLuaRevert(L *lua.LState) (bool, error) {
@@ -61,13 +61,14 @@ func ExecuteLuaRevert(L *lua.LState) (bool, error) {
 	err := L.CallByParam(lua.P{
 		Fn:      L.GetGlobal("revert"),
 		Protect: true,
-		NRet:    1,
+		NRet:    2,
 	})
 	if err != nil {
 		return false, err
 	}
 
-	return bool(L.Get(-1).(lua.LBool)), nil
+	result, err := getRuleResult(L.Get(-2), L.Get(-1))
+	return result.Status.IsSuccessful(), err
 }
 
 func getOptions(options []option.Option, L *lua.LState) (lua.LValue, error) {
@@ -152,7 +153,7 @@ func attachRuleRequiring(importLoopData *shared.ImportLoopData, L *lua.LState) {
 		rulesetIdentifier := L.Get(1).String()
 		ruleName := L.Get(2).String()
 
-		doesRulePass, err := CheckRuleByIdentifier(importLoopData, rulesetIdentifier, ruleName)
+		ruleResult, err := CheckRuleByIdentifier(importLoopData, rulesetIdentifier, ruleName)
 		handleErrorAndPanic(importLoopData.ErrChan, err)
 
 		rulesetLocation, err := NewRulesetLocation(rulesetIdentifier, false)
@@ -163,8 +164,8 @@ func attachRuleRequiring(importLoopData *shared.ImportLoopData, L *lua.LState) {
 			panic(nil)
 		}
 
-		if !doesRulePass {
-			importLoopData.ErrChan <- fmt.Errorf("rule %s/%s did not pass requirements", rulesetIdentifier, ruleName)
+		if ruleResult.Status != RulePassed && ruleResult.Status != RuleAlreadySatisfied {
+			importLoopData.ErrChan <- fmt.Errorf("rule %s/%s did not pass requirements (%s)", rulesetIdentifier, ruleName, ruleResult)
 			panic(nil)
 		}
 		return 0
@@ -187,11 +188,11 @@ func attachRuleRequiring(importLoopData *shared.ImportLoopData, L *lua.LState) {
 			panic(nil)
 		}
 
-		doesRulePass, err := CheckRuleScript(importLoopData, string(script), filepath.Dir(rulePath))
+		ruleResult, err := CheckRuleScript(importLoopData, string(script), filepath.Dir(rulePath))
 		handleErrorAndPanic(importLoopData.ErrChan, err)
 
-		if !doesRulePass {
-			importLoopData.ErrChan <- fmt.Errorf("rule from %s did not pass requirements", rulePath)
+		if ruleResult.Status != RulePassed && ruleResult.Status != RuleAlreadySatisfied {
+			importLoopData.ErrChan <- fmt.Errorf("rule from %s did not pass requirements (%s)", rulePath, ruleResult)
 			panic(nil)
 		}
 
@@ -0,0 +1,473 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/shared/option"
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type LintSeverity string
+
+const (
+	LintError   LintSeverity = "error"
+	LintWarning LintSeverity = "warning"
+)
+
+const (
+	apiNamespaceName = "api"
+	mainFunctionName = "main"
+	rulesDirName     = "rules"
+)
+
+type LintIssue struct {
+	File     string       `json:"file"`
+	Line     int          `json:"line,omitempty"`
+	Severity LintSeverity `json:"severity"`
+	Message  string       `json:"message"`
+}
+
+func (i LintIssue) String() string {
+	position := i.File
+	if i.Line > 0 {
+		position = fmt.Sprintf("%s:%d", i.File, i.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s", position, i.Severity, i.Message)
+}
+
+type LintIssues []LintIssue
+
+func (issues LintIssues) HasErrors() bool {
+	return slices.ContainsFunc(issues, func(issue LintIssue) bool {
+		return issue.Severity == LintError
+	})
+}
+
+func (issues *LintIssues) add(file string, line int, severity LintSeverity, format string, args ...any) {
+	*issues = append(*issues, LintIssue{
+		File:     file,
+		Line:     line,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// LintRuleset validates spito.yml and every rule of the ruleset without executing anything
+func LintRuleset(rulesetPath string) (LintIssues, error) {
+	rulesetPath, err := filepath.Abs(rulesetPath)
+	if err != nil {
+		return nil, err
+	}
+	rulesetLocation, err := NewRulesetLocation(rulesetPath, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues LintIssues
+
+	rawConfig, err := ReadSpitoYaml(&rulesetLocation)
+	if err != nil {
+		issues.add(shared.ConfigFilename, 0, LintError, "cannot read config file: %s", err.Error())
+		return issues, nil
+	}
+
+	var rulesetConf shared.ConfigFileLayout
+	if err := yaml.Unmarshal(rawConfig, &rulesetConf); err != nil {
+		issues.add(shared.ConfigFilename, 0, LintError, "invalid config file: %s", err.Error())
+		return issues, nil
+	}
+
+	ruleNames := make([]string, 0, len(rulesetConf.Rules))
+	for ruleName := range rulesetConf.Rules {
+		ruleNames = append(ruleNames, ruleName)
+	}
+	slices.Sort(ruleNames)
+
+	registeredScripts := make(map[string]bool)
+	for _, ruleName := range ruleNames {
+		ruleConf := rulesetConf.Rules[ruleName]
+		if ruleConf.Path == "" {
+			issues.add(shared.ConfigFilename, 0, LintError, "rule '%s' doesn't have path", ruleName)
+			continue
+		}
+
+		for _, permission := range ruleConf.Permissions {
+			if _, err := shared.ParsePermission(string(permission)); err != nil {
+				issues.add(shared.ConfigFilename, 0, LintError, "rule '%s' has invalid permissions: %s", ruleName, err.Error())
+			}
+		}
+
+		scriptPath := filepath.Join(rulesetPath, ruleConf.Path)
+		registeredScripts[scriptPath] = true
+
+		script, err := os.ReadFile(scriptPath)
+		if err != nil {
+			issues.add(shared.ConfigFilename, 0, LintError, "cannot read rule '%s' from %s: %s", ruleName, ruleConf.Path, err.Error())
+			continue
+		}
+
+		relativePath, _ := filepath.Rel(rulesetPath, scriptPath)
+		issues = append(issues, LintScript(relativePath, string(script), ruleConf)...)
+	}
+
+	issues = append(issues, lintDependencies(&rulesetConf, rulesetLocation.GetIdentifier())...)
+	issues = append(issues, lintUnregisteredScripts(rulesetPath, registeredScripts)...)
+
+	return issues, nil
+}
+
+// LintScript validates decorators, options and lua code of the single rule. ruleConf holds settings from spito.yml
+func LintScript(file string, script string, ruleConf shared.RuleConfigLayout) LintIssues {
+	var issues LintIssues
+
+	luaScript, decorators, err := GetDecorators(script)
+	if err != nil {
+		var syntaxErr *DecoratorSyntaxError
+		if errors.As(err, &syntaxErr) {
+			issues.add(file, syntaxErr.Line, LintError, "invalid decorator: %s", syntaxErr.Message)
+		} else {
+			issues.add(file, 0, LintError, "invalid decorator: %s", err.Error())
+		}
+		return issues
+	}
+
+	for _, decorator := range decorators {
+		switch decorator.Type {
+		case UnsafeDecorator:
+			ruleConf.Unsafe = true
+		case OptionsDecorator:
+			if _, err := option.ParseOptions(decorator.CompactContent()); err != nil {
+				issues.add(file, decorator.Line, LintError, "invalid options: %s", err.Error())
+			}
+		case DescriptionDecorator:
+			if _, err := decorator.Arguments(); err != nil {
+				issues.add(file, decorator.Line, LintError, "invalid description: %s", err.Error())
+			}
+		case DistroDecorator, InitDecorator, ArchDecorator, ConflictsDecorator, ReplacesDecorator:
+			if _, err := appendDecoratorValues(nil, decorator); err != nil {
+				issues.add(file, decorator.Line, LintError, "invalid decorator: %s", err.Error())
+			}
+		case PermissionsDecorator:
+			if _, err := appendPermissions(nil, decorator); err != nil {
+				issues.add(file, decorator.Line, LintError, "invalid permissions: %s", err.Error())
+			}
+		}
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(luaScript), file)
+	if err != nil {
+		line := 0
+		message := err.Error()
+		if parseErr, ok := err.(*parse.Error); ok {
+			line = parseErr.Pos.Line
+			message = parseErr.Message
+		}
+		issues.add(file, line, LintError, "syntax error: %s", message)
+		return issues
+	}
+
+	if _, err := lua.Compile(chunk, file); err != nil {
+		issues.add(file, 0, LintError, "cannot compile: %s", err.Error())
+	}
+
+	if !definesGlobalFunction(chunk, mainFunctionName) {
+		issues.add(file, 0, LintError, "rule doesn't define '%s' function", mainFunctionName)
+	}
+
+	issues = append(issues, lintApiUsage(file, chunk, ruleConf.Unsafe)...)
+	return issues
+}
+
+func definesGlobalFunction(chunk []ast.Stmt, name string) bool {
+	for _, stmt := range chunk {
+		switch stmt := stmt.(type) {
+		case *ast.FuncDefStmt:
+			if ident, ok := stmt.Name.Func.(*ast.IdentExpr); ok && ident.Value == name && stmt.Name.Receiver == nil {
+				return true
+			}
+		case *ast.AssignStmt:
+			for _, lhs := range stmt.Lhs {
+				if ident, ok := lhs.(*ast.IdentExpr); ok && ident.Value == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func lintApiUsage(file string, chunk []ast.Stmt, isUnsafe bool) LintIssues {
+	var issues LintIssues
+	availableApi := getAvailableApi()
+
+	walkStmts(chunk, func(expr ast.Expr) {
+		attrGet, ok := expr.(*ast.AttrGetExpr)
+		if !ok {
+			return
+		}
+
+		// api.namespace
+		if isApiIdent(attrGet.Object) {
+			namespace, ok := attrGet.Key.(*ast.StringExpr)
+			if !ok {
+				return
+			}
+			if _, exists := availableApi[namespace.Value]; !exists {
+				issues.add(file, expr.Line(), LintError, "undefined api namespace: %s.%s", apiNamespaceName, namespace.Value)
+			} else if namespace.Value == "sh" && !isUnsafe {
+				issues.add(file, expr.Line(), LintError, "%s.sh can be used only by unsafe rules, add #![unsafe] decorator", apiNamespaceName)
+			}
+			return
+		}
+
+		// api.namespace.function
+		namespaceGet, ok := attrGet.Object.(*ast.AttrGetExpr)
+		if !ok || !isApiIdent(namespaceGet.Object) {
+			return
+		}
+		namespace, isNamespaceString := namespaceGet.Key.(*ast.StringExpr)
+		function, isFunctionString := attrGet.Key.(*ast.StringExpr)
+		if !isNamespaceString || !isFunctionString {
+			return
+		}
+
+		functions, exists := availableApi[namespace.Value]
+		if exists && !functions[function.Value] {
+			issues.add(file, expr.Line(), LintError, "undefined api function: %s.%s.%s", apiNamespaceName, namespace.Value, function.Value)
+		}
+	})
+
+	return issues
+}
+
+func isApiIdent(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.IdentExpr)
+	return ok && ident.Value == apiNamespaceName
+}
+
+// getAvailableApi returns names of the api namespaces and fields available in each of them
+func getAvailableApi() map[string]map[string]bool {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+
+	importLoopData := shared.ImportLoopData{InfoApi: silentInfoApi{}}
+	attachApi(&importLoopData, &shared.RuleConfigLayout{Unsafe: true}, L)
+
+	availableApi := make(map[string]map[string]bool)
+	apiTable, ok := L.GetGlobal(apiNamespaceName).(*lua.LTable)
+	if !ok {
+		return availableApi
+	}
+
+	apiTable.ForEach(func(namespaceName, namespace lua.LValue) {
+		fields := make(map[string]bool)
+		if namespaceTable, ok := namespace.(*lua.LTable); ok {
+			namespaceTable.ForEach(func(fieldName, _ lua.LValue) {
+				fields[fieldName.String()] = true
+			})
+		}
+		availableApi[namespaceName.String()] = fields
+	})
+
+	return availableApi
+}
+
+// lintDependencies finds dependencies on unknown rules and dependency cycles inside the ruleset
+func lintDependencies(rulesetConf *shared.ConfigFileLayout, identifier string) LintIssues {
+	var issues LintIssues
+
+	isLocalRuleset := func(rulesetIdentifier string) bool {
+		normalizedIdentifier := NormalizeIdentifier(rulesetIdentifier)
+		return normalizedIdentifier == identifier ||
+			(rulesetConf.RepoUrl != "" && normalizedIdentifier == NormalizeIdentifier(rulesetConf.RepoUrl)) ||
+			(rulesetConf.Identifier != "" && rulesetIdentifier == rulesetConf.Identifier)
+	}
+
+	localDependencies := make(map[string][]string)
+	ruleNames := make([]string, 0, len(rulesetConf.Dependencies))
+	for ruleName, dependencies := range rulesetConf.Dependencies {
+		ruleNames = append(ruleNames, ruleName)
+
+		if _, exists := rulesetConf.Rules[ruleName]; !exists {
+			issues.add(shared.ConfigFilename, 0, LintError, "dependencies are declared for unknown rule '%s'", ruleName)
+		}
+
+		for _, dependency := range dependencies {
+			rulesetIdentifier, dependencyRuleName, found := strings.Cut(dependency, "@")
+			if !found || rulesetIdentifier == "" || dependencyRuleName == "" {
+				issues.add(shared.ConfigFilename, 0, LintError, "invalid dependency '%s' of rule '%s', use ruleset@rule format", dependency, ruleName)
+				continue
+			}
+			if !isLocalRuleset(rulesetIdentifier) {
+				continue
+			}
+			if _, exists := rulesetConf.Rules[dependencyRuleName]; !exists {
+				issues.add(shared.ConfigFilename, 0, LintError, "rule '%s' depends on unknown rule '%s'", ruleName, dependencyRuleName)
+				continue
+			}
+			localDependencies[ruleName] = append(localDependencies[ruleName], dependencyRuleName)
+		}
+	}
+	slices.Sort(ruleNames)
+
+	const (
+		notVisited = iota
+		inProgress
+		visited
+	)
+	state := make(map[string]int)
+	var stack []string
+
+	var visit func(ruleName string)
+	visit = func(ruleName string) {
+		state[ruleName] = inProgress
+		stack = append(stack, ruleName)
+
+		for _, dependency := range localDependencies[ruleName] {
+			switch state[dependency] {
+			case inProgress:
+				cycleStart := slices.Index(stack, dependency)
+				cycle := append(slices.Clone(stack[cycleStart:]), dependency)
+				issues.add(shared.ConfigFilename, 0, LintError, "dependency cycle: %s", strings.Join(cycle, " -> "))
+			case notVisited:
+				visit(dependency)
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[ruleName] = visited
+	}
+
+	for _, ruleName := range ruleNames {
+		if state[ruleName] == notVisited {
+			visit(ruleName)
+		}
+	}
+
+	return issues
+}
+
+// lintUnregisteredScripts warns about rules which exist in the rules directory, but can't be executed
+func lintUnregisteredScripts(rulesetPath string, registeredScripts map[string]bool) LintIssues {
+	var issues LintIssues
+
+	entries, err := os.ReadDir(filepath.Join(rulesetPath, rulesDirName))
+	if err != nil {
+		return issues
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".lua") || strings.HasSuffix(name, "_test.lua") {
+			continue
+		}
+		if !registeredScripts[filepath.Join(rulesetPath, rulesDirName, name)] {
+			issues.add(filepath.Join(rulesDirName, name), 0, LintWarning, "rule isn't registered in %s", shared.ConfigFilename)
+		}
+	}
+
+	return issues
+}
+
+func walkStmts(stmts []ast.Stmt, visit func(expr ast.Expr)) {
+	for _, stmt := range stmts {
+		walkStmt(stmt, visit)
+	}
+}
+
+func walkStmt(stmt ast.Stmt, visit func(expr ast.Expr)) {
+	switch stmt := stmt.(type) {
+	case *ast.AssignStmt:
+		walkExprs(stmt.Lhs, visit)
+		walkExprs(stmt.Rhs, visit)
+	case *ast.LocalAssignStmt:
+		walkExprs(stmt.Exprs, visit)
+	case *ast.FuncCallStmt:
+		walkExpr(stmt.Expr, visit)
+	case *ast.DoBlockStmt:
+		walkStmts(stmt.Stmts, visit)
+	case *ast.WhileStmt:
+		walkExpr(stmt.Condition, visit)
+		walkStmts(stmt.Stmts, visit)
+	case *ast.RepeatStmt:
+		walkExpr(stmt.Condition, visit)
+		walkStmts(stmt.Stmts, visit)
+	case *ast.IfStmt:
+		walkExpr(stmt.Condition, visit)
+		walkStmts(stmt.Then, visit)
+		walkStmts(stmt.Else, visit)
+	case *ast.NumberForStmt:
+		walkExprs([]ast.Expr{stmt.Init, stmt.Limit, stmt.Step}, visit)
+		walkStmts(stmt.Stmts, visit)
+	case *ast.GenericForStmt:
+		walkExprs(stmt.Exprs, visit)
+		walkStmts(stmt.Stmts, visit)
+	case *ast.FuncDefStmt:
+		walkExpr(stmt.Name.Func, visit)
+		walkExpr(stmt.Name.Receiver, visit)
+		walkExpr(stmt.Func, visit)
+	case *ast.ReturnStmt:
+		walkExprs(stmt.Exprs, visit)
+	}
+}
+
+func walkExprs(exprs []ast.Expr, visit func(expr ast.Expr)) {
+	for _, expr := range exprs {
+		walkExpr(expr, visit)
+	}
+}
+
+func walkExpr(expr ast.Expr, visit func(expr ast.Expr)) {
+	if expr == nil {
+		return
+	}
+	visit(expr)
+
+	switch expr := expr.(type) {
+	case *ast.AttrGetExpr:
+		walkExpr(expr.Object, visit)
+		walkExpr(expr.Key, visit)
+	case *ast.TableExpr:
+		for _, field := range expr.Fields {
+			walkExpr(field.Key, visit)
+			walkExpr(field.Value, visit)
+		}
+	case *ast.FuncCallExpr:
+		walkExpr(expr.Func, visit)
+		walkExpr(expr.Receiver, visit)
+		walkExprs(expr.Args, visit)
+	case *ast.LogicalOpExpr:
+		walkExprs([]ast.Expr{expr.Lhs, expr.Rhs}, visit)
+	case *ast.RelationalOpExpr:
+		walkExprs([]ast.Expr{expr.Lhs, expr.Rhs}, visit)
+	case *ast.StringConcatOpExpr:
+		walkExprs([]ast.Expr{expr.Lhs, expr.Rhs}, visit)
+	case *ast.ArithmeticOpExpr:
+		walkExprs([]ast.Expr{expr.Lhs, expr.Rhs}, visit)
+	case *ast.UnaryMinusOpExpr:
+		walkExpr(expr.Expr, visit)
+	case *ast.UnaryNotOpExpr:
+		walkExpr(expr.Expr, visit)
+	case *ast.UnaryLenOpExpr:
+		walkExpr(expr.Expr, visit)
+	case *ast.FunctionExpr:
+		walkStmts(expr.Stmts, visit)
+	}
+}
+
+// silentInfoApi is used where the api has to be created, but it's never executed
+type silentInfoApi struct{}
+
+func (silentInfoApi) Log(...any)       {}
+func (silentInfoApi) Debug(...any)     {}
+func (silentInfoApi) Error(...any)     {}
+func (silentInfoApi) Warn(...any)      {}
+func (silentInfoApi) Important(...any) {}
@@ -0,0 +1,390 @@
+package checker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/package_conflict"
+	"github.com/avorty/spito/pkg/privileged"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"os"
+	"slices"
+	"strings"
+)
+
+// PackageTransaction lists packages requested by all the executed rules,
+// they are installed and removed at once when the changes are applied
+type PackageTransaction struct {
+	Install            []string `json:"install,omitempty"`
+	Remove             []string `json:"remove,omitempty"`
+	AddRepositories    []string `json:"addRepositories,omitempty"`
+	RemoveRepositories []string `json:"removeRepositories,omitempty"`
+	ImportKeys         []string `json:"importKeys,omitempty"`
+}
+
+func GetPackageTransaction(importLoopData *shared.ImportLoopData) PackageTransaction {
+	transaction := PackageTransaction{
+		Install: importLoopData.PackageTracker.GetPackagesToInstall(),
+		Remove:  importLoopData.PackageTracker.GetPackagesToRemove(),
+	}
+	for _, request := range importLoopData.PackageTracker.GetRepositoryRequests() {
+		if request.IsRemoved() {
+			transaction.RemoveRepositories = append(transaction.RemoveRepositories, request.Name)
+		} else {
+			transaction.AddRepositories = append(transaction.AddRepositories, request.Name)
+		}
+	}
+	for _, request := range importLoopData.PackageTracker.GetKeyRequests() {
+		transaction.ImportKeys = append(transaction.ImportKeys, request.Fingerprint)
+	}
+	return transaction
+}
+
+func (t PackageTransaction) IsEmpty() bool {
+	return len(t.Install) == 0 && len(t.Remove) == 0 &&
+		len(t.AddRepositories) == 0 && len(t.RemoveRepositories) == 0 && len(t.ImportKeys) == 0
+}
+
+// ExecutePackageTransaction changes repositories and removes and installs packages requested by the rules,
+// so the package manager is executed at most twice. Daemon operations waiting for the packages are executed afterwards.
+// If installation fails, changes made by the transaction are reverted
+func ExecutePackageTransaction(importLoopData *shared.ImportLoopData) error {
+	tracker := importLoopData.PackageTracker
+	installRequests := tracker.GetInstallRequests()
+	removeRequests := tracker.GetRemoveRequests()
+	packageManager := getTransactionPackageManager(importLoopData, getTransactionRules(tracker))
+
+	recordedChanges := len(importLoopData.VRCT.Fs.PackageChanges())
+	err := changeRepositories(importLoopData, packageManager, tracker.GetKeyRequests(), tracker.GetRepositoryRequests())
+	if err == nil {
+		err = removePackages(importLoopData, packageManager, removeRequests)
+	}
+	if err == nil {
+		err = installPackages(importLoopData, packageManager, installRequests)
+	}
+	if err != nil {
+		// The configuration of the package manager has been applied before the other files
+		transactionChanges := importLoopData.VRCT.Fs.PackageChanges()[recordedChanges:]
+		revertErr := RevertPackageChanges(importLoopData.InfoApi, getManagedRoot(importLoopData), transactionChanges)
+		return errors.Join(err, revertErr, importLoopData.VRCT.Revert())
+	}
+	importLoopData.PackageTracker.Clear()
+	return nil
+}
+
+// getTransactionPackageManager returns package manager for the transaction, when spito is run by a regular user,
+// the privileged helper checks that all the rules requesting packages are allowed to manage them
+func getTransactionPackageManager(importLoopData *shared.ImportLoopData, rules []vrctFs.Rule) api.PackageManager {
+	if PrivilegedHelper == nil || importLoopData.Simulated {
+		return api.PackageManagerBackend
+	}
+
+	return privileged.TransactionBackend{
+		Client:         PrivilegedHelper,
+		Rules:          rules,
+		Root:           getManagedRoot(importLoopData),
+		PackageManager: api.PackageManagerBackend,
+	}
+}
+
+// getTransactionRules returns rules which have requested any package, repository or key
+func getTransactionRules(tracker package_conflict.PackageConflictTracker) []vrctFs.Rule {
+	var rules []vrctFs.Rule
+	addRule := func(rule vrctFs.Rule) {
+		if !slices.Contains(rules, rule) {
+			rules = append(rules, rule)
+		}
+	}
+
+	for _, request := range append(tracker.GetInstallRequests(), tracker.GetRemoveRequests()...) {
+		addRule(request.Rule)
+	}
+	for _, request := range tracker.GetRepositoryRequests() {
+		addRule(request.Rule)
+	}
+	for _, request := range tracker.GetKeyRequests() {
+		addRule(request.Rule)
+	}
+	return rules
+}
+
+// changeRepositories imports keys and changes repositories before packages are installed,
+// databases of the added or replaced repositories are synchronized, so their packages can be installed
+func changeRepositories(
+	importLoopData *shared.ImportLoopData,
+	packageManager api.PackageManager,
+	keyRequests []package_conflict.KeyRequest,
+	repositoryRequests []package_conflict.RepositoryRequest,
+) error {
+	for _, request := range keyRequests {
+		hasKey, err := packageManager.HasKey(request.Fingerprint)
+		if err != nil {
+			return err
+		}
+		if hasKey {
+			continue
+		}
+
+		if err := packageManager.ImportKey(request.Fingerprint, request.Source); err != nil {
+			return err
+		}
+		importLoopData.VRCT.Fs.RecordPackageChange(vrctFs.PackageChange{
+			Name:   request.Fingerprint,
+			Action: vrctFs.KeyImported,
+			Rule:   request.Rule,
+		})
+	}
+
+	if len(repositoryRequests) == 0 {
+		return nil
+	}
+	changedRepositories, err := changeRepositoryConfig(importLoopData, repositoryRequests)
+	if err != nil || len(changedRepositories) == 0 {
+		return err
+	}
+	return packageManager.SyncDatabases(changedRepositories...)
+}
+
+// changeRepositoryConfig adds, replaces or removes the repositories in the configuration of the package manager
+// through the VRCT. The configuration is applied before the other files, so the package manager can use it,
+// and it's restored together with them when the changes are reverted. Names of added or replaced repositories are returned
+func changeRepositoryConfig(importLoopData *shared.ImportLoopData, requests []package_conflict.RepositoryRequest) ([]string, error) {
+	// The configuration doesn't exist yet in a new root, e.g. in the sandbox of tests
+	previousConfig, err := importLoopData.VRCT.Fs.ReadFile(api.PacmanConfigPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("cannot read %s: %w", api.PacmanConfigPath, err)
+	}
+	repositories, err := api.ParseConfigRepositories(previousConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	config := previousConfig
+	var changedRepositories []string
+	for _, request := range requests {
+		changedConfig, err := changeRepository(config, repositories, request)
+		if err != nil {
+			return nil, fmt.Errorf("cannot change the repository %s: %w", request.Name, err)
+		}
+		if !request.IsRemoved() && !bytes.Equal(changedConfig, config) {
+			changedRepositories = append(changedRepositories, request.Name)
+		}
+		config = changedConfig
+	}
+	if bytes.Equal(config, previousConfig) {
+		return nil, nil
+	}
+
+	if err := importLoopData.VRCT.Fs.CreateFile(api.PacmanConfigPath, config, false); err != nil {
+		return nil, fmt.Errorf("cannot change repositories in %s: %w", api.PacmanConfigPath, err)
+	}
+	for _, request := range requests {
+		importLoopData.VRCT.Fs.AddFileRule(api.PacmanConfigPath, request.Rule)
+	}
+	return changedRepositories, importLoopData.VRCT.ApplyFile(api.PacmanConfigPath, GetRulesToRevert(importLoopData.RulesHistory))
+}
+
+// changeRepository adds, replaces or removes the repository unless it's already configured as requested
+func changeRepository(config []byte, repositories []api.Repository, request package_conflict.RepositoryRequest) ([]byte, error) {
+	if request.IsRemoved() {
+		return api.RemoveConfigRepository(config, request.Name)
+	}
+
+	isConfigured := slices.ContainsFunc(repositories, func(repository api.Repository) bool {
+		return repository.String() == request.Configuration
+	})
+	if isConfigured {
+		return config, nil
+	}
+
+	repository, err := api.ParseRepository(request.Configuration)
+	if err != nil {
+		return nil, err
+	}
+	return api.AddConfigRepository(config, repository)
+}
+
+// checkPackagesPermission makes the rule fail when it requests packages, not when the transaction is executed
+func checkPackagesPermission(packageManager api.PackageManager) error {
+	if ruleBackend, ok := packageManager.(privileged.RuleBackend); ok {
+		return ruleBackend.CheckPermission(shared.PermissionPackages)
+	}
+	return nil
+}
+
+// checkRepositoryPermission requires the files permission as well, because repositories are changed
+// in the configuration of the package manager through the VRCT
+func checkRepositoryPermission(packageManager api.PackageManager) error {
+	if err := checkPackagesPermission(packageManager); err != nil {
+		return err
+	}
+	if ruleBackend, ok := packageManager.(privileged.RuleBackend); ok {
+		return ruleBackend.CheckPermission(shared.PermissionFiles)
+	}
+	return nil
+}
+
+// installPackages installs the packages and records which of them were newly installed or upgraded,
+// so they are reverted together with the files changed by the rules
+func installPackages(
+	importLoopData *shared.ImportLoopData,
+	packageManager api.PackageManager,
+	requests []package_conflict.PackageRequest,
+) error {
+	if len(requests) == 0 {
+		return nil
+	}
+	previousPackages := getInstalledPackages(packageManager, requests)
+
+	packageStrings := make([]string, len(requests))
+	for i, request := range requests {
+		packageStrings[i] = request.Package
+	}
+	if err := packageManager.InstallPackages(packageStrings...); err != nil {
+		return err
+	}
+
+	for _, request := range requests {
+		previousPackage, wasInstalled := previousPackages[request.Name()]
+		if !wasInstalled {
+			importLoopData.VRCT.Fs.RecordPackageChange(vrctFs.PackageChange{
+				Name:   request.Name(),
+				Action: vrctFs.PackageInstalled,
+				Rule:   request.Rule,
+			})
+			continue
+		}
+
+		currentPackage, err := packageManager.GetPackage(request.Name())
+		if err == nil && currentPackage.Version != previousPackage.Version {
+			importLoopData.VRCT.Fs.RecordPackageChange(vrctFs.PackageChange{
+				Name:    request.Name(),
+				Action:  vrctFs.PackageUpgraded,
+				Version: previousPackage.Version,
+				Rule:    request.Rule,
+			})
+		}
+	}
+	return nil
+}
+
+// removePackages removes the packages and records their versions, so they can be installed again by revert
+func removePackages(
+	importLoopData *shared.ImportLoopData,
+	packageManager api.PackageManager,
+	requests []package_conflict.PackageRequest,
+) error {
+	previousPackages := getInstalledPackages(packageManager, requests)
+	if len(previousPackages) == 0 {
+		return nil
+	}
+
+	// Packages which aren't installed are already removed, the package manager would fail on them
+	var packageNames []string
+	for _, request := range requests {
+		if _, isInstalled := previousPackages[request.Name()]; isInstalled {
+			packageNames = append(packageNames, request.Name())
+		}
+	}
+	if err := packageManager.RemovePackages(packageNames...); err != nil {
+		return err
+	}
+
+	for _, request := range requests {
+		previousPackage, wasInstalled := previousPackages[request.Name()]
+		if !wasInstalled {
+			continue
+		}
+		importLoopData.VRCT.Fs.RecordPackageChange(vrctFs.PackageChange{
+			Name:     request.Name(),
+			Action:   vrctFs.PackageRemoved,
+			Version:  previousPackage.Version,
+			Explicit: previousPackage.IsExplicit(),
+			Rule:     request.Rule,
+		})
+	}
+	return nil
+}
+
+// RevertPackageChanges removes packages installed by the rules and installs upgraded or removed ones again.
+// Installed packages which are required by other packages now and removed dependencies are left as they are
+func RevertPackageChanges(infoApi shared.InfoInterface, root string, changes []vrctFs.PackageChange) error {
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		packageManager := getRevertPackageManager(change.Rule, root)
+		if change.Action == vrctFs.KeyImported {
+			if err := revertKeyChange(packageManager, change); err != nil {
+				return err
+			}
+			continue
+		}
+
+		currentPackage, getErr := packageManager.GetPackage(change.Name)
+		isInstalled := getErr == nil
+
+		var err error
+		switch change.Action {
+		case vrctFs.PackageInstalled:
+			if !isInstalled {
+				continue
+			}
+			if len(currentPackage.RequiredBy) > 0 {
+				infoApi.Warn(fmt.Sprintf("Package %s is required by %s now, it won't be removed",
+					change.Name, strings.Join(currentPackage.RequiredBy, ", ")))
+				continue
+			}
+			err = packageManager.RemovePackages(change.Name)
+		case vrctFs.PackageUpgraded:
+			if !isInstalled || currentPackage.Version == change.Version {
+				continue
+			}
+			err = packageManager.InstallPackageVersion(change.Name, change.Version)
+		case vrctFs.PackageRemoved:
+			if isInstalled {
+				continue
+			}
+			if !change.Explicit {
+				infoApi.Debug(fmt.Sprintf("Package %s was installed as a dependency, it won't be installed again", change.Name))
+				continue
+			}
+			err = packageManager.InstallPackages(change.Name)
+		}
+
+		if err != nil {
+			return fmt.Errorf("cannot revert changes of the package %s: %w", change.Name, err)
+		}
+	}
+	return nil
+}
+
+// revertKeyChange removes the key imported before packages were installed
+func revertKeyChange(packageManager api.PackageManager, change vrctFs.PackageChange) error {
+	if err := packageManager.RemoveKey(change.Name); err != nil {
+		return fmt.Errorf("cannot revert changes of the key %s: %w", change.Name, err)
+	}
+	return nil
+}
+
+// getRevertPackageManager returns package manager which reverts changes made by the rule, when spito is run
+// by a regular user, the privileged helper checks whether the rule is allowed to manage packages
+func getRevertPackageManager(rule vrctFs.Rule, root string) api.PackageManager {
+	if PrivilegedHelper == nil {
+		return api.PackageManagerBackend
+	}
+
+	// If permissions cannot be read, the helper rejects the change with an error explaining why
+	permissions, _ := GetRulePermissions(rule)
+	return newRuleBackend(rule, root, permissions)
+}
+
+func getInstalledPackages(packageManager api.PackageManager, requests []package_conflict.PackageRequest) map[string]api.Package {
+	installedPackages := make(map[string]api.Package)
+	for _, request := range requests {
+		if installedPackage, err := packageManager.GetPackage(request.Name()); err == nil {
+			installedPackages[request.Name()] = installedPackage
+		}
+	}
+	return installedPackages
+}
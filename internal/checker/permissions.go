@@ -0,0 +1,204 @@
+package checker
+
+import (
+	"errors"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/path"
+	"github.com/avorty/spito/pkg/privileged"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/trust"
+	"github.com/avorty/spito/pkg/userinfo"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"path/filepath"
+)
+
+// PrivilegedHelper performs privileged operations of the rules when spito is run by a regular user.
+// It is nil when spito runs as root and performs them by itself
+var PrivilegedHelper *privileged.Client
+
+var ErrRequiresRoot = errors.New("tried to execute a spito rule that requires root privileges (run check with sudo -E)")
+
+var ErrLocalRuleRequiresRoot = errors.New("rule files and local rulesets cannot use the privileged helper, " +
+	"because it trusts only rulesets fetched by itself (run check with sudo -E)")
+
+// HelperStateDirectory keeps rulesets fetched and verified by the privileged helper and its trust store
+const HelperStateDirectory = "/var/lib/spito"
+
+// usePrivileges checks whether privileged operations of the rule can be performed. If the rule is allowed
+// to change files of the system, changes are applied by the privileged helper
+func usePrivileges(importLoopData *shared.ImportLoopData, ruleConf *shared.RuleConfigLayout) error {
+	if !ruleConf.RequiresPrivileges() || importLoopData.Simulated {
+		return nil
+	}
+
+	isRunAsRoot, err := userinfo.IsRoot()
+	if err != nil {
+		return err
+	}
+	if isRunAsRoot {
+		return nil
+	}
+	if PrivilegedHelper == nil {
+		return ErrRequiresRoot
+	}
+	isLocal, err := isLocalRule(importLoopData.CurrentRule)
+	if err != nil {
+		return err
+	}
+	if isLocal {
+		return ErrLocalRuleRequiresRoot
+	}
+
+	if ruleConf.HasPermission(shared.PermissionFiles) {
+		importLoopData.VRCT.Applier = PrivilegedHelper
+	}
+	return nil
+}
+
+// getRuleBackends returns package and init managers used by the rule, when spito is run by a regular user,
+// changes are performed by the privileged helper
+func getRuleBackends(importLoopData *shared.ImportLoopData, ruleConf *shared.RuleConfigLayout) (api.PackageManager, api.InitManager) {
+	if PrivilegedHelper == nil || importLoopData.Simulated {
+		return api.PackageManagerBackend, api.InitManagerBackend
+	}
+
+	ruleBackend := newRuleBackend(importLoopData.CurrentRule, getManagedRoot(importLoopData), ruleConf.GetPermissions())
+	return ruleBackend, ruleBackend
+}
+
+// getManagedRoot returns the root of the system changed by the rules
+func getManagedRoot(importLoopData *shared.ImportLoopData) string {
+	// Revert functions are executed without VRCT
+	if root := importLoopData.VRCT.Fs.Root(); root != "" {
+		return root
+	}
+	return "/"
+}
+
+func newRuleBackend(rule vrctFs.Rule, root string, permissions []shared.Permission) privileged.RuleBackend {
+	return privileged.RuleBackend{
+		Client:         PrivilegedHelper,
+		Rule:           rule,
+		Root:           root,
+		Permissions:    permissions,
+		PackageManager: api.PackageManagerBackend,
+		InitManager:    api.InitManagerBackend,
+	}
+}
+
+// GetRulePermissions reads permissions declared by the rule from the ruleset config and the rule decorators
+func GetRulePermissions(rule vrctFs.Rule) ([]shared.Permission, error) {
+	_, ruleConf, err := getHistoryRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	return getValidPermissions(ruleConf)
+}
+
+// getValidPermissions returns an error if the ruleset config declares an unknown permission
+func getValidPermissions(ruleConf shared.RuleConfigLayout) ([]shared.Permission, error) {
+	for _, permission := range ruleConf.Permissions {
+		if _, err := shared.ParsePermission(string(permission)); err != nil {
+			return nil, err
+		}
+	}
+	return ruleConf.GetPermissions(), nil
+}
+
+// GetTrustedRulePermissions is used by the privileged helper instead of GetRulePermissions. It doesn't trust
+// rule files and local rulesets of the user, the ruleset is fetched into the helper's directory and verified by itself
+func GetTrustedRulePermissions(rule vrctFs.Rule) ([]shared.Permission, error) {
+	isLocal, err := isLocalRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	if isLocal {
+		return nil, ErrLocalRuleRequiresRoot
+	}
+
+	rulesetLocation, err := NewRulesetLocation(rule.Url, false)
+	if err != nil {
+		return nil, err
+	}
+	rulesetConf, err := GetRulesetConf(&rulesetLocation)
+	if err != nil {
+		return nil, err
+	}
+	ruleConf, err := rulesetConf.GetRuleConf(rule.NameOrScript)
+	if err != nil {
+		return nil, err
+	}
+	script, err := getScript(&rulesetLocation, rule.NameOrScript)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := processScript(script, &ruleConf); err != nil {
+		return nil, err
+	}
+	return getValidPermissions(ruleConf)
+}
+
+// UseHelperStateDirectory makes rulesets fetched and verified in a directory, which only root can change
+func UseHelperStateDirectory() {
+	shared.LocalStateSpitoPath = HelperStateDirectory
+	trust.DefaultStorePath = filepath.Join(HelperStateDirectory, "trust.json")
+}
+
+// isLocalRule returns true for rule files and rules of rulesets given by a path
+func isLocalRule(rule vrctFs.Rule) (bool, error) {
+	if rule.IsScript || filepath.IsAbs(rule.Url) {
+		return true, nil
+	}
+	return path.PathExists(rule.Url)
+}
+
+// getHistoryRule returns processed script and config of the rule saved in the rules history
+func getHistoryRule(rule vrctFs.Rule) (string, shared.RuleConfigLayout, error) {
+	ruleConf := shared.RuleConfigLayout{}
+	if rule.IsScript {
+		script, err := processScript(rule.NameOrScript, &ruleConf)
+		return script, ruleConf, err
+	}
+
+	isPath, err := path.PathExists(rule.Url)
+	if err != nil || rule.Url == "" {
+		isPath = false
+	}
+
+	rulesetLocation, err := NewRulesetLocation(rule.Url, isPath)
+	if err != nil {
+		return "", ruleConf, err
+	}
+	rulesetConf, err := GetRulesetConf(&rulesetLocation)
+	if err != nil {
+		return "", ruleConf, err
+	}
+	ruleConf, err = rulesetConf.GetRuleConf(rule.NameOrScript)
+	if err != nil {
+		return "", ruleConf, err
+	}
+
+	script, err := getScript(&rulesetLocation, rule.NameOrScript)
+	if err != nil {
+		return "", ruleConf, err
+	}
+	script, err = processScript(script, &ruleConf)
+	return script, ruleConf, err
+}
+
+// appendPermissions reads values of #![permissions(files, packages, daemons)] decorator
+func appendPermissions(permissions []shared.Permission, decorator RawDecorator) ([]shared.Permission, error) {
+	values, err := appendDecoratorValues(nil, decorator)
+	if err != nil {
+		return permissions, err
+	}
+
+	for _, value := range values {
+		permission, err := shared.ParsePermission(value)
+		if err != nil {
+			return permissions, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, nil
+}
@@ -1,13 +1,11 @@
 package checker
 
 import (
+	"errors"
 	"fmt"
-	"github.com/avorty/spito/pkg/api"
 	"github.com/avorty/spito/pkg/shared"
 	"github.com/avorty/spito/pkg/shared/option"
-	"regexp"
 	"strings"
-	"unicode"
 )
 
 type DecoratorType uint
@@ -18,12 +16,40 @@ const (
 	OptionsDecorator
 	EnvironmentDecorator
 	SudoDecorator
+	DistroDecorator
+	InitDecorator
+	ArchDecorator
+	ConflictsDecorator
+	ReplacesDecorator
+	PermissionsDecorator
 	UnknownDecorator
 )
 
 type RawDecorator struct {
-	Type    DecoratorType
+	Type DecoratorType
+	// Content is the code written between parentheses of the decorator
 	Content string
+	// Line and Column point to the beginning of the decorator inside the script
+	Line   int
+	Column int
+
+	tokens     []decoratorToken
+	source     string
+	contentEnd int
+}
+
+// Arguments parses decorator content as comma separated list of values and name=value pairs
+func (d RawDecorator) Arguments() (DecoratorArguments, error) {
+	return parseDecoratorArguments(d.source, d.tokens, d.contentEnd)
+}
+
+// CompactContent returns content without comments and white spaces outside of strings
+func (d RawDecorator) CompactContent() string {
+	var content strings.Builder
+	for _, token := range d.tokens {
+		content.WriteString(token.raw)
+	}
+	return content.String()
 }
 
 func processScript(script string, ruleConf *shared.RuleConfigLayout) (string, error) {
@@ -44,11 +70,41 @@ func processScript(script string, ruleConf *shared.RuleConfigLayout) (string, er
 			ruleConf.Sudo = true
 			break
 		case OptionsDecorator:
-			ruleConf.Options, err = option.AppendOptions(ruleConf.Options, decorator.Content)
+			ruleConf.Options, err = option.AppendOptions(ruleConf.Options, decorator.CompactContent())
 			if err != nil {
 				return newScript, err
 			}
 			break
+		case DistroDecorator:
+			ruleConf.Distros, err = appendDecoratorValues(ruleConf.Distros, decorator)
+			if err != nil {
+				return newScript, err
+			}
+		case InitDecorator:
+			ruleConf.InitSystems, err = appendDecoratorValues(ruleConf.InitSystems, decorator)
+			if err != nil {
+				return newScript, err
+			}
+		case ArchDecorator:
+			ruleConf.Architectures, err = appendDecoratorValues(ruleConf.Architectures, decorator)
+			if err != nil {
+				return newScript, err
+			}
+		case ConflictsDecorator:
+			ruleConf.Conflicts, err = appendDecoratorValues(ruleConf.Conflicts, decorator)
+			if err != nil {
+				return newScript, err
+			}
+		case ReplacesDecorator:
+			ruleConf.Replaces, err = appendDecoratorValues(ruleConf.Replaces, decorator)
+			if err != nil {
+				return newScript, err
+			}
+		case PermissionsDecorator:
+			ruleConf.Permissions, err = appendPermissions(ruleConf.Permissions, decorator)
+			if err != nil {
+				return newScript, err
+			}
 		default:
 			break
 		}
@@ -57,47 +113,142 @@ func processScript(script string, ruleConf *shared.RuleConfigLayout) (string, er
 	return newScript, nil
 }
 
-// GetDecorators Returns script without decorators and array of decorator values
+// GetDecorators Returns script without decorators and array of decorator values.
+// Decorators are recognized only at the beginning of a line outside of lua strings.
+// Returned error is *DecoratorSyntaxError if any of decorators is invalid
 func GetDecorators(script string) (string, []RawDecorator, error) {
 	var fileScopeDecorators []RawDecorator
+	var processedScript strings.Builder
+
+	scanner := decoratorScanner{script: script, isLineStart: true}
+	offset := 0
+	for {
+		decoratorIndex := scanner.nextDecorator()
+		if decoratorIndex == -1 {
+			break
+		}
 
-	fileScopeRegex := regexp.MustCompile(`#!\[[^]]+]`)
-	decoratorMatches := fileScopeRegex.FindAllString(script, -1)
+		decorator, decoratorEnd, err := parseDecorator(script, decoratorIndex)
+		if err != nil {
+			return script, fileScopeDecorators, err
+		}
+		fileScopeDecorators = append(fileScopeDecorators, decorator)
 
-	for _, decorator := range decoratorMatches {
-		script = strings.Replace(script, decorator, "", 1)
+		// Keep new lines, so line numbers in lua errors are still correct
+		processedScript.WriteString(script[offset:decoratorIndex])
+		processedScript.WriteString(strings.Repeat("\n", strings.Count(script[decoratorIndex:decoratorEnd], "\n")))
+		offset = decoratorEnd
+		// Many decorators can be written in one line, e.g. #![unsafe] #![sudo]
+		scanner.offset, scanner.isLineStart = decoratorEnd, true
+	}
+	processedScript.WriteString(script[offset:])
 
-		processedDecorator := api.RemoveComments(decorator, "--", "--[[", "]]")
-		processedDecorator = removeWhitespaces(processedDecorator)
+	return processedScript.String(), fileScopeDecorators, nil
+}
 
-		processedDecorator = strings.TrimPrefix(processedDecorator, "#![")
-		processedDecorator = strings.TrimSuffix(processedDecorator, "]")
+// decoratorScanner walks through lua code to find where decorators start. Text inside strings
+// and in the middle of comments is skipped, but decorators can start a line inside the block comment,
+// rules put multi-line decorators there to keep the script valid lua, e.g. --[[ #![options(...)] ]]
+type decoratorScanner struct {
+	script      string
+	offset      int
+	isLineStart bool
+	// closingBracket ends the block comment or the long string in which the scanner is, e.g. ]==]
+	closingBracket string
+	isLongString   bool
+}
 
-		var finalDecorator RawDecorator
-		bracketIndex := strings.Index(processedDecorator, "(")
-		rawDecoratorName := processedDecorator
-		if bracketIndex != -1 {
-			rawDecoratorName = processedDecorator[0:bracketIndex]
-		}
+// nextDecorator returns offset of the next decorator or -1 if there are no more decorators
+func (s *decoratorScanner) nextDecorator() int {
+	for s.offset < len(s.script) {
+		rest := s.script[s.offset:]
 
-		var err error
-		finalDecorator.Type, err = GetDecoratorType(rawDecoratorName)
-		if err != nil {
-			return script, fileScopeDecorators, err
+		if s.closingBracket != "" {
+			switch {
+			case !s.isLongString && s.isLineStart && strings.HasPrefix(rest, decoratorStart):
+				return s.offset
+			case strings.HasPrefix(rest, s.closingBracket):
+				s.offset += len(s.closingBracket)
+				s.closingBracket, s.isLineStart = "", false
+			default:
+				s.advance()
+			}
+			continue
 		}
 
-		betweenParenthesesRegex := regexp.MustCompile(`\(.*\)`)
-		decoratorContent := betweenParenthesesRegex.FindString(processedDecorator)
-		if len(decoratorContent) > 2 {
-			decoratorContent = strings.TrimPrefix(decoratorContent, "(")
-			decoratorContent = strings.TrimSuffix(decoratorContent, ")")
+		switch {
+		case s.isLineStart && strings.HasPrefix(rest, decoratorStart):
+			return s.offset
+		case strings.HasPrefix(rest, "--"):
+			if level := getLongBracketLevel(rest[len("--"):]); level != -1 {
+				s.enterLongBracket(len("--"), level, false)
+				// Text right after the opening of block comment is treated as a new line
+				s.isLineStart = true
+				continue
+			}
+			lineEnd := strings.IndexByte(rest, '\n')
+			if lineEnd == -1 {
+				lineEnd = len(rest)
+			}
+			s.offset += lineEnd
+		case rest[0] == '[' && getLongBracketLevel(rest) != -1:
+			s.enterLongBracket(0, getLongBracketLevel(rest), true)
+		case rest[0] == '"' || rest[0] == '\'':
+			s.skipShortString(rest[0])
+		default:
+			s.advance()
 		}
-		finalDecorator.Content = decoratorContent
+	}
+	return -1
+}
+
+func (s *decoratorScanner) advance() {
+	switch s.script[s.offset] {
+	case '\n':
+		s.isLineStart = true
+	case ' ', '\t', '\r':
+	default:
+		s.isLineStart = false
+	}
+	s.offset++
+}
 
-		fileScopeDecorators = append(fileScopeDecorators, finalDecorator)
+func (s *decoratorScanner) enterLongBracket(prefixLength, level int, isLongString bool) {
+	s.offset += prefixLength + level + len("[[")
+	s.closingBracket = "]" + strings.Repeat("=", level) + "]"
+	s.isLongString = isLongString
+	s.isLineStart = false
+}
+
+// skipShortString skips string in quotes, unclosed string ends with the line like in lua
+func (s *decoratorScanner) skipShortString(quote byte) {
+	s.offset++
+	for s.offset < len(s.script) {
+		switch s.script[s.offset] {
+		case quote:
+			s.offset++
+			s.isLineStart = false
+			return
+		case '\\':
+			s.offset += 2
+		case '\n':
+			return
+		default:
+			s.offset++
+		}
 	}
+}
 
-	return script, fileScopeDecorators, nil
+// getLongBracketLevel returns the number of equal signs of lua long bracket, e.g. 2 for [==[, or -1 if text doesn't start with it
+func getLongBracketLevel(text string) int {
+	if !strings.HasPrefix(text, "[") {
+		return -1
+	}
+	level := len(text[1:]) - len(strings.TrimLeft(text[1:], "="))
+	if !strings.HasPrefix(text[1+level:], "[") {
+		return -1
+	}
+	return level
 }
 
 func GetDecoratorType(name string) (DecoratorType, error) {
@@ -120,54 +271,51 @@ func GetDecoratorType(name string) (DecoratorType, error) {
 	case "sudo":
 		decoratorType = SudoDecorator
 		break
+	case "distro":
+		decoratorType = DistroDecorator
+	case "init":
+		decoratorType = InitDecorator
+	case "arch":
+		decoratorType = ArchDecorator
+	case "conflicts":
+		decoratorType = ConflictsDecorator
+	case "replaces":
+		decoratorType = ReplacesDecorator
+	case "permissions":
+		decoratorType = PermissionsDecorator
 	default:
 		return UnknownDecorator, fmt.Errorf("unknown decorator: %s", name)
 	}
 	return decoratorType, nil
 }
 
-func removeQuotes(text *string) {
-	*text = strings.TrimPrefix(*text, "\"")
-	*text = strings.TrimSuffix(*text, "\"")
-}
-
+// GetDecoratorArguments parses arguments written as decorator content, e.g. "Installs neovim", path="./README.md".
+// Lists are not supported, use RawDecorator.Arguments for them
 func GetDecoratorArguments(decoratorCode string) ([]string, map[string]string, error) {
-	// TODO: escape it first
-	arguments := strings.Split(decoratorCode, ",")
+	tokens, err := tokenize(decoratorCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	arguments, err := parseDecoratorArguments(decoratorCode, tokens, len(decoratorCode))
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var positionalArguments []string
-	namedArguments := make(map[string]string)
-
-	for argumentIndex, argument := range arguments {
-		argumentTokens := strings.Split(argument, "=")
-		if argTokenLen := len(argumentTokens); argTokenLen > 2 {
-			return nil, nil, fmt.Errorf("syntax error in argument number %d", argumentIndex)
-		} else if argTokenLen == 1 {
-			removeQuotes(&argumentTokens[0])
-
-			positionalArguments = append(positionalArguments, argumentTokens[0])
-			continue
+	for _, argument := range arguments.Positional {
+		if argument.IsList {
+			return nil, nil, errors.New("list cannot be used as decorator argument here")
 		}
-		removeQuotes(&argumentTokens[1])
-		namedArguments[argumentTokens[0]] = argumentTokens[1]
+		positionalArguments = append(positionalArguments, argument.Text)
 	}
-	return positionalArguments, namedArguments, nil
-}
-
-func removeWhitespaces(decorator string) string {
-	var result strings.Builder
-	isString := false
 
-	for i := 0; i < len(decorator); i++ {
-		char := decorator[i]
-
-		if char == '"' {
-			isString = !isString
-		}
-		if !unicode.IsSpace(rune(char)) || isString {
-			result.WriteByte(char)
+	namedArguments := make(map[string]string)
+	for name, argument := range arguments.Named {
+		if argument.IsList {
+			return nil, nil, fmt.Errorf("list cannot be used as '%s' decorator argument", name)
 		}
+		namedArguments[name] = argument.Text
 	}
 
-	return result.String()
+	return positionalArguments, namedArguments, nil
 }
@@ -0,0 +1,188 @@
+package checker
+
+import (
+	"errors"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/privileged"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+)
+
+// CheckReport describes the executed rule, it is printed with json output and returned by the D-Bus service
+type CheckReport struct {
+	Rule         string     `json:"rule"`
+	Passed       bool       `json:"passed"`
+	Result       RuleResult `json:"result"`
+	Applied      bool       `json:"applied"`
+	RevertNumber *int       `json:"revertNumber,omitempty"`
+	ChangedFiles []string   `json:"changedFiles,omitempty"`
+	// Packages are installed and removed together with applying the changes
+	Packages PackageTransaction `json:"packages"`
+}
+
+func NewCheckReport(rule string, ruleResult RuleResult) CheckReport {
+	return CheckReport{
+		Rule:   rule,
+		Passed: ruleResult.Status.IsSuccessful(),
+		Result: ruleResult,
+	}
+}
+
+// SetApplied records changes applied after the rule has been checked
+func (r *CheckReport) SetApplied(revertNum int, changedFiles []string) {
+	r.Applied = true
+	r.RevertNumber = &revertNum
+	r.ChangedFiles = changedFiles
+}
+
+// EnvironmentReport describes the applied environment
+type EnvironmentReport struct {
+	Environment  string   `json:"environment"`
+	RevertNumber int      `json:"revertNumber"`
+	ChangedFiles []string `json:"changedFiles,omitempty"`
+}
+
+// RevertReport describes reverted changes
+type RevertReport struct {
+	RevertNumber   int                    `json:"revertNumber"`
+	Rules          []vrctFs.Rule          `json:"rules"`
+	ChangedFiles   []string               `json:"changedFiles,omitempty"`
+	PackageChanges []vrctFs.PackageChange `json:"packageChanges,omitempty"`
+	DaemonChanges  []vrctFs.DaemonChange  `json:"daemonChanges,omitempty"`
+	UnitChanges    []vrctFs.UnitChange    `json:"unitChanges,omitempty"`
+}
+
+// ApplyChanges applies changes made by the rules executed in this run to the real system
+// and returns the number which can be used to revert them
+func ApplyChanges(importLoopData *shared.ImportLoopData) (int, error) {
+	appliedRules, err := ResolveAppliedConflicts(importLoopData)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := ExecutePackageTransaction(importLoopData); err != nil {
+		return 0, err
+	}
+
+	revertNum, applyErr := importLoopData.VRCT.Apply(GetRulesToRevert(importLoopData.RulesHistory))
+	if applyErr != nil {
+		importLoopData.InfoApi.Error("unfortunately the rule couldn't be applied. Reverting changes...")
+		if err := RevertFailedApply(importLoopData); err != nil {
+			return 0, err
+		}
+		return 0, applyErr
+	}
+
+	appliedRules.Register(importLoopData.RulesHistory, revertNum)
+	if err := appliedRules.Save(); err != nil {
+		return revertNum, err
+	}
+	return revertNum, ExecuteDaemonOperations(importLoopData, revertNum)
+}
+
+// RevertFailedApply reverts files of the changes which couldn't be applied
+// and packages changed by the transaction executed before them
+func RevertFailedApply(importLoopData *shared.ImportLoopData) error {
+	err := importLoopData.VRCT.Revert()
+	packageChanges := importLoopData.VRCT.Fs.PackageChanges()
+	return errors.Join(err, RevertPackageChanges(importLoopData.InfoApi, getManagedRoot(importLoopData), packageChanges))
+}
+
+func GetRulesToRevert(rulesHistory shared.RulesHistory) []vrctFs.Rule {
+	var rulesToRevert []vrctFs.Rule
+	for _, rule := range rulesHistory {
+		rulesToRevert = append(rulesToRevert, vrctFs.Rule{
+			Url:          rule.Url,
+			NameOrScript: rule.NameOrScript,
+			IsScript:     rule.IsScript,
+		})
+	}
+	return rulesToRevert
+}
+
+// RevertChanges reverts changes applied together with the given revert number.
+// Package and daemon apis operate on the root in which the changes were applied until it finishes
+func RevertChanges(infoApi shared.InfoInterface, revertNum int) (RevertReport, error) {
+	revertSteps, changedFiles, err := revertFiles(revertNum)
+	if err != nil {
+		return RevertReport{}, err
+	}
+	defer func() {
+		_ = revertSteps.DeleteRuntimeTemp()
+	}()
+
+	previousPackageManager, previousInitManager := api.PackageManagerBackend, api.InitManagerBackend
+	previousUserInitManager, previousPlatform := api.UserInitManagerBackend, api.PlatformBackend
+	api.UseRoot(revertSteps.Root)
+	defer func() {
+		api.PackageManagerBackend, api.InitManagerBackend = previousPackageManager, previousInitManager
+		api.UserInitManagerBackend, api.PlatformBackend = previousUserInitManager, previousPlatform
+	}()
+
+	if err := revertSteps.RevertRules(GetRevertRuleFn(infoApi)); err != nil {
+		return RevertReport{}, err
+	}
+	if err := ReloadRevertedUnits(infoApi, revertSteps.Root, revertSteps.UnitChanges); err != nil {
+		return RevertReport{}, err
+	}
+	// Daemons are restored before their packages are removed and once again after removed packages are installed
+	if err := RevertDaemonChanges(infoApi, revertSteps.Root, revertSteps.DaemonChanges); err != nil {
+		return RevertReport{}, err
+	}
+	if err := RevertPackageChanges(infoApi, revertSteps.Root, revertSteps.PackageChanges); err != nil {
+		return RevertReport{}, err
+	}
+	if len(revertSteps.PackageChanges) > 0 {
+		if err := RevertDaemonChanges(infoApi, revertSteps.Root, revertSteps.DaemonChanges); err != nil {
+			return RevertReport{}, err
+		}
+	}
+	if err := ForgetRevertedRules(revertNum); err != nil {
+		return RevertReport{}, err
+	}
+	if err := forgetRevertedEnvironment(revertNum); err != nil {
+		return RevertReport{}, err
+	}
+
+	return RevertReport{
+		RevertNumber:   revertNum,
+		Rules:          revertSteps.RulesToRevert,
+		ChangedFiles:   changedFiles,
+		PackageChanges: revertSteps.PackageChanges,
+		DaemonChanges:  revertSteps.DaemonChanges,
+		UnitChanges:    revertSteps.UnitChanges,
+	}, nil
+}
+
+// revertFiles restores the files and returns revert steps with the rules, which have to be reverted.
+// Changes applied by the privileged helper are reverted by it as well
+func revertFiles(revertNum int) (vrctFs.RevertSteps, []string, error) {
+	revertSteps, err := vrctFs.NewRevertSteps()
+	if err != nil {
+		return revertSteps, nil, err
+	}
+
+	isPrivileged := false
+	if PrivilegedHelper != nil {
+		isPrivileged, err = privileged.IsRevertPrivileged(revertNum)
+		if err != nil {
+			return revertSteps, nil, err
+		}
+	}
+
+	if isPrivileged {
+		response, err := PrivilegedHelper.RevertFiles(revertNum)
+		if err != nil {
+			return revertSteps, nil, err
+		}
+		revertSteps.RulesToRevert, revertSteps.Root = response.Rules, response.Root
+		revertSteps.PackageChanges, revertSteps.DaemonChanges = response.PackageChanges, response.DaemonChanges
+		revertSteps.UnitChanges = response.UnitChanges
+		return revertSteps, response.ChangedFiles, nil
+	}
+
+	if err := revertSteps.Deserialize(revertNum); err != nil {
+		return revertSteps, nil, err
+	}
+	return revertSteps, revertSteps.ChangedFiles(), revertSteps.RevertFiles()
+}
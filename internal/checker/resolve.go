@@ -3,6 +3,7 @@ package checker
 import (
 	"errors"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"os"
 	"path/filepath"
 )
@@ -21,36 +22,95 @@ func getScript(ruleSetLocation *RulesetLocation, ruleName string) (string, error
 	return string(script), nil
 }
 
+// FetchRuleset downloads or updates ruleset and verifies who signed it. The fetched revision is checked out
+// only after it has been verified, so unverified rules never get into the working tree
 func FetchRuleset(rulesetLocation *RulesetLocation) error {
-	err := rulesetLocation.CreateDir()
+	repo, isCloned, err := fetchRuleset(rulesetLocation)
+	var fetchedHash plumbing.Hash
+	if err == nil {
+		fetchedHash, err = getFetchedRevision(repo, isCloned)
+	}
+	if err == nil {
+		err = verifyRulesetSignature(rulesetLocation, repo, fetchedHash)
+	}
+
+	if err != nil && isCloned {
+		// Otherwise the ruleset would be treated as downloaded
+		return errors.Join(err, os.RemoveAll(rulesetLocation.GetRulesetPath()))
+	}
+	if err != nil {
+		return err
+	}
+	return checkoutRuleset(repo, fetchedHash)
+}
+
+// prepareRuleset fetches the ruleset if it hasn't been downloaded yet, otherwise it verifies its current revision
+func prepareRuleset(rulesetLocation *RulesetLocation) error {
+	if !rulesetLocation.IsRuleSetDownloaded() {
+		return FetchRuleset(rulesetLocation)
+	}
+
+	repo, err := git.PlainOpen(rulesetLocation.GetRulesetPath())
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
 	if err != nil {
 		return err
 	}
+	return verifyRulesetSignature(rulesetLocation, repo, head.Hash())
+}
+
+// fetchRuleset clones the ruleset without checking it out or fetches its remote branch,
+// the returned bool is true if the ruleset has been cloned
+func fetchRuleset(rulesetLocation *RulesetLocation) (*git.Repository, bool, error) {
+	err := rulesetLocation.CreateDir()
+	if err != nil {
+		return nil, false, err
+	}
 
 	fullRulesetUrl := *rulesetLocation.GetFullUrl()
 
-	_, err = git.PlainClone(rulesetLocation.GetRulesetPath(), false, &git.CloneOptions{
-		URL: fullRulesetUrl,
+	repo, err := git.PlainClone(rulesetLocation.GetRulesetPath(), false, &git.CloneOptions{
+		URL:        fullRulesetUrl,
+		NoCheckout: true,
 	})
+	if !errors.Is(err, git.ErrRepositoryAlreadyExists) {
+		return repo, true, err
+	}
 
-	if errors.Is(err, git.ErrRepositoryAlreadyExists) {
-		repo, err := git.PlainOpen(rulesetLocation.GetRulesetPath())
-		if err != nil {
-			return err
-		}
+	repo, err = git.PlainOpen(rulesetLocation.GetRulesetPath())
+	if err != nil {
+		return nil, false, err
+	}
+	// We force fetch because nobody should modify by themselves rulesets in their spito directory
+	err = repo.Fetch(&git.FetchOptions{Force: true, RemoteURL: fullRulesetUrl})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil, false, err
+	}
+	return repo, false, nil
+}
 
-		worktree, err := repo.Worktree()
-		if err != nil {
-			return err
-		}
+// getFetchedRevision returns the cloned revision or the fetched revision of the checked out branch
+func getFetchedRevision(repo *git.Repository, isCloned bool) (plumbing.Hash, error) {
+	head, err := repo.Head()
+	if err != nil || isCloned {
+		return head.Hash(), err
+	}
 
-		// We force pull because nobody should modify by themselves rulesets in their spito directory
-		err = worktree.Pull(&git.PullOptions{Force: true, RemoteURL: fullRulesetUrl})
-		if errors.Is(err, git.NoErrAlreadyUpToDate) {
-			return nil
-		}
+	remoteBranch := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, head.Name().Short())
+	fetchedRef, err := repo.Reference(remoteBranch, true)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return fetchedRef.Hash(), nil
+}
 
+// checkoutRuleset moves the checked out branch to the verified revision and updates the working tree
+func checkoutRuleset(repo *git.Repository, hash plumbing.Hash) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
 		return err
 	}
-	return err
+	return worktree.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset})
 }
@@ -0,0 +1,173 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yuin/gopher-lua"
+	"strings"
+)
+
+type RuleStatus uint
+
+const (
+	RuleFailed RuleStatus = iota
+	RulePassed
+	// RuleNotApplicable means that the rule targets other platform, so it wasn't executed at all
+	RuleNotApplicable
+	// RuleSkipped is returned by the rule, which decided it doesn't have to be applied
+	RuleSkipped
+	// RuleAlreadySatisfied is returned by the rule, which found that the system already is in the desired state
+	RuleAlreadySatisfied
+)
+
+// luaStatuses are names of statuses used by rules and in the json output
+var luaStatuses = map[RuleStatus]string{
+	RuleFailed:           "fail",
+	RulePassed:           "pass",
+	RuleNotApplicable:    "not_applicable",
+	RuleSkipped:          "skip",
+	RuleAlreadySatisfied: "noop",
+}
+
+func (s RuleStatus) String() string {
+	switch s {
+	case RulePassed:
+		return "passed"
+	case RuleNotApplicable:
+		return "not applicable"
+	case RuleSkipped:
+		return "skipped"
+	case RuleAlreadySatisfied:
+		return "already satisfied"
+	}
+	return "failed"
+}
+
+func (s RuleStatus) MarshalText() ([]byte, error) {
+	return []byte(luaStatuses[s]), nil
+}
+
+func (s *RuleStatus) UnmarshalText(text []byte) error {
+	status, err := parseRuleStatus(string(text))
+	*s = status
+	return err
+}
+
+func parseRuleStatus(name string) (RuleStatus, error) {
+	for status, statusName := range luaStatuses {
+		if statusName == strings.ToLower(name) {
+			return status, nil
+		}
+	}
+	return RuleFailed, fmt.Errorf("unknown rule status: '%s', use pass, fail, skip or noop", name)
+}
+
+// IsSuccessful returns true if the rule doesn't prevent the rules depending on it from being applied
+func (s RuleStatus) IsSuccessful() bool {
+	return s != RuleFailed
+}
+
+// RuleResult is returned by the rule's main function, either as a boolean or as a table
+// with status, message and data fields
+type RuleResult struct {
+	Status  RuleStatus `json:"status"`
+	Message string     `json:"message,omitempty"`
+	Data    any        `json:"data,omitempty"`
+}
+
+func (r RuleResult) String() string {
+	if r.Message == "" {
+		return r.Status.String()
+	}
+	return fmt.Sprintf("%s: %s", r.Status.String(), r.Message)
+}
+
+func (r RuleResult) Json() (string, error) {
+	result, err := json.Marshal(r)
+	return string(result), err
+}
+
+func ruleResultFromBool(doesRulePass bool) RuleResult {
+	if doesRulePass {
+		return RuleResult{Status: RulePassed}
+	}
+	return RuleResult{Status: RuleFailed}
+}
+
+// getRuleResult converts values returned by the lua function. Supported forms are:
+// `return true`, `return false, "message"` and `return { status = "noop", message = "...", data = {...} }`
+func getRuleResult(value lua.LValue, message lua.LValue) (RuleResult, error) {
+	switch value := value.(type) {
+	case lua.LBool:
+		result := ruleResultFromBool(bool(value))
+		if message.Type() == lua.LTString {
+			result.Message = message.String()
+		}
+		return result, nil
+	case *lua.LTable:
+		status, err := parseRuleStatus(lua.LVAsString(value.RawGetString("status")))
+		if err != nil {
+			return RuleResult{}, err
+		}
+		return RuleResult{
+			Status:  status,
+			Message: lua.LVAsString(value.RawGetString("message")),
+			Data:    luaToGo(value.RawGetString("data")),
+		}, nil
+	}
+
+	return RuleResult{}, fmt.Errorf("rule has to return boolean or result table, but it returned %s", value.Type().String())
+}
+
+// luaToGo converts lua value into the value which can be serialized to json.
+// Tables with consecutive integer keys starting from 1 are converted to slices
+func luaToGo(value lua.LValue) any {
+	switch value := value.(type) {
+	case lua.LBool:
+		return bool(value)
+	case lua.LNumber:
+		return float64(value)
+	case lua.LString:
+		return string(value)
+	case *lua.LTable:
+		if length := value.Len(); length > 0 {
+			list := make([]any, 0, length)
+			for i := 1; i <= length; i++ {
+				list = append(list, luaToGo(value.RawGetInt(i)))
+			}
+			return list
+		}
+
+		table := make(map[string]any)
+		value.ForEach(func(key lua.LValue, fieldValue lua.LValue) {
+			table[key.String()] = luaToGo(fieldValue)
+		})
+		return table
+	}
+	return nil
+}
+
+func getResultNamespace(L *lua.LState) lua.LValue {
+	resultNamespace := newLuaNamespace()
+
+	for status, statusName := range luaStatuses {
+		if status == RuleNotApplicable {
+			continue
+		}
+		resultNamespace.AddField(statusName, L.NewFunction(newResultConstructor(statusName)))
+	}
+
+	return resultNamespace.createTable(L)
+}
+
+// newResultConstructor creates the function returning result table, e.g. api.result.noop("already installed")
+func newResultConstructor(statusName string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		result := L.NewTable()
+		result.RawSetString("status", lua.LString(statusName))
+		result.RawSetString("message", L.Get(1))
+		result.RawSetString("data", L.Get(2))
+		L.Push(result)
+		return 1
+	}
+}
@@ -2,7 +2,6 @@ package checker
 
 import (
 	"errors"
-	"github.com/avorty/spito/pkg/path"
 	"github.com/avorty/spito/pkg/shared"
 	"github.com/avorty/spito/pkg/vrct"
 	"github.com/avorty/spito/pkg/vrct/vrctFs"
@@ -13,41 +12,22 @@ func GetRevertRuleFn(infoApi shared.InfoInterface) func(rule vrctFs.Rule) error
 	return func(rule vrctFs.Rule) error {
 		importLoopData := shared.ImportLoopData{
 			VRCT:         vrct.RuleVRCT{},
-			InfoApi:      infoApi,
+			InfoApi:      shared.WithRuleContext(infoApi, getRuleContext(rule.Url, rule.NameOrScript, rule.IsScript)),
 			RulesHistory: make(shared.RulesHistory),
 			ErrChan:      make(chan error),
+			CurrentRule:  rule,
 		}
 
-		isPath, err := path.PathExists(rule.Url)
-		if err != nil || rule.Url == "" {
-			isPath = false
-		}
-
-		rulesetLocation, err := NewRulesetLocation(rule.Url, isPath)
+		script, ruleConfigLayout, err := getHistoryRule(rule)
 		if err != nil {
 			return err
 		}
-		var script string
-		if rule.IsScript {
-			script = rule.NameOrScript
-		} else {
-			script, err = getScript(&rulesetLocation, rule.NameOrScript)
-			if err != nil {
-				return err
-			}
-		}
 
 		cwd, err := os.Getwd()
 		if err != nil {
 			return err
 		}
 
-		ruleConfigLayout := shared.RuleConfigLayout{Path: cwd}
-		script, err = processScript(script, &ruleConfigLayout)
-		if err != nil {
-			return err
-		}
-
 		// TODO: Passing here cwd is not the best idea
 		L, err := GetLuaState(script, &importLoopData, &ruleConfigLayout, cwd)
 		if err != nil {
@@ -72,9 +52,10 @@ func GetRevertRuleFnFromScript(infoApi shared.InfoInterface) func(rule vrctFs.Ru
 	return func(rule vrctFs.Rule) error {
 		importLoopData := shared.ImportLoopData{
 			VRCT:         vrct.RuleVRCT{},
-			InfoApi:      infoApi,
+			InfoApi:      shared.WithRuleContext(infoApi, getRuleContext(rule.Url, rule.NameOrScript, true)),
 			RulesHistory: make(shared.RulesHistory),
 			ErrChan:      make(chan error),
+			CurrentRule:  rule,
 		}
 
 		script := rule.NameOrScript
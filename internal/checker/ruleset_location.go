@@ -55,18 +55,22 @@ func NewRulesetLocation(identifierOrPath string, isPath bool) (RulesetLocation,
 		return r, nil
 	}
 
-	// check if simpleUrlOrPath is url:
-	if !strings.Contains(identifierOrPath, ".") {
-		simpleUrl := GetDefaultRepoPrefix() + "/" + identifierOrPath
-		simpleUrl = strings.ToLower(simpleUrl)
+	r.simpleUrlOrPath = NormalizeIdentifier(identifierOrPath)
 
-		r.simpleUrlOrPath = simpleUrl
+	err := FetchRuleset(&r)
+	return r, err
+}
 
-		err := FetchRuleset(&r)
-		return r, err
+// NormalizeIdentifier converts git identifier or url into the form used by RulesetLocation,
+// e.g. both avorty/spito-ruleset and https://github.com/avorty/spito-ruleset.git into github.com/avorty/spito-ruleset
+func NormalizeIdentifier(identifier string) string {
+	// check if identifier is url:
+	if !strings.Contains(identifier, ".") {
+		simpleUrl := GetDefaultRepoPrefix() + "/" + identifier
+		return strings.ToLower(simpleUrl)
 	}
 
-	simpleUrl := identifierOrPath
+	simpleUrl := identifier
 	simpleUrl = strings.ReplaceAll(simpleUrl, "https://", "")
 	simpleUrl = strings.ReplaceAll(simpleUrl, "http://", "")
 	simpleUrl = strings.ReplaceAll(simpleUrl, "www.", "")
@@ -80,10 +84,7 @@ func NewRulesetLocation(identifierOrPath string, isPath bool) (RulesetLocation,
 		simpleUrl = simpleUrl[:urlLen-4]
 	}
 
-	r.simpleUrlOrPath = strings.ToLower(simpleUrl)
-
-	err := FetchRuleset(&r)
-	return r, err
+	return strings.ToLower(simpleUrl)
 }
 
 func (r *RulesetLocation) GetIdentifier() string {
@@ -122,15 +123,8 @@ func (r *RulesetLocation) IsRuleSetDownloaded() bool {
 func InstallDependency(ruleIdentifier string, waitGroup *sync.WaitGroup, errChan chan error) {
 	var err error
 	defer waitGroup.Done()
-	dependencyLocation, err := NewRulesetLocation(strings.Split(ruleIdentifier, "@")[0], false)
-	if err != nil {
-		errChan <- err
-		panic(nil)
-	}
-
-	if !dependencyLocation.IsRuleSetDownloaded() {
-		err = FetchRuleset(&dependencyLocation)
-	}
+	// NewRulesetLocation fetches the dependency and verifies the fetched revision before it's checked out
+	_, err = NewRulesetLocation(strings.Split(ruleIdentifier, "@")[0], false)
 	if err != nil {
 		errChan <- err
 		panic(nil)
@@ -219,14 +213,5 @@ func (r *RulesetLocation) createLockfile(errChan chan error) error {
 	}
 
 	_, err = lockfile.Write(yamlOutput)
-	if err != nil {
-		return err
-	}
-
-	_, err = lockfile.Write(yamlOutput)
-	if err != nil {
-		return nil
-	}
-
-	return nil
+	return err
 }
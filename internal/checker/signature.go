@@ -0,0 +1,91 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/trust"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"os"
+	"sync"
+)
+
+var (
+	ErrSigningKeyChanged = errors.New("signing key of the ruleset has changed")
+	ErrUntrustedRuleset  = errors.New("ruleset isn't signed by any key you have trusted")
+)
+
+// Rulesets are fetched concurrently while creating lockfiles, so access to the trust store has to be synchronized
+var trustStoreMutex sync.Mutex
+
+// FetchInfoApi shows warnings about signatures of fetched rulesets. Rulesets are fetched before any rule
+// is executed, so commands set it instead of passing their InfoApi through every fetch
+var FetchInfoApi shared.InfoInterface
+
+// verifyRulesetSignature checks who signed the revision of the ruleset.
+// Until the user trusts any key using `spito trust add`, the first key which signed the ruleset
+// is trusted and pinned (trust on first use), so every later revision has to be signed by the same key.
+// Once there are keys added by the user, rulesets have to be signed by one of them
+func verifyRulesetSignature(rulesetLocation *RulesetLocation, repo *git.Repository, revision plumbing.Hash) error {
+	trustStoreMutex.Lock()
+	defer trustStoreMutex.Unlock()
+
+	identifier := rulesetLocation.GetIdentifier()
+
+	store, err := trust.LoadStore(trust.DefaultStorePath)
+	if err != nil {
+		return err
+	}
+	pinnedFingerprint, isPinned := store.GetPin(identifier)
+	requiresExplicitKey := store.HasExplicitKeys()
+
+	signerKey, err := trust.VerifyCommit(repo, revision, store)
+	if errors.Is(err, trust.ErrUnsigned) || errors.Is(err, trust.ErrUnknownSigner) {
+		if isPinned {
+			return fmt.Errorf("ruleset %s is pinned to the key %s, but its current %s", identifier, pinnedFingerprint, err.Error())
+		}
+		if requiresExplicitKey {
+			return fmt.Errorf("%w: %s %s\nIf you trust its author, add the key using: spito trust add --ruleset %s {key_file}",
+				ErrUntrustedRuleset, identifier, err.Error(), identifier)
+		}
+		printWarning(fmt.Sprintf("cannot verify ruleset %s: %s", identifier, err.Error()))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("verification of ruleset %s failed: %w", identifier, err)
+	}
+
+	if isPinned {
+		if pinnedFingerprint != signerKey.Fingerprint {
+			return fmt.Errorf("%w: %s was signed by %s, but now it's signed by %s\n"+
+				"If you trust the new key, unpin the old one using: spito trust remove --ruleset %s",
+				ErrSigningKeyChanged, identifier, pinnedFingerprint, signerKey.Fingerprint, identifier)
+		}
+		return nil
+	}
+
+	if requiresExplicitKey {
+		trustedKey, err := store.FindKey(signerKey.Fingerprint)
+		if err != nil || !trustedKey.IsExplicit {
+			return fmt.Errorf("%w: %s is signed by %s key %s\nIf you trust it, add the key using: spito trust add --ruleset %s {key_file}",
+				ErrUntrustedRuleset, identifier, signerKey.Type, signerKey.Fingerprint, identifier)
+		}
+		store.Pin(identifier, signerKey.Fingerprint)
+		return store.Save()
+	}
+
+	printWarning(fmt.Sprintf("trusting %s key %s for ruleset %s on first use", signerKey.Type, signerKey.Fingerprint, identifier))
+	store.Trust(signerKey)
+	store.Pin(identifier, signerKey.Fingerprint)
+	return store.Save()
+}
+
+// printWarning falls back to stderr when the command hasn't set FetchInfoApi, e.g. in tests
+func printWarning(message string) {
+	if FetchInfoApi != nil {
+		FetchInfoApi.Warn(message)
+		return
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "[warn]", message)
+}
@@ -0,0 +1,231 @@
+package checker
+
+import (
+	"fmt"
+	"github.com/avorty/spito/pkg/path"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/shared/option"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// RuleSummary describes what a rule is going to do before it is executed
+type RuleSummary struct {
+	Identifier   string          `json:"identifier"`
+	Name         string          `json:"name"`
+	Description  string          `json:"description"`
+	Unsafe       bool            `json:"unsafe"`
+	Sudo         bool            `json:"sudo"`
+	Environment  bool            `json:"environment"`
+	Options      []option.Option `json:"options"`
+	Dependencies []string        `json:"dependencies"`
+	Conflicts    []string        `json:"conflicts"`
+	Replaces     []string        `json:"replaces"`
+	// AppliedConflicts are already applied rules, which have to be reverted before executing the rule
+	AppliedConflicts []RuleConflict `json:"appliedConflicts"`
+	// UnsafeDependencies and SudoDependencies are rules from the whole dependency tree,
+	// which are unsafe or require root privileges, even if the rule itself doesn't
+	UnsafeDependencies []string `json:"unsafeDependencies"`
+	SudoDependencies   []string `json:"sudoDependencies"`
+}
+
+// RequiresConfirmation returns true if rule can do something potentially dangerous,
+// so user should explicitly agree to execute it
+func (s RuleSummary) RequiresConfirmation() bool {
+	return s.Unsafe || s.Sudo || len(s.UnsafeDependencies) > 0 || len(s.SudoDependencies) > 0 ||
+		len(s.AppliedConflicts) > 0
+}
+
+func GetRuleSummary(rulesetLocation *RulesetLocation, ruleName string, userOptions []string) (RuleSummary, error) {
+	rulesetConf, err := GetRulesetConf(rulesetLocation)
+	if err != nil {
+		return RuleSummary{}, err
+	}
+
+	ruleConf, err := rulesetConf.GetRuleConf(ruleName)
+	if err != nil {
+		return RuleSummary{}, err
+	}
+
+	script, err := getScript(rulesetLocation, ruleName)
+	if err != nil {
+		return RuleSummary{}, err
+	}
+
+	summary, err := summarizeScript(script, &ruleConf, userOptions)
+	if err != nil {
+		return summary, err
+	}
+	summary.Identifier = rulesetLocation.GetIdentifier()
+	summary.Name = ruleName
+
+	summary.Dependencies, err = getRuleDependencies(rulesetLocation, rulesetConf, ruleName)
+	if err != nil {
+		return summary, err
+	}
+	if err := summary.summarizeDependencies(); err != nil {
+		return summary, err
+	}
+
+	err = summary.findAppliedConflicts(&ruleConf, false)
+	return summary, err
+}
+
+func GetScriptSummary(scriptPath string, userOptions []string) (RuleSummary, error) {
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return RuleSummary{}, err
+	}
+	return GetScriptContentSummary(string(script), scriptPath, userOptions)
+}
+
+// GetScriptContentSummary summarizes the script, which has been already read from scriptPath
+func GetScriptContentSummary(script string, scriptPath string, userOptions []string) (RuleSummary, error) {
+	ruleConf := shared.RuleConfigLayout{Path: scriptPath}
+	summary, err := summarizeScript(script, &ruleConf, userOptions)
+	if err != nil {
+		return summary, err
+	}
+	summary.Identifier = filepath.Dir(scriptPath)
+	summary.Name = filepath.Base(scriptPath)
+
+	err = summary.findAppliedConflicts(&ruleConf, true)
+	return summary, err
+}
+
+// findAppliedConflicts finds applied rules conflicting with the summarized one, conflicts of dependencies
+// are known only after executing them, so they are checked before applying changes
+func (s *RuleSummary) findAppliedConflicts(ruleConf *shared.RuleConfigLayout, isScript bool) error {
+	s.Conflicts = normalizeRuleReferences(ruleConf.Conflicts, s.Identifier)
+	s.Replaces = normalizeRuleReferences(ruleConf.Replaces, s.Identifier)
+
+	appliedRules, err := ReadAppliedRules()
+	if err != nil {
+		return err
+	}
+
+	rulesHistory := shared.RulesHistory{}
+	rulesHistory.Push(s.Identifier, s.Name, false, isScript)
+	rulesHistory.SetRelations(s.Identifier, s.Name, s.Conflicts, s.Replaces)
+
+	s.AppliedConflicts = appliedRules.FindConflicts(rulesHistory)
+	return nil
+}
+
+func summarizeScript(script string, ruleConf *shared.RuleConfigLayout, userOptions []string) (RuleSummary, error) {
+	_, decorators, err := GetDecorators(script)
+	if err != nil {
+		return RuleSummary{}, err
+	}
+
+	if _, err := processScript(script, ruleConf); err != nil {
+		return RuleSummary{}, err
+	}
+
+	options, err := option.Compare(userOptions, ruleConf.Options)
+	if err != nil {
+		return RuleSummary{}, err
+	}
+
+	description := ruleConf.Description
+	if description == "" {
+		description = getDescriptionFromDecorators(decorators)
+	}
+
+	return RuleSummary{
+		Description: description,
+		Unsafe:      ruleConf.Unsafe,
+		Sudo:        ruleConf.Sudo,
+		Environment: ruleConf.Environment,
+		Options:     options,
+	}, nil
+}
+
+// getDescriptionFromDecorators returns description only if it's written directly inside decorator
+func getDescriptionFromDecorators(decorators []RawDecorator) string {
+	descriptionIndex := slices.IndexFunc(decorators, func(decorator RawDecorator) bool {
+		return decorator.Type == DescriptionDecorator
+	})
+	if descriptionIndex == -1 {
+		return ""
+	}
+
+	positionalArguments, _, err := GetDecoratorArguments(decorators[descriptionIndex].Content)
+	if err != nil || len(positionalArguments) == 0 {
+		return ""
+	}
+	return positionalArguments[0]
+}
+
+// summarizeDependencies walks the dependency tree and finds dependencies which are unsafe or require root privileges.
+// Rulesets of dependencies which aren't downloaded yet are fetched, checking the rule would fetch them anyway
+func (s *RuleSummary) summarizeDependencies() error {
+	visitedDependencies := make(map[string]bool)
+	dependencies := slices.Clone(s.Dependencies)
+
+	for len(dependencies) > 0 {
+		dependency := dependencies[0]
+		dependencies = dependencies[1:]
+		if visitedDependencies[dependency] {
+			continue
+		}
+		visitedDependencies[dependency] = true
+
+		rulesetIdentifier, ruleName, _ := strings.Cut(dependency, "@")
+		rulesetLocation := RulesetLocation{simpleUrlOrPath: NormalizeIdentifier(rulesetIdentifier)}
+		if err := prepareRuleset(&rulesetLocation); err != nil {
+			return fmt.Errorf("cannot fetch the dependency %s: %w", dependency, err)
+		}
+
+		rulesetConf, err := GetRulesetConf(&rulesetLocation)
+		if err != nil {
+			return err
+		}
+		ruleConf, err := rulesetConf.GetRuleConf(ruleName)
+		if err != nil {
+			return err
+		}
+		script, err := getScript(&rulesetLocation, ruleName)
+		if err != nil {
+			return err
+		}
+		if _, err := processScript(script, &ruleConf); err != nil {
+			return fmt.Errorf("cannot process the dependency %s: %w", dependency, err)
+		}
+
+		if ruleConf.Unsafe {
+			s.UnsafeDependencies = append(s.UnsafeDependencies, dependency)
+		}
+		if ruleConf.Sudo {
+			s.SudoDependencies = append(s.SudoDependencies, dependency)
+		}
+
+		nestedDependencies, err := getRuleDependencies(&rulesetLocation, rulesetConf, ruleName)
+		if err != nil {
+			return err
+		}
+		dependencies = append(dependencies, nestedDependencies...)
+	}
+	return nil
+}
+
+// getRuleDependencies prefers already resolved dependency tree from the lockfile,
+// if it doesn't exist yet, it returns only direct dependencies from the config file
+func getRuleDependencies(rulesetLocation *RulesetLocation, rulesetConf shared.ConfigFileLayout, ruleName string) ([]string, error) {
+	lockfileExists, err := path.PathExists(filepath.Join(rulesetLocation.GetRulesetPath(), shared.LockFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	if !lockfileExists {
+		return rulesetConf.Dependencies[ruleName], nil
+	}
+
+	dependencyTree, err := rulesetLocation.getLockfileTree()
+	if err != nil {
+		return nil, err
+	}
+	return dependencyTree.Dependencies[ruleName], nil
+}
@@ -0,0 +1,54 @@
+package checker
+
+import (
+	"fmt"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/shared"
+	"strings"
+)
+
+// getNotApplicableReason returns empty string if the rule can be executed on the current platform
+func getNotApplicableReason(ruleConf *shared.RuleConfigLayout) (string, error) {
+	if len(ruleConf.Distros) == 0 && len(ruleConf.InitSystems) == 0 && len(ruleConf.Architectures) == 0 {
+		return "", nil
+	}
+
+	platform, err := api.PlatformBackend()
+	if err != nil {
+		return "", err
+	}
+
+	distroNames := append([]string{platform.Distro}, platform.DistroLike...)
+	if !matchesAnyTarget(ruleConf.Distros, distroNames...) {
+		return fmt.Sprintf("rule targets %s distros, but the system is %s", strings.Join(ruleConf.Distros, ", "), getPlatformValue(platform.Distro)), nil
+	}
+	if !matchesAnyTarget(ruleConf.InitSystems, string(platform.InitSystem)) {
+		return fmt.Sprintf("rule targets %s init systems, but the system uses %s", strings.Join(ruleConf.InitSystems, ", "), getPlatformValue(string(platform.InitSystem))), nil
+	}
+	if !matchesAnyTarget(ruleConf.Architectures, platform.Arch) {
+		return fmt.Sprintf("rule targets %s architectures, but the system is %s", strings.Join(ruleConf.Architectures, ", "), getPlatformValue(platform.Arch)), nil
+	}
+
+	return "", nil
+}
+
+func matchesAnyTarget(targets []string, values ...string) bool {
+	if len(targets) == 0 {
+		return true
+	}
+	for _, target := range targets {
+		for _, value := range values {
+			if value != "" && strings.EqualFold(target, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getPlatformValue(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/pkg/shared"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const conflictsConfig = `repo_url: github.com/avorty/desktop-ruleset
+identifier: desktop-ruleset
+rules:
+  gdm:
+    path: ./rules/gdm.lua
+  sddm:
+    path: ./rules/sddm.lua
+    replaces:
+      - lightdm
+`
+
+func useTemporaryAppliedRules(t *testing.T) {
+	previousPath := checker.AppliedRulesPath
+	checker.AppliedRulesPath = filepath.Join(t.TempDir(), "applied-rules.json")
+	t.Cleanup(func() {
+		checker.AppliedRulesPath = previousPath
+	})
+}
+
+func TestAppliedRulesConflicts(t *testing.T) {
+	useTemporaryAppliedRules(t)
+
+	appliedRules, err := checker.ReadAppliedRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appliedHistory := shared.RulesHistory{}
+	appliedHistory.Push("github.com/avorty/kde", "sddm", false, false)
+	appliedHistory.SetRelations("github.com/avorty/kde", "sddm", []string{"github.com/avorty/gnome@gdm"}, nil)
+	appliedRules.Register(appliedHistory, 4)
+	if err := appliedRules.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	appliedRules, err = checker.ReadAppliedRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newHistory := shared.RulesHistory{}
+	newHistory.Push("github.com/avorty/gnome", "gdm", false, false)
+	conflicts := appliedRules.FindConflicts(newHistory)
+
+	if len(conflicts) != 1 || conflicts[0].AppliedRevertNum != 4 || conflicts[0].IsReplacement {
+		t.Fatalf("expected conflict with applied sddm, got: %+v", conflicts)
+	}
+	if conflicts[0].OtherRule != "github.com/avorty/kde@sddm" {
+		t.Fatalf("unexpected conflicting rule: %s", conflicts[0].OtherRule)
+	}
+
+	appliedRules.RemoveReverted(4)
+	if conflicts := appliedRules.FindConflicts(newHistory); len(conflicts) != 0 {
+		t.Fatalf("reverted rule shouldn't conflict, got: %+v", conflicts)
+	}
+}
+
+func TestSummaryFindsAppliedConflicts(t *testing.T) {
+	useTemporaryAppliedRules(t)
+
+	rulesetPath := t.TempDir()
+	files := map[string]string{
+		shared.ConfigFilename: conflictsConfig,
+		"rules/gdm.lua":       "#![conflicts(sddm)]\nfunction main() return true end\n",
+		"rules/sddm.lua":      "function main() return true end\n",
+	}
+	for name, content := range files {
+		filePath := filepath.Join(rulesetPath, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rulesetLocation, err := checker.NewRulesetLocation(rulesetPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appliedRules, err := checker.ReadAppliedRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	appliedHistory := shared.RulesHistory{}
+	appliedHistory.Push(rulesetLocation.GetIdentifier(), "sddm", false, false)
+	appliedHistory.Push("github.com/avorty/xfce", "lightdm", false, false)
+	appliedRules.Register(appliedHistory, 7)
+	if err := appliedRules.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := checker.GetRuleSummary(&rulesetLocation, "gdm", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.AppliedConflicts) != 1 || summary.AppliedConflicts[0].OtherRule != rulesetLocation.GetIdentifier()+"@sddm" {
+		t.Fatalf("expected conflict with applied sddm, got: %+v", summary.AppliedConflicts)
+	}
+	if !summary.RequiresConfirmation() {
+		t.Fatal("conflicting rule should require confirmation")
+	}
+
+	summary, err = checker.GetRuleSummary(&rulesetLocation, "sddm", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Replaces) != 1 || summary.Replaces[0] != rulesetLocation.GetIdentifier()+"@lightdm" {
+		t.Fatalf("replaces from config weren't normalized: %+v", summary.Replaces)
+	}
+}
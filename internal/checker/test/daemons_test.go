@@ -0,0 +1,335 @@
+package test
+
+import (
+	"github.com/avorty/spito/cmd/cmdApi"
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/internal/tester"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/package_conflict"
+	"github.com/avorty/spito/pkg/vrct"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const daemonsScript = `
+function main()
+    api.daemon.enable("sshd")
+    api.daemon.start("sshd")
+    api.daemon.stop("sddm")
+    api.daemon.disable("sddm")
+    return true
+end
+`
+
+func useInitManager(t *testing.T, initManager api.InitManager) {
+	previousInitManager := api.InitManagerBackend
+	api.InitManagerBackend = initManager
+	t.Cleanup(func() {
+		api.InitManagerBackend = previousInitManager
+	})
+}
+
+func TestRevertDaemonChanges(t *testing.T) {
+	initManager := tester.NewFakeInitManager()
+	initManager.Daemons["sshd"] = api.Daemon{Name: "sshd"}
+	initManager.Daemons["sddm"] = api.Daemon{Name: "sddm", IsActive: true, IsEnabled: true}
+	useInitManager(t, initManager)
+
+	importLoopData := getImportLoopData(t)
+	result, err := checker.CheckRuleScript(importLoopData, daemonsScript, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Status.IsSuccessful() {
+		t.Fatalf("rule should pass, got: %+v", result)
+	}
+	if len(initManager.Actions) != 0 {
+		t.Fatalf("daemons shouldn't be changed before the changes are applied, got: %+v", initManager.Actions)
+	}
+	if err := importLoopData.ExecuteDeferredDaemonOperations(); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := importLoopData.VRCT.Fs.DaemonChanges()
+	if len(changes) != 2 {
+		t.Fatalf("only the first change of every daemon should be recorded, got: %+v", changes)
+	}
+
+	if err := checker.RevertDaemonChanges(cmdApi.InfoApi{}, "/", changes); err != nil {
+		t.Fatal(err)
+	}
+
+	if sshd := initManager.Daemons["sshd"]; sshd.IsActive || sshd.IsEnabled {
+		t.Fatalf("sshd should be stopped and disabled again, got: %+v", sshd)
+	}
+	if sddm := initManager.Daemons["sddm"]; !sddm.IsActive || !sddm.IsEnabled {
+		t.Fatalf("sddm should be started and enabled again, got: %+v", sddm)
+	}
+}
+
+const failedDaemonsScript = `
+function main()
+    assert(api.daemon.start("sshd") == nil)
+    return false
+end
+`
+
+func TestFailedRuleDoesNotChangeDaemons(t *testing.T) {
+	initManager := tester.NewFakeInitManager()
+	initManager.Daemons["sshd"] = api.Daemon{Name: "sshd"}
+	useInitManager(t, initManager)
+
+	importLoopData := getImportLoopData(t)
+	result, err := checker.CheckRuleScript(importLoopData, failedDaemonsScript, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status.IsSuccessful() {
+		t.Fatalf("rule should fail, got: %+v", result)
+	}
+	if len(initManager.Actions) != 0 {
+		t.Fatalf("daemons of the failed rule shouldn't be changed, got: %+v", initManager.Actions)
+	}
+}
+
+const unitNamesScript = `
+function main()
+    assert(api.daemon.enable("sshd") == nil)
+    assert(api.daemon.start("sshd.service") == nil)
+    return api.daemon.stop("sshd") ~= nil
+end
+`
+
+func TestDaemonNamesMatchUnitNames(t *testing.T) {
+	initManager := tester.NewFakeInitManager()
+	initManager.Daemons["sshd"] = api.Daemon{Name: "sshd"}
+	initManager.Daemons["sshd.service"] = api.Daemon{Name: "sshd.service"}
+	useInitManager(t, initManager)
+
+	importLoopData := getImportLoopData(t)
+	result, err := checker.CheckRuleScript(importLoopData, unitNamesScript, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Status.IsSuccessful() {
+		t.Fatalf("starting and stopping the same service under other name should conflict, got: %+v", result)
+	}
+	if err := importLoopData.ExecuteDeferredDaemonOperations(); err != nil {
+		t.Fatal(err)
+	}
+	if changes := importLoopData.VRCT.Fs.DaemonChanges(); len(changes) != 1 {
+		t.Fatalf("service should be recorded once under both names, got: %+v", changes)
+	}
+}
+
+const userDaemonsScript = `
+function main()
+    assert(api.daemon.start("syncthing", "user") == nil)
+    assert(api.daemon.stop("syncthing") == nil, "system daemon doesn't conflict with the user service")
+    assert(api.daemon.start("syncthing", "user") == nil)
+    assert(api.daemon.stop("syncthing", "user") ~= nil, "user service cannot be started and stopped")
+    assert(api.daemon.restart("syncthing", "user", "user") ~= nil, "only a single scope can be given")
+    return api.daemon.enable("syncthing", "session") ~= nil
+end
+`
+
+func useUserInitManager(t *testing.T, initManager api.InitManager) {
+	previousInitManager := api.UserInitManagerBackend
+	api.UserInitManagerBackend = initManager
+	t.Cleanup(func() {
+		api.UserInitManagerBackend = previousInitManager
+	})
+}
+
+func TestUserDaemons(t *testing.T) {
+	initManager, userInitManager := tester.NewFakeInitManager(), tester.NewFakeInitManager()
+	initManager.Daemons["syncthing"] = api.Daemon{Name: "syncthing", IsActive: true}
+	userInitManager.Daemons["syncthing"] = api.Daemon{Name: "syncthing"}
+	useInitManager(t, initManager)
+	useUserInitManager(t, userInitManager)
+
+	importLoopData := getImportLoopData(t)
+	result, err := checker.CheckRuleScript(importLoopData, userDaemonsScript, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Status.IsSuccessful() {
+		t.Fatalf("rule should pass, got: %+v", result)
+	}
+	if err := importLoopData.ExecuteDeferredDaemonOperations(); err != nil {
+		t.Fatal(err)
+	}
+	if syncthing := userInitManager.Daemons["syncthing"]; !syncthing.IsActive {
+		t.Fatalf("user service should be started, got: %+v", syncthing)
+	}
+
+	changes := importLoopData.VRCT.Fs.DaemonChanges()
+	if len(changes) != 2 {
+		t.Fatalf("system daemon and user service should be recorded separately, got: %+v", changes)
+	}
+	if err := checker.RevertDaemonChanges(cmdApi.InfoApi{}, "/", changes); err != nil {
+		t.Fatal(err)
+	}
+
+	if syncthing := userInitManager.Daemons["syncthing"]; syncthing.IsActive {
+		t.Fatalf("user service should be stopped again, got: %+v", syncthing)
+	}
+	if syncthing := initManager.Daemons["syncthing"]; !syncthing.IsActive {
+		t.Fatalf("system daemon should be started again, got: %+v", syncthing)
+	}
+}
+
+const unitsScript = `
+function main()
+    assert(api.daemon.createUnit("backup.service", {
+        Unit = { Description = "Backup of the home directory" },
+        Service = { Type = "oneshot", ExecStart = "/usr/bin/restic backup /home" },
+    }) == nil)
+    assert(api.daemon.createUnit("backup.timer", {
+        Timer = { OnCalendar = "daily", Persistent = true },
+        Install = { WantedBy = "timers.target" },
+    }) == nil)
+    assert(api.daemon.dropIn("sshd", "port", { Service = { ExecStart = { "", "/usr/bin/sshd -D -p 2222" } } }) == nil)
+    assert(api.daemon.createUnit("backup.timer", { Unit = {} }) ~= nil, "timer requires the Timer section")
+    return api.daemon.enable("backup.timer") == nil
+end
+`
+
+func usePlatform(t *testing.T, platform api.Platform) {
+	previousPlatform := api.PlatformBackend
+	api.PlatformBackend = func() (api.Platform, error) {
+		return platform, nil
+	}
+	t.Cleanup(func() {
+		api.PlatformBackend = previousPlatform
+	})
+}
+
+func TestUnits(t *testing.T) {
+	initManager := tester.NewFakeInitManager()
+	initManager.Daemons["backup.timer"] = api.Daemon{Name: "backup.timer"}
+	useInitManager(t, initManager)
+	usePlatform(t, api.Platform{Distro: "arch", InitSystem: api.SYSTEMD})
+
+	useTemporaryAppliedRules(t)
+
+	root := t.TempDir()
+	ruleVRCT, err := vrct.NewRuleVRCTWithRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	importLoopData := getImportLoopData(t)
+	importLoopData.VRCT = *ruleVRCT
+	importLoopData.PackageTracker = package_conflict.NewPackageConflictTracker()
+	result, err := checker.CheckRuleScript(importLoopData, unitsScript, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Status.IsSuccessful() {
+		t.Fatalf("rule should pass, got: %+v", result)
+	}
+
+	timer, err := importLoopData.VRCT.Fs.ReadFile("/etc/systemd/system/backup.timer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedTimer := "[Timer]\nOnCalendar=daily\nPersistent=true\n\n[Install]\nWantedBy=timers.target\n"
+	if string(timer) != expectedTimer {
+		t.Fatalf("unexpected timer:\n%s", timer)
+	}
+	dropIn, err := importLoopData.VRCT.Fs.ReadFile("/etc/systemd/system/sshd.service.d/port.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dropIn) != "[Service]\nExecStart=\nExecStart=/usr/bin/sshd -D -p 2222\n" {
+		t.Fatalf("unexpected drop-in:\n%s", dropIn)
+	}
+
+	if len(initManager.Actions) != 0 {
+		t.Fatalf("daemons shouldn't be changed before the units are written, got: %+v", initManager.Actions)
+	}
+	revertNum, err := checker.ApplyChanges(importLoopData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "etc/systemd/system/backup.timer")); err != nil {
+		t.Fatalf("timer should be written when the changes are applied: %s", err)
+	}
+	expectedActions := []tester.DaemonAction{{Action: "daemon-reload"}, {Action: "enable", Daemon: "backup.timer"}}
+	if !slices.Equal(initManager.Actions, expectedActions) {
+		t.Fatalf("init system should be reloaded once before the timer is enabled, got: %+v", initManager.Actions)
+	}
+
+	revertSteps, err := vrctFs.NewRevertSteps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer revertSteps.DeleteRuntimeTemp()
+	if err := revertSteps.Deserialize(revertNum); err != nil {
+		t.Fatal(err)
+	}
+	if len(revertSteps.DaemonChanges) != 1 || revertSteps.DaemonChanges[0].Name != "backup.timer" {
+		t.Fatalf("state of the timer enabled after apply should be saved in the revert steps, got: %+v", revertSteps.DaemonChanges)
+	}
+	if len(revertSteps.UnitChanges) != 3 {
+		t.Fatalf("every written unit should be saved in the revert steps, got: %+v", revertSteps.UnitChanges)
+	}
+
+	if err := revertSteps.RevertFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.ReloadRevertedUnits(cmdApi.InfoApi{}, root, revertSteps.UnitChanges); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.RevertDaemonChanges(cmdApi.InfoApi{}, root, revertSteps.DaemonChanges); err != nil {
+		t.Fatal(err)
+	}
+	if timer := initManager.Daemons["backup.timer"]; timer.IsEnabled {
+		t.Fatalf("timer should be disabled again, got: %+v", timer)
+	}
+	if _, err := os.Stat(filepath.Join(root, "etc/systemd/system/backup.timer")); !os.IsNotExist(err) {
+		t.Fatal("reverted timer should be removed")
+	}
+}
+
+const maskDaemonsScript = `
+function main()
+    assert(api.daemon.stop("bluetooth") == nil)
+    assert(api.daemon.mask("bluetooth") == nil)
+    assert(api.daemon.start("bluetooth") ~= nil, "masked daemon cannot be started")
+    assert(not api.daemon.isFailed("bluetooth"))
+    return api.daemon.isFailed("backup")
+end
+`
+
+func TestMaskDaemons(t *testing.T) {
+	initManager := tester.NewFakeInitManager()
+	initManager.Daemons["bluetooth"] = api.Daemon{Name: "bluetooth", IsActive: true, IsEnabled: true}
+	initManager.Daemons["backup"] = api.Daemon{Name: "backup", IsFailed: true}
+	useInitManager(t, initManager)
+
+	importLoopData := getImportLoopData(t)
+	result, err := checker.CheckRuleScript(importLoopData, maskDaemonsScript, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Status.IsSuccessful() {
+		t.Fatalf("rule should pass, got: %+v", result)
+	}
+	if err := importLoopData.ExecuteDeferredDaemonOperations(); err != nil {
+		t.Fatal(err)
+	}
+	if bluetooth := initManager.Daemons["bluetooth"]; !bluetooth.IsMasked {
+		t.Fatalf("bluetooth should be masked, got: %+v", bluetooth)
+	}
+
+	if err := checker.RevertDaemonChanges(cmdApi.InfoApi{}, "/", importLoopData.VRCT.Fs.DaemonChanges()); err != nil {
+		t.Fatal(err)
+	}
+	if bluetooth := initManager.Daemons["bluetooth"]; bluetooth.IsMasked || !bluetooth.IsActive {
+		t.Fatalf("bluetooth should be unmasked and started again, got: %+v", bluetooth)
+	}
+}
@@ -0,0 +1,184 @@
+package test
+
+import (
+	"errors"
+	"github.com/avorty/spito/internal/checker"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecoratorArguments(t *testing.T) {
+	script := `#![description("Installs a, b and [c] \"quoted\"", path = './README.md')]
+#![Unsafe]
+#![description(Sets up
+    neovim)]
+function main() return true end
+`
+	processedScript, decorators, err := checker.GetDecorators(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedScript := "\n\n\n\nfunction main() return true end\n"
+	if processedScript != expectedScript {
+		t.Fatalf("decorators weren't removed properly, got:\n%q", processedScript)
+	}
+
+	if len(decorators) != 3 {
+		t.Fatalf("expected 3 decorators, got %d", len(decorators))
+	}
+	if decorators[1].Type != checker.UnsafeDecorator || decorators[1].Line != 2 {
+		t.Fatalf("unexpected second decorator: %+v", decorators[1])
+	}
+
+	positional, named, err := checker.GetDecoratorArguments(decorators[0].Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(positional, []string{`Installs a, b and [c] "quoted"`}) {
+		t.Fatalf("unexpected positional arguments: %#v", positional)
+	}
+	if !reflect.DeepEqual(named, map[string]string{"path": "./README.md"}) {
+		t.Fatalf("unexpected named arguments: %#v", named)
+	}
+
+	positional, _, err = checker.GetDecoratorArguments(decorators[2].Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(positional, []string{"Sets up\n    neovim"}) {
+		t.Fatalf("unexpected multi-line description: %#v", positional)
+	}
+}
+
+func TestDecoratorNestedLists(t *testing.T) {
+	_, decorators, err := checker.GetDecorators(`#![description([arch, "manjaro"], [[x86_64], []])]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arguments, err := decorators[0].Arguments()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []checker.DecoratorValue{
+		{IsList: true, List: []checker.DecoratorValue{{Text: "arch"}, {Text: "manjaro"}}},
+		{IsList: true, List: []checker.DecoratorValue{
+			{IsList: true, List: []checker.DecoratorValue{{Text: "x86_64"}}},
+			{IsList: true},
+		}},
+	}
+	if !reflect.DeepEqual(arguments.Positional, expected) {
+		t.Fatalf("unexpected arguments: %#v", arguments.Positional)
+	}
+}
+
+func TestMultilineOptionsDecorator(t *testing.T) {
+	_, decorators, err := checker.GetDecorators(`--[[
+    #![options({
+        name?: string, -- it's a comment
+        position = "team leader",
+        dog = {
+            age: int = 5
+        }
+    })]
+--]]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{name?:string,position="team leader",dog={age:int=5}}`
+	if content := decorators[0].CompactContent(); content != expected {
+		t.Fatalf("expected compact content %s, got %s", expected, content)
+	}
+}
+
+func TestDecoratorsOnlyStartLines(t *testing.T) {
+	script := `#![description(Don't break)] #![unsafe]
+-- comments can mention #![sudo]
+local text = "#![sudo]"
+local longText = [[
+#![sudo]
+]]
+--[==[
+  #![environment]
+]==]
+function main() return true end
+`
+	processedScript, decorators, err := checker.GetDecorators(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoratorTypes []checker.DecoratorType
+	for _, decorator := range decorators {
+		decoratorTypes = append(decoratorTypes, decorator.Type)
+	}
+	expectedTypes := []checker.DecoratorType{checker.DescriptionDecorator, checker.UnsafeDecorator, checker.EnvironmentDecorator}
+	if !reflect.DeepEqual(decoratorTypes, expectedTypes) {
+		t.Fatalf("expected decorators %v, got %v", expectedTypes, decoratorTypes)
+	}
+
+	positional, _, err := checker.GetDecoratorArguments(decorators[0].Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(positional, []string{"Don't break"}) {
+		t.Fatalf("unexpected description: %#v", positional)
+	}
+	if !strings.Contains(processedScript, `local text = "#![sudo]"`) {
+		t.Fatalf("string shouldn't be changed, got:\n%s", processedScript)
+	}
+}
+
+type decoratorErrorCase struct {
+	script string
+	line   int
+	column int
+}
+
+var decoratorErrorCases = []decoratorErrorCase{
+	{"\n\n  #![unknown]", 3, 6},
+	{"#![description(\"unclosed)]", 1, 16},
+	{"#![description(Installs neovim]", 1, 31},
+	{"#![unsafe", 1, 1},
+	{"#![unsafe oops]", 1, 11},
+	{"#![description(\"bad \\x escape\")]", 1, 21},
+}
+
+func TestDecoratorSyntaxErrors(t *testing.T) {
+	for _, errorCase := range decoratorErrorCases {
+		_, _, err := checker.GetDecorators(errorCase.script)
+
+		var syntaxErr *checker.DecoratorSyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Fatalf("expected syntax error for %q, got: %v", errorCase.script, err)
+		}
+		if syntaxErr.Line != errorCase.line || syntaxErr.Column != errorCase.column {
+			t.Errorf("expected error at %d:%d for %q, got: %s", errorCase.line, errorCase.column, errorCase.script, syntaxErr.Error())
+		}
+	}
+}
+
+func TestDecoratorDashesInText(t *testing.T) {
+	positional, named, err := checker.GetDecoratorArguments("foo--bar, Installs a--b -- comment\n, path=\"x\"-- comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(positional, []string{"foo--bar", "Installs a--b"}) {
+		t.Fatalf("dashes inside text shouldn't start a comment, got: %#v", positional)
+	}
+	if !reflect.DeepEqual(named, map[string]string{"path": "x"}) {
+		t.Fatalf("comment after an argument should be skipped, got: %#v", named)
+	}
+}
+
+func TestDecoratorArgumentErrors(t *testing.T) {
+	for _, content := range []string{`"a" "b"`, `path=`, `[a, b`, `a, {b}`, `path=a, path=b`} {
+		if _, _, err := checker.GetDecoratorArguments(content); err == nil {
+			t.Errorf("expected error for arguments: %s", content)
+		}
+	}
+}
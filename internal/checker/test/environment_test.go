@@ -106,7 +106,7 @@ func applyRule(templateData templateDataT, t *testing.T) templateDataT {
 		}
 	}()
 
-	if err := checker.ApplyEnvironmentScript(importLoopData, ruleSourceCode, ruleFile.Name()); err != nil {
+	if _, err := checker.ApplyEnvironmentScript(importLoopData, ruleSourceCode, ruleFile.Name()); err != nil {
 		t.Fatal(err.Error())
 	}
 
@@ -150,3 +150,35 @@ func getSourceCode(t *testing.T, templateData templateDataT) string {
 
 	return sourceCode.String()
 }
+
+func TestRevertingEnvUpdatesItsState(t *testing.T) {
+	useTemporaryAppliedRules(t)
+	previousEnvironmentDataPath := checker.EnvironmentDataPath
+	checker.EnvironmentDataPath = filepath.Join(t.TempDir(), "environment-data.json")
+	t.Cleanup(func() {
+		checker.EnvironmentDataPath = previousEnvironmentDataPath
+	})
+
+	templateData := templateDataT{
+		Path:      "/tmp/test-file-" + path.RandomLetters(10),
+		Content:   "it should be reverted",
+		Decorator: "#![environment]",
+	}
+	scriptPath := filepath.Join(t.TempDir(), "env.lua")
+	revertNum, err := checker.ApplyEnvironmentScript(getImportLoopData(t), getSourceCode(t, templateData), scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := checker.RevertChanges(cmdApi.InfoApi{}, revertNum); err != nil {
+		t.Fatal(err)
+	}
+
+	appliedEnvironments, err := checker.ReadAppliedEnvironments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(appliedEnvironments) != 1 || appliedEnvironments[0].IsApplied {
+		t.Fatalf("reverted environment should be marked as not applied, got: %+v", appliedEnvironments)
+	}
+}
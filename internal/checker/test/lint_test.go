@@ -0,0 +1,111 @@
+package test
+
+import (
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/pkg/shared"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const lintConfig = `repo_url: github.com/avorty/lint-ruleset
+identifier: lint-ruleset
+rules:
+  valid:
+    path: ./rules/valid.lua
+  broken:
+    path: ./rules/broken.lua
+  missing:
+    path: ./rules/missing.lua
+dependencies:
+  valid:
+    - avorty/lint-ruleset@broken
+  broken:
+    - avorty/lint-ruleset@valid
+`
+
+const validRule = `#![unsafe]
+#![options({editor:string="nvim"})]
+function main()
+    api.pkg.get("neovim")
+    api.sh.command("true")
+    return true
+end
+`
+
+const brokenRule = `function helper()
+    api.fs.notExistingFn("/etc")
+    api.nothing.get()
+    api.sh.command("true")
+end
+`
+
+func writeLintRuleset(t *testing.T) string {
+	rulesetPath := t.TempDir()
+	files := map[string]string{
+		shared.ConfigFilename: lintConfig,
+		"rules/valid.lua":     validRule,
+		"rules/broken.lua":    brokenRule,
+		"rules/unused.lua":    "function main() return true end\n",
+	}
+	for name, content := range files {
+		filePath := filepath.Join(rulesetPath, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rulesetPath
+}
+
+func TestLintRuleset(t *testing.T) {
+	issues, err := checker.LintRuleset(writeLintRuleset(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"spito.yml: error: cannot read rule 'missing'",
+		"rules/broken.lua:2: error: undefined api function: api.fs.notExistingFn",
+		"rules/broken.lua:3: error: undefined api namespace: api.nothing",
+		"rules/broken.lua:4: error: api.sh can be used only by unsafe rules",
+		"rules/broken.lua: error: rule doesn't define 'main' function",
+		"spito.yml: error: dependency cycle: broken -> valid -> broken",
+		"rules/unused.lua: warning: rule isn't registered in spito.yml",
+	}
+
+	var printedIssues []string
+	for _, issue := range issues {
+		printedIssues = append(printedIssues, issue.String())
+		if strings.HasPrefix(issue.File, "rules/valid.lua") {
+			t.Errorf("unexpected issue in valid rule: %s", issue.String())
+		}
+	}
+	for _, expectedIssue := range expected {
+		found := false
+		for _, issue := range printedIssues {
+			if strings.HasPrefix(issue, expectedIssue) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected issue '%s', got:\n%s", expectedIssue, strings.Join(printedIssues, "\n"))
+		}
+	}
+
+	if !issues.HasErrors() {
+		t.Error("expected lint to report errors")
+	}
+}
+
+func TestLintScriptSyntaxError(t *testing.T) {
+	script := "#![description(test)]\n\nfunction main()\n    return (\nend\n"
+	issues := checker.LintScript("rule.lua", script, shared.RuleConfigLayout{})
+
+	if len(issues) != 1 || issues[0].Line != 5 || !strings.Contains(issues[0].Message, "syntax error") {
+		t.Fatalf("expected syntax error in line 5, got: %v", issues)
+	}
+}
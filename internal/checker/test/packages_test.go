@@ -0,0 +1,337 @@
+package test
+
+import (
+	"github.com/avorty/spito/cmd/cmdApi"
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/internal/tester"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/package_conflict"
+	"github.com/avorty/spito/pkg/vrct"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const packagesScript = `
+function main()
+    local err = api.pkg.install("neovim", "git@2.44")
+    if err ~= nil then
+        api.info.error(err)
+        return false
+    end
+    return api.pkg.remove("nano", "less") == nil
+end
+`
+
+func usePackageManager(t *testing.T, packageManager api.PackageManager) {
+	previousPackageManager := api.PackageManagerBackend
+	api.PackageManagerBackend = packageManager
+	t.Cleanup(func() {
+		api.PackageManagerBackend = previousPackageManager
+	})
+}
+
+func TestRevertPackageChanges(t *testing.T) {
+	packageManager := tester.NewFakePackageManager()
+	packageManager.Installed["git"] = api.Package{Name: "git", Version: "2.43"}
+	packageManager.Installed["nano"] = api.Package{Name: "nano", Version: "7.2", InstallReason: "Explicitly installed"}
+	packageManager.Installed["less"] = api.Package{Name: "less", Version: "643", InstallReason: "Installed as a dependency for another package"}
+	usePackageManager(t, packageManager)
+
+	importLoopData := getImportLoopData(t)
+	importLoopData.PackageTracker = package_conflict.NewPackageConflictTracker()
+	result, err := checker.CheckRuleScript(importLoopData, packagesScript, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Status.IsSuccessful() {
+		t.Fatalf("rule should pass, got: %+v", result)
+	}
+	if len(packageManager.Actions) != 0 {
+		t.Fatalf("packages should be changed only when changes are applied, got: %+v", packageManager.Actions)
+	}
+	if err := checker.ExecutePackageTransaction(importLoopData); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := importLoopData.VRCT.Fs.PackageChanges()
+	expectedActions := map[string]int{
+		"neovim": vrctFs.PackageInstalled,
+		"git":    vrctFs.PackageUpgraded,
+		"nano":   vrctFs.PackageRemoved,
+		"less":   vrctFs.PackageRemoved,
+	}
+	if len(changes) != len(expectedActions) {
+		t.Fatalf("expected %d package changes, got: %+v", len(expectedActions), changes)
+	}
+	for _, change := range changes {
+		if expectedActions[change.Name] != change.Action {
+			t.Fatalf("unexpected change of %s: %+v", change.Name, change)
+		}
+	}
+
+	// Package installed by the rule which is needed by other package now has to stay
+	neovim := packageManager.Installed["neovim"]
+	neovim.RequiredBy = []string{"lazyvim"}
+	packageManager.Installed["neovim"] = neovim
+
+	if err := checker.RevertPackageChanges(cmdApi.InfoApi{}, "/", changes); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := packageManager.GetPackage("neovim"); err != nil {
+		t.Fatal("package required by other package shouldn't be removed")
+	}
+	if git, _ := packageManager.GetPackage("git"); git.Version != "2.43" {
+		t.Fatalf("upgraded package should be downgraded, got version %s", git.Version)
+	}
+	if _, err := packageManager.GetPackage("nano"); err != nil {
+		t.Fatal("explicitly installed package should be installed again")
+	}
+	if _, err := packageManager.GetPackage("less"); err == nil {
+		t.Fatal("dependency shouldn't be installed again")
+	}
+}
+
+const desktopScript = `
+function main()
+    api.pkg.install("sddm")
+    return api.daemon.enable("sddm") == nil
+end
+`
+
+func TestDaemonOperationsWaitForPackageTransaction(t *testing.T) {
+	packageManager, initManager := tester.NewFakePackageManager(), tester.NewFakeInitManager()
+	usePackageManager(t, packageManager)
+	useInitManager(t, initManager)
+
+	importLoopData := getImportLoopData(t)
+	importLoopData.PackageTracker = package_conflict.NewPackageConflictTracker()
+	result, err := checker.CheckRuleScript(importLoopData, desktopScript, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Status.IsSuccessful() {
+		t.Fatalf("rule should pass, got: %+v", result)
+	}
+	if len(initManager.Actions) != 0 {
+		t.Fatalf("daemon shouldn't be enabled before its package is installed, got: %+v", initManager.Actions)
+	}
+
+	transaction := checker.GetPackageTransaction(importLoopData)
+	if len(transaction.Install) != 1 || transaction.Install[0] != "sddm" {
+		t.Fatalf("sddm should be waiting for installation, got: %+v", transaction)
+	}
+
+	// The fake package manager doesn't provide daemons, so the test adds the one shipped by the package
+	initManager.Daemons["sddm"] = api.Daemon{Name: "sddm"}
+	if err := checker.ExecutePackageTransaction(importLoopData); err != nil {
+		t.Fatal(err)
+	}
+	if err := importLoopData.ExecuteDeferredDaemonOperations(); err != nil {
+		t.Fatal(err)
+	}
+	if sddm := initManager.Daemons["sddm"]; !sddm.IsEnabled {
+		t.Fatal("daemon should be enabled after the transaction")
+	}
+	if !checker.GetPackageTransaction(importLoopData).IsEmpty() {
+		t.Fatal("executed transaction should be cleared")
+	}
+}
+
+const packageQueriesScript = `
+function main()
+    local explicit = api.pkg.listInstalled("explicit")
+    assert(#explicit == 1 and explicit[1].Name == "vim", "vim should be the only explicit package")
+    assert(#api.pkg.listInstalled() == 2, "all installed packages should be listed")
+    assert(#api.pkg.listInstalled("orphan") == 0, "xxd is required by vim")
+
+    local owner = api.pkg.owner("/usr/bin/xxd")
+    assert(owner.Name == "xxd", "xxd should own /usr/bin/xxd")
+    assert(api.pkg.files("vim")[1] == "/usr/bin/vim", "vim should own /usr/bin/vim")
+    assert(api.pkg.isExplicit("vim") and not api.pkg.isExplicit("xxd"), "xxd is a dependency")
+
+    local results = api.pkg.search("editor")
+    assert(#results == 2 and results[1].Name == "neovim", "editors should be found")
+
+    local updates = api.pkg.updatesAvailable()
+    assert(#updates == 1 and updates[1].Version == "9.1", "vim should be upgradable to 9.1")
+
+    local _, err = api.pkg.listInstalled("everything")
+    return err ~= nil
+end
+`
+
+func TestPackageQueries(t *testing.T) {
+	packageManager := tester.NewFakePackageManager()
+	packageManager.Installed["vim"] = api.Package{Name: "vim", Version: "9.0", InstallReason: api.ExplicitInstallReason}
+	packageManager.Installed["xxd"] = api.Package{Name: "xxd", Version: "9.0", RequiredBy: []string{"vim"}}
+	packageManager.Available["vim"] = api.Package{Name: "vim", Version: "9.1", Description: "Vi Improved, a text editor"}
+	packageManager.Available["neovim"] = api.Package{Name: "neovim", Version: "0.9.5", Description: "Vim-based text editor"}
+	packageManager.Available["xxd"] = api.Package{Name: "xxd", Version: "9.0", Description: "Hexdump utility"}
+	packageManager.Files["vim"] = []string{"/usr/bin/vim"}
+	packageManager.Files["xxd"] = []string{"/usr/bin/xxd"}
+	usePackageManager(t, packageManager)
+
+	result, err := checker.CheckRuleScript(getImportLoopData(t), packageQueriesScript, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != checker.RulePassed {
+		t.Fatalf("rule should pass, got: %+v", result)
+	}
+}
+
+const repositoryScript = `
+function main()
+    local err = api.pkg.importKey("3056 513887B78AEB", "hkps://keyserver.ubuntu.com")
+    if err == nil then
+        err = api.pkg.addRepository("chaotic-aur", { Include = "/etc/pacman.d/chaotic-mirrorlist" })
+    end
+    if err == nil then
+        err = api.pkg.addRepository("extra", { Servers = { "https://mirror.example.com/$repo/os/$arch" } })
+    end
+    if err == nil then
+        err = api.pkg.removeRepository("testing")
+    end
+    if err == nil then
+        err = api.pkg.install("paru")
+    end
+    if err ~= nil then
+        api.info.error(err)
+        return false
+    end
+    return api.pkg.addRepository("invalid name", { Include = "/etc/pacman.d/mirrorlist" }) ~= nil
+end
+`
+
+const testPacmanConfig = `[options]
+Architecture = auto
+
+[testing]
+Include = /etc/pacman.d/mirrorlist
+
+[core]
+Include = /etc/pacman.d/mirrorlist
+
+[extra]
+Include = /etc/pacman.d/mirrorlist
+`
+
+func TestRepositoryChanges(t *testing.T) {
+	packageManager := tester.NewFakePackageManager()
+	usePackageManager(t, packageManager)
+
+	root := t.TempDir()
+	configPath := filepath.Join(root, api.PacmanConfigPath)
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, []byte(testPacmanConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ruleVRCT, err := vrct.NewRuleVRCTWithRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importLoopData := getImportLoopData(t)
+	importLoopData.VRCT = *ruleVRCT
+	importLoopData.PackageTracker = package_conflict.NewPackageConflictTracker()
+	result, err := checker.CheckRuleScript(importLoopData, repositoryScript, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Status.IsSuccessful() {
+		t.Fatalf("rule should pass, got: %+v", result)
+	}
+	if len(packageManager.Actions) != 0 {
+		t.Fatalf("repositories should be changed only when changes are applied, got: %+v", packageManager.Actions)
+	}
+	if err := checker.ExecutePackageTransaction(importLoopData); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only databases of the added and replaced repositories are synchronized, so the system isn't partially upgraded
+	expectedActions := []tester.PackageAction{
+		{Action: "importKey", Package: "3056513887B78AEB"},
+		{Action: "syncDatabases", Package: "chaotic-aur"},
+		{Action: "syncDatabases", Package: "extra"},
+		{Action: "install", Package: "paru"},
+	}
+	if !slices.Equal(packageManager.Actions, expectedActions) {
+		t.Fatalf("expected actions %+v, got: %+v", expectedActions, packageManager.Actions)
+	}
+
+	// Packages from the added repositories are installed before the other files are applied
+	config, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repositories, err := api.ParseConfigRepositories(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var repositoryNames []string
+	for _, repository := range repositories {
+		repositoryNames = append(repositoryNames, repository.Name)
+	}
+	if !slices.Equal(repositoryNames, []string{"core", "extra", "chaotic-aur"}) || len(repositories[1].Servers) != 1 {
+		t.Fatalf("configuration should be applied before the transaction, got:\n%s", config)
+	}
+
+	revertNum, err := importLoopData.VRCT.Apply(checker.GetRulesToRevert(importLoopData.RulesHistory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revertSteps, err := vrctFs.NewRevertSteps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer revertSteps.DeleteRuntimeTemp()
+	if err := revertSteps.Deserialize(revertNum); err != nil {
+		t.Fatal(err)
+	}
+	if err := revertSteps.RevertFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.RevertPackageChanges(cmdApi.InfoApi{}, root, revertSteps.PackageChanges); err != nil {
+		t.Fatal(err)
+	}
+
+	if config, err := os.ReadFile(configPath); err != nil || string(config) != testPacmanConfig {
+		t.Fatalf("configuration should be restored with the removed repository in its place, got:\n%s", config)
+	}
+	if len(packageManager.Keys) != 0 {
+		t.Fatalf("imported key should be removed, got: %v", packageManager.Keys)
+	}
+}
+
+const packageVersionsScript = `
+function main()
+    assert(api.pkg.install("git@2.44") == nil)
+    assert(api.pkg.install("git") == nil, "any version of git is satisfied by the required one")
+    return api.pkg.install("git@2.43") ~= nil
+end
+`
+
+func TestPackageVersionConflict(t *testing.T) {
+	usePackageManager(t, tester.NewFakePackageManager())
+
+	importLoopData := getImportLoopData(t)
+	importLoopData.PackageTracker = package_conflict.NewPackageConflictTracker()
+	result, err := checker.CheckRuleScript(importLoopData, packageVersionsScript, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Status.IsSuccessful() {
+		t.Fatalf("rule should pass, got: %+v", result)
+	}
+
+	transaction := checker.GetPackageTransaction(importLoopData)
+	if len(transaction.Install) != 1 || transaction.Install[0] != "git@2.44" {
+		t.Fatalf("git should be installed in the required version, got: %+v", transaction)
+	}
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"errors"
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const permissionsConfig = `repo_url: github.com/avorty/permissions-ruleset
+identifier: permissions-ruleset
+rules:
+  sddm:
+    path: ./rules/sddm.lua
+    permissions:
+      - packages
+  legacy:
+    path: ./rules/legacy.lua
+  invalid:
+    path: ./rules/invalid.lua
+    permissions:
+      - everything
+`
+
+func TestGetRulePermissions(t *testing.T) {
+	rulesetPath := t.TempDir()
+	files := map[string]string{
+		shared.ConfigFilename: permissionsConfig,
+		"rules/sddm.lua":      "#![permissions(daemons)]\nfunction main() return true end\n",
+		"rules/legacy.lua":    "#![sudo]\nfunction main() return true end\n",
+		"rules/invalid.lua":   "function main() return true end\n",
+	}
+	for name, content := range files {
+		filePath := filepath.Join(rulesetPath, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[vrctFs.Rule][]shared.Permission{
+		{Url: rulesetPath, NameOrScript: "sddm"}:   {shared.PermissionPackages, shared.PermissionDaemons},
+		{Url: rulesetPath, NameOrScript: "legacy"}: shared.AllPermissions,
+		{Url: rulesetPath, NameOrScript: "#![permissions([files])]\nfunction main() return true end\n", IsScript: true}: {
+			shared.PermissionFiles,
+		},
+		{Url: rulesetPath, NameOrScript: "function main() return true end\n", IsScript: true}: nil,
+	}
+	for rule, expectedPermissions := range expected {
+		permissions, err := checker.GetRulePermissions(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedPermissions = slices.Clone(expectedPermissions)
+		slices.Sort(permissions)
+		slices.Sort(expectedPermissions)
+		if !slices.Equal(permissions, expectedPermissions) {
+			t.Fatalf("expected %v permissions of %s, got %v", expectedPermissions, rule.NameOrScript, permissions)
+		}
+	}
+
+	if _, err := checker.GetRulePermissions(vrctFs.Rule{Url: rulesetPath, NameOrScript: "invalid"}); err == nil {
+		t.Fatal("unknown permission should be rejected")
+	}
+	script := vrctFs.Rule{Url: rulesetPath, NameOrScript: "#![permissions(root)]\nfunction main() end\n", IsScript: true}
+	if _, err := checker.GetRulePermissions(script); err == nil {
+		t.Fatal("unknown permission in decorator should be rejected")
+	}
+}
+
+func TestHelperDoesNotTrustLocalRules(t *testing.T) {
+	localRules := []vrctFs.Rule{
+		{Url: t.TempDir(), NameOrScript: "sddm"},
+		{Url: "github.com/avorty/permissions-ruleset", NameOrScript: "#![permissions(packages)]\n", IsScript: true},
+	}
+	for _, rule := range localRules {
+		if _, err := checker.GetTrustedRulePermissions(rule); !errors.Is(err, checker.ErrLocalRuleRequiresRoot) {
+			t.Fatalf("permissions of the local rule %s shouldn't be trusted, got: %v", rule.NameOrScript, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package test
+
+import (
+	"github.com/avorty/spito/internal/checker"
+	"reflect"
+	"testing"
+)
+
+func TestRuleResults(t *testing.T) {
+	testCases := []struct {
+		name     string
+		script   string
+		expected checker.RuleResult
+	}{
+		{
+			name:     "boolean",
+			script:   `function main() return true end`,
+			expected: checker.RuleResult{Status: checker.RulePassed},
+		},
+		{
+			name:     "boolean with message",
+			script:   `function main() return false, "neovim is not installed" end`,
+			expected: checker.RuleResult{Status: checker.RuleFailed, Message: "neovim is not installed"},
+		},
+		{
+			name:   "result constructor",
+			script: `function main() return api.result.noop("already configured", { editor = "nvim", plugins = { "lsp" } }) end`,
+			expected: checker.RuleResult{
+				Status:  checker.RuleAlreadySatisfied,
+				Message: "already configured",
+				Data:    map[string]any{"editor": "nvim", "plugins": []any{"lsp"}},
+			},
+		},
+		{
+			name:     "result table",
+			script:   `function main() return { status = "skip", message = "no gpu" } end`,
+			expected: checker.RuleResult{Status: checker.RuleSkipped, Message: "no gpu"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ruleResult, err := checker.CheckRuleScript(getImportLoopData(t), testCase.script, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ruleResult, testCase.expected) {
+				t.Fatalf("expected %+v, got %+v", testCase.expected, ruleResult)
+			}
+		})
+	}
+}
+
+func TestInvalidRuleResult(t *testing.T) {
+	for _, script := range []string{
+		`function main() return 1 end`,
+		`function main() return { status = "maybe" } end`,
+	} {
+		if _, err := checker.CheckRuleScript(getImportLoopData(t), script, t.TempDir()); err == nil {
+			t.Fatalf("expected error for script: %s", script)
+		}
+	}
+}
+
+func TestRuleResultJson(t *testing.T) {
+	resultJson, err := checker.RuleResult{Status: checker.RuleAlreadySatisfied, Message: "done"}.Json()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resultJson != `{"status":"noop","message":"done"}` {
+		t.Fatalf("unexpected json: %s", resultJson)
+	}
+}
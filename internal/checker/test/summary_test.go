@@ -0,0 +1,157 @@
+package test
+
+import (
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/trust"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		filePath := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// commitFiles writes the files into a new git repository and commits them, like in a downloaded ruleset
+func commitFiles(t *testing.T, root string, files map[string]string) {
+	writeFiles(t, root, files)
+	repo, err := git.PlainInit(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worktree.AddGlob("."); err != nil {
+		t.Fatal(err)
+	}
+	_, err = worktree.Commit("init", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useTemporaryRulesets makes downloaded rulesets live in the temporary directory,
+// so dependencies can be created without fetching them
+func useTemporaryRulesets(t *testing.T) string {
+	previousPath, previousStorePath := shared.LocalStateSpitoPath, trust.DefaultStorePath
+	shared.LocalStateSpitoPath = t.TempDir()
+	trust.DefaultStorePath = filepath.Join(shared.LocalStateSpitoPath, "trust.json")
+	t.Cleanup(func() {
+		shared.LocalStateSpitoPath, trust.DefaultStorePath = previousPath, previousStorePath
+	})
+	return filepath.Join(shared.LocalStateSpitoPath, "rulesets")
+}
+
+func TestSummaryOfDependencies(t *testing.T) {
+	useTemporaryAppliedRules(t)
+	rulesetsDir := useTemporaryRulesets(t)
+
+	commitFiles(t, filepath.Join(rulesetsDir, "github.com/avorty/base"), map[string]string{
+		shared.ConfigFilename: `repo_url: github.com/avorty/base
+identifier: base
+rules:
+  fonts:
+    path: ./rules/fonts.lua
+  drivers:
+    path: ./rules/drivers.lua
+dependencies:
+  fonts:
+    - avorty/base@drivers
+`,
+		"rules/fonts.lua":   "function main() return true end\n",
+		"rules/drivers.lua": "#![sudo]\nfunction main() return true end\n",
+	})
+
+	rulesetPath := t.TempDir()
+	writeFiles(t, rulesetPath, map[string]string{
+		shared.ConfigFilename: `repo_url: github.com/avorty/desktop
+identifier: desktop
+rules:
+  desktop:
+    path: ./rules/desktop.lua
+  editor:
+    path: ./rules/editor.lua
+dependencies:
+  desktop:
+    - avorty/base@fonts
+`,
+		"rules/desktop.lua": "function main() return true end\n",
+		"rules/editor.lua":  "function main() return true end\n",
+	})
+
+	rulesetLocation, err := checker.NewRulesetLocation(rulesetPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := checker.GetRuleSummary(&rulesetLocation, "desktop", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Sudo || !slices.Equal(summary.SudoDependencies, []string{"avorty/base@drivers"}) {
+		t.Fatalf("expected the nested dependency to require root privileges, got: %+v", summary)
+	}
+	if !summary.RequiresConfirmation() {
+		t.Fatal("rule with sudo dependency should require confirmation")
+	}
+
+	summary, err = checker.GetRuleSummary(&rulesetLocation, "editor", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.RequiresConfirmation() {
+		t.Fatalf("safe rule without dependencies shouldn't require confirmation: %+v", summary)
+	}
+}
+
+func TestSummaryVerifiesDownloadedDependencies(t *testing.T) {
+	useTemporaryAppliedRules(t)
+	rulesetsDir := useTemporaryRulesets(t)
+
+	commitFiles(t, filepath.Join(rulesetsDir, "github.com/avorty/base"), map[string]string{
+		shared.ConfigFilename: "repo_url: github.com/avorty/base\nidentifier: base\nrules:\n  fonts:\n    path: ./fonts.lua\n",
+		"fonts.lua":           "function main() return true end\n",
+	})
+	store, err := trust.LoadStore(trust.DefaultStorePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Pin("github.com/avorty/base", "0123456789ABCDEF")
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	rulesetPath := t.TempDir()
+	writeFiles(t, rulesetPath, map[string]string{
+		shared.ConfigFilename: "repo_url: github.com/avorty/desktop\nidentifier: desktop\nrules:\n  desktop:\n    path: ./desktop.lua\n" +
+			"dependencies:\n  desktop:\n    - avorty/base@fonts\n",
+		"desktop.lua": "function main() return true end\n",
+	})
+	rulesetLocation, err := checker.NewRulesetLocation(rulesetPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = checker.GetRuleSummary(&rulesetLocation, "desktop", nil)
+	if err == nil || !strings.Contains(err.Error(), "is pinned to the key 0123456789ABCDEF") {
+		t.Fatalf("unsigned revision of the pinned dependency shouldn't be used, got: %v", err)
+	}
+}
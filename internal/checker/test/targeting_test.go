@@ -0,0 +1,53 @@
+package test
+
+import (
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/shared"
+	"testing"
+)
+
+func TestNotApplicableRuleIsNotRecorded(t *testing.T) {
+	usePlatform(t, api.Platform{Distro: "arch", InitSystem: api.SYSTEMD, Arch: "x86_64"})
+
+	rulesetPath := t.TempDir()
+	writeFiles(t, rulesetPath, map[string]string{
+		shared.ConfigFilename: `repo_url: github.com/avorty/targeting
+identifier: targeting
+rules:
+  debian:
+    path: ./rules/debian.lua
+  arch:
+    path: ./rules/arch.lua
+`,
+		"rules/debian.lua": "#![distro(debian)]\nfunction main() return true end\n",
+		"rules/arch.lua":   "#![distro(arch)]\nfunction main() return true end\n",
+	})
+
+	importLoopData := getImportLoopData(t)
+	for i := 0; i < 2; i++ {
+		result, err := checker.CheckRuleByPath(importLoopData, rulesetPath, "debian")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != checker.RuleNotApplicable {
+			t.Fatalf("rule for debian should be not applicable, got: %+v", result)
+		}
+	}
+	if rulesToRevert := checker.GetRulesToRevert(importLoopData.RulesHistory); len(rulesToRevert) != 0 {
+		t.Fatalf("not applicable rule shouldn't be reverted, got: %+v", rulesToRevert)
+	}
+
+	result, err := checker.CheckRuleByPath(importLoopData, rulesetPath, "arch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != checker.RulePassed {
+		t.Fatalf("rule for arch should pass, got: %+v", result)
+	}
+	for _, rule := range importLoopData.RulesHistory {
+		if importLoopData.RulesHistory.IsRuleInProgress(rule.Url, rule.NameOrScript) {
+			t.Fatalf("executed rule is still in progress: %+v", rule)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"github.com/yuin/gopher-lua"
+)
+
+// getUnitFile converts lua table of sections to the unit file, e.g. { Service = { ExecStart = "/usr/bin/backup" } }.
+// Values are strings, numbers, booleans or arrays of them for keys assigned many times
+func getUnitFile(sectionsTable *lua.LTable) (api.UnitFile, error) {
+	sections := make(map[string]map[string][]string)
+	var err error
+
+	sectionsTable.ForEach(func(sectionName lua.LValue, section lua.LValue) {
+		if err != nil {
+			return
+		}
+		sectionTable, ok := section.(*lua.LTable)
+		if sectionName.Type() != lua.LTString || !ok {
+			err = fmt.Errorf("section %s has to be a table of keys", sectionName.String())
+			return
+		}
+
+		keys := make(map[string][]string)
+		sectionTable.ForEach(func(key lua.LValue, value lua.LValue) {
+			if err != nil {
+				return
+			}
+			if key.Type() != lua.LTString {
+				err = fmt.Errorf("key %s in section [%s] has to be a string", key.String(), sectionName.String())
+				return
+			}
+			keys[key.String()], err = getUnitValues(value)
+			if err != nil {
+				err = fmt.Errorf("invalid value of %s in section [%s]: %w", key.String(), sectionName.String(), err)
+			}
+		})
+		sections[sectionName.String()] = keys
+	})
+
+	return api.NewUnitFile(sections), err
+}
+
+func getUnitValues(value lua.LValue) ([]string, error) {
+	switch value.Type() {
+	case lua.LTString, lua.LTNumber, lua.LTBool:
+		return []string{value.String()}, nil
+	case lua.LTTable:
+		var values []string
+		table := value.(*lua.LTable)
+		for i := 1; i <= table.Len(); i++ {
+			element := table.RawGetInt(i)
+			if element.Type() == lua.LTTable {
+				return nil, fmt.Errorf("arrays can't be nested")
+			}
+			elementValues, err := getUnitValues(element)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, elementValues...)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("%s can't be written to the unit", value.Type().String())
+}
+
+// ExecuteDaemonOperations reloads init systems whose units were written by the rules and executes daemon operations
+// of the rules. Units are written together with other files, so it's called after the changes are applied,
+// states of the changed daemons are then added to the revert steps of the applied changes
+func ExecuteDaemonOperations(importLoopData *shared.ImportLoopData, revertNum int) error {
+	recordedChanges := len(importLoopData.VRCT.Fs.DaemonChanges())
+	err := importLoopData.ExecuteDeferredDaemonOperations()
+
+	daemonChanges := importLoopData.VRCT.Fs.DaemonChanges()[recordedChanges:]
+	if len(daemonChanges) == 0 {
+		return err
+	}
+	rulesToRevert := GetRulesToRevert(importLoopData.RulesHistory)
+	return errors.Join(err, importLoopData.VRCT.AddDaemonChanges(revertNum, rulesToRevert, daemonChanges))
+}
+
+// ReloadRevertedUnits reloads the init system once for every scope of the reverted units,
+// so daemons are restored with the units they had before the rules changed them
+func ReloadRevertedUnits(infoApi shared.InfoInterface, root string, changes []vrctFs.UnitChange) error {
+	reloadedScopes := make(map[string]bool)
+	for _, change := range changes {
+		if reloadedScopes[change.Scope] {
+			continue
+		}
+		reloadedScopes[change.Scope] = true
+
+		infoApi.Debug(fmt.Sprintf("Reloading units after %s has been reverted", change.Path))
+		if err := getRevertInitManager(change.Scope, change.Rule, root).ReloadDaemons(); err != nil {
+			return fmt.Errorf("cannot reload the reverted unit %s: %w", change.Name, err)
+		}
+	}
+	return nil
+}
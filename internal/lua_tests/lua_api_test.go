@@ -126,13 +126,13 @@ func TestLuaApi(t *testing.T) {
 			InfoApi:       cmdApi.InfoApi{},
 		}
 
-		doesRulePass, err := checker.CheckRuleScript(&runtimeData, string(file), "")
+		ruleResult, err := checker.CheckRuleScript(&runtimeData, string(file), "")
 		if err != nil {
 			t.Fatalf("Error occurred in script '%s' : %s", script.file, fmt.Sprint(err))
 		}
 
-		if !doesRulePass {
-			logAndFail(t, "Rule %s did not pass!", script.file)
+		if ruleResult.Status != checker.RulePassed {
+			logAndFail(t, "Rule %s did not pass: %s", script.file, ruleResult.String())
 		}
 
 		var ruleIdentifiers []vrctFs.Rule
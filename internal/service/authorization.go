@@ -0,0 +1,61 @@
+package service
+
+import (
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"os"
+)
+
+// Polkit actions, which callers of other users have to be authorized for, they are defined in org.avorty.spito.policy
+const (
+	ReadActionId    = "org.avorty.spito.read"
+	ExecuteActionId = "org.avorty.spito.execute"
+)
+
+const (
+	polkitBusName    = "org.freedesktop.PolicyKit1"
+	polkitObjectPath = dbus.ObjectPath("/org/freedesktop/PolicyKit1/Authority")
+	polkitInterface  = "org.freedesktop.PolicyKit1.Authority"
+	// polkitAllowUserInteraction lets polkit ask the caller for the password
+	polkitAllowUserInteraction = uint32(1)
+)
+
+type polkitSubject struct {
+	Kind    string
+	Details map[string]dbus.Variant
+}
+
+type polkitResult struct {
+	IsAuthorized bool
+	IsChallenge  bool
+	Details      map[string]string
+}
+
+// authorize returns the uid of the sender or an error if it isn't allowed to perform the action.
+// Callers running as the same user as the service are always allowed, others are authorized by polkit
+func (s *Service) authorize(sender dbus.Sender, actionId string) (int, error) {
+	var senderUid uint32
+	err := s.conn.BusObject().Call("org.freedesktop.DBus.GetConnectionUnixUser", 0, string(sender)).Store(&senderUid)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get the user of %s: %w", sender, err)
+	}
+	if int(senderUid) == os.Getuid() {
+		return int(senderUid), nil
+	}
+
+	subject := polkitSubject{
+		Kind:    "system-bus-name",
+		Details: map[string]dbus.Variant{"name": dbus.MakeVariant(string(sender))},
+	}
+	var result polkitResult
+	err = s.conn.Object(polkitBusName, polkitObjectPath).Call(
+		polkitInterface+".CheckAuthorization", 0, subject, actionId, map[string]string{}, polkitAllowUserInteraction, "",
+	).Store(&result)
+	if err != nil {
+		return 0, fmt.Errorf("cannot authorize %s with polkit: %w", sender, err)
+	}
+	if !result.IsAuthorized {
+		return 0, fmt.Errorf("user %d is not authorized to %s", senderUid, actionId)
+	}
+	return int(senderUid), nil
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// readAsCaller reads the file with credentials of the caller, so callers of other users
+// cannot make the service read files, which they aren't allowed to open themselves
+func readAsCaller(callerUid int, filePath string) ([]byte, error) {
+	if !filepath.IsAbs(filePath) {
+		return nil, fmt.Errorf("path '%s' has to be absolute, it would be resolved in the working directory of the service", filePath)
+	}
+	if callerUid == os.Getuid() {
+		return os.ReadFile(filePath)
+	}
+
+	credential, err := getCredential(callerUid)
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	command := exec.Command("cat", "--", filePath)
+	command.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+	command.Stderr = &stderr
+	content, err := command.Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s as user %d: %w, %s", filePath, callerUid, err, strings.TrimSpace(stderr.String()))
+	}
+	return content, nil
+}
+
+// getCredential returns the user and the groups of the caller
+func getCredential(callerUid int) (*syscall.Credential, error) {
+	callerUser, err := user.LookupId(strconv.Itoa(callerUid))
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(callerUser.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	groupIds, err := callerUser.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]uint32, 0, len(groupIds))
+	for _, groupId := range groupIds {
+		group, err := strconv.ParseUint(groupId, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, uint32(group))
+	}
+	return &syscall.Credential{Uid: uint32(callerUid), Gid: uint32(gid), Groups: groups}, nil
+}
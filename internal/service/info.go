@@ -0,0 +1,36 @@
+package service
+
+import "github.com/avorty/spito/pkg/shared"
+
+// signalInfoApi sends messages printed by spito and rules to all clients with Log signal
+type signalInfoApi struct {
+	service *Service
+}
+
+func (i signalInfoApi) PrintEntry(entry shared.LogEntry) {
+	_ = i.service.conn.Emit(ObjectPath, InterfaceName+".Log", entry.Level.String(), entry.Rule, entry.Message)
+}
+
+func (i signalInfoApi) Log(args ...any) {
+	i.print(shared.LevelLog, args)
+}
+
+func (i signalInfoApi) Debug(args ...any) {
+	i.print(shared.LevelDebug, args)
+}
+
+func (i signalInfoApi) Error(args ...any) {
+	i.print(shared.LevelError, args)
+}
+
+func (i signalInfoApi) Warn(args ...any) {
+	i.print(shared.LevelWarn, args)
+}
+
+func (i signalInfoApi) Important(args ...any) {
+	i.print(shared.LevelImportant, args)
+}
+
+func (i signalInfoApi) print(level shared.LogLevel, args []any) {
+	i.PrintEntry(shared.LogEntry{Level: level, Message: shared.JoinMessage(args...)})
+}
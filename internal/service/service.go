@@ -0,0 +1,384 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/avorty/spito/internal/checker"
+	daemontracker "github.com/avorty/spito/pkg"
+	"github.com/avorty/spito/pkg/package_conflict"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/vrct"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	BusName       = "org.avorty.Spito"
+	InterfaceName = "org.avorty.Spito"
+	ObjectPath    = dbus.ObjectPath("/org/avorty/Spito")
+)
+
+// Progress stages sent with the Progress signal
+const (
+	StageStarted  = "started"
+	StageChecked  = "checked"
+	StageFinished = "finished"
+	StageFailed   = "failed"
+)
+
+const introspectionXml = `
+<node>
+	<interface name="` + InterfaceName + `">
+		<method name="GetRuleSummary">
+			<arg name="identifierOrPath" direction="in" type="s"/>
+			<arg name="rule" direction="in" type="s"/>
+			<arg name="options" direction="in" type="as"/>
+			<arg name="summary" direction="out" type="s"/>
+		</method>
+		<method name="CheckRule">
+			<arg name="identifierOrPath" direction="in" type="s"/>
+			<arg name="rule" direction="in" type="s"/>
+			<arg name="options" direction="in" type="as"/>
+			<arg name="apply" direction="in" type="b"/>
+			<arg name="confirmed" direction="in" type="b"/>
+			<arg name="report" direction="out" type="s"/>
+		</method>
+		<method name="CheckRuleFile">
+			<arg name="path" direction="in" type="s"/>
+			<arg name="options" direction="in" type="as"/>
+			<arg name="apply" direction="in" type="b"/>
+			<arg name="confirmed" direction="in" type="b"/>
+			<arg name="report" direction="out" type="s"/>
+		</method>
+		<method name="ApplyEnvironment">
+			<arg name="identifierOrPath" direction="in" type="s"/>
+			<arg name="environment" direction="in" type="s"/>
+			<arg name="options" direction="in" type="as"/>
+			<arg name="confirmed" direction="in" type="b"/>
+			<arg name="report" direction="out" type="s"/>
+		</method>
+		<method name="ListHistory">
+			<arg name="history" direction="out" type="s"/>
+		</method>
+		<method name="Revert">
+			<arg name="revertNumber" direction="in" type="i"/>
+			<arg name="report" direction="out" type="s"/>
+		</method>
+		<signal name="Log">
+			<arg name="level" type="s"/>
+			<arg name="rule" type="s"/>
+			<arg name="message" type="s"/>
+		</signal>
+		<signal name="Progress">
+			<arg name="operation" type="s"/>
+			<arg name="target" type="s"/>
+			<arg name="stage" type="s"/>
+		</signal>
+	</interface>` + introspect.IntrospectDataString + `</node>`
+
+// Service exports spito operations on D-Bus, results are returned as the same json objects,
+// which are printed by commands with --output json. Operations are executed one at a time,
+// because backends of the apis are shared by the whole process.
+// Callers of other users have to be authorized by polkit and rules, which require confirmation,
+// are executed only if the caller has confirmed them
+type Service struct {
+	conn    *dbus.Conn
+	logFile *shared.LogFile
+	mutex   sync.Mutex
+}
+
+// Serve exports the service on the connection and requests its well-known name
+func Serve(conn *dbus.Conn, logFile *shared.LogFile) (*Service, error) {
+	service := &Service{
+		conn:    conn,
+		logFile: logFile,
+	}
+
+	if err := conn.Export(service, ObjectPath, InterfaceName); err != nil {
+		return nil, err
+	}
+	err := conn.Export(introspect.Introspectable(introspectionXml), ObjectPath, "org.freedesktop.DBus.Introspectable")
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.RequestName(BusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return nil, fmt.Errorf("D-Bus name %s is already taken, is spito serve already running?", BusName)
+	}
+
+	return service, nil
+}
+
+func (s *Service) GetRuleSummary(sender dbus.Sender, identifierOrPath string, ruleName string, options []string) (string, *dbus.Error) {
+	callerUid, err := s.authorize(sender, ReadActionId)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rulesetLocation, err := getRulesetLocation(callerUid, identifierOrPath)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	summary, err := checker.GetRuleSummary(&rulesetLocation, ruleName, options)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return toJson(summary)
+}
+
+// CheckRule executes the rule and applies its changes if apply is true and the rule has passed
+func (s *Service) CheckRule(
+	sender dbus.Sender,
+	identifierOrPath string,
+	ruleName string,
+	options []string,
+	apply bool,
+	confirmed bool,
+) (string, *dbus.Error) {
+	return s.execute(sender, "CheckRule", identifierOrPath+"@"+ruleName, options, func(importLoopData *shared.ImportLoopData, callerUid int) (any, error) {
+		rulesetLocation, err := getRulesetLocation(callerUid, identifierOrPath)
+		if err != nil {
+			return nil, err
+		}
+		summary, err := checker.GetRuleSummary(&rulesetLocation, ruleName, options)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkConfirmation(summary, confirmed); err != nil {
+			return nil, err
+		}
+		rulesetConf, err := checker.GetRulesetConf(&rulesetLocation)
+		if err != nil {
+			return nil, err
+		}
+		ruleConf, err := rulesetConf.GetRuleConf(ruleName)
+		if err != nil {
+			return nil, err
+		}
+		if ruleConf.Environment {
+			return nil, fmt.Errorf("rule %s is an environment, use ApplyEnvironment method", ruleName)
+		}
+
+		var ruleResult checker.RuleResult
+		if rulesetLocation.IsPath {
+			ruleResult, err = checker.CheckRuleByPath(importLoopData, rulesetLocation.GetRulesetPath(), ruleName)
+		} else {
+			ruleResult, err = checker.CheckRuleByIdentifier(importLoopData, identifierOrPath, ruleName)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		report := checker.NewCheckReport(rulesetLocation.GetIdentifier()+"@"+ruleName, ruleResult)
+		return report, s.applyIfPassed(importLoopData, &report, apply)
+	})
+}
+
+// CheckRuleFile executes the rule file and applies its changes if apply is true and the rule has passed.
+// The path has to be absolute and the file is read with credentials of the caller
+func (s *Service) CheckRuleFile(sender dbus.Sender, rulePath string, options []string, apply bool, confirmed bool) (string, *dbus.Error) {
+	return s.execute(sender, "CheckRuleFile", rulePath, options, func(importLoopData *shared.ImportLoopData, callerUid int) (any, error) {
+		rulePath = filepath.Clean(rulePath)
+		script, err := readAsCaller(callerUid, rulePath)
+		if err != nil {
+			return nil, err
+		}
+		summary, err := checker.GetScriptContentSummary(string(script), rulePath, options)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkConfirmation(summary, confirmed); err != nil {
+			return nil, err
+		}
+
+		ruleResult, err := checker.CheckRuleScript(importLoopData, string(script), filepath.Dir(rulePath))
+		if err != nil {
+			return nil, err
+		}
+
+		report := checker.NewCheckReport(rulePath, ruleResult)
+		return report, s.applyIfPassed(importLoopData, &report, apply)
+	})
+}
+
+func (s *Service) ApplyEnvironment(
+	sender dbus.Sender,
+	identifierOrPath string,
+	envName string,
+	options []string,
+	confirmed bool,
+) (string, *dbus.Error) {
+	return s.execute(sender, "ApplyEnvironment", identifierOrPath+"@"+envName, options, func(importLoopData *shared.ImportLoopData, callerUid int) (any, error) {
+		rulesetLocation, err := getRulesetLocation(callerUid, identifierOrPath)
+		if err != nil {
+			return nil, err
+		}
+		summary, err := checker.GetRuleSummary(&rulesetLocation, envName, options)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkConfirmation(summary, confirmed); err != nil {
+			return nil, err
+		}
+
+		revertNum, err := checker.ApplyEnvironmentByIdentifier(importLoopData, identifierOrPath, envName)
+		if err != nil {
+			return nil, err
+		}
+		return checker.EnvironmentReport{
+			Environment:  identifierOrPath + "@" + envName,
+			RevertNumber: revertNum,
+			ChangedFiles: importLoopData.VRCT.ChangedFiles(),
+		}, nil
+	})
+}
+
+func (s *Service) ListHistory(sender dbus.Sender) (string, *dbus.Error) {
+	if _, err := s.authorize(sender, ReadActionId); err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	history, err := checker.GetHistory()
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return toJson(history)
+}
+
+func (s *Service) Revert(sender dbus.Sender, revertNum int32) (string, *dbus.Error) {
+	target := fmt.Sprint(revertNum)
+	if _, err := s.authorize(sender, ExecuteActionId); err != nil {
+		return s.finish("Revert", target, nil, err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.emitProgress("Revert", target, StageStarted)
+
+	report, err := checker.RevertChanges(s.newLogger(), int(revertNum))
+	return s.finish("Revert", target, report, err)
+}
+
+// execute runs the operation with a new runtime data, if the sender is authorized to do it, and sends progress signals
+func (s *Service) execute(
+	sender dbus.Sender,
+	operation string,
+	target string,
+	options []string,
+	operationFn func(importLoopData *shared.ImportLoopData, callerUid int) (any, error),
+) (string, *dbus.Error) {
+	callerUid, err := s.authorize(sender, ExecuteActionId)
+	if err != nil {
+		return s.finish(operation, target, nil, err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.emitProgress(operation, target, StageStarted)
+
+	ruleVRCT, err := vrct.NewRuleVRCT()
+	if err != nil {
+		return s.finish(operation, target, nil, err)
+	}
+
+	importLoopData := shared.ImportLoopData{
+		VRCT:           *ruleVRCT,
+		InfoApi:        s.newLogger(),
+		RulesHistory:   shared.RulesHistory{},
+		ErrChan:        make(chan error),
+		PackageTracker: package_conflict.NewPackageConflictTracker(),
+		Options:        options,
+		DaemonTracker:  daemontracker.NewDaemonTracker(),
+	}
+	defer func() {
+		if err := importLoopData.DeleteRuntimeTemp(); err != nil {
+			importLoopData.InfoApi.Error("failed to remove temporary VRCT files:", err.Error())
+		}
+	}()
+
+	result, err := operationFn(&importLoopData, callerUid)
+	return s.finish(operation, target, result, err)
+}
+
+func (s *Service) applyIfPassed(importLoopData *shared.ImportLoopData, report *checker.CheckReport, apply bool) error {
+	if !apply || report.Result.Status != checker.RulePassed {
+		return nil
+	}
+	s.emitProgress("Apply", report.Rule, StageChecked)
+
+	report.Packages = checker.GetPackageTransaction(importLoopData)
+	revertNum, err := checker.ApplyChanges(importLoopData)
+	if err != nil {
+		return err
+	}
+	report.SetApplied(revertNum, importLoopData.VRCT.ChangedFiles())
+	return nil
+}
+
+// checkConfirmation rejects rules, which are unsafe or require root privileges, unless the caller has confirmed them
+func checkConfirmation(summary checker.RuleSummary, confirmed bool) error {
+	if summary.RequiresConfirmation() && !confirmed {
+		return fmt.Errorf("rule %s requires confirmation, check its summary and call the method with confirmed set to true", summary.Name)
+	}
+	return nil
+}
+
+func (s *Service) finish(operation string, target string, result any, err error) (string, *dbus.Error) {
+	if err != nil {
+		s.emitProgress(operation, target, StageFailed)
+		s.newLogger().Error(err.Error())
+		return "", dbus.MakeFailedError(err)
+	}
+
+	s.emitProgress(operation, target, StageFinished)
+	return toJson(result)
+}
+
+// newLogger sends all messages with Log signal, clients decide which levels they show
+func (s *Service) newLogger() shared.Logger {
+	return shared.Logger{
+		Output: signalInfoApi{service: s},
+		Level:  shared.LevelDebug,
+		File:   s.logFile,
+	}
+}
+
+func (s *Service) emitProgress(operation string, target string, stage string) {
+	_ = s.conn.Emit(ObjectPath, InterfaceName+".Progress", operation, target, stage)
+}
+
+// getRulesetLocation treats only absolute paths as local rulesets, relative ones would be resolved
+// in the working directory of the service. Files of local rulesets are read with privileges of the service,
+// so only callers running as the same user can use them
+func getRulesetLocation(callerUid int, identifierOrPath string) (checker.RulesetLocation, error) {
+	if strings.HasPrefix(identifierOrPath, ".") {
+		return checker.RulesetLocation{}, fmt.Errorf("path '%s' has to be absolute, it would be resolved in the working directory of the service", identifierOrPath)
+	}
+	if !filepath.IsAbs(identifierOrPath) {
+		return checker.NewRulesetLocation(identifierOrPath, false)
+	}
+	if callerUid != os.Getuid() {
+		return checker.RulesetLocation{}, fmt.Errorf("local ruleset %s can be used only by the user running the service, use CheckRuleFile or a published ruleset", identifierOrPath)
+	}
+	return checker.NewRulesetLocation(filepath.Clean(identifierOrPath), true)
+}
+
+func toJson(value any) (string, *dbus.Error) {
+	result, err := json.Marshal(value)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return string(result), nil
+}
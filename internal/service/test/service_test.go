@@ -0,0 +1,199 @@
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/internal/service"
+	"github.com/godbus/dbus/v5"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rulesetConfig = `repo_url: github.com/avorty/service-ruleset
+identifier: service-ruleset
+rules:
+  editor:
+    path: ./rules/editor.lua
+`
+
+const editorRule = `function main()
+    api.info.log("neovim is already configured")
+    return api.result.noop("nothing to do")
+end
+`
+
+// startPrivateBus starts dbus-daemon, so the test doesn't depend on the session bus of the user
+func startPrivateBus(t *testing.T) string {
+	daemonPath, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+
+	configPath := filepath.Join(t.TempDir(), "bus.conf")
+	config := `<busconfig>
+	<type>session</type>
+	<listen>unix:dir=` + t.TempDir() + `</listen>
+	<policy context="default">
+		<allow send_destination="*" eavesdrop="true"/>
+		<allow eavesdrop="true"/>
+		<allow own="*"/>
+	</policy>
+</busconfig>`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	daemon := exec.Command(daemonPath, "--config-file="+configPath, "--nofork", "--print-address")
+	stdout, err := daemon.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = daemon.Process.Kill()
+		_ = daemon.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(address)
+}
+
+func connect(t *testing.T, address string) *dbus.Conn {
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+func createRuleset(t *testing.T) string {
+	rulesetPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rulesetPath, "rules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rulesetPath, "spito.yml"), []byte(rulesetConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rulesetPath, "rules", "editor.lua"), []byte(editorRule), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return rulesetPath
+}
+
+func TestService(t *testing.T) {
+	address := startPrivateBus(t)
+	if _, err := service.Serve(connect(t, address), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	client := connect(t, address)
+	if err := client.AddMatchSignal(dbus.WithMatchInterface(service.InterfaceName)); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 32)
+	client.Signal(signals)
+
+	object := client.Object(service.BusName, service.ObjectPath)
+
+	var introspection string
+	if err := object.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&introspection); err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range []string{"CheckRule", "ApplyEnvironment", "ListHistory", "Revert", "Progress"} {
+		if !strings.Contains(introspection, member) {
+			t.Fatalf("introspection doesn't contain %s:\n%s", member, introspection)
+		}
+	}
+
+	var reportJson string
+	err := object.Call(service.InterfaceName+".CheckRule", 0, createRuleset(t), "editor", []string{}, true, false).Store(&reportJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var report checker.CheckReport
+	if err := json.Unmarshal([]byte(reportJson), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Result.Status != checker.RuleAlreadySatisfied || report.Result.Message != "nothing to do" || report.Applied {
+		t.Fatalf("unexpected report: %s", reportJson)
+	}
+
+	var isLogReceived, isFinished bool
+	timeout := time.After(5 * time.Second)
+	for !isLogReceived || !isFinished {
+		select {
+		case signal := <-signals:
+			switch signal.Name {
+			case service.InterfaceName + ".Log":
+				if signal.Body[2] == "neovim is already configured" {
+					isLogReceived = strings.HasSuffix(signal.Body[1].(string), "@editor")
+				}
+			case service.InterfaceName + ".Progress":
+				isFinished = isFinished || signal.Body[2] == service.StageFinished
+			}
+		case <-timeout:
+			t.Fatalf("signals weren't received, log: %v, finished: %v", isLogReceived, isFinished)
+		}
+	}
+
+	err = object.Call(service.InterfaceName+".CheckRule", 0, createRuleset(t), "missing", []string{}, false, false).Err
+	if err == nil {
+		t.Fatal("checking missing rule should fail")
+	}
+}
+
+func TestServiceRequiresConfirmation(t *testing.T) {
+	address := startPrivateBus(t)
+	if _, err := service.Serve(connect(t, address), nil); err != nil {
+		t.Fatal(err)
+	}
+	object := connect(t, address).Object(service.BusName, service.ObjectPath)
+
+	rulePath := filepath.Join(t.TempDir(), "unsafe.lua")
+	if err := os.WriteFile(rulePath, []byte("#![unsafe]\n"+editorRule), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := object.Call(service.InterfaceName+".CheckRuleFile", 0, rulePath, []string{}, false, false).Err
+	if err == nil || !strings.Contains(err.Error(), "requires confirmation") {
+		t.Fatalf("unsafe rule has been executed without confirmation, error: %v", err)
+	}
+
+	var reportJson string
+	err = object.Call(service.InterfaceName+".CheckRuleFile", 0, rulePath, []string{}, false, true).Store(&reportJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServiceRejectsRelativePaths(t *testing.T) {
+	address := startPrivateBus(t)
+	if _, err := service.Serve(connect(t, address), nil); err != nil {
+		t.Fatal(err)
+	}
+	object := connect(t, address).Object(service.BusName, service.ObjectPath)
+
+	err := object.Call(service.InterfaceName+".CheckRuleFile", 0, "./unsafe.lua", []string{}, false, true).Err
+	if err == nil || !strings.Contains(err.Error(), "has to be absolute") {
+		t.Fatalf("relative rule file path has been accepted, error: %v", err)
+	}
+
+	err = object.Call(service.InterfaceName+".GetRuleSummary", 0, "../ruleset", "editor", []string{}).Err
+	if err == nil || !strings.Contains(err.Error(), "has to be absolute") {
+		t.Fatalf("relative ruleset path has been accepted, error: %v", err)
+	}
+}
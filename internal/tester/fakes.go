@@ -0,0 +1,323 @@
+package tester
+
+import (
+	"fmt"
+	"github.com/avorty/spito/pkg/api"
+	"slices"
+	"strings"
+)
+
+type PackageAction struct {
+	Action  string
+	Package string
+}
+
+// FakePackageManager simulates package manager, it only remembers which packages are installed
+type FakePackageManager struct {
+	Installed map[string]api.Package
+	// Available simulates repositories, they are searched and installed packages are upgraded to their versions
+	Available map[string]api.Package
+	// Files owned by the installed packages
+	Files map[string][]string
+	// Keys are fingerprints of the trusted keys
+	Keys    []string
+	Actions []PackageAction
+}
+
+func NewFakePackageManager() *FakePackageManager {
+	return &FakePackageManager{
+		Installed: make(map[string]api.Package),
+		Available: make(map[string]api.Package),
+		Files:     make(map[string][]string),
+	}
+}
+
+func (f *FakePackageManager) GetPackage(name string) (api.Package, error) {
+	installedPackage, ok := f.Installed[name]
+	if !ok {
+		return api.Package{}, fmt.Errorf("package '%s' was not found", name)
+	}
+	return installedPackage, nil
+}
+
+func (f *FakePackageManager) ListInstalledPackages(filter api.PackageFilter) ([]api.Package, error) {
+	return getSortedPackages(f.Installed, func(installedPackage api.Package) bool {
+		_, isAvailable := f.Available[installedPackage.Name]
+		switch filter {
+		case api.ExplicitPackages:
+			return installedPackage.IsExplicit()
+		case api.DependencyPackages:
+			return !installedPackage.IsExplicit()
+		case api.OrphanPackages:
+			return !installedPackage.IsExplicit() && len(installedPackage.RequiredBy) == 0
+		case api.ForeignPackages:
+			return !isAvailable
+		}
+		return true
+	}), nil
+}
+
+func (f *FakePackageManager) SearchPackages(term string) ([]api.Package, error) {
+	term = strings.ToLower(term)
+	return getSortedPackages(f.Available, func(availablePackage api.Package) bool {
+		return strings.Contains(strings.ToLower(availablePackage.Name), term) ||
+			strings.Contains(strings.ToLower(availablePackage.Description), term)
+	}), nil
+}
+
+func (f *FakePackageManager) GetFileOwner(filePath string) (api.Package, error) {
+	for packageName, files := range f.Files {
+		if slices.Contains(files, filePath) {
+			return f.GetPackage(packageName)
+		}
+	}
+	return api.Package{}, fmt.Errorf("no package owns %s", filePath)
+}
+
+func (f *FakePackageManager) GetPackageFiles(name string) ([]string, error) {
+	if _, err := f.GetPackage(name); err != nil {
+		return nil, err
+	}
+	return f.Files[name], nil
+}
+
+func (f *FakePackageManager) GetAvailableUpdates() ([]api.Package, error) {
+	var updates []api.Package
+	for _, installedPackage := range getSortedPackages(f.Installed, nil) {
+		availablePackage, isAvailable := f.Available[installedPackage.Name]
+		if isAvailable && api.CompareVersions(api.PacmanVersions, availablePackage.Version, installedPackage.Version) > 0 {
+			updates = append(updates, availablePackage)
+		}
+	}
+	return updates, nil
+}
+
+// getSortedPackages returns packages accepted by the filter sorted by their names, nil filter accepts all of them
+func getSortedPackages(packages map[string]api.Package, filter func(api.Package) bool) []api.Package {
+	result := make([]api.Package, 0, len(packages))
+	for _, foundPackage := range packages {
+		if filter == nil || filter(foundPackage) {
+			result = append(result, foundPackage)
+		}
+	}
+	slices.SortFunc(result, func(a, b api.Package) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result
+}
+
+func (f *FakePackageManager) InstallPackages(packages ...string) error {
+	for _, packageString := range packages {
+		packageName, constraint, err := api.ParsePackageString(packageString)
+		if err != nil {
+			return err
+		}
+
+		installedPackage, isInstalled := f.Installed[packageName]
+		if isInstalled && constraint.Operator != api.VersionAny &&
+			constraint.IsSatisfiedBy(api.PacmanVersions, installedPackage.Version) {
+			continue
+		}
+
+		// Repositories of the fake package manager contain any requested version
+		f.Installed[packageName] = newFakePackage(packageName, constraint.Version)
+		f.Actions = append(f.Actions, PackageAction{Action: "install", Package: packageName})
+	}
+	return nil
+}
+
+func (f *FakePackageManager) InstallPackageVersion(name string, version string) error {
+	f.Installed[name] = newFakePackage(name, version)
+	f.Actions = append(f.Actions, PackageAction{Action: "install", Package: name})
+	return nil
+}
+
+func (f *FakePackageManager) RemovePackages(packages ...string) error {
+	for _, packageName := range packages {
+		if _, ok := f.Installed[packageName]; !ok {
+			return fmt.Errorf("target not found: %s", packageName)
+		}
+
+		delete(f.Installed, packageName)
+		delete(f.Files, packageName)
+		f.Actions = append(f.Actions, PackageAction{Action: "remove", Package: packageName})
+	}
+	return nil
+}
+
+func (f *FakePackageManager) HasKey(fingerprint string) (bool, error) {
+	fingerprint, err := api.NormalizeKeyFingerprint(fingerprint)
+	return slices.Contains(f.Keys, fingerprint), err
+}
+
+func (f *FakePackageManager) ImportKey(fingerprint string, source string) error {
+	fingerprint, err := api.NormalizeKeyFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(f.Keys, fingerprint) {
+		f.Keys = append(f.Keys, fingerprint)
+	}
+	f.Actions = append(f.Actions, PackageAction{Action: "importKey", Package: fingerprint})
+	return nil
+}
+
+func (f *FakePackageManager) RemoveKey(fingerprint string) error {
+	fingerprint, err := api.NormalizeKeyFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+	f.Keys = slices.DeleteFunc(f.Keys, func(key string) bool {
+		return key == fingerprint
+	})
+	f.Actions = append(f.Actions, PackageAction{Action: "removeKey", Package: fingerprint})
+	return nil
+}
+
+func (f *FakePackageManager) SyncDatabases(repositories ...string) error {
+	for _, repository := range repositories {
+		f.Actions = append(f.Actions, PackageAction{Action: "syncDatabases", Package: repository})
+	}
+	return nil
+}
+
+func (f *FakePackageManager) wasDone(action, packageName string) bool {
+	for _, packageAction := range f.Actions {
+		if packageAction.Action == action && packageAction.Package == packageName {
+			return true
+		}
+	}
+	return false
+}
+
+type DaemonAction struct {
+	Action string
+	Daemon string
+}
+
+// FakeInitManager simulates init system, daemons have to be added before they can be controlled
+type FakeInitManager struct {
+	Daemons map[string]api.Daemon
+	Actions []DaemonAction
+	// UnitExists reports whether the unit file was written, such units become daemons when the init system is reloaded
+	UnitExists func(unitName string) bool
+	isReloaded bool
+}
+
+func NewFakeInitManager() *FakeInitManager {
+	return &FakeInitManager{
+		Daemons: make(map[string]api.Daemon),
+	}
+}
+
+func (f *FakeInitManager) GetDaemon(daemonName string) (api.Daemon, error) {
+	daemon, ok := f.Daemons[daemonName]
+	if !ok && f.isReloaded && f.UnitExists != nil && f.UnitExists(daemonName) {
+		daemon = newFakeDaemon(daemonName, false, false)
+		f.Daemons[daemonName] = daemon
+		return daemon, nil
+	}
+	if !ok {
+		return api.Daemon{}, api.ErrDaemonDoesNotExist
+	}
+	return daemon, nil
+}
+
+func (f *FakeInitManager) StartDaemon(daemonName string) error {
+	return f.updateDaemon("start", daemonName, func(daemon *api.Daemon) {
+		daemon.IsActive = true
+	})
+}
+
+func (f *FakeInitManager) StopDaemon(daemonName string) error {
+	return f.updateDaemon("stop", daemonName, func(daemon *api.Daemon) {
+		daemon.IsActive = false
+	})
+}
+
+func (f *FakeInitManager) RestartDaemon(daemonName string) error {
+	return f.updateDaemon("restart", daemonName, func(daemon *api.Daemon) {
+		daemon.IsActive = true
+	})
+}
+
+func (f *FakeInitManager) EnableDaemon(daemonName string) error {
+	return f.updateDaemon("enable", daemonName, func(daemon *api.Daemon) {
+		daemon.IsEnabled = true
+	})
+}
+
+func (f *FakeInitManager) DisableDaemon(daemonName string) error {
+	return f.updateDaemon("disable", daemonName, func(daemon *api.Daemon) {
+		daemon.IsEnabled = false
+	})
+}
+
+func (f *FakeInitManager) MaskDaemon(daemonName string) error {
+	return f.updateDaemon("mask", daemonName, func(daemon *api.Daemon) {
+		daemon.IsMasked = true
+	})
+}
+
+func (f *FakeInitManager) UnmaskDaemon(daemonName string) error {
+	return f.updateDaemon("unmask", daemonName, func(daemon *api.Daemon) {
+		daemon.IsMasked = false
+	})
+}
+
+func (f *FakeInitManager) ReloadDaemons() error {
+	f.isReloaded = true
+	f.Actions = append(f.Actions, DaemonAction{Action: "daemon-reload"})
+	return nil
+}
+
+func (f *FakeInitManager) updateDaemon(action, daemonName string, update func(daemon *api.Daemon)) error {
+	daemon, err := f.GetDaemon(daemonName)
+	if err != nil {
+		return err
+	}
+
+	update(&daemon)
+	f.Daemons[daemonName] = daemon
+	f.Actions = append(f.Actions, DaemonAction{Action: action, Daemon: daemonName})
+	return nil
+}
+
+func (f *FakeInitManager) wasDone(action, daemonName string) bool {
+	for _, daemonAction := range f.Actions {
+		if daemonAction.Action == action && daemonAction.Daemon == daemonName {
+			return true
+		}
+	}
+	return false
+}
+
+// captureInfoApi collects everything rules print, so it can be attached to the test result
+type captureInfoApi struct {
+	output *[]string
+}
+
+func (c captureInfoApi) print(prefix string, args []any) {
+	line := "[" + prefix + "] " + strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+	*c.output = append(*c.output, line)
+}
+
+func (c captureInfoApi) Log(args ...any) {
+	c.print("log", args)
+}
+
+func (c captureInfoApi) Debug(args ...any) {
+	c.print("debug", args)
+}
+
+func (c captureInfoApi) Error(args ...any) {
+	c.print("error", args)
+}
+
+func (c captureInfoApi) Warn(args ...any) {
+	c.print("warn", args)
+}
+
+func (c captureInfoApi) Important(args ...any) {
+	c.print("important", args)
+}
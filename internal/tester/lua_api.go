@@ -0,0 +1,216 @@
+package tester
+
+import (
+	"github.com/avorty/spito/internal/checker"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/yuin/gopher-lua"
+	luar "layeh.com/gopher-luar"
+)
+
+const testNamespaceName = "test"
+
+// attachTestApi creates the `test` namespace which is available in the *_test.lua files
+func attachTestApi(s *sandbox, L *lua.LState) {
+	testNamespace := L.NewTable()
+
+	L.SetFuncs(testNamespace, map[string]lua.LGFunction{
+		"run": func(L *lua.LState) int {
+			ruleName := L.CheckString(1)
+			var options []string
+			for i := 2; i <= L.GetTop(); i++ {
+				options = append(options, L.CheckString(i))
+			}
+
+			ruleResult, err := s.runRule(ruleName, options)
+			isPassed := ruleResult.Status == checker.RulePassed || ruleResult.Status == checker.RuleAlreadySatisfied
+			L.Push(lua.LBool(isPassed && err == nil))
+			pushError(L, err)
+			L.Push(lua.LString(ruleResult.Status.String()))
+			L.Push(lua.LString(ruleResult.Message))
+			return 4
+		},
+		"setPlatform": func(L *lua.LState) int {
+			platform := L.CheckTable(1)
+			s.platform = api.Platform{
+				Distro:     lua.LVAsString(platform.RawGetString("distro")),
+				InitSystem: api.InitSystem(lua.LVAsString(platform.RawGetString("init"))),
+				Arch:       lua.LVAsString(platform.RawGetString("arch")),
+			}
+			return 0
+		},
+		"assert": func(L *lua.LState) int {
+			if !lua.LVAsBool(L.Get(1)) {
+				L.RaiseError("assertion failed: %s", L.OptString(2, "condition is false"))
+			}
+			return 0
+		},
+		"assertEqual": func(L *lua.LState) int {
+			expected, actual := L.Get(1), L.Get(2)
+			if !L.Equal(expected, actual) {
+				L.RaiseError("assertion failed: %s: expected '%s', got '%s'",
+					L.OptString(3, "values are not equal"), expected.String(), actual.String())
+			}
+			return 0
+		},
+		"fail": func(L *lua.LState) int {
+			L.RaiseError("%s", L.OptString(1, "test failed"))
+			return 0
+		},
+	})
+
+	// setFacts replaces facts returned by api.sys.getFacts, the table has the same fields as the facts
+	L.SetField(testNamespace, "setFacts", luar.New(L, func(facts api.Facts) {
+		s.facts = facts
+	}))
+
+	L.SetField(testNamespace, "fs", getFsNamespace(s, L))
+	L.SetField(testNamespace, "pkg", getPackageNamespace(s, L))
+	L.SetField(testNamespace, "daemon", getDaemonNamespace(s, L))
+
+	L.SetGlobal(testNamespaceName, testNamespace)
+}
+
+func getFsNamespace(s *sandbox, L *lua.LState) *lua.LTable {
+	return L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		// write creates file in the simulated system before the rule is executed
+		"write": func(L *lua.LState) int {
+			pushError(L, s.writeFile(L.CheckString(1), L.CheckString(2)))
+			return 1
+		},
+		// read returns content of the file as the rule left it
+		"read": func(L *lua.LState) int {
+			content, err := s.vrct.Fs.ReadFile(L.CheckString(1))
+			if err != nil {
+				L.Push(lua.LNil)
+				pushError(L, err)
+				return 2
+			}
+			L.Push(lua.LString(content))
+			L.Push(lua.LNil)
+			return 2
+		},
+		"exists": func(L *lua.LState) int {
+			_, err := s.vrct.Fs.Stat(L.CheckString(1))
+			L.Push(lua.LBool(err == nil))
+			return 1
+		},
+	})
+}
+
+func getPackageNamespace(s *sandbox, L *lua.LState) *lua.LTable {
+	packageManager := s.packageManager
+
+	return L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		// add marks package as installed before the rule is executed, optionally only as a dependency
+		"add": func(L *lua.LState) int {
+			packageName, version := L.CheckString(1), L.OptString(2, "")
+			installedPackage := newFakePackage(packageName, version)
+			if L.OptBool(3, false) {
+				installedPackage.InstallReason = "Installed as a dependency for another package"
+			}
+			packageManager.Installed[packageName] = installedPackage
+			return 0
+		},
+		// addAvailable adds package to the simulated repositories
+		"addAvailable": func(L *lua.LState) int {
+			packageName := L.CheckString(1)
+			availablePackage := api.Package{Name: packageName, Version: L.OptString(2, ""), Description: L.OptString(3, "")}
+			packageManager.Available[packageName] = availablePackage
+			return 0
+		},
+		// addFiles makes the installed package own the files
+		"addFiles": func(L *lua.LState) int {
+			packageName := L.CheckString(1)
+			for i := 2; i <= L.GetTop(); i++ {
+				packageManager.Files[packageName] = append(packageManager.Files[packageName], L.CheckString(i))
+			}
+			return 0
+		},
+		"get": func(L *lua.LState) int {
+			installedPackage, err := packageManager.GetPackage(L.CheckString(1))
+			if err != nil {
+				L.Push(lua.LNil)
+				return 1
+			}
+			L.Push(luar.New(L, installedPackage))
+			return 1
+		},
+		"isInstalled": func(L *lua.LState) int {
+			_, isInstalled := packageManager.Installed[L.CheckString(1)]
+			L.Push(lua.LBool(isInstalled))
+			return 1
+		},
+		"wasInstalled": func(L *lua.LState) int {
+			L.Push(lua.LBool(packageManager.wasDone("install", L.CheckString(1))))
+			return 1
+		},
+		"wasRemoved": func(L *lua.LState) int {
+			L.Push(lua.LBool(packageManager.wasDone("remove", L.CheckString(1))))
+			return 1
+		},
+	})
+}
+
+func getDaemonNamespace(s *sandbox, L *lua.LState) *lua.LTable {
+	return L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		// add creates daemon in the simulated init system before the rule is executed
+		"add": func(L *lua.LState) int {
+			daemonName := L.CheckString(1)
+			initManager := getScopedInitManager(s, L, 4)
+			initManager.Daemons[daemonName] = newFakeDaemon(daemonName, L.OptBool(2, false), L.OptBool(3, false))
+			return 0
+		},
+		"get": func(L *lua.LState) int {
+			daemon, err := getScopedInitManager(s, L, 2).GetDaemon(L.CheckString(1))
+			if err != nil {
+				L.Push(lua.LNil)
+				return 1
+			}
+			L.Push(luar.New(L, daemon))
+			return 1
+		},
+		// did checks whether the rule executed given action (start, stop, restart, enable, disable, mask or unmask) on the daemon
+		"did": func(L *lua.LState) int {
+			initManager := getScopedInitManager(s, L, 3)
+			L.Push(lua.LBool(initManager.wasDone(L.CheckString(2), L.CheckString(1))))
+			return 1
+		},
+	})
+}
+
+// getScopedInitManager returns the simulated init system of the optional scope argument
+func getScopedInitManager(s *sandbox, L *lua.LState, scopeIndex int) *FakeInitManager {
+	scope, err := api.ParseDaemonScope(L.OptString(scopeIndex, ""))
+	if err != nil {
+		L.ArgError(scopeIndex, err.Error())
+	}
+	if scope == api.UserScope {
+		return s.userInitManager
+	}
+	return s.initManager
+}
+
+func pushError(L *lua.LState, err error) {
+	if err != nil {
+		L.Push(lua.LString(err.Error()))
+		return
+	}
+	L.Push(lua.LNil)
+}
+
+func newFakePackage(name, version string) api.Package {
+	return api.Package{
+		Name:          name,
+		Version:       version,
+		InstallReason: api.ExplicitInstallReason,
+	}
+}
+
+func newFakeDaemon(name string, isActive, isEnabled bool) api.Daemon {
+	return api.Daemon{
+		Name:      name,
+		IsActive:  isActive,
+		IsEnabled: isEnabled,
+		RunLevel:  "multi-user.target",
+	}
+}
@@ -0,0 +1,140 @@
+package tester
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Format string
+
+const (
+	TapFormat   Format = "tap"
+	JUnitFormat Format = "junit"
+)
+
+func WriteReport(writer io.Writer, format Format, results []Result) error {
+	switch format {
+	case TapFormat:
+		return WriteTap(writer, results)
+	case JUnitFormat:
+		return WriteJUnit(writer, results)
+	default:
+		return fmt.Errorf("unknown report format '%s', use %s or %s", format, TapFormat, JUnitFormat)
+	}
+}
+
+// WriteTap writes results in the Test Anything Protocol version 13
+func WriteTap(writer io.Writer, results []Result) error {
+	var report strings.Builder
+
+	report.WriteString("TAP version 13\n")
+	report.WriteString(fmt.Sprintf("1..%d\n", len(results)))
+
+	for i, result := range results {
+		status := "ok"
+		if !result.Passed {
+			status = "not ok"
+		}
+		report.WriteString(fmt.Sprintf("%s %d - %s: %s\n", status, i+1, result.File, result.Name))
+
+		if !result.Passed {
+			report.WriteString("  ---\n")
+			report.WriteString(fmt.Sprintf("  message: %q\n", result.Message))
+			report.WriteString(fmt.Sprintf("  duration_ms: %d\n", result.Duration.Milliseconds()))
+			report.WriteString("  ...\n")
+		}
+		for _, line := range result.Output {
+			report.WriteString("# " + line + "\n")
+		}
+	}
+
+	_, err := io.WriteString(writer, report.String())
+	return err
+}
+
+type jUnitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []jUnitTestSuite `xml:"testsuite"`
+}
+
+type jUnitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []jUnitTestCase `xml:"testcase"`
+}
+
+type jUnitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *jUnitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type jUnitFailure struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+// WriteJUnit writes results as JUnit XML, every test file is a separate test suite
+func WriteJUnit(writer io.Writer, results []Result) error {
+	report := jUnitTestSuites{
+		Name:  "spito",
+		Tests: len(results),
+	}
+	suiteIndexes := make(map[string]int)
+	suiteDurations := make(map[string]float64)
+
+	for _, result := range results {
+		suiteIndex, ok := suiteIndexes[result.File]
+		if !ok {
+			suiteIndex = len(report.Suites)
+			suiteIndexes[result.File] = suiteIndex
+			report.Suites = append(report.Suites, jUnitTestSuite{Name: result.File})
+		}
+		suite := &report.Suites[suiteIndex]
+
+		testCase := jUnitTestCase{
+			Name:      result.Name,
+			ClassName: result.File,
+			Time:      formatSeconds(result.Duration.Seconds()),
+			SystemOut: strings.Join(result.Output, "\n"),
+		}
+		if !result.Passed {
+			testCase.Failure = &jUnitFailure{
+				Message: result.Message,
+				Content: result.Message,
+			}
+			suite.Failures++
+			report.Failures++
+		}
+
+		suite.Tests++
+		suite.TestCases = append(suite.TestCases, testCase)
+		suiteDurations[result.File] += result.Duration.Seconds()
+		suite.Time = formatSeconds(suiteDurations[result.File])
+	}
+
+	if _, err := io.WriteString(writer, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(writer, "\n")
+	return err
+}
+
+func formatSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
@@ -0,0 +1,170 @@
+package tester
+
+import (
+	"github.com/avorty/spito/internal/checker"
+	daemontracker "github.com/avorty/spito/pkg"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/package_conflict"
+	"github.com/avorty/spito/pkg/path"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/vrct"
+	"os"
+	"path/filepath"
+)
+
+const sandboxRootPrefix = "spito-test-root-"
+
+// sandbox is a throwaway system in which a single test is executed.
+// It consists of empty filesystem root, fake package manager and fake init system
+type sandbox struct {
+	rulesetPath    string
+	root           string
+	vrct           *vrct.RuleVRCT
+	packageManager *FakePackageManager
+	initManager    *FakeInitManager
+	// userInitManager simulates the user's service manager
+	userInitManager *FakeInitManager
+	platform        api.Platform
+	facts           api.Facts
+	output          []string
+
+	previousPackageManager  api.PackageManager
+	previousInitManager     api.InitManager
+	previousUserInitManager api.InitManager
+	previousPlatform        func() (api.Platform, error)
+	previousFacts           func() api.Facts
+}
+
+// defaultPlatform is used by targeting decorators unless test changes it using test.setPlatform
+var defaultPlatform = api.Platform{
+	Distro:     "arch",
+	InitSystem: api.SYSTEMD,
+	Arch:       "x86_64",
+}
+
+// defaultFacts describe a simple machine without GPU, tests can change them using test.setFacts
+var defaultFacts = api.Facts{
+	Hostname: "spito-test",
+	Kernel:   api.KernelFacts{Release: "6.6.0", Architecture: "x86_64"},
+	Cpu:      api.CpuFacts{Vendor: "GenuineIntel", Cores: 4, Threads: 8},
+	Memory:   api.MemoryFacts{Total: 8 << 30, Available: 4 << 30},
+	Timezone: "UTC",
+	Locale:   "en_US.UTF-8",
+}
+
+func newSandbox(rulesetPath string) (*sandbox, error) {
+	root, err := os.MkdirTemp("", sandboxRootPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	ruleVRCT, err := vrct.NewRuleVRCTWithRoot(root)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sandbox{
+		rulesetPath:             rulesetPath,
+		root:                    root,
+		vrct:                    ruleVRCT,
+		packageManager:          NewFakePackageManager(),
+		initManager:             NewFakeInitManager(),
+		userInitManager:         NewFakeInitManager(),
+		platform:                defaultPlatform,
+		facts:                   defaultFacts,
+		previousPackageManager:  api.PackageManagerBackend,
+		previousInitManager:     api.InitManagerBackend,
+		previousUserInitManager: api.UserInitManagerBackend,
+		previousPlatform:        api.PlatformBackend,
+		previousFacts:           api.FactsBackend,
+	}
+
+	s.initManager.UnitExists = func(unitName string) bool {
+		return s.unitExists(unitName, api.SystemScope)
+	}
+	s.userInitManager.UnitExists = func(unitName string) bool {
+		return s.unitExists(unitName, api.UserScope)
+	}
+
+	api.PackageManagerBackend = s.packageManager
+	api.InitManagerBackend = s.initManager
+	api.UserInitManagerBackend = s.userInitManager
+	api.PlatformBackend = func() (api.Platform, error) {
+		return s.platform, nil
+	}
+	api.FactsBackend = func() api.Facts {
+		return s.facts
+	}
+
+	return s, nil
+}
+
+func (s *sandbox) close() error {
+	api.PackageManagerBackend = s.previousPackageManager
+	api.InitManagerBackend = s.previousInitManager
+	api.UserInitManagerBackend = s.previousUserInitManager
+	api.PlatformBackend = s.previousPlatform
+	api.FactsBackend = s.previousFacts
+
+	if err := s.vrct.DeleteRuntimeTemp(); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.root)
+}
+
+// realPath returns where given path of the simulated system is stored
+func (s *sandbox) realPath(filePath string) (string, error) {
+	if err := path.ExpandTilde(&filePath); err != nil {
+		return "", err
+	}
+
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filePath), nil
+}
+
+func (s *sandbox) writeFile(filePath, content string) error {
+	realPath, err := s.realPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(realPath), path.DirectoryPermissions); err != nil {
+		return err
+	}
+	return os.WriteFile(realPath, []byte(content), path.FilePermissions)
+}
+
+// runRule executes rule from the tested ruleset, every run uses new rule history,
+// but changes to the filesystem, packages and daemons are kept during the whole test
+func (s *sandbox) runRule(ruleName string, options []string) (checker.RuleResult, error) {
+	importLoopData := shared.ImportLoopData{
+		VRCT:           *s.vrct,
+		InfoApi:        captureInfoApi{output: &s.output},
+		RulesHistory:   shared.RulesHistory{},
+		ErrChan:        make(chan error),
+		PackageTracker: package_conflict.NewPackageConflictTracker(),
+		Options:        options,
+		DaemonTracker:  daemontracker.NewDaemonTracker(),
+		Simulated:      true,
+	}
+
+	ruleResult, err := checker.CheckRuleByPath(&importLoopData, s.rulesetPath, ruleName)
+	if err != nil || !ruleResult.Status.IsSuccessful() {
+		return ruleResult, err
+	}
+
+	// Requested packages are installed, units are reloaded and daemons are changed as if the changes were applied
+	if err := checker.ExecutePackageTransaction(&importLoopData); err != nil {
+		return ruleResult, err
+	}
+	return ruleResult, importLoopData.ExecuteDeferredDaemonOperations()
+}
+
+// unitExists checks whether the rule has created the unit, daemons without the type suffix are services
+func (s *sandbox) unitExists(unitName string, scope api.DaemonScope) bool {
+	_, err := s.vrct.Fs.Stat(api.GetUnitPath(daemontracker.GetUnitName(unitName), scope))
+	return err == nil
+}
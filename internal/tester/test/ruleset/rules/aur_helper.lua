@@ -0,0 +1,11 @@
+#![distro(arch)]
+#![init(systemd, openrc)]
+#![arch([x86_64, aarch64])]
+
+function main()
+    local err = api.pkg.install("yay")
+    if err ~= nil then
+        return false
+    end
+    return true
+end
@@ -0,0 +1,27 @@
+function main()
+    local err = api.pkg.install("neovim")
+    if err ~= nil then
+        return false
+    end
+
+    err = api.pkg.remove("vim")
+    if err ~= nil then
+        return false
+    end
+
+    local config, _ = api.fs.readFile("/etc/editor.conf")
+    if config == nil or config == "" then
+        config = "editor=nano"
+    end
+
+    api.fs.createFile("/etc/editor.conf", config .. "\nline_numbers=true", false)
+
+    err = api.daemon.enable("sshd")
+    if err ~= nil then
+        api.info.warn(err)
+        return false
+    end
+
+    api.info.log("editor is configured")
+    return true
+end
@@ -0,0 +1,13 @@
+function main()
+    local facts = api.sys.getFacts()
+    if not facts:HasGpu("nvidia") then
+        api.info.log("no nvidia GPU in " .. facts.Hostname)
+        return true
+    end
+
+    local err = api.pkg.install("nvidia")
+    if err ~= nil then
+        return false
+    end
+    return true
+end
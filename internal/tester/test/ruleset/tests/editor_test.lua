@@ -0,0 +1,27 @@
+function test_configures_editor()
+    test.fs.write("/etc/editor.conf", "editor=vim")
+    test.pkg.add("vim", "9.0")
+    test.daemon.add("sshd", true, false)
+
+    local passed, err = test.run("editor")
+    test.assertEqual(nil, err, "rule returned error")
+    test.assert(passed, "rule should pass")
+
+    test.assert(test.pkg.isInstalled("neovim"), "neovim should be installed")
+    test.assert(test.pkg.wasRemoved("vim"), "vim should be removed")
+    test.assert(test.daemon.did("sshd", "enable"), "sshd should be enabled")
+
+    local config = test.fs.read("/etc/editor.conf")
+    test.assertEqual("editor=vim\nline_numbers=true", config, "config is different")
+end
+
+function test_fails_without_daemon()
+    test.pkg.add("vim")
+
+    local passed, _ = test.run("editor")
+    test.assert(not passed, "rule shouldn't pass without sshd")
+end
+
+function test_failing_assertion()
+    test.assertEqual(1, 2, "numbers differ")
+end
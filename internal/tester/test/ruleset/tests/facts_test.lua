@@ -0,0 +1,15 @@
+function test_installs_nvidia_drivers()
+    test.setFacts({ Hostname = "workstation", Gpus = { { Vendor = "nvidia", VendorId = "0x10de" } } })
+
+    local passed, err = test.run("gpu_drivers")
+    test.assertEqual(nil, err, "rule returned error")
+    test.assert(passed, "rule should pass")
+    test.assert(test.pkg.wasInstalled("nvidia"), "nvidia drivers should be installed")
+end
+
+function test_skips_drivers_without_gpu()
+    local passed, err = test.run("gpu_drivers")
+    test.assertEqual(nil, err, "rule returned error")
+    test.assert(passed, "rule should pass")
+    test.assert(not test.pkg.isInstalled("nvidia"), "nvidia drivers shouldn't be installed")
+end
@@ -0,0 +1,17 @@
+function test_runs_on_targeted_platform()
+    local passed, err, result = test.run("aur_helper")
+    test.assertEqual(nil, err, "rule returned error")
+    test.assert(passed, "rule should pass")
+    test.assertEqual("passed", result)
+    test.assert(test.pkg.isInstalled("yay"), "yay should be installed")
+end
+
+function test_skips_other_platform()
+    test.setPlatform({ distro = "debian", init = "systemd", arch = "x86_64" })
+
+    local passed, err, result = test.run("aur_helper")
+    test.assertEqual(nil, err, "rule returned error")
+    test.assert(not passed, "not applicable rule shouldn't pass")
+    test.assertEqual("not applicable", result)
+    test.assert(not test.pkg.isInstalled("yay"), "yay shouldn't be installed")
+end
@@ -0,0 +1,136 @@
+package test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/avorty/spito/internal/tester"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// copyRuleset copies the ruleset to the temporary directory, because running it creates the lockfile
+func copyRuleset(t *testing.T) string {
+	rulesetPath := t.TempDir()
+
+	err := filepath.WalkDir("ruleset", func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		destinationPath := filepath.Join(rulesetPath, strings.TrimPrefix(filePath, "ruleset"))
+		if entry.IsDir() {
+			return os.MkdirAll(destinationPath, 0755)
+		}
+
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(destinationPath, content, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rulesetPath
+}
+
+func runTests(t *testing.T) map[string]tester.Result {
+	results, err := tester.RunRuleset(copyRuleset(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resultsByName := make(map[string]tester.Result)
+	for _, result := range results {
+		resultsByName[result.Name] = result
+	}
+	return resultsByName
+}
+
+// readHostFile returns content of the file of the real system, so tests can check that it hasn't changed
+func readHostFile(filePath string) string {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err.Error()
+	}
+	return string(content)
+}
+
+func TestRunRuleset(t *testing.T) {
+	hostEditorConfig := readHostFile("/etc/editor.conf")
+	results := runTests(t)
+
+	if len(results) != 7 {
+		t.Fatalf("expected 7 tests, got %d", len(results))
+	}
+
+	for _, testName := range []string{"test_configures_editor", "test_fails_without_daemon"} {
+		result := results[testName]
+		if !result.Passed {
+			t.Fatalf("%s failed: %s", testName, result.Message)
+		}
+		if result.File != filepath.Join("tests", "editor_test.lua") {
+			t.Fatalf("unexpected test file %s", result.File)
+		}
+	}
+
+	for _, testName := range []string{
+		"test_runs_on_targeted_platform", "test_skips_other_platform",
+		"test_installs_nvidia_drivers", "test_skips_drivers_without_gpu",
+	} {
+		if result := results[testName]; !result.Passed {
+			t.Fatalf("%s failed: %s", testName, result.Message)
+		}
+	}
+
+	failingResult := results["test_failing_assertion"]
+	if failingResult.Passed || !strings.Contains(failingResult.Message, "numbers differ") {
+		t.Fatalf("test_failing_assertion should fail with its message, got: %+v", failingResult)
+	}
+
+	output := strings.Join(results["test_configures_editor"].Output, "\n")
+	if !strings.Contains(output, "editor is configured") {
+		t.Fatalf("output of the rule wasn't captured, got: %s", output)
+	}
+
+	// The rule writes the config only to the sandbox root, which editor_test.lua reads using test.fs.read
+	if readHostFile("/etc/editor.conf") != hostEditorConfig {
+		t.Fatal("test has modified real filesystem")
+	}
+}
+
+func TestReports(t *testing.T) {
+	var resultList []tester.Result
+	for _, result := range runTests(t) {
+		resultList = append(resultList, result)
+	}
+
+	var tapReport bytes.Buffer
+	if err := tester.WriteReport(&tapReport, tester.TapFormat, resultList); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tapReport.String(), "TAP version 13\n1..7\n") {
+		t.Fatalf("invalid TAP header:\n%s", tapReport.String())
+	}
+	if strings.Count(tapReport.String(), "\nnot ok ") != 1 {
+		t.Fatalf("expected exactly one failing test in TAP report:\n%s", tapReport.String())
+	}
+
+	var jUnitReport bytes.Buffer
+	if err := tester.WriteReport(&jUnitReport, tester.JUnitFormat, resultList); err != nil {
+		t.Fatal(err)
+	}
+
+	var parsedReport struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+	}
+	if err := xml.Unmarshal(jUnitReport.Bytes(), &parsedReport); err != nil {
+		t.Fatal(err)
+	}
+	if parsedReport.Tests != 7 || parsedReport.Failures != 1 {
+		t.Fatalf("expected 7 tests and 1 failure in JUnit report, got %+v", parsedReport)
+	}
+}
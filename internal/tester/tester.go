@@ -0,0 +1,186 @@
+// Package tester runs unit tests of rules written in *_test.lua files.
+// Every test is executed inside a sandbox, so rules never touch the real system
+package tester
+
+import (
+	"errors"
+	"github.com/yuin/gopher-lua"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	TestFileSuffix     = "_test.lua"
+	testFunctionPrefix = "test"
+)
+
+type Result struct {
+	// File is path of the test file relative to the ruleset
+	File     string        `json:"file"`
+	Name     string        `json:"name"`
+	Passed   bool          `json:"passed"`
+	Message  string        `json:"message,omitempty"`
+	Output   []string      `json:"output,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// DiscoverTests returns paths of all test files inside the ruleset
+func DiscoverTests(rulesetPath string) ([]string, error) {
+	var testFiles []string
+
+	err := filepath.WalkDir(rulesetPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), ".") && filePath != rulesetPath {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), TestFileSuffix) {
+			testFiles = append(testFiles, filePath)
+		}
+		return nil
+	})
+
+	return testFiles, err
+}
+
+// RunRuleset executes all tests found in the ruleset
+func RunRuleset(rulesetPath string) ([]Result, error) {
+	rulesetPath, err := filepath.Abs(rulesetPath)
+	if err != nil {
+		return nil, err
+	}
+
+	testFiles, err := DiscoverTests(rulesetPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, testFile := range testFiles {
+		fileResults, err := RunFile(rulesetPath, testFile)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, fileResults...)
+	}
+
+	return results, nil
+}
+
+// RunFile executes every global function whose name starts with "test", each of them in a new sandbox
+func RunFile(rulesetPath, testFilePath string) ([]Result, error) {
+	relativePath, err := filepath.Rel(rulesetPath, testFilePath)
+	if err != nil {
+		relativePath = testFilePath
+	}
+
+	script, err := os.ReadFile(testFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	testNames, err := getTestNames(string(script), testFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(testNames))
+	for i, testName := range testNames {
+		results[i] = runTest(rulesetPath, string(script), relativePath, testName)
+	}
+
+	return results, nil
+}
+
+func getTestNames(script, chunkName string) ([]string, error) {
+	L := newTestLuaState()
+	defer L.Close()
+
+	if err := loadScript(L, script, chunkName); err != nil {
+		return nil, err
+	}
+
+	var testNames []string
+	L.G.Global.ForEach(func(key, value lua.LValue) {
+		name := key.String()
+		if value.Type() == lua.LTFunction && strings.HasPrefix(name, testFunctionPrefix) {
+			testNames = append(testNames, name)
+		}
+	})
+	slices.Sort(testNames)
+
+	return testNames, nil
+}
+
+func runTest(rulesetPath, script, relativePath, testName string) Result {
+	result := Result{
+		File: relativePath,
+		Name: testName,
+	}
+	startTime := time.Now()
+
+	err := func() (err error) {
+		s, err := newSandbox(rulesetPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			result.Output = s.output
+			err = errors.Join(err, s.close())
+		}()
+
+		L := newTestLuaState()
+		defer L.Close()
+
+		L.SetGlobal("RULESET_DIR", lua.LString(rulesetPath))
+		attachTestApi(s, L)
+
+		if err := loadScript(L, script, relativePath); err != nil {
+			return err
+		}
+
+		return L.CallByParam(lua.P{
+			Fn:      L.GetGlobal(testName),
+			Protect: true,
+		})
+	}()
+
+	result.Duration = time.Since(startTime)
+	result.Passed = err == nil
+	result.Message = getErrorMessage(err)
+	return result
+}
+
+// getErrorMessage skips stack trace of lua errors, position of the failed assertion is enough
+func getErrorMessage(err error) string {
+	var apiError *lua.ApiError
+	if errors.As(err, &apiError) && apiError.Object != nil {
+		return apiError.Object.String()
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func newTestLuaState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	lua.OpenBase(L)
+	lua.OpenString(L)
+	lua.OpenTable(L)
+	return L
+}
+
+func loadScript(L *lua.LState, script, chunkName string) error {
+	fn, err := L.Load(strings.NewReader(script), chunkName)
+	if err != nil {
+		return err
+	}
+	L.Push(fn)
+	return L.PCall(0, lua.MultRet, nil)
+}
@@ -0,0 +1,649 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/avorty/spito/pkg/path"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/userinfo"
+	"github.com/go-git/go-git/v5"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	aurRpcInfoUrl        = "https://aur.archlinux.org/rpc/v5/info"
+	aurCloneTemplate     = "https://aur.archlinux.org/%s.git"
+	defaultCacheLocation = "~/.cache"
+	aurPackageDirectory  = "/var/cache/spito/aur"
+	makepkgCommand       = "makepkg"
+	asDependenciesOption = "--asdeps"
+	pkgbuildFilename     = "PKGBUILD"
+
+	// AurHelperEnv selects AUR helper (yay or paru) which installs AUR packages instead of spito
+	AurHelperEnv = "SPITO_AUR_HELPER"
+	// AurReviewCommandEnv is a command executed with the path of PKGBUILD before the package is built,
+	// the package isn't installed if the command fails
+	AurReviewCommandEnv = "SPITO_AUR_REVIEW_COMMAND"
+)
+
+var SupportedAurHelpers = []string{"yay", "paru"}
+
+type AurPackage struct {
+	Name         string
+	PackageBase  string
+	Version      string
+	Depends      []string
+	MakeDepends  []string
+	CheckDepends []string
+}
+
+// dependencies returns everything which has to be installed to build and install the package
+func (p AurPackage) dependencies() []string {
+	dependencies := make([]string, 0, len(p.Depends)+len(p.MakeDepends)+len(p.CheckDepends))
+	dependencies = append(dependencies, p.Depends...)
+	dependencies = append(dependencies, p.MakeDepends...)
+	return append(dependencies, p.CheckDepends...)
+}
+
+type AurResponseLayout struct {
+	Type    string
+	Error   string
+	Results []AurPackage
+}
+
+// AurSystem performs operations of the Aur on the managed system
+type AurSystem interface {
+	// IsSatisfied returns true if an installed package satisfies the dependency, e.g. "zlib>=1.3"
+	IsSatisfied(dependency string) bool
+	IsInRepositories(packageName string) bool
+	InstallFromRepositories(asDependencies bool, packages ...string) error
+	// PackageList returns paths of the package files, which makepkg builds in the directory
+	PackageList(directory string) ([]string, error)
+	Build(directory string) error
+	// PackageInfo returns the name and the version stored in the package file
+	PackageInfo(packageFile string) (string, string, error)
+	InstallFiles(asDependencies bool, packageFiles ...string) error
+	RunHelper(helper string, packages ...string) error
+}
+
+// Aur resolves, builds and installs packages from the Arch User Repository
+type Aur struct {
+	RpcUrl           string
+	CloneUrlTemplate string
+	// CacheDirectory contains clones of the packages, they are updated instead of being cloned again
+	CacheDirectory string
+	// PackageDirectory contains verified copies of the built package files in the managed system,
+	// only its owner can write to it, so the copies are installed and reused until their version changes
+	PackageDirectory string
+	// Helper installs the packages instead of spito if it's set
+	Helper string
+	// ReviewPkgbuild is called before the package is built, returned error cancels the installation
+	ReviewPkgbuild func(packageBase string, pkgbuildPath string) error
+	// NewSystem returns system managed in the root directory
+	NewSystem func(root string) AurSystem
+	// InfoApi shows which AUR packages are installed, nothing is shown if it isn't set
+	InfoApi shared.InfoInterface
+}
+
+// AurBackend is used by PacmanPackageManager to install packages which aren't in the repositories
+var AurBackend = NewAur()
+
+// NewAur returns Aur using the official AUR, AurHelperEnv and AurReviewCommandEnv configure it
+func NewAur() *Aur {
+	aur := &Aur{
+		RpcUrl:           aurRpcInfoUrl,
+		CloneUrlTemplate: aurCloneTemplate,
+		CacheDirectory:   filepath.Join(path.GetEnvWithDefaultValue("XDG_CACHE_HOME", defaultCacheLocation), "spito", "aur"),
+		PackageDirectory: aurPackageDirectory,
+		Helper:           os.Getenv(AurHelperEnv),
+		NewSystem: func(root string) AurSystem {
+			return pacmanAurSystem{root: root}
+		},
+	}
+	aur.UseReviewCommand(os.Getenv(AurReviewCommandEnv))
+	return aur
+}
+
+// UseReviewCommand makes the command review PKGBUILDs, e.g. "less" or a linter, empty command disables the review
+func (a *Aur) UseReviewCommand(command string) {
+	if command == "" {
+		a.ReviewPkgbuild = nil
+		return
+	}
+
+	a.ReviewPkgbuild = func(packageBase string, pkgbuildPath string) error {
+		arguments := append(strings.Fields(command), pkgbuildPath)
+		reviewCommand := exec.Command(arguments[0], arguments[1:]...)
+		reviewCommand.Stdin, reviewCommand.Stdout, reviewCommand.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := runAsRegularUser(reviewCommand); err != nil {
+			return fmt.Errorf("review of the AUR package %s has failed: %w", packageBase, err)
+		}
+		return nil
+	}
+}
+
+// Info returns packages found in the AUR, names which weren't found are skipped
+func (a *Aur) Info(names ...string) ([]AurPackage, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	requestUrl := a.RpcUrl + "?" + url.Values{"arg[]": names}.Encode()
+	response, err := http.Get(requestUrl)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query the AUR: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot query the AUR: %s", response.Status)
+	}
+
+	var jsonBody AurResponseLayout
+	if err := json.Unmarshal(body, &jsonBody); err != nil {
+		return nil, fmt.Errorf("invalid response of the AUR: %w", err)
+	}
+	if jsonBody.Type == "error" {
+		return nil, fmt.Errorf("the AUR returned an error: %s", jsonBody.Error)
+	}
+	return jsonBody.Results, nil
+}
+
+// SplitPackages returns packages which pacman can install and the others, which have to be installed from the AUR
+func (a *Aur) SplitPackages(root string, packages []string) ([]string, []string) {
+	system := a.NewSystem(root)
+
+	var repositoryPackages, aurPackages []string
+	for _, packageName := range packages {
+		if system.IsInRepositories(packageName) {
+			repositoryPackages = append(repositoryPackages, packageName)
+		} else {
+			aurPackages = append(aurPackages, packageName)
+		}
+	}
+	return repositoryPackages, aurPackages
+}
+
+// Install builds and installs the AUR packages together with their dependencies,
+// dependencies are installed as such, so pacman can remove them when they aren't needed anymore
+func (a *Aur) Install(root string, packages ...string) error {
+	if len(packages) == 0 {
+		return nil
+	}
+	if a.InfoApi != nil {
+		a.InfoApi.Log("Installing AUR packages:", strings.Join(packages, " "))
+	}
+	system := a.NewSystem(root)
+
+	if a.Helper != "" {
+		if !slices.Contains(SupportedAurHelpers, a.Helper) {
+			return fmt.Errorf("unsupported AUR helper %s, use one of: %s", a.Helper, strings.Join(SupportedAurHelpers, ", "))
+		}
+		return system.RunHelper(a.Helper, packages...)
+	}
+
+	buildOrder, repositoryDependencies, err := a.Resolve(system, packages...)
+	if err != nil {
+		return err
+	}
+	if len(repositoryDependencies) > 0 {
+		if err := system.InstallFromRepositories(true, repositoryDependencies...); err != nil {
+			return fmt.Errorf("cannot install dependencies of the AUR packages: %w", err)
+		}
+	}
+
+	packageDirectory := filepath.Join(root, a.PackageDirectory)
+	if err := preparePackageDirectory(packageDirectory); err != nil {
+		return err
+	}
+
+	for _, aurPackage := range buildOrder {
+		packageFile, err := a.build(system, packageDirectory, aurPackage)
+		if err != nil {
+			return err
+		}
+
+		isDependency := !slices.Contains(packages, aurPackage.Name)
+		if err := system.InstallFiles(isDependency, packageFile); err != nil {
+			return fmt.Errorf("cannot install the AUR package %s: %w", aurPackage.Name, err)
+		}
+	}
+	return nil
+}
+
+// Resolve returns AUR packages in the order in which they have to be built, every package follows its dependencies.
+// Dependencies which aren't installed yet and are available in the repositories are returned separately
+func (a *Aur) Resolve(system AurSystem, packages ...string) ([]AurPackage, []string, error) {
+	resolver := aurResolver{
+		aur:      a,
+		system:   system,
+		packages: make(map[string]AurPackage),
+		state:    make(map[string]int),
+	}
+	if err := resolver.fetch(packages); err != nil {
+		return nil, nil, err
+	}
+
+	for _, packageName := range packages {
+		if err := resolver.visit(packageName, ""); err != nil {
+			return nil, nil, err
+		}
+	}
+	return resolver.buildOrder, resolver.repositoryDependencies, nil
+}
+
+const (
+	notVisited = iota
+	visiting
+	visited
+)
+
+type aurResolver struct {
+	aur                    *Aur
+	system                 AurSystem
+	packages               map[string]AurPackage
+	state                  map[string]int
+	buildOrder             []AurPackage
+	repositoryDependencies []string
+}
+
+// fetch queries the AUR for packages which haven't been queried yet by a single request
+func (r *aurResolver) fetch(names []string) error {
+	names = slices.DeleteFunc(slices.Clone(names), func(name string) bool {
+		_, isFetched := r.packages[name]
+		return isFetched
+	})
+
+	aurPackages, err := r.aur.Info(names...)
+	if err != nil {
+		return err
+	}
+	for _, aurPackage := range aurPackages {
+		r.packages[aurPackage.Name] = aurPackage
+	}
+	return nil
+}
+
+func (r *aurResolver) visit(packageName string, requiredBy string) error {
+	switch r.state[packageName] {
+	case visited:
+		return nil
+	case visiting:
+		return fmt.Errorf("AUR packages %s and %s depend on each other", packageName, requiredBy)
+	}
+
+	aurPackage, isFound := r.packages[packageName]
+	if !isFound {
+		if requiredBy == "" {
+			return fmt.Errorf("package %s was found neither in the repositories nor in the AUR", packageName)
+		}
+		return fmt.Errorf("dependency %s of %s was found neither in the repositories nor in the AUR", packageName, requiredBy)
+	}
+	r.state[packageName] = visiting
+
+	var aurDependencies []string
+	for _, dependency := range aurPackage.dependencies() {
+		dependencyName := getDependencyName(dependency)
+		switch {
+		case r.system.IsSatisfied(dependency):
+		case r.system.IsInRepositories(dependencyName):
+			if !slices.Contains(r.repositoryDependencies, dependencyName) {
+				r.repositoryDependencies = append(r.repositoryDependencies, dependencyName)
+			}
+		default:
+			aurDependencies = append(aurDependencies, dependencyName)
+		}
+	}
+
+	if err := r.fetch(aurDependencies); err != nil {
+		return err
+	}
+	for _, dependency := range aurDependencies {
+		if err := r.visit(dependency, packageName); err != nil {
+			return err
+		}
+	}
+
+	r.state[packageName] = visited
+	r.buildOrder = append(r.buildOrder, aurPackage)
+	return nil
+}
+
+// getDependencyName strips the version from the dependency, e.g. "zlib>=1.3" becomes "zlib"
+func getDependencyName(dependency string) string {
+	if operatorIndex := strings.IndexAny(dependency, "<>="); operatorIndex != -1 {
+		return dependency[:operatorIndex]
+	}
+	return dependency
+}
+
+// build updates the clone of the package and builds it unless the package directory already contains
+// its version, it returns the package file copied to the package directory (package base can build more of them).
+// makepkg sources the PKGBUILD even to list the package files, so it's reviewed first.
+// Files built in the clone could be replaced by the regular user, so root never installs them directly
+func (a *Aur) build(system AurSystem, packageDirectory string, aurPackage AurPackage) (string, error) {
+	storedFile, err := findStoredPackage(packageDirectory, aurPackage)
+	if err != nil || storedFile != "" {
+		return storedFile, err
+	}
+
+	packageBase := aurPackage.PackageBase
+	if packageBase == "" {
+		packageBase = aurPackage.Name
+	}
+
+	repositoryPath, err := a.updateClone(packageBase)
+	if err != nil {
+		return "", fmt.Errorf("cannot download the AUR package %s: %w", packageBase, err)
+	}
+
+	if a.ReviewPkgbuild != nil {
+		if err := a.ReviewPkgbuild(packageBase, filepath.Join(repositoryPath, pkgbuildFilename)); err != nil {
+			return "", err
+		}
+	}
+
+	packageFiles, err := system.PackageList(repositoryPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot read PKGBUILD of the AUR package %s: %w", packageBase, err)
+	}
+	packageFileIndex := slices.IndexFunc(packageFiles, func(packageFile string) bool {
+		return isPackageFileOf(filepath.Base(packageFile), aurPackage)
+	})
+	if packageFileIndex == -1 {
+		return "", fmt.Errorf("PKGBUILD of the AUR package %s doesn't build %s %s", packageBase, aurPackage.Name, aurPackage.Version)
+	}
+
+	if err := system.Build(repositoryPath); err != nil {
+		return "", fmt.Errorf("cannot build the AUR package %s: %w", packageBase, err)
+	}
+
+	storedFile, err = storePackageFile(system, packageDirectory, packageFiles[packageFileIndex], aurPackage)
+	if err != nil {
+		return "", fmt.Errorf("cannot store the AUR package %s: %w", aurPackage.Name, err)
+	}
+	return storedFile, nil
+}
+
+// preparePackageDirectory creates the package directory and checks that nobody except its owner can write to it
+func preparePackageDirectory(packageDirectory string) error {
+	if err := os.MkdirAll(packageDirectory, path.DirectoryPermissions); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(packageDirectory)
+	if err != nil {
+		return err
+	}
+	stat, isStat := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !isStat || int(stat.Uid) != os.Geteuid() || info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("AUR package directory %s has to be a directory writable only by user %d", packageDirectory, os.Geteuid())
+	}
+	return nil
+}
+
+// findStoredPackage returns the package file of the version, which has been already built and verified,
+// or an empty string if there's no such file
+func findStoredPackage(packageDirectory string, aurPackage AurPackage) (string, error) {
+	entries, err := os.ReadDir(packageDirectory)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && isPackageFileOf(entry.Name(), aurPackage) {
+			return filepath.Join(packageDirectory, entry.Name()), nil
+		}
+	}
+	return "", nil
+}
+
+// isPackageFileOf checks the name of the package file, e.g. app-2.0-1-x86_64.pkg.tar.zst,
+// the architecture cannot contain dashes, so files of other packages, e.g. app-2.0-1-1, aren't matched
+func isPackageFileOf(fileName string, aurPackage AurPackage) bool {
+	suffix, hasPrefix := strings.CutPrefix(fileName, aurPackage.Name+"-"+aurPackage.Version+"-")
+	return hasPrefix && !strings.Contains(suffix, "-") && strings.Contains(suffix, ".pkg.tar")
+}
+
+// storePackageFile copies the built package file to the package directory and verifies its name and version there,
+// the copy cannot be replaced by the user who has built it, so it's the one which is installed
+func storePackageFile(system AurSystem, packageDirectory string, builtFile string, aurPackage AurPackage) (string, error) {
+	// The built file could be replaced by a symlink or a fifo, which would block reading
+	source, err := os.OpenFile(builtFile, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+	if info, err := source.Stat(); err != nil {
+		return "", err
+	} else if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", builtFile)
+	}
+
+	storedFile, err := os.CreateTemp(packageDirectory, ".*.part")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(storedFile.Name())
+	_, err = io.Copy(storedFile, source)
+	if err == nil {
+		err = storedFile.Chmod(path.FilePermissions)
+	}
+	if closeErr := storedFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	name, version, err := system.PackageInfo(storedFile.Name())
+	if err != nil {
+		return "", fmt.Errorf("cannot read the package file %s: %w", filepath.Base(builtFile), err)
+	}
+	if name != aurPackage.Name || version != aurPackage.Version {
+		return "", fmt.Errorf("package file %s contains %s %s instead of %s %s",
+			filepath.Base(builtFile), name, version, aurPackage.Name, aurPackage.Version)
+	}
+
+	storedPath := filepath.Join(packageDirectory, filepath.Base(builtFile))
+	return storedPath, os.Rename(storedFile.Name(), storedPath)
+}
+
+// updateClone pulls changes of the package, its clone is created again if it cannot be updated
+func (a *Aur) updateClone(packageBase string) (string, error) {
+	cacheDirectory := a.CacheDirectory
+	if err := path.ExpandTilde(&cacheDirectory); err != nil {
+		return "", err
+	}
+	repositoryPath := filepath.Join(cacheDirectory, packageBase)
+
+	if repository, err := git.PlainOpen(repositoryPath); err == nil {
+		worktree, err := repository.Worktree()
+		if err == nil {
+			err = worktree.Pull(&git.PullOptions{Force: true})
+		}
+		if err == nil || errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return repositoryPath, nil
+		}
+	}
+
+	if err := os.RemoveAll(repositoryPath); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(cacheDirectory, path.DirectoryPermissions); err != nil {
+		return "", err
+	}
+	if _, err := git.PlainClone(repositoryPath, false, &git.CloneOptions{
+		URL: fmt.Sprintf(a.CloneUrlTemplate, packageBase),
+	}); err != nil {
+		return "", err
+	}
+
+	// makepkg refuses to run as root, the clone has to be writable by the user who builds it
+	if err := chownToRegularUser(repositoryPath); err != nil {
+		return "", err
+	}
+	return repositoryPath, chownToRegularUser(cacheDirectory)
+}
+
+// pacmanAurSystem uses pacman of the system mounted in the root and builds packages with makepkg
+type pacmanAurSystem struct {
+	root string
+}
+
+func (s pacmanAurSystem) IsSatisfied(dependency string) bool {
+	// pacman -T prints dependencies which aren't satisfied and fails if there are any
+	return exec.Command(packageManager, withRoot(s.root, "-T", dependency)...).Run() == nil
+}
+
+func (s pacmanAurSystem) IsInRepositories(packageName string) bool {
+	// Packages provided by other ones, e.g. "sh", are found as well
+	return exec.Command(packageManager, withRoot(s.root, "-Sp", "--print-format", "%n", packageName)...).Run() == nil
+}
+
+func (s pacmanAurSystem) InstallFromRepositories(asDependencies bool, packages ...string) error {
+	argv := withRoot(s.root, installCommand, noConfirmOption, neededOption)
+	if asDependencies {
+		argv = append(argv, asDependenciesOption)
+	}
+	return runPackageManager(exec.Command(packageManager, append(argv, packages...)...))
+}
+
+func (s pacmanAurSystem) PackageList(directory string) ([]string, error) {
+	packageListCommand := exec.Command(makepkgCommand, "--packagelist")
+	packageListCommand.Dir = directory
+	output, err := outputAsRegularUser(packageListCommand)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
+
+func (s pacmanAurSystem) Build(directory string) error {
+	// Dependencies are already installed, so makepkg doesn't need to ask for the password
+	buildCommand := exec.Command(makepkgCommand, noConfirmOption, "--force")
+	buildCommand.Dir = directory
+	_, err := outputAsRegularUser(buildCommand)
+	return err
+}
+
+func (s pacmanAurSystem) PackageInfo(packageFile string) (string, string, error) {
+	// pacman -Qp prints the name and the version of the package file, e.g. "app 2.0-1"
+	infoCommand := exec.Command(packageManager, "-Qp", packageFile)
+	infoCommand.Env = append(os.Environ(), "LANG=C")
+	output, err := infoCommand.Output()
+	if err != nil {
+		return "", "", err
+	}
+	name, version, isFound := strings.Cut(strings.TrimSpace(string(output)), " ")
+	if !isFound {
+		return "", "", fmt.Errorf("unexpected output of pacman: %s", output)
+	}
+	return name, version, nil
+}
+
+func (s pacmanAurSystem) InstallFiles(asDependencies bool, packageFiles ...string) error {
+	argv := withRoot(s.root, installFromFileOption, noConfirmOption)
+	if asDependencies {
+		argv = append(argv, asDependenciesOption)
+	}
+	return runPackageManager(exec.Command(packageManager, append(argv, packageFiles...)...))
+}
+
+func (s pacmanAurSystem) RunHelper(helper string, packages ...string) error {
+	if s.root != "" && s.root != "/" {
+		return fmt.Errorf("AUR helper %s cannot install packages to other root", helper)
+	}
+
+	argv := append([]string{installCommand, noConfirmOption, neededOption}, packages...)
+	_, err := outputAsRegularUser(exec.Command(helper, argv...))
+	return err
+}
+
+// runPackageManager executes pacman and returns its output in the error if it fails
+func runPackageManager(command *exec.Cmd) error {
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// outputAsRegularUser executes the command as the user who runs spito, even if spito runs as root
+func outputAsRegularUser(command *exec.Cmd) (string, error) {
+	var output strings.Builder
+	command.Stdout, command.Stderr = &output, &output
+	if err := runAsRegularUser(command); err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(output.String()))
+	}
+	return output.String(), nil
+}
+
+func runAsRegularUser(command *exec.Cmd) error {
+	if os.Geteuid() != userinfo.RootEuid {
+		return command.Run()
+	}
+
+	regularUser, err := userinfo.GetRegularUser()
+	if err != nil {
+		return err
+	}
+	uid, gid, err := getIds(regularUser.Uid, regularUser.Gid)
+	if err != nil {
+		return err
+	}
+	if uid == userinfo.RootEuid {
+		return errors.New("AUR packages cannot be built by root, run spito as a regular user")
+	}
+
+	command.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+	}
+	command.Env = append(os.Environ(), "HOME="+regularUser.HomeDir, "USER="+regularUser.Username)
+	return command.Run()
+}
+
+// chownToRegularUser gives the directory to the user who runs spito, if spito runs as root
+func chownToRegularUser(directory string) error {
+	if os.Geteuid() != userinfo.RootEuid {
+		return nil
+	}
+
+	regularUser, err := userinfo.GetRegularUser()
+	if err != nil {
+		return err
+	}
+	uid, gid, err := getIds(regularUser.Uid, regularUser.Gid)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(directory, func(filePath string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(filePath, uid, gid)
+	})
+}
+
+func getIds(uid string, gid string) (int, int, error) {
+	numericUid, err := strconv.Atoi(uid)
+	if err != nil {
+		return 0, 0, err
+	}
+	numericGid, err := strconv.Atoi(gid)
+	return numericUid, numericGid, err
+}
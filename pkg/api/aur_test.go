@@ -0,0 +1,371 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAurSystem builds packages by creating files named after pkgname and pkgver from PKGBUILD
+type fakeAurSystem struct {
+	installed          []string
+	repositories       []string
+	repositoryInstalls []string
+	builds             []string
+	installs           []string
+	// packageLists are directories whose PKGBUILD has been sourced by makepkg --packagelist
+	packageLists []string
+	helperCalls  []string
+	// builtContent replaces the name and the version written to the built package files if it's set
+	builtContent string
+}
+
+func (s *fakeAurSystem) IsSatisfied(dependency string) bool {
+	return slices.Contains(s.installed, getDependencyName(dependency))
+}
+
+func (s *fakeAurSystem) IsInRepositories(packageName string) bool {
+	return slices.Contains(s.repositories, packageName)
+}
+
+func (s *fakeAurSystem) InstallFromRepositories(asDependencies bool, packages ...string) error {
+	s.repositoryInstalls = append(s.repositoryInstalls, fmt.Sprintf("%s asdeps=%t", strings.Join(packages, " "), asDependencies))
+	s.installed = append(s.installed, packages...)
+	return nil
+}
+
+func (s *fakeAurSystem) PackageList(directory string) ([]string, error) {
+	s.packageLists = append(s.packageLists, filepath.Base(directory))
+	return s.getPackageFiles(directory)
+}
+
+func (s *fakeAurSystem) getPackageFiles(directory string) ([]string, error) {
+	names, version, err := readFakePkgbuild(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var packageFiles []string
+	for _, name := range names {
+		packageFiles = append(packageFiles, filepath.Join(directory, name+"-"+version+"-x86_64.pkg.tar.zst"))
+	}
+	return packageFiles, nil
+}
+
+// readFakePkgbuild returns pkgname and pkgver from PKGBUILD in the directory
+func readFakePkgbuild(directory string) ([]string, string, error) {
+	pkgbuild, err := os.ReadFile(filepath.Join(directory, pkgbuildFilename))
+	if err != nil {
+		return nil, "", err
+	}
+
+	variables := make(map[string]string)
+	for _, line := range strings.Split(string(pkgbuild), "\n") {
+		if key, value, isFound := strings.Cut(line, "="); isFound {
+			variables[key] = value
+		}
+	}
+	return strings.Fields(variables["pkgname"]), variables["pkgver"], nil
+}
+
+// Build writes the name and the version of the package to its files, e.g. "app 2.0-1"
+func (s *fakeAurSystem) Build(directory string) error {
+	names, version, err := readFakePkgbuild(directory)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		content := name + " " + version
+		if s.builtContent != "" {
+			content = s.builtContent
+		}
+		if err := os.WriteFile(filepath.Join(directory, name+"-"+version+"-x86_64.pkg.tar.zst"), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	s.builds = append(s.builds, filepath.Base(directory))
+	return nil
+}
+
+func (s *fakeAurSystem) PackageInfo(packageFile string) (string, string, error) {
+	content, err := os.ReadFile(packageFile)
+	if err != nil {
+		return "", "", err
+	}
+	name, version, _ := strings.Cut(string(content), " ")
+	return name, version, nil
+}
+
+func (s *fakeAurSystem) InstallFiles(asDependencies bool, packageFiles ...string) error {
+	for _, packageFile := range packageFiles {
+		s.installs = append(s.installs, fmt.Sprintf("%s asdeps=%t", filepath.Base(packageFile), asDependencies))
+	}
+	return nil
+}
+
+func (s *fakeAurSystem) RunHelper(helper string, packages ...string) error {
+	s.helperCalls = append(s.helperCalls, helper+" "+strings.Join(packages, " "))
+	return nil
+}
+
+// fakeAur serves AUR RPC and git repositories of the packages from a temporary directory
+type fakeAur struct {
+	t              *testing.T
+	packages       map[string]AurPackage
+	repositoryPath string
+}
+
+func newFakeAur(t *testing.T, packages ...AurPackage) (*fakeAur, *Aur, *fakeAurSystem) {
+	fake := &fakeAur{
+		t:              t,
+		packages:       make(map[string]AurPackage),
+		repositoryPath: t.TempDir(),
+	}
+	for _, aurPackage := range packages {
+		fake.publish(aurPackage)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		response := AurResponseLayout{Type: "multiinfo", Results: []AurPackage{}}
+		for _, name := range request.URL.Query()["arg[]"] {
+			if aurPackage, isFound := fake.packages[name]; isFound {
+				response.Results = append(response.Results, aurPackage)
+			}
+		}
+		_ = json.NewEncoder(writer).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	system := &fakeAurSystem{}
+	aur := &Aur{
+		RpcUrl:           server.URL,
+		CloneUrlTemplate: filepath.Join(fake.repositoryPath, "%s"),
+		CacheDirectory:   t.TempDir(),
+		PackageDirectory: t.TempDir(),
+		NewSystem: func(root string) AurSystem {
+			return system
+		},
+	}
+	return fake, aur, system
+}
+
+// publish commits PKGBUILD of the package to its repository and makes the RPC return the package
+func (f *fakeAur) publish(aurPackage AurPackage) {
+	if aurPackage.PackageBase == "" {
+		aurPackage.PackageBase = aurPackage.Name
+	}
+	f.packages[aurPackage.Name] = aurPackage
+
+	repositoryPath := filepath.Join(f.repositoryPath, aurPackage.PackageBase)
+	repository, err := git.PlainOpen(repositoryPath)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		repository, err = git.PlainInit(repositoryPath, false)
+	}
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	var packageNames []string
+	for _, otherPackage := range f.packages {
+		if otherPackage.PackageBase == aurPackage.PackageBase {
+			packageNames = append(packageNames, otherPackage.Name)
+		}
+	}
+	slices.Sort(packageNames)
+	pkgbuild := fmt.Sprintf("pkgname=%s\npkgver=%s\n", strings.Join(packageNames, " "), aurPackage.Version)
+	if err := os.WriteFile(filepath.Join(repositoryPath, pkgbuildFilename), []byte(pkgbuild), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+
+	worktree, err := repository.Worktree()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if _, err := worktree.Add(pkgbuildFilename); err != nil {
+		f.t.Fatal(err)
+	}
+	_, err = worktree.Commit("Update to "+aurPackage.Version, &git.CommitOptions{
+		Author: &object.Signature{Name: "spito", Email: "spito@example.com", When: time.Now()},
+	})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func TestAurInstall(t *testing.T) {
+	fake, aur, system := newFakeAur(t,
+		AurPackage{Name: "app", Version: "2.0-1", Depends: []string{"glibc", "libfoo>=1.0"}, MakeDepends: []string{"cmake"}},
+		AurPackage{Name: "libfoo", Version: "1.0-1", Depends: []string{"zlib"}, CheckDepends: []string{"libfoo-test-data"}},
+		AurPackage{Name: "libfoo-test-data", PackageBase: "libfoo-data", Version: "1.0-1"},
+	)
+	system.installed = []string{"glibc"}
+	system.repositories = []string{"cmake", "zlib"}
+
+	var reviewedPackages []string
+	aur.ReviewPkgbuild = func(packageBase string, pkgbuildPath string) error {
+		if _, err := os.Stat(pkgbuildPath); err != nil {
+			t.Errorf("reviewed PKGBUILD of %s doesn't exist: %v", packageBase, err)
+		}
+		reviewedPackages = append(reviewedPackages, packageBase)
+		return nil
+	}
+
+	if err := aur.Install("/", "app"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRepositoryInstalls := []string{"cmake zlib asdeps=true"}
+	if !slices.Equal(system.repositoryInstalls, expectedRepositoryInstalls) {
+		t.Errorf("expected repository installs %v, got %v", expectedRepositoryInstalls, system.repositoryInstalls)
+	}
+	expectedBuilds := []string{"libfoo-data", "libfoo", "app"}
+	if !slices.Equal(system.builds, expectedBuilds) {
+		t.Errorf("expected builds %v, got %v", expectedBuilds, system.builds)
+	}
+	if !slices.Equal(reviewedPackages, expectedBuilds) {
+		t.Errorf("expected reviewed packages %v, got %v", expectedBuilds, reviewedPackages)
+	}
+	expectedInstalls := []string{
+		"libfoo-test-data-1.0-1-x86_64.pkg.tar.zst asdeps=true",
+		"libfoo-1.0-1-x86_64.pkg.tar.zst asdeps=true",
+		"app-2.0-1-x86_64.pkg.tar.zst asdeps=false",
+	}
+	if !slices.Equal(system.installs, expectedInstalls) {
+		t.Errorf("expected installs %v, got %v", expectedInstalls, system.installs)
+	}
+
+	// Dependencies are already installed, only the updated package is built
+	system.installed, system.builds = []string{"glibc", "cmake", "zlib", "libfoo", "libfoo-test-data"}, nil
+	fake.publish(AurPackage{Name: "app", Version: "2.1-1", Depends: []string{"glibc", "libfoo>=1.0"}, MakeDepends: []string{"cmake"}})
+	if err := aur.Install("/", "app"); err != nil {
+		t.Fatal(err)
+	}
+	expectedBuilds = []string{"app"}
+	if !slices.Equal(system.builds, expectedBuilds) {
+		t.Errorf("expected builds %v after the update, got %v", expectedBuilds, system.builds)
+	}
+	if lastInstall := system.installs[len(system.installs)-1]; lastInstall != "app-2.1-1-x86_64.pkg.tar.zst asdeps=false" {
+		t.Errorf("expected the updated package to be installed, got %s", lastInstall)
+	}
+
+	// Files in the clone could be replaced by the user, only verified copies in the package directory are reused
+	if err := os.WriteFile(filepath.Join(aur.CacheDirectory, "app", "app-2.1-1-x86_64.pkg.tar.zst"), []byte("glibc 2.0-1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := aur.Install("/", "app"); err != nil {
+		t.Fatal(err)
+	}
+	if expectedBuilds = []string{"app"}; !slices.Equal(system.builds, expectedBuilds) {
+		t.Errorf("expected builds %v after reinstalling, got %v", expectedBuilds, system.builds)
+	}
+	name, version, err := system.PackageInfo(filepath.Join(aur.PackageDirectory, "app-2.1-1-x86_64.pkg.tar.zst"))
+	if err != nil || name != "app" || version != "2.1-1" {
+		t.Errorf("expected the verified copy of app 2.1-1 to be reused, got %s %s, %v", name, version, err)
+	}
+}
+
+func TestAurVerifiesBuiltPackages(t *testing.T) {
+	_, aur, system := newFakeAur(t, AurPackage{Name: "app", Version: "1.0-1"})
+
+	system.builtContent = "glibc 2.0-1"
+	err := aur.Install("/", "app")
+	if err == nil || !strings.Contains(err.Error(), "contains glibc 2.0-1 instead of app 1.0-1") {
+		t.Fatalf("expected the forged package to be rejected, got %v", err)
+	}
+	if len(system.installs) != 0 {
+		t.Errorf("expected nothing to be installed, got %v", system.installs)
+	}
+	if entries, err := os.ReadDir(aur.PackageDirectory); err != nil || len(entries) != 0 {
+		t.Errorf("expected the forged package not to be stored, got %v, %v", entries, err)
+	}
+
+	// Package files could be replaced in a directory, which other users can write to
+	system.builtContent = ""
+	if err := os.Chmod(aur.PackageDirectory, 0777); err != nil {
+		t.Fatal(err)
+	}
+	err = aur.Install("/", "app")
+	if err == nil || !strings.Contains(err.Error(), "writable only by user") {
+		t.Fatalf("expected the writable package directory to be rejected, got %v", err)
+	}
+}
+
+func TestAurInstallErrors(t *testing.T) {
+	testCases := []struct {
+		name          string
+		packages      []AurPackage
+		reviewErr     error
+		expectedError string
+	}{
+		{
+			name:          "missing package",
+			expectedError: "package app was found neither in the repositories nor in the AUR",
+		},
+		{
+			name:          "missing dependency",
+			packages:      []AurPackage{{Name: "app", Version: "1.0-1", MakeDepends: []string{"missing"}}},
+			expectedError: "dependency missing of app was found neither in the repositories nor in the AUR",
+		},
+		{
+			name: "dependency cycle",
+			packages: []AurPackage{
+				{Name: "app", Version: "1.0-1", Depends: []string{"libfoo"}},
+				{Name: "libfoo", Version: "1.0-1", Depends: []string{"app"}},
+			},
+			expectedError: "AUR packages app and libfoo depend on each other",
+		},
+		{
+			name:          "rejected review",
+			packages:      []AurPackage{{Name: "app", Version: "1.0-1"}},
+			reviewErr:     errors.New("PKGBUILD has been rejected"),
+			expectedError: "PKGBUILD has been rejected",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, aur, system := newFakeAur(t, testCase.packages...)
+			aur.ReviewPkgbuild = func(string, string) error {
+				return testCase.reviewErr
+			}
+
+			err := aur.Install("/", "app")
+			if err == nil || !strings.Contains(err.Error(), testCase.expectedError) {
+				t.Fatalf("expected error containing '%s', got %v", testCase.expectedError, err)
+			}
+			if len(system.installs) != 0 {
+				t.Errorf("expected nothing to be installed, got %v", system.installs)
+			}
+			if testCase.reviewErr != nil && len(system.packageLists) != 0 {
+				t.Errorf("rejected PKGBUILD shouldn't be sourced, got %v", system.packageLists)
+			}
+		})
+	}
+}
+
+func TestAurHelper(t *testing.T) {
+	_, aur, system := newFakeAur(t)
+
+	aur.Helper = "paru"
+	if err := aur.Install("/", "app", "libfoo"); err != nil {
+		t.Fatal(err)
+	}
+	if expectedCalls := []string{"paru app libfoo"}; !slices.Equal(system.helperCalls, expectedCalls) {
+		t.Errorf("expected helper calls %v, got %v", expectedCalls, system.helperCalls)
+	}
+
+	aur.Helper = "unknown"
+	if err := aur.Install("/", "app"); err == nil {
+		t.Error("expected unsupported AUR helper to fail")
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import "fmt"
+
+// PackageManager is used by the pkg api to query and modify packages of the system
+type PackageManager interface {
+	GetPackage(name string) (Package, error)
+	ListInstalledPackages(filter PackageFilter) ([]Package, error)
+	// SearchPackages returns packages available in the repositories whose name or description matches the term
+	SearchPackages(term string) ([]Package, error)
+	// GetFileOwner returns the installed package which owns the file
+	GetFileOwner(filePath string) (Package, error)
+	GetPackageFiles(name string) ([]string, error)
+	// GetAvailableUpdates returns installed packages which can be upgraded, their version is the available one
+	GetAvailableUpdates() ([]Package, error)
+	InstallPackages(packages ...string) error
+	RemovePackages(packages ...string) error
+	// InstallPackageVersion installs the exact version of the package, e.g. to revert its upgrade
+	InstallPackageVersion(name string, version string) error
+	HasKey(fingerprint string) (bool, error)
+	// ImportKey trusts packages signed by the key, see importKey for possible sources
+	ImportKey(fingerprint string, source string) error
+	RemoveKey(fingerprint string) error
+	// SyncDatabases downloads package databases only of the given repositories, see syncDatabases
+	SyncDatabases(repositories ...string) error
+}
+
+// PackageFilter selects installed packages returned by PackageManager.ListInstalledPackages
+type PackageFilter string
+
+const (
+	AllPackages      PackageFilter = ""
+	ExplicitPackages PackageFilter = "explicit"
+	// DependencyPackages were installed only as dependencies of other packages
+	DependencyPackages PackageFilter = "dependency"
+	// OrphanPackages were installed as dependencies, but nothing requires them anymore
+	OrphanPackages PackageFilter = "orphan"
+	// ForeignPackages aren't in any repository, e.g. they were installed from the AUR
+	ForeignPackages PackageFilter = "foreign"
+)
+
+var packageFilters = []PackageFilter{AllPackages, ExplicitPackages, DependencyPackages, OrphanPackages, ForeignPackages}
+
+// ParsePackageFilter returns an error if the filter is unknown, "all" is accepted as well as an empty filter
+func ParsePackageFilter(filter string) (PackageFilter, error) {
+	if filter == "all" {
+		return AllPackages, nil
+	}
+	for _, packageFilter := range packageFilters {
+		if string(packageFilter) == filter {
+			return packageFilter, nil
+		}
+	}
+	return AllPackages, fmt.Errorf("unknown package filter '%s', use one of: all, explicit, dependency, orphan, foreign", filter)
+}
+
+// InitManager is used by the daemon api to query and control daemons of the system
+type InitManager interface {
+	GetDaemon(daemonName string) (Daemon, error)
+	StartDaemon(daemonName string) error
+	StopDaemon(daemonName string) error
+	RestartDaemon(daemonName string) error
+	EnableDaemon(daemonName string) error
+	DisableDaemon(daemonName string) error
+	// MaskDaemon prevents the daemon from being started at all, even by other daemons
+	MaskDaemon(daemonName string) error
+	UnmaskDaemon(daemonName string) error
+	// ReloadDaemons makes the init system read changed unit files, init systems without such cache do nothing
+	ReloadDaemons() error
+}
+
+// Backends used by the lua api. They can be replaced, e.g. `spito test` uses fake ones,
+// so rules under test don't touch the real system
+var (
+	PackageManagerBackend PackageManager = PacmanPackageManager{}
+	InitManagerBackend    InitManager    = SystemInitManager{}
+	// UserInitManagerBackend controls services of the user who runs spito
+	UserInitManagerBackend InitManager = SystemdUserInitManager{}
+)
+
+// UseRoot makes package and daemon apis operate on the system mounted in the root directory,
+// targeting decorators see the platform of that system too
+func UseRoot(root string) {
+	PackageManagerBackend = PacmanPackageManager{Root: root}
+	InitManagerBackend = SystemInitManager{Root: root}
+	UserInitManagerBackend = SystemdUserInitManager{Root: root}
+	PlatformBackend = func() (Platform, error) {
+		return GetPlatform(root)
+	}
+}
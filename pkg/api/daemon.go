@@ -3,12 +3,14 @@ package api
 import (
 	"context"
 	"errors"
+	daemontracker "github.com/avorty/spito/pkg"
 	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
-	"time"
 
 	"gopkg.in/ini.v1"
 )
@@ -19,6 +21,8 @@ var (
 	ErrDaemonDoesNotExist    = errors.New("daemon does not exist")
 	ErrUnknownDirectory      = errors.New("init system uses unknown init scripts directory")
 	ErrUnsupportedInitOutput = errors.New("init system produced unknown output")
+	ErrSystemNotRunning      = errors.New("system in the alternative root is not running, daemons can only be enabled or disabled")
+	ErrMaskUnsupported       = errors.New("daemons can be masked only by systemd")
 )
 
 type Daemon struct {
@@ -26,6 +30,17 @@ type Daemon struct {
 	IsActive  bool
 	IsEnabled bool
 	RunLevel  string
+	// Fields below are filled only by systemd
+	IsFailed bool
+	IsMasked bool
+	// SubState describes the state in more detail, e.g. running, exited or dead
+	SubState string
+	// UnitPath is the file describing the unit
+	UnitPath string
+	MainPid  int
+	// Restarts counts automatic restarts of the service since it was started
+	Restarts   int
+	RecentLogs []string
 }
 
 func execute(ctx context.Context, name string, args ...string) (string, bool, error) {
@@ -66,46 +81,79 @@ func getDaemonDataFromFS(daemonName, path string) (bool, string, error) {
 	return runLevel != "", runLevel, nil
 }
 
-func getSystemdDaemon(ctx context.Context, daemonName string) (Daemon, error) {
+// systemctlFunc executes systemctl of the system or of the user, like execute
+type systemctlFunc func(args ...string) (string, bool, error)
+
+// journalFunc returns recent lines logged by the unit
+type journalFunc func(unitName string) []string
+
+// systemdProperties are read at once, `systemctl show` loads the unit, so properties of aliases are the real unit's
+var systemdProperties = []string{
+	"Id", "LoadState", "ActiveState", "SubState", "UnitFileState", "FragmentPath", "MainPID", "NRestarts",
+}
+
+// recentLogLines is the number of journal lines returned with the daemon
+const recentLogLines = 10
+
+func getSystemdDaemon(daemonName string, systemctl systemctlFunc, journal journalFunc) (Daemon, error) {
 	daemon := Daemon{Name: daemonName}
 
-	isActiveOutput, ok, err := execute(ctx, "systemctl", "is-active", daemonName)
-	if !ok {
+	showOutput, ok, err := systemctl("show", "--property="+strings.Join(systemdProperties, ","), daemonName)
+	if !ok || err != nil {
 		return daemon, err
 	}
-	if isActiveOutput == "active" {
-		daemon.IsActive = true
-	}
+	properties := parseSystemdProperties(showOutput)
+	setSystemdProperties(&daemon, properties)
 
-	isEnabledOutput, ok, err := execute(ctx, "systemctl", "is-enabled", daemonName)
+	getDefaultOutput, ok, err := systemctl("get-default")
 	if !ok {
 		return daemon, err
 	}
+	daemon.RunLevel = getDefaultOutput
 
-	// If output is alias, resolve real daemon
-	if isEnabledOutput == "alias" {
-		daemonIdOutput, ok, err := execute(ctx, "systemctl", "show", "-p", "Id", "--value", daemonName)
-		if !ok {
-			return daemon, err
-		}
+	if properties["LoadState"] == "loaded" {
+		daemon.RecentLogs = journal(properties["Id"])
+	}
+	return daemon, nil
+}
 
-		isEnabledOutput, ok, err = execute(ctx, "systemctl", "is-enabled", daemonIdOutput)
-		if !ok {
-			return daemon, err
+// parseSystemdProperties parses key=value lines printed by `systemctl show`
+func parseSystemdProperties(output string) map[string]string {
+	properties := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if found {
+			properties[key] = strings.TrimSpace(value)
 		}
 	}
+	return properties
+}
 
-	if isEnabledOutput == "enabled" || isEnabledOutput == "static" || isEnabledOutput == "indirect" {
-		daemon.IsEnabled = true
-	}
+func setSystemdProperties(daemon *Daemon, properties map[string]string) {
+	activeState, unitFileState := properties["ActiveState"], properties["UnitFileState"]
+
+	daemon.IsActive = activeState == "active"
+	daemon.IsFailed = activeState == "failed"
+	daemon.IsEnabled = unitFileState == "enabled" || unitFileState == "static" || unitFileState == "indirect"
+	daemon.IsMasked = properties["LoadState"] == "masked" || strings.HasPrefix(unitFileState, "masked")
+	daemon.SubState = properties["SubState"]
+	daemon.UnitPath = properties["FragmentPath"]
+	// NRestarts is missing in older systemd versions, the count is zero then
+	daemon.MainPid, _ = strconv.Atoi(properties["MainPID"])
+	daemon.Restarts, _ = strconv.Atoi(properties["NRestarts"])
+}
 
-	getDefaultOutput, ok, err := execute(ctx, "systemctl", "get-default")
-	if !ok {
-		return daemon, err
+// getJournal returns lines logged by the unit, the journal can't be read e.g. by users outside
+// of the systemd-journal group, so logs are empty if journalctl fails
+func getJournal(unitOption string, journalctl systemctlFunc) journalFunc {
+	return func(unitName string) []string {
+		output, ok, err := journalctl(unitOption+"="+unitName, "--lines="+strconv.Itoa(recentLogLines),
+			"--output=cat", "--no-pager", "--quiet")
+		if !ok || err != nil || output == "" {
+			return nil
+		}
+		return strings.Split(output, "\n")
 	}
-	daemon.RunLevel = getDefaultOutput
-
-	return daemon, nil
 }
 
 func getRootlessOpenRCDaemon(ctx context.Context, daemonName string) (Daemon, error) {
@@ -255,83 +303,238 @@ func getRunitDaemon(ctx context.Context, daemonName string) (Daemon, error) {
 	return daemon, nil
 }
 
+// daemonNameRegex matches characters allowed in unit names, the name can't start with "-", so it isn't an option
+var daemonNameRegex = regexp.MustCompile(`^[A-Za-z0-9_:\\][A-Za-z0-9_.@:\\-]*$`)
+
+// validateDaemonName accepts names of init scripts and full names of systemd units,
+// e.g. backup.timer, getty@tty1 or escaped mnt-data\x2dbackup.mount
+func validateDaemonName(daemonName string) error {
+	isSafe := daemonNameRegex.MatchString(strings.TrimSpace(daemonName))
+	if !isSafe {
+		return errors.New("daemon name contains illegal character")
+	}
+	return nil
+}
+
+// GetDaemon inspects the daemon using the init system running on this machine
 func GetDaemon(daemonName string) (Daemon, error) {
+	return SystemInitManager{}.GetDaemon(daemonName)
+}
+
+// SystemInitManager manages daemons of the init system running on this machine.
+// If Root is set, it manages the (not running) system mounted there
+type SystemInitManager struct {
+	Root string
+}
+
+// initManager returns init manager of the detected init system, every operation detects it again,
+// because the init system of the root can change, e.g. while a system is being installed there
+func (s SystemInitManager) initManager() (InitManager, error) {
+	if isAlternativeRoot(s.Root) {
+		return NewInitManager(getInitSystemInRoot(s.Root), s.Root)
+	}
+
 	initSystem, err := GetInitSystem()
+	if err != nil {
+		return nil, err
+	}
+	return NewInitManager(initSystem, s.Root)
+}
+
+func (s SystemInitManager) GetDaemon(daemonName string) (Daemon, error) {
+	initManager, err := s.initManager()
 	if err != nil {
 		return Daemon{}, err
 	}
+	return initManager.GetDaemon(daemonName)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func (s SystemInitManager) StartDaemon(daemonName string) error {
+	initManager, err := s.initManager()
+	if err != nil {
+		return err
+	}
+	return initManager.StartDaemon(daemonName)
+}
 
-	daemonName = strings.TrimSpace(daemonName)
-	isSafe, _ := regexp.MatchString(`^[A-Za-z0-9_]+$`, daemonName)
+func (s SystemInitManager) StopDaemon(daemonName string) error {
+	initManager, err := s.initManager()
+	if err != nil {
+		return err
+	}
+	return initManager.StopDaemon(daemonName)
+}
 
-	if !isSafe {
-		return Daemon{}, errors.New("daemon name contains illegal character")
+func (s SystemInitManager) RestartDaemon(daemonName string) error {
+	initManager, err := s.initManager()
+	if err != nil {
+		return err
 	}
+	return initManager.RestartDaemon(daemonName)
+}
 
-	switch initSystem {
-	case SYSTEMD:
-		return getSystemdDaemon(ctx, daemonName)
-	case OPENRC:
-		return getOpenRCDaemon(ctx, daemonName)
-	case RUNIT:
-		return getRunitDaemon(ctx, daemonName)
-	default:
-		return Daemon{}, ErrUnsupportedInit
+func (s SystemInitManager) EnableDaemon(daemonName string) error {
+	initManager, err := s.initManager()
+	if err != nil {
+		return err
 	}
+	return initManager.EnableDaemon(daemonName)
 }
 
-func errorIfNotSystemd() error {
-	initSystem, err := GetInitSystem()
+func (s SystemInitManager) DisableDaemon(daemonName string) error {
+	initManager, err := s.initManager()
 	if err != nil {
 		return err
 	}
+	return initManager.DisableDaemon(daemonName)
+}
 
-	if initSystem == SYSTEMD {
-		return nil
+func (s SystemInitManager) MaskDaemon(daemonName string) error {
+	initManager, err := s.initManager()
+	if err != nil {
+		return err
 	}
-	return errors.New("this api method supports only systemd")
+	return initManager.MaskDaemon(daemonName)
 }
 
-type DaemonApi struct {
-	ImportLoopData *shared.ImportLoopData
+func (s SystemInitManager) UnmaskDaemon(daemonName string) error {
+	initManager, err := s.initManager()
+	if err != nil {
+		return err
+	}
+	return initManager.UnmaskDaemon(daemonName)
 }
 
-func (s *DaemonApi) StartDaemon(daemonName string) error {
-	if err := errorIfNotSystemd(); err != nil {
+func (s SystemInitManager) ReloadDaemons() error {
+	initManager, err := s.initManager()
+	if err != nil {
 		return err
 	}
+	return initManager.ReloadDaemons()
+}
 
-	return s.ImportLoopData.DaemonTracker.StartDaemon(daemonName)
+type DaemonApi struct {
+	ImportLoopData *shared.ImportLoopData
+	// InitManager is used instead of InitManagerBackend if it's set
+	InitManager InitManager
+	// UserInitManager is used instead of UserInitManagerBackend if it's set
+	UserInitManager InitManager
+	// Rule which changes the daemons, it's saved with their previous state
+	Rule vrctFs.Rule
 }
 
-func (s *DaemonApi) StopDaemon(daemonName string) error {
-	if err := errorIfNotSystemd(); err != nil {
-		return err
+func (s *DaemonApi) initManager(scope DaemonScope) InitManager {
+	if scope == UserScope {
+		if s.UserInitManager != nil {
+			return s.UserInitManager
+		}
+		return UserInitManagerBackend
+	}
+
+	if s.InitManager != nil {
+		return s.InitManager
 	}
+	return InitManagerBackend
+}
 
-	return s.ImportLoopData.DaemonTracker.StopDaemon(daemonName)
+// recordDaemonState saves the state the daemon had before it was changed, so revert can restore it
+func (s *DaemonApi) recordDaemonState(daemonName string, scope DaemonScope, daemon Daemon) {
+	change := vrctFs.DaemonChange{
+		Name:       daemonName,
+		WasActive:  daemon.IsActive,
+		WasEnabled: daemon.IsEnabled,
+		WasMasked:  daemon.IsMasked,
+		Rule:       s.Rule,
+	}
+	if scope == UserScope {
+		change.Scope = string(UserScope)
+	}
+	s.ImportLoopData.VRCT.Fs.RecordDaemonChange(change)
 }
-func (s *DaemonApi) RestartDaemon(daemonName string) error {
-	if err := errorIfNotSystemd(); err != nil {
+
+// changeDaemon records the operation to find conflicts with other rules and executes it when the changes are applied,
+// once packages are installed and units are written.
+// The scope is optional, daemons of the system are changed without it
+func (s *DaemonApi) changeDaemon(
+	daemonName string,
+	scopeArgs []string,
+	track func(tracker *daemontracker.DaemonTracker, daemonName string) error,
+	operation func(initManager InitManager, daemonName string) error,
+) error {
+	scope, err := parseScopeArgument(scopeArgs)
+	if err != nil {
 		return err
 	}
 
-	return s.ImportLoopData.DaemonTracker.RestartDaemon(daemonName)
-}
-func (s *DaemonApi) EnableDaemon(daemonName string) error {
-	if err := errorIfNotSystemd(); err != nil {
+	tracker := &s.ImportLoopData.DaemonTracker
+	if scope == UserScope {
+		tracker = tracker.User()
+	}
+	if err := track(tracker, daemonName); err != nil {
 		return err
 	}
 
-	return s.ImportLoopData.DaemonTracker.EnableDaemon(daemonName)
+	s.ImportLoopData.DeferDaemonOperation(func() error {
+		return s.executeDaemonOperation(daemonName, scope, operation)
+	})
+	return nil
 }
-func (s *DaemonApi) DisableDaemon(daemonName string) error {
-	if err := errorIfNotSystemd(); err != nil {
+
+// executeDaemonOperation changes the daemon and records its previous state once the operation succeeds,
+// failed operations don't change the daemon, so there is nothing to revert
+func (s *DaemonApi) executeDaemonOperation(
+	daemonName string, scope DaemonScope, operation func(initManager InitManager, daemonName string) error,
+) error {
+	initManager := s.initManager(scope)
+	// Operation itself reports that the daemon doesn't exist
+	daemon, stateErr := initManager.GetDaemon(daemonName)
+	if err := operation(initManager, daemonName); err != nil {
 		return err
 	}
+	if stateErr == nil {
+		s.recordDaemonState(daemonName, scope, daemon)
+	}
+	return nil
+}
+
+func (s *DaemonApi) GetDaemon(daemonName string, scope ...string) (Daemon, error) {
+	daemonScope, err := parseScopeArgument(scope)
+	if err != nil {
+		return Daemon{}, err
+	}
+	return s.initManager(daemonScope).GetDaemon(daemonName)
+}
+
+func (s *DaemonApi) StartDaemon(daemonName string, scope ...string) error {
+	return s.changeDaemon(daemonName, scope, (*daemontracker.DaemonTracker).StartDaemon, InitManager.StartDaemon)
+}
+
+func (s *DaemonApi) StopDaemon(daemonName string, scope ...string) error {
+	return s.changeDaemon(daemonName, scope, (*daemontracker.DaemonTracker).StopDaemon, InitManager.StopDaemon)
+}
+
+func (s *DaemonApi) RestartDaemon(daemonName string, scope ...string) error {
+	return s.changeDaemon(daemonName, scope, (*daemontracker.DaemonTracker).RestartDaemon, InitManager.RestartDaemon)
+}
+
+func (s *DaemonApi) EnableDaemon(daemonName string, scope ...string) error {
+	return s.changeDaemon(daemonName, scope, (*daemontracker.DaemonTracker).EnableDaemon, InitManager.EnableDaemon)
+}
+
+func (s *DaemonApi) DisableDaemon(daemonName string, scope ...string) error {
+	return s.changeDaemon(daemonName, scope, (*daemontracker.DaemonTracker).DisableDaemon, InitManager.DisableDaemon)
+}
+
+func (s *DaemonApi) MaskDaemon(daemonName string, scope ...string) error {
+	return s.changeDaemon(daemonName, scope, (*daemontracker.DaemonTracker).MaskDaemon, InitManager.MaskDaemon)
+}
+
+func (s *DaemonApi) UnmaskDaemon(daemonName string, scope ...string) error {
+	return s.changeDaemon(daemonName, scope, (*daemontracker.DaemonTracker).UnmaskDaemon, InitManager.UnmaskDaemon)
+}
 
-	return s.ImportLoopData.DaemonTracker.DisableDaemon(daemonName)
+// IsDaemonFailed returns true if the daemon has exited with an error, its logs are in the RecentLogs of the daemon
+func (s *DaemonApi) IsDaemonFailed(daemonName string, scope ...string) (bool, error) {
+	daemon, err := s.GetDaemon(daemonName, scope...)
+	return daemon.IsFailed, err
 }
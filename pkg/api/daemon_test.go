@@ -1,6 +1,10 @@
 package api
 
-import "testing"
+import (
+	"slices"
+	"strings"
+	"testing"
+)
 
 func TestGetDaemon(t *testing.T) {
 	daemon, err := GetDaemon("dbus")
@@ -30,3 +34,71 @@ func TestGetDaemon(t *testing.T) {
 	t.Logf("\nIs enabled: %t", daemon.IsEnabled)
 	t.Logf("\nRun level: %s", daemon.RunLevel)
 }
+
+const failedDaemonProperties = `Id=backup.service
+LoadState=loaded
+ActiveState=failed
+SubState=failed
+UnitFileState=enabled
+FragmentPath=/etc/systemd/system/backup.service
+MainPID=0
+NRestarts=3`
+
+func TestGetSystemdDaemon(t *testing.T) {
+	var journalUnit string
+	systemctl := func(args ...string) (string, bool, error) {
+		switch args[0] {
+		case "show":
+			if args[len(args)-1] != "backup" {
+				t.Fatalf("unexpected daemon: %v", args)
+			}
+			return failedDaemonProperties, true, nil
+		case "get-default":
+			return "graphical.target", true, nil
+		}
+		t.Fatalf("unexpected systemctl call: %v", args)
+		return "", false, nil
+	}
+	journal := func(unitName string) []string {
+		journalUnit = unitName
+		return []string{"restic: repository is locked", "backup.service: Failed with result 'exit-code'."}
+	}
+
+	daemon, err := getSystemdDaemon("backup", systemctl, journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if daemon.IsActive || !daemon.IsFailed || !daemon.IsEnabled || daemon.IsMasked || daemon.Restarts != 3 {
+		t.Fatalf("daemon should be failed after 3 restarts, got: %+v", daemon)
+	}
+	if daemon.UnitPath != "/etc/systemd/system/backup.service" || daemon.RunLevel != "graphical.target" {
+		t.Fatalf("unexpected unit path or run level: %+v", daemon)
+	}
+	if journalUnit != "backup.service" || !strings.Contains(daemon.RecentLogs[0], "locked") {
+		t.Fatalf("logs of the unit should be returned, got: %s, %v", journalUnit, daemon.RecentLogs)
+	}
+}
+
+func TestParseMaskedDaemon(t *testing.T) {
+	daemon := Daemon{Name: "bluetooth"}
+	setSystemdProperties(&daemon, parseSystemdProperties("LoadState=masked\nActiveState=inactive\nSubState=dead\nUnitFileState=masked\nMainPID=0"))
+	if !daemon.IsMasked || daemon.IsEnabled || daemon.SubState != "dead" {
+		t.Fatalf("daemon should be masked, got: %+v", daemon)
+	}
+}
+
+func TestValidateDaemonName(t *testing.T) {
+	validNames := []string{"sshd", "getty@tty1", "foo-bar.service", "mnt-data\\x2dbackup.mount", "user@1000.service"}
+	for _, daemonName := range validNames {
+		if err := validateDaemonName(daemonName); err != nil {
+			t.Errorf("%s should be valid: %v", daemonName, err)
+		}
+	}
+
+	invalidNames := []string{"", "-sshd", "--now", "ssh d", "../sshd", "sshd;reboot"}
+	if slices.ContainsFunc(invalidNames, func(daemonName string) bool {
+		return validateDaemonName(daemonName) == nil
+	}) {
+		t.Errorf("all the names should be invalid: %v", invalidNames)
+	}
+}
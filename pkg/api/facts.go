@@ -0,0 +1,314 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/zcalusic/sysinfo"
+)
+
+// Facts describe hardware and configuration of the machine, rules use them to decide what to change,
+// e.g. to install nvidia drivers only on machines with nvidia GPU
+type Facts struct {
+	Hostname string
+	Kernel   KernelFacts
+	Cpu      CpuFacts
+	Memory   MemoryFacts
+	// BlockDevices are disks of the machine, partitions, loop and ram devices are skipped
+	BlockDevices      []BlockDevice
+	NetworkInterfaces []NetworkInterface
+	Gpus              []Gpu
+	// Timezone is the name from the tz database, e.g. Europe/Warsaw
+	Timezone string
+	// Locale is the language of the system, e.g. en_US.UTF-8
+	Locale  string
+	Desktop DesktopFacts
+	// Virtualization is the hypervisor, e.g. kvm, vmware or vbox, it's empty on bare metal
+	Virtualization string
+	// Container is the container runtime, e.g. docker, podman or lxc, it's empty outside of containers
+	Container string
+}
+
+type KernelFacts struct {
+	Release string
+	Version string
+	// Architecture uses names reported by uname, like Platform.Arch
+	Architecture string
+}
+
+type CpuFacts struct {
+	Vendor  string
+	Model   string
+	Cores   int
+	Threads int
+}
+
+// MemoryFacts are in bytes
+type MemoryFacts struct {
+	Total     uint64
+	Available uint64
+	SwapTotal uint64
+}
+
+type BlockDevice struct {
+	Name string
+	// Size is in bytes
+	Size         uint64
+	Model        string
+	IsRotational bool
+	IsRemovable  bool
+}
+
+type NetworkInterface struct {
+	Name       string
+	MacAddress string
+	// Addresses are in the CIDR notation, e.g. 192.168.1.10/24
+	Addresses  []string
+	IsUp       bool
+	IsLoopback bool
+}
+
+type Gpu struct {
+	// Vendor is nvidia, amd, intel, or other name for known PCI vendor ids, the id itself otherwise
+	Vendor   string
+	VendorId string
+	DeviceId string
+	// Driver is the kernel driver in use, e.g. nouveau, nvidia or amdgpu, it's empty if no driver is loaded
+	Driver string
+}
+
+// DesktopFacts describe the graphical session spito was started from, they are empty in other sessions
+type DesktopFacts struct {
+	// Session is the desktop environment, e.g. KDE or GNOME
+	Session string
+	// SessionType is wayland, x11 or tty
+	SessionType string
+}
+
+// HasGpu returns true if the machine has GPU of the vendor, e.g. nvidia
+func (f Facts) HasGpu(vendor string) bool {
+	return slices.ContainsFunc(f.Gpus, func(gpu Gpu) bool {
+		return strings.EqualFold(gpu.Vendor, vendor)
+	})
+}
+
+// FactsBackend returns facts of the machine, they are gathered once per run.
+// It's replaced in tests, so rules can be checked against other hardware
+var FactsBackend = getCachedFacts
+
+var (
+	cachedFacts Facts
+	factsOnce   sync.Once
+)
+
+func getCachedFacts() Facts {
+	factsOnce.Do(func() {
+		cachedFacts = GetFacts()
+	})
+	return cachedFacts
+}
+
+// pciVendors maps PCI vendor ids of display controllers to their names
+var pciVendors = map[string]string{
+	"0x10de": "nvidia",
+	"0x1002": "amd",
+	"0x1022": "amd",
+	"0x8086": "intel",
+	"0x1af4": "virtio",
+	"0x15ad": "vmware",
+	"0x80ee": "virtualbox",
+	"0x1234": "qemu",
+	"0x1b36": "qemu",
+	"0x1414": "microsoft",
+	"0x5143": "qualcomm",
+}
+
+// containerSystems are virtualization systems reported by gopsutil, which are containers, not hypervisors
+var containerSystems = []string{"docker", "lxc", "openvz", "linux-vserver", "rkt", "podman", "systemd-nspawn", "wsl"}
+
+// GetFacts gathers facts of the running machine. Facts which can't be read, e.g. inside a container
+// without /sys, are left empty
+func GetFacts() Facts {
+	var systemInfo sysinfo.SysInfo
+	systemInfo.GetSysInfo()
+
+	facts := Facts{
+		Hostname: systemInfo.Node.Hostname,
+		Kernel: KernelFacts{
+			Release:      systemInfo.Kernel.Release,
+			Version:      systemInfo.Kernel.Version,
+			Architecture: systemInfo.Kernel.Architecture,
+		},
+		Cpu: CpuFacts{
+			Vendor:  systemInfo.CPU.Vendor,
+			Model:   systemInfo.CPU.Model,
+			Cores:   int(systemInfo.CPU.Cores),
+			Threads: int(systemInfo.CPU.Threads),
+		},
+		BlockDevices:   getBlockDevices("/"),
+		Gpus:           getGpus("/"),
+		Timezone:       systemInfo.Node.Timezone,
+		Locale:         getLocale("/"),
+		Virtualization: systemInfo.Node.Hypervisor,
+		Desktop: DesktopFacts{
+			Session:     getFirstEnv("XDG_CURRENT_DESKTOP", "XDG_SESSION_DESKTOP", "DESKTOP_SESSION"),
+			SessionType: os.Getenv("XDG_SESSION_TYPE"),
+		},
+	}
+
+	if memory, err := mem.VirtualMemory(); err == nil {
+		facts.Memory = MemoryFacts{Total: memory.Total, Available: memory.Available, SwapTotal: memory.SwapTotal}
+	}
+	facts.NetworkInterfaces = getNetworkInterfaces()
+
+	system, role, err := host.Virtualization()
+	if err == nil && role == "guest" && !slices.Contains(containerSystems, system) && facts.Virtualization == "" {
+		facts.Virtualization = system
+	}
+	facts.Container = getContainer("/")
+	if facts.Container == "" && err == nil && role == "guest" && slices.Contains(containerSystems, system) {
+		facts.Container = system
+	}
+	return facts
+}
+
+func getFirstEnv(variableNames ...string) string {
+	for _, variableName := range variableNames {
+		if value := os.Getenv(variableName); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// readSysFile returns trimmed content of the file, it's empty if the file can't be read
+func readSysFile(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// getGpus finds display controllers (PCI class 0x03) of the machine
+func getGpus(root string) []Gpu {
+	devicesPath := filepath.Join(root, "sys/bus/pci/devices")
+	devices, err := os.ReadDir(devicesPath)
+	if err != nil {
+		return nil
+	}
+
+	var gpus []Gpu
+	for _, device := range devices {
+		devicePath := filepath.Join(devicesPath, device.Name())
+		if !strings.HasPrefix(readSysFile(filepath.Join(devicePath, "class")), "0x03") {
+			continue
+		}
+
+		gpu := Gpu{
+			VendorId: readSysFile(filepath.Join(devicePath, "vendor")),
+			DeviceId: readSysFile(filepath.Join(devicePath, "device")),
+		}
+		gpu.Vendor = gpu.VendorId
+		if vendor, ok := pciVendors[gpu.VendorId]; ok {
+			gpu.Vendor = vendor
+		}
+		if driverPath, err := os.Readlink(filepath.Join(devicePath, "driver")); err == nil {
+			gpu.Driver = filepath.Base(driverPath)
+		}
+		gpus = append(gpus, gpu)
+	}
+	return gpus
+}
+
+// getBlockDevices lists disks from /sys/block, sizes there are in 512-byte sectors
+func getBlockDevices(root string) []BlockDevice {
+	blockPath := filepath.Join(root, "sys/block")
+	entries, err := os.ReadDir(blockPath)
+	if err != nil {
+		return nil
+	}
+
+	var devices []BlockDevice
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "zram") {
+			continue
+		}
+
+		devicePath := filepath.Join(blockPath, name)
+		sectors, _ := strconv.ParseUint(readSysFile(filepath.Join(devicePath, "size")), 10, 64)
+
+		devices = append(devices, BlockDevice{
+			Name:         name,
+			Size:         sectors * 512,
+			Model:        readSysFile(filepath.Join(devicePath, "device/model")),
+			IsRotational: readSysFile(filepath.Join(devicePath, "queue/rotational")) == "1",
+			IsRemovable:  readSysFile(filepath.Join(devicePath, "removable")) == "1",
+		})
+	}
+	return devices
+}
+
+func getNetworkInterfaces() []NetworkInterface {
+	interfaceStats, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var interfaces []NetworkInterface
+	for _, interfaceStat := range interfaceStats {
+		networkInterface := NetworkInterface{
+			Name:       interfaceStat.Name,
+			MacAddress: interfaceStat.HardwareAddr,
+			IsUp:       slices.Contains(interfaceStat.Flags, "up"),
+			IsLoopback: slices.Contains(interfaceStat.Flags, "loopback"),
+		}
+		for _, address := range interfaceStat.Addrs {
+			networkInterface.Addresses = append(networkInterface.Addresses, address.Addr)
+		}
+		interfaces = append(interfaces, networkInterface)
+	}
+	return interfaces
+}
+
+// getLocale returns locale of the environment, or of the system if it isn't set, e.g. when spito runs as a service
+func getLocale(root string) string {
+	if locale := getFirstEnv("LC_ALL", "LANG"); locale != "" {
+		return locale
+	}
+
+	for _, configPath := range []string{"etc/locale.conf", "etc/default/locale"} {
+		for _, line := range strings.Split(readSysFile(filepath.Join(root, configPath)), "\n") {
+			if locale, found := strings.CutPrefix(strings.TrimSpace(line), "LANG="); found {
+				return strings.Trim(locale, `"'`)
+			}
+		}
+	}
+	return ""
+}
+
+// getContainer detects the container runtime by the files which runtimes create
+func getContainer(root string) string {
+	// systemd and podman write the name of the runtime there
+	if container := readSysFile(filepath.Join(root, "run/systemd/container")); container != "" {
+		return container
+	}
+	if pathExists(filepath.Join(root, "run/.containerenv")) {
+		return "podman"
+	}
+	if pathExists(filepath.Join(root, ".dockerenv")) {
+		return "docker"
+	}
+	if strings.Contains(strings.ToLower(readSysFile(filepath.Join(root, "proc/sys/kernel/osrelease"))), "microsoft") {
+		return "wsl"
+	}
+	return ""
+}
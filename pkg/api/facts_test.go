@@ -0,0 +1,99 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRootFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		filePath := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetGpus(t *testing.T) {
+	root := t.TempDir()
+	writeRootFiles(t, root, map[string]string{
+		"sys/bus/pci/devices/0000:01:00.0/class":  "0x030000\n",
+		"sys/bus/pci/devices/0000:01:00.0/vendor": "0x10de\n",
+		"sys/bus/pci/devices/0000:01:00.0/device": "0x2684\n",
+		"sys/bus/pci/devices/0000:02:00.0/class":  "0x020000\n",
+		"sys/bus/pci/devices/0000:02:00.0/vendor": "0x8086\n",
+		"sys/bus/drivers/nvidia/.keep":            "",
+	})
+	driverPath := filepath.Join(root, "sys/bus/drivers/nvidia")
+	if err := os.Symlink(driverPath, filepath.Join(root, "sys/bus/pci/devices/0000:01:00.0/driver")); err != nil {
+		t.Fatal(err)
+	}
+
+	facts := Facts{Gpus: getGpus(root)}
+	if len(facts.Gpus) != 1 {
+		t.Fatalf("expected only the display controller, got %+v", facts.Gpus)
+	}
+	if gpu := facts.Gpus[0]; gpu.Vendor != "nvidia" || gpu.DeviceId != "0x2684" || gpu.Driver != "nvidia" {
+		t.Fatalf("unexpected gpu: %+v", gpu)
+	}
+	if !facts.HasGpu("NVIDIA") || facts.HasGpu("amd") {
+		t.Fatalf("HasGpu doesn't match the vendor of %+v", facts.Gpus)
+	}
+}
+
+func TestGetBlockDevices(t *testing.T) {
+	root := t.TempDir()
+	writeRootFiles(t, root, map[string]string{
+		"sys/block/sda/size":             "1953525168\n",
+		"sys/block/sda/device/model":     "ST1000DM010  \n",
+		"sys/block/sda/queue/rotational": "1\n",
+		"sys/block/sda/removable":        "0\n",
+		"sys/block/loop0/size":           "8\n",
+	})
+
+	devices := getBlockDevices(root)
+	if len(devices) != 1 {
+		t.Fatalf("expected loop devices to be skipped, got %+v", devices)
+	}
+	expected := BlockDevice{Name: "sda", Size: 1953525168 * 512, Model: "ST1000DM010", IsRotational: true}
+	if devices[0] != expected {
+		t.Fatalf("unexpected block device: %+v", devices[0])
+	}
+}
+
+func TestGetLocale(t *testing.T) {
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LANG", "")
+
+	root := t.TempDir()
+	writeRootFiles(t, root, map[string]string{"etc/locale.conf": "LC_TIME=C\nLANG=\"pl_PL.UTF-8\"\n"})
+	if locale := getLocale(root); locale != "pl_PL.UTF-8" {
+		t.Fatalf("expected locale of the system, got '%s'", locale)
+	}
+
+	t.Setenv("LANG", "de_DE.UTF-8")
+	if locale := getLocale(root); locale != "de_DE.UTF-8" {
+		t.Fatalf("expected locale of the environment, got '%s'", locale)
+	}
+}
+
+func TestGetContainer(t *testing.T) {
+	containers := map[string]map[string]string{
+		"podman":         {"run/.containerenv": ""},
+		"docker":         {".dockerenv": ""},
+		"systemd-nspawn": {"run/systemd/container": "systemd-nspawn\n"},
+		"":               {"proc/sys/kernel/osrelease": "6.6.0-arch1-1\n"},
+	}
+
+	for expected, files := range containers {
+		root := t.TempDir()
+		writeRootFiles(t, root, files)
+		if container := getContainer(root); container != expected {
+			t.Errorf("expected container '%s', got '%s'", expected, container)
+		}
+	}
+}
@@ -0,0 +1,687 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	openRCInitScriptsDir   = "/etc/init.d"
+	openRCRunLevelsDir     = "/etc/runlevels"
+	openRCDefaultRunLevel  = "default"
+	runitRunLevelsDir      = "/etc/runit/runsvdir"
+	runitDefaultRunLevel   = "default"
+	s6ServicesDir          = "/etc/s6/sv"
+	s6EnabledServicesDir   = "/etc/s6/adminsv/default/contents.d"
+	s6SupervisedServiceDir = "/run/service"
+	s6DefaultBundle        = "default"
+	dinitServicesDir       = "/etc/dinit.d"
+	dinitBootDir           = "/etc/dinit.d/boot.d"
+	dinitBootService       = "boot"
+	daemonQueryTimeout     = 10 * time.Second
+)
+
+var ErrS6InRoot = errors.New("s6-rc database cannot be compiled for the system in the alternative root, s6 daemons can only be changed on the running system")
+
+// NewInitManager returns init manager controlling daemons of the init system,
+// if root is set, it manages the (not running) system mounted there
+func NewInitManager(initSystem InitSystem, root string) (InitManager, error) {
+	switch initSystem {
+	case SYSTEMD:
+		return SystemdInitManager{Root: root}, nil
+	case OPENRC:
+		return OpenRCInitManager{Root: root}, nil
+	case RUNIT:
+		return RunitInitManager{Root: root}, nil
+	case S6:
+		return S6InitManager{Root: root}, nil
+	case DINIT:
+		return DinitInitManager{Root: root}, nil
+	default:
+		return nil, ErrUnsupportedInit
+	}
+}
+
+func isAlternativeRoot(root string) bool {
+	return root != "" && root != "/"
+}
+
+// runInitCommand executes the command of the init system, its output is returned as a part of the error
+func runInitCommand(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return errors.Join(err, errors.New(strings.TrimSpace(string(output))))
+	}
+	return nil
+}
+
+// validateServiceName makes sure the daemon name can be used as a file name and isn't mistaken for an option
+func validateServiceName(daemonName string) error {
+	if daemonName == "" || daemonName == "." || daemonName == ".." ||
+		strings.ContainsAny(daemonName, "/\x00") || strings.HasPrefix(daemonName, "-") {
+		return fmt.Errorf("invalid daemon name '%s'", daemonName)
+	}
+	return nil
+}
+
+func pathExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// linkService creates the link enabling the service, nothing happens if it already exists
+func linkService(target, linkPath string) error {
+	if pathExists(linkPath) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(target, linkPath)
+}
+
+// unlinkService removes the link enabling the service, nothing happens if it doesn't exist
+func unlinkService(linkPath string) error {
+	err := os.Remove(linkPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// SystemdInitManager controls daemons with systemctl
+type SystemdInitManager struct {
+	Root string
+}
+
+// runSystemdCommand executes systemctl, if root is set, it operates on the system mounted there
+func runSystemdCommand(root string, args ...string) error {
+	if isAlternativeRoot(root) {
+		args = append([]string{"--root=" + root}, args...)
+	}
+	return runInitCommand("systemctl", args...)
+}
+
+// getSystemdDaemonInRoot inspects daemon of the system mounted in the root directory,
+// such system isn't running, so only information about enabling is available
+func getSystemdDaemonInRoot(ctx context.Context, root, daemonName string) (Daemon, error) {
+	daemon := Daemon{Name: daemonName}
+	rootOption := "--root=" + root
+
+	isEnabledOutput, ok, err := execute(ctx, "systemctl", rootOption, "is-enabled", daemonName)
+	if !ok {
+		return daemon, err
+	}
+	if isEnabledOutput == "enabled" || isEnabledOutput == "static" || isEnabledOutput == "indirect" {
+		daemon.IsEnabled = true
+	}
+	daemon.IsMasked = isEnabledOutput == "masked"
+
+	getDefaultOutput, ok, err := execute(ctx, "systemctl", rootOption, "get-default")
+	if !ok {
+		return daemon, err
+	}
+	daemon.RunLevel = getDefaultOutput
+
+	return daemon, nil
+}
+
+func (s SystemdInitManager) GetDaemon(daemonName string) (Daemon, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), daemonQueryTimeout)
+	defer cancel()
+
+	daemonName = strings.TrimSpace(daemonName)
+	if err := validateDaemonName(daemonName); err != nil {
+		return Daemon{}, err
+	}
+
+	if isAlternativeRoot(s.Root) {
+		return getSystemdDaemonInRoot(ctx, s.Root, daemonName)
+	}
+	journalctl := func(args ...string) (string, bool, error) {
+		return execute(ctx, "journalctl", args...)
+	}
+	return getSystemdDaemon(daemonName, func(args ...string) (string, bool, error) {
+		return execute(ctx, "systemctl", args...)
+	}, getJournal("--unit", journalctl))
+}
+
+func (s SystemdInitManager) StartDaemon(daemonName string) error {
+	if isAlternativeRoot(s.Root) {
+		return ErrSystemNotRunning
+	}
+	return runSystemdCommand(s.Root, "start", daemonName)
+}
+
+func (s SystemdInitManager) StopDaemon(daemonName string) error {
+	if isAlternativeRoot(s.Root) {
+		return ErrSystemNotRunning
+	}
+	return runSystemdCommand(s.Root, "stop", daemonName)
+}
+
+func (s SystemdInitManager) RestartDaemon(daemonName string) error {
+	if isAlternativeRoot(s.Root) {
+		return ErrSystemNotRunning
+	}
+	return runSystemdCommand(s.Root, "restart", daemonName)
+}
+
+func (s SystemdInitManager) EnableDaemon(daemonName string) error {
+	return runSystemdCommand(s.Root, "enable", daemonName)
+}
+
+func (s SystemdInitManager) DisableDaemon(daemonName string) error {
+	return runSystemdCommand(s.Root, "disable", daemonName)
+}
+
+func (s SystemdInitManager) MaskDaemon(daemonName string) error {
+	return runSystemdCommand(s.Root, "mask", daemonName)
+}
+
+func (s SystemdInitManager) UnmaskDaemon(daemonName string) error {
+	return runSystemdCommand(s.Root, "unmask", daemonName)
+}
+
+// ReloadDaemons does nothing in the alternative root, its systemd reads the units when the system boots
+func (s SystemdInitManager) ReloadDaemons() error {
+	if isAlternativeRoot(s.Root) {
+		return nil
+	}
+	return runSystemdCommand(s.Root, "daemon-reload")
+}
+
+// OpenRCInitManager controls daemons with rc-service and rc-update,
+// in the alternative root runlevels are changed directly, as rc-update does
+type OpenRCInitManager struct {
+	Root string
+}
+
+func (o OpenRCInitManager) runLevelsDir() string {
+	return filepath.Join(o.Root, openRCRunLevelsDir) + "/"
+}
+
+func (o OpenRCInitManager) checkDaemon(daemonName string) error {
+	if err := validateServiceName(daemonName); err != nil {
+		return err
+	}
+	if !pathExists(filepath.Join(o.Root, openRCInitScriptsDir, daemonName)) {
+		return ErrDaemonDoesNotExist
+	}
+	return nil
+}
+
+func (o OpenRCInitManager) GetDaemon(daemonName string) (Daemon, error) {
+	daemonName = strings.TrimSpace(daemonName)
+	if err := validateDaemonName(daemonName); err != nil {
+		return Daemon{}, err
+	}
+
+	if !isAlternativeRoot(o.Root) {
+		ctx, cancel := context.WithTimeout(context.Background(), daemonQueryTimeout)
+		defer cancel()
+		return getOpenRCDaemon(ctx, daemonName)
+	}
+
+	if err := o.checkDaemon(daemonName); err != nil {
+		return Daemon{}, err
+	}
+	daemon := Daemon{Name: daemonName}
+	var err error
+	daemon.IsEnabled, daemon.RunLevel, err = getDaemonDataFromFS(daemonName, o.runLevelsDir())
+	return daemon, err
+}
+
+func (o OpenRCInitManager) runService(daemonName string, command string) error {
+	if isAlternativeRoot(o.Root) {
+		return ErrSystemNotRunning
+	}
+	if err := validateServiceName(daemonName); err != nil {
+		return err
+	}
+	return runInitCommand("rc-service", daemonName, command)
+}
+
+func (o OpenRCInitManager) StartDaemon(daemonName string) error {
+	return o.runService(daemonName, "start")
+}
+
+func (o OpenRCInitManager) StopDaemon(daemonName string) error {
+	return o.runService(daemonName, "stop")
+}
+
+func (o OpenRCInitManager) RestartDaemon(daemonName string) error {
+	return o.runService(daemonName, "restart")
+}
+
+// EnableDaemon adds the daemon to the default runlevel
+func (o OpenRCInitManager) EnableDaemon(daemonName string) error {
+	if err := o.checkDaemon(daemonName); err != nil {
+		return err
+	}
+	linkPath := filepath.Join(o.runLevelsDir(), openRCDefaultRunLevel, daemonName)
+	if pathExists(linkPath) {
+		return nil
+	}
+
+	if !isAlternativeRoot(o.Root) {
+		return runInitCommand("rc-update", "add", daemonName, openRCDefaultRunLevel)
+	}
+	return linkService(filepath.Join(openRCInitScriptsDir, daemonName), linkPath)
+}
+
+// DisableDaemon removes the daemon from all the runlevels
+func (o OpenRCInitManager) DisableDaemon(daemonName string) error {
+	if err := o.checkDaemon(daemonName); err != nil {
+		return err
+	}
+	isEnabled, _, err := getDaemonDataFromFS(daemonName, o.runLevelsDir())
+	if err != nil || !isEnabled {
+		return err
+	}
+
+	if !isAlternativeRoot(o.Root) {
+		return runInitCommand("rc-update", "--all", "delete", daemonName)
+	}
+
+	runLevels, err := os.ReadDir(o.runLevelsDir())
+	if err != nil {
+		return err
+	}
+	for _, runLevel := range runLevels {
+		if err := unlinkService(filepath.Join(o.runLevelsDir(), runLevel.Name(), daemonName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o OpenRCInitManager) MaskDaemon(string) error {
+	return ErrMaskUnsupported
+}
+
+func (o OpenRCInitManager) UnmaskDaemon(string) error {
+	return ErrMaskUnsupported
+}
+
+// ReloadDaemons does nothing, init scripts are read whenever they are executed
+func (o OpenRCInitManager) ReloadDaemons() error {
+	return nil
+}
+
+// runitLayout describes where the distro keeps runit services
+type runitLayout struct {
+	// services contains definitions of all the services
+	services string
+	// supervised contains services supervised by the running runsvdir, it's the current runlevel
+	supervised string
+}
+
+var runitLayouts = []runitLayout{
+	// Artix
+	{services: "/etc/runit/sv", supervised: "/run/runit/service"},
+	// Void
+	{services: "/etc/sv", supervised: "/var/service"},
+}
+
+// RunitInitManager controls daemons with sv, daemons are enabled by linking them to the default runlevel,
+// runsvdir then starts them, because runit doesn't distinguish enabled and running services
+type RunitInitManager struct {
+	Root string
+}
+
+func (r RunitInitManager) getLayout() (runitLayout, error) {
+	for _, layout := range runitLayouts {
+		if pathExists(filepath.Join(r.Root, layout.services)) {
+			return layout, nil
+		}
+	}
+	return runitLayout{}, ErrUnknownDirectory
+}
+
+func (r RunitInitManager) runLevelsDir() string {
+	return filepath.Join(r.Root, runitRunLevelsDir) + "/"
+}
+
+// checkDaemon returns the directory of the service, if it exists
+func (r RunitInitManager) checkDaemon(daemonName string) (string, error) {
+	if err := validateServiceName(daemonName); err != nil {
+		return "", err
+	}
+	layout, err := r.getLayout()
+	if err != nil {
+		return "", err
+	}
+
+	serviceDir := filepath.Join(layout.services, daemonName)
+	if !pathExists(filepath.Join(r.Root, serviceDir)) {
+		return "", ErrDaemonDoesNotExist
+	}
+	return serviceDir, nil
+}
+
+func (r RunitInitManager) GetDaemon(daemonName string) (Daemon, error) {
+	daemonName = strings.TrimSpace(daemonName)
+	if err := validateDaemonName(daemonName); err != nil {
+		return Daemon{}, err
+	}
+
+	if !isAlternativeRoot(r.Root) {
+		ctx, cancel := context.WithTimeout(context.Background(), daemonQueryTimeout)
+		defer cancel()
+		return getRunitDaemon(ctx, daemonName)
+	}
+
+	if _, err := r.checkDaemon(daemonName); err != nil {
+		return Daemon{}, err
+	}
+	daemon := Daemon{Name: daemonName}
+	var err error
+	daemon.IsEnabled, daemon.RunLevel, err = getDaemonDataFromFS(daemonName, r.runLevelsDir())
+	return daemon, err
+}
+
+// control sends the command to the supervised service, sv is given its path,
+// because the supervised directory differs between distros
+func (r RunitInitManager) control(daemonName string, command string) error {
+	if isAlternativeRoot(r.Root) {
+		return ErrSystemNotRunning
+	}
+	if _, err := r.checkDaemon(daemonName); err != nil {
+		return err
+	}
+	layout, err := r.getLayout()
+	if err != nil {
+		return err
+	}
+	return runInitCommand("sv", command, filepath.Join(layout.supervised, daemonName))
+}
+
+func (r RunitInitManager) StartDaemon(daemonName string) error {
+	return r.control(daemonName, "up")
+}
+
+func (r RunitInitManager) StopDaemon(daemonName string) error {
+	return r.control(daemonName, "down")
+}
+
+func (r RunitInitManager) RestartDaemon(daemonName string) error {
+	return r.control(daemonName, "restart")
+}
+
+func (r RunitInitManager) EnableDaemon(daemonName string) error {
+	serviceDir, err := r.checkDaemon(daemonName)
+	if err != nil {
+		return err
+	}
+	return linkService(serviceDir, filepath.Join(r.runLevelsDir(), runitDefaultRunLevel, daemonName))
+}
+
+func (r RunitInitManager) DisableDaemon(daemonName string) error {
+	if _, err := r.checkDaemon(daemonName); err != nil {
+		return err
+	}
+	return unlinkService(filepath.Join(r.runLevelsDir(), runitDefaultRunLevel, daemonName))
+}
+
+func (r RunitInitManager) MaskDaemon(string) error {
+	return ErrMaskUnsupported
+}
+
+func (r RunitInitManager) UnmaskDaemon(string) error {
+	return ErrMaskUnsupported
+}
+
+// ReloadDaemons does nothing, runsv reads the service directory when it starts the service
+func (r RunitInitManager) ReloadDaemons() error {
+	return nil
+}
+
+// S6InitManager controls daemons with s6-rc, daemons are enabled by adding them to the default bundle,
+// the database is compiled again after every change
+type S6InitManager struct {
+	Root string
+}
+
+func (s S6InitManager) checkDaemon(daemonName string) error {
+	if err := validateServiceName(daemonName); err != nil {
+		return err
+	}
+	if !pathExists(filepath.Join(s.Root, s6ServicesDir, daemonName)) {
+		return ErrDaemonDoesNotExist
+	}
+	return nil
+}
+
+func (s S6InitManager) GetDaemon(daemonName string) (Daemon, error) {
+	daemonName = strings.TrimSpace(daemonName)
+	if err := validateDaemonName(daemonName); err != nil {
+		return Daemon{}, err
+	}
+	if err := s.checkDaemon(daemonName); err != nil {
+		return Daemon{}, err
+	}
+
+	daemon := Daemon{Name: daemonName}
+	if pathExists(filepath.Join(s.Root, s6EnabledServicesDir, daemonName)) {
+		daemon.IsEnabled = true
+		daemon.RunLevel = s6DefaultBundle
+	}
+	if isAlternativeRoot(s.Root) {
+		return daemon, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), daemonQueryTimeout)
+	defer cancel()
+
+	activeServices, ok, err := execute(ctx, "s6-rc", "-a", "list")
+	if !ok || err != nil {
+		return daemon, err
+	}
+	for _, activeService := range strings.Split(activeServices, "\n") {
+		if strings.TrimSpace(activeService) == daemonName {
+			daemon.IsActive = true
+		}
+	}
+	return daemon, nil
+}
+
+func (s S6InitManager) runCommand(daemonName string, name string, args ...string) error {
+	if isAlternativeRoot(s.Root) {
+		return ErrSystemNotRunning
+	}
+	if err := s.checkDaemon(daemonName); err != nil {
+		return err
+	}
+	return runInitCommand(name, args...)
+}
+
+func (s S6InitManager) StartDaemon(daemonName string) error {
+	return s.runCommand(daemonName, "s6-rc", "-u", "change", daemonName)
+}
+
+func (s S6InitManager) StopDaemon(daemonName string) error {
+	return s.runCommand(daemonName, "s6-rc", "-d", "change", daemonName)
+}
+
+// RestartDaemon restarts the supervised process, services depending on it keep running
+func (s S6InitManager) RestartDaemon(daemonName string) error {
+	return s.runCommand(daemonName, "s6-svc", "-r", filepath.Join(s6SupervisedServiceDir, daemonName))
+}
+
+func (s S6InitManager) EnableDaemon(daemonName string) error {
+	if isAlternativeRoot(s.Root) {
+		return ErrS6InRoot
+	}
+	if err := s.checkDaemon(daemonName); err != nil {
+		return err
+	}
+
+	enabledPath := filepath.Join(s6EnabledServicesDir, daemonName)
+	if pathExists(enabledPath) {
+		return nil
+	}
+	if err := os.WriteFile(enabledPath, nil, 0644); err != nil {
+		return err
+	}
+	return runInitCommand("s6-db-reload")
+}
+
+func (s S6InitManager) DisableDaemon(daemonName string) error {
+	if isAlternativeRoot(s.Root) {
+		return ErrS6InRoot
+	}
+	if err := s.checkDaemon(daemonName); err != nil {
+		return err
+	}
+
+	enabledPath := filepath.Join(s6EnabledServicesDir, daemonName)
+	if !pathExists(enabledPath) {
+		return nil
+	}
+	if err := os.Remove(enabledPath); err != nil {
+		return err
+	}
+	return runInitCommand("s6-db-reload")
+}
+
+func (s S6InitManager) MaskDaemon(string) error {
+	return ErrMaskUnsupported
+}
+
+func (s S6InitManager) UnmaskDaemon(string) error {
+	return ErrMaskUnsupported
+}
+
+// ReloadDaemons does nothing, the database is compiled again by EnableDaemon and DisableDaemon
+func (s S6InitManager) ReloadDaemons() error {
+	return nil
+}
+
+// DinitInitManager controls daemons with dinitctl, in the alternative root
+// daemons are enabled by linking them to the boot service, as dinitctl does
+type DinitInitManager struct {
+	Root string
+}
+
+func (d DinitInitManager) checkDaemon(daemonName string) error {
+	if err := validateServiceName(daemonName); err != nil {
+		return err
+	}
+	if !pathExists(filepath.Join(d.Root, dinitServicesDir, daemonName)) {
+		return ErrDaemonDoesNotExist
+	}
+	return nil
+}
+
+func (d DinitInitManager) GetDaemon(daemonName string) (Daemon, error) {
+	daemonName = strings.TrimSpace(daemonName)
+	if err := validateDaemonName(daemonName); err != nil {
+		return Daemon{}, err
+	}
+	if err := d.checkDaemon(daemonName); err != nil {
+		return Daemon{}, err
+	}
+
+	daemon := Daemon{Name: daemonName}
+	if pathExists(filepath.Join(d.Root, dinitBootDir, daemonName)) {
+		daemon.IsEnabled = true
+		daemon.RunLevel = dinitBootService
+	}
+	if isAlternativeRoot(d.Root) {
+		return daemon, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), daemonQueryTimeout)
+	defer cancel()
+
+	status, ok, err := execute(ctx, "dinitctl", "status", daemonName)
+	if !ok || err != nil {
+		return daemon, err
+	}
+	daemon.IsActive, err = parseDinitState(status)
+	return daemon, err
+}
+
+// parseDinitState reads whether the service is started from the output of dinitctl status
+func parseDinitState(status string) (bool, error) {
+	for _, line := range strings.Split(status, "\n") {
+		state, isFound := strings.CutPrefix(strings.TrimSpace(line), "State:")
+		if isFound {
+			return strings.HasPrefix(strings.TrimSpace(state), "STARTED"), nil
+		}
+	}
+	return false, ErrUnsupportedInitOutput
+}
+
+func (d DinitInitManager) runCommand(daemonName string, command string) error {
+	if isAlternativeRoot(d.Root) {
+		return ErrSystemNotRunning
+	}
+	if err := d.checkDaemon(daemonName); err != nil {
+		return err
+	}
+	return runInitCommand("dinitctl", command, daemonName)
+}
+
+func (d DinitInitManager) StartDaemon(daemonName string) error {
+	return d.runCommand(daemonName, "start")
+}
+
+func (d DinitInitManager) StopDaemon(daemonName string) error {
+	return d.runCommand(daemonName, "stop")
+}
+
+func (d DinitInitManager) RestartDaemon(daemonName string) error {
+	return d.runCommand(daemonName, "restart")
+}
+
+// EnableDaemon makes the boot service wait for the daemon, dinitctl also starts it
+func (d DinitInitManager) EnableDaemon(daemonName string) error {
+	if err := d.checkDaemon(daemonName); err != nil {
+		return err
+	}
+	linkPath := filepath.Join(d.Root, dinitBootDir, daemonName)
+	if pathExists(linkPath) {
+		return nil
+	}
+
+	if !isAlternativeRoot(d.Root) {
+		return runInitCommand("dinitctl", "enable", daemonName)
+	}
+	return linkService(filepath.Join("..", daemonName), linkPath)
+}
+
+func (d DinitInitManager) DisableDaemon(daemonName string) error {
+	if err := d.checkDaemon(daemonName); err != nil {
+		return err
+	}
+	linkPath := filepath.Join(d.Root, dinitBootDir, daemonName)
+	if !pathExists(linkPath) {
+		return nil
+	}
+
+	if !isAlternativeRoot(d.Root) {
+		return runInitCommand("dinitctl", "disable", daemonName)
+	}
+	return unlinkService(linkPath)
+}
+
+func (d DinitInitManager) MaskDaemon(string) error {
+	return ErrMaskUnsupported
+}
+
+func (d DinitInitManager) UnmaskDaemon(string) error {
+	return ErrMaskUnsupported
+}
+
+// ReloadDaemons does nothing, dinit loads service descriptions when the services are started
+func (d DinitInitManager) ReloadDaemons() error {
+	return nil
+}
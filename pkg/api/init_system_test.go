@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createInitRoot(t *testing.T, directories ...string) string {
+	root := t.TempDir()
+	for _, directory := range directories {
+		if err := os.MkdirAll(filepath.Join(root, directory), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// testEnablingInRoot enables and disables sshd in the system which isn't running,
+// the init manager has to change only the link in enabledPath
+func testEnablingInRoot(t *testing.T, initManager InitManager, root string, enabledPath string) {
+	if err := initManager.StartDaemon("sshd"); !errors.Is(err, ErrSystemNotRunning) {
+		t.Fatalf("daemons in the alternative root cannot be started, got: %v", err)
+	}
+	if err := initManager.EnableDaemon("missing"); !errors.Is(err, ErrDaemonDoesNotExist) {
+		t.Fatalf("missing daemon cannot be enabled, got: %v", err)
+	}
+	if err := initManager.EnableDaemon("../sshd"); err == nil {
+		t.Fatal("daemon name cannot contain a path")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := initManager.EnableDaemon("sshd"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(root, enabledPath)); err != nil {
+		t.Fatalf("daemon should be linked to %s: %v", enabledPath, err)
+	}
+	daemon, err := initManager.GetDaemon("sshd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !daemon.IsEnabled || daemon.RunLevel == "" {
+		t.Fatalf("daemon should be enabled, got: %+v", daemon)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := initManager.DisableDaemon("sshd"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if daemon, err := initManager.GetDaemon("sshd"); err != nil || daemon.IsEnabled {
+		t.Fatalf("daemon should be disabled, got: %+v, %v", daemon, err)
+	}
+}
+
+func TestOpenRCInRoot(t *testing.T) {
+	root := createInitRoot(t, "etc/init.d/sshd", "etc/runlevels/default", "etc/runlevels/boot")
+	initManager := OpenRCInitManager{Root: root}
+	testEnablingInRoot(t, initManager, root, "etc/runlevels/default/sshd")
+
+	// Daemon is removed from all the runlevels
+	if err := os.Symlink("/etc/init.d/sshd", filepath.Join(root, "etc/runlevels/boot/sshd")); err != nil {
+		t.Fatal(err)
+	}
+	if err := initManager.DisableDaemon("sshd"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "etc/runlevels/boot/sshd")); !os.IsNotExist(err) {
+		t.Fatal("daemon should be removed from the boot runlevel")
+	}
+}
+
+func TestRunitInRoot(t *testing.T) {
+	root := createInitRoot(t, "etc/runit/sv/sshd", "etc/runit/runsvdir/default")
+	testEnablingInRoot(t, RunitInitManager{Root: root}, root, "etc/runit/runsvdir/default/sshd")
+
+	link, err := os.Readlink(filepath.Join(root, "etc/runit/runsvdir/default/sshd"))
+	if err == nil {
+		t.Fatalf("disabled daemon should be unlinked, got link to %s", link)
+	}
+
+	voidRoot := createInitRoot(t, "etc/sv/sshd", "etc/runit/runsvdir/default")
+	if err := (RunitInitManager{Root: voidRoot}).EnableDaemon("sshd"); err != nil {
+		t.Fatal(err)
+	}
+	link, err = os.Readlink(filepath.Join(voidRoot, "etc/runit/runsvdir/default/sshd"))
+	if err != nil || link != "/etc/sv/sshd" {
+		t.Fatalf("daemon should be linked to its service directory, got %s: %v", link, err)
+	}
+}
+
+func TestDinitInRoot(t *testing.T) {
+	root := createInitRoot(t, "etc/dinit.d/sshd", "etc/dinit.d/boot.d")
+	testEnablingInRoot(t, DinitInitManager{Root: root}, root, "etc/dinit.d/boot.d/sshd")
+}
+
+func TestS6InRoot(t *testing.T) {
+	root := createInitRoot(t, "etc/s6/sv/sshd", "etc/s6/adminsv/default/contents.d")
+	if err := (S6InitManager{Root: root}).EnableDaemon("sshd"); !errors.Is(err, ErrS6InRoot) {
+		t.Fatalf("s6 daemons cannot be enabled in the alternative root, got: %v", err)
+	}
+}
+
+func TestParseDinitState(t *testing.T) {
+	status := "Service: sshd\n    State: STARTED\n    Activation: explicitly started\n    Process ID: 421\n"
+	if isActive, err := parseDinitState(status); err != nil || !isActive {
+		t.Fatalf("service should be started, got %t: %v", isActive, err)
+	}
+	if isActive, err := parseDinitState("Service: sshd\n    State: STOPPED\n"); err != nil || isActive {
+		t.Fatalf("service should be stopped, got %t: %v", isActive, err)
+	}
+	if _, err := parseDinitState("unknown"); !errors.Is(err, ErrUnsupportedInitOutput) {
+		t.Fatalf("unknown output should be reported, got: %v", err)
+	}
+}
+
+func TestInitManagerInRoot(t *testing.T) {
+	root := createInitRoot(t, "usr/bin/dinit", "etc/dinit.d/sshd", "etc/dinit.d/boot.d")
+	if err := (SystemInitManager{Root: root}).EnableDaemon("sshd"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "etc/dinit.d/boot.d/sshd")); err != nil {
+		t.Fatal("init system of the root should be detected and used")
+	}
+
+	if err := (SystemInitManager{Root: t.TempDir()}).EnableDaemon("sshd"); !errors.Is(err, ErrUnsupportedInit) {
+		t.Fatalf("unknown init system should be reported, got: %v", err)
+	}
+}
@@ -1,21 +1,14 @@
 package api
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/avorty/spito/pkg/path"
 	"github.com/avorty/spito/pkg/userinfo"
-	"github.com/go-git/go-git/v5"
 	"github.com/oleiade/reflections"
 	"github.com/schollz/progressbar/v3"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"slices"
 	"strings"
 	"time"
@@ -27,14 +20,10 @@ const (
 	installFromFileOption = "-U"
 	noConfirmOption       = "--noconfirm"
 	removeCommand         = "-Rns"
-	changeUserCommand     = "/usr/bin/sudo"
-	changeUserOption      = "-u"
-	aurAPIRequestURL      = "https://aur.archlinux.org/rpc/v5/info"
-	aurCloneTemplate      = "https://aur.archlinux.org/%s.git"
-	defaultCacheLocation  = "~/.cache"
-	makepkgCommand        = "makepkg"
 	nodeLikeSpinnerType   = 11
 	neededOption          = "--needed"
+	sysrootOption         = "--sysroot"
+	pacmanCacheDirectory  = "/var/cache/pacman/pkg"
 )
 
 type Package struct {
@@ -59,6 +48,15 @@ type Package struct {
 	InstallReason string
 	InstallScript bool
 	ValidatedBy   string
+	// Repository is set only for packages returned by the search
+	Repository string
+}
+
+// ExplicitInstallReason is the install reason of packages which weren't installed only as dependencies
+const ExplicitInstallReason = "Explicitly installed"
+
+func (p Package) IsExplicit() bool {
+	return p.InstallReason == ExplicitInstallReason
 }
 
 func iFErrPrint(err error) {
@@ -67,8 +65,16 @@ func iFErrPrint(err error) {
 	}
 }
 
-func getPackageInfoString(packageName string, packageManager string) (string, error) {
-	cmd := exec.Command(packageManager, "-Qi", packageName)
+// withRoot adds option which makes pacman operate on the system mounted in the root directory
+func withRoot(root string, args ...string) []string {
+	if root == "" || root == "/" {
+		return args
+	}
+	return append([]string{sysrootOption, root}, args...)
+}
+
+func getPackageInfoString(root string, packageName string, packageManager string) (string, error) {
+	cmd := exec.Command(packageManager, withRoot(root, "-Qi", packageName)...)
 	cmd.Env = append(cmd.Environ(), "LANG=C")
 	data, err := cmd.Output()
 	if err != nil {
@@ -91,7 +97,7 @@ func (p *Package) setField(key string, value string) {
 
 		break
 	case "[]string":
-		values := strings.Split(value, " ")
+		values := strings.Fields(value)
 		err := reflections.SetField(p, key, values)
 		iFErrPrint(err)
 
@@ -108,14 +114,36 @@ func (p *Package) setField(key string, value string) {
 }
 
 func GetPackage(name string) (Package, error) {
-	p := Package{}
+	return getPackage("/", name)
+}
 
-	packageInfoString, err := getPackageInfoString(name, packageManager)
+func getPackage(root string, name string) (Package, error) {
+	packageInfoString, err := getPackageInfoString(root, name, packageManager)
 	if err != nil {
 		return Package{}, err
 	}
-	packageInfo := strings.Split(packageInfoString, "\n")
-	packageInfo = packageInfo[:len(packageInfo)-2] // Delete empty elements
+
+	packages := parsePackages(packageInfoString)
+	if len(packages) == 0 {
+		return Package{}, fmt.Errorf("package '%s' was not found", name)
+	}
+	return packages[0], nil
+}
+
+// parsePackages parses output of pacman -Qi, information about every package is followed by an empty line
+func parsePackages(packageInfoString string) []Package {
+	var packages []Package
+	for _, packageInfo := range strings.Split(packageInfoString, "\n\n") {
+		packageInfo = strings.Trim(packageInfo, "\n")
+		if packageInfo != "" {
+			packages = append(packages, parsePackage(strings.Split(packageInfo, "\n")))
+		}
+	}
+	return packages
+}
+
+func parsePackage(packageInfo []string) Package {
+	p := Package{}
 
 	var multiLineValue string
 	var multiLineKey string
@@ -157,172 +185,138 @@ func GetPackage(name string) (Package, error) {
 
 		p.setField(key, value)
 	}
-	return p, nil
+	return p
 }
 
-type AurPackage struct {
-	Name    string
-	Depends []string
-}
-
-type AurResponseLayout struct {
-	Results []AurPackage
-}
-
-func getListOfAURPackages(packages ...string) ([]string, error) {
+// queryPacman returns output of the pacman query. Pacman fails without any message when nothing matches the query,
+// e.g. when no package can be upgraded, empty output is returned then
+func queryPacman(root string, args ...string) (string, error) {
+	cmd := exec.Command(packageManager, withRoot(root, args...)...)
+	cmd.Env = append(cmd.Environ(), "LANG=C")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
 
-	requestValues := url.Values{
-		"arg[]": packages,
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && stderr.Len() == 0 {
+		return "", nil
 	}
-	requestUrl := aurAPIRequestURL + "?" + requestValues.Encode()
-	response, err := http.Get(requestUrl)
 	if err != nil {
-		return []string{}, err
+		return "", fmt.Errorf("%s %s has failed: %w: %s", packageManager, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
 	}
+	return string(output), nil
+}
 
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return []string{}, err
+var packageFilterOptions = map[PackageFilter][]string{
+	AllPackages:        {},
+	ExplicitPackages:   {"--explicit"},
+	DependencyPackages: {"--deps"},
+	OrphanPackages:     {"--deps", "--unrequired"},
+	ForeignPackages:    {"--foreign"},
+}
+
+func listInstalledPackages(root string, filter PackageFilter) ([]Package, error) {
+	filterOptions, isKnown := packageFilterOptions[filter]
+	if !isKnown {
+		return nil, fmt.Errorf("unknown package filter '%s'", filter)
 	}
-	err = response.Body.Close()
+
+	packageInfoString, err := queryPacman(root, append([]string{"-Qi"}, filterOptions...)...)
 	if err != nil {
-		return []string{}, err
+		return nil, err
 	}
+	return parsePackages(packageInfoString), nil
+}
 
-	var jsonBody AurResponseLayout
-	err = json.Unmarshal(body, &jsonBody)
-
+func searchPackages(root string, term string) ([]Package, error) {
+	searchOutput, err := queryPacman(root, "-Ss", term)
 	if err != nil {
-		return []string{}, err
+		return nil, err
 	}
+	return parseSearchResults(searchOutput), nil
+}
 
-	var result []string
-	for _, aurPackage := range jsonBody.Results {
-		result = append(result, aurPackage.Name)
-
-		aurDependencies, err := getListOfAURPackages(aurPackage.Depends...)
-		if err != nil {
-			return []string{}, err
+// parseSearchResults parses output of pacman -Ss, e.g.
+//
+//	extra/vim 9.1.0000-1 (groups) [installed]
+//	    Vi Improved, a highly configurable, improved version of the vi text editor
+func parseSearchResults(searchOutput string) []Package {
+	var packages []Package
+	for _, line := range strings.Split(searchOutput, "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, " ") {
+			if len(packages) > 0 {
+				packages[len(packages)-1].Description = strings.TrimSpace(line)
+			}
+			continue
 		}
-		aurPackage.Depends = slices.DeleteFunc(aurPackage.Depends, func(pkg string) bool {
-			return slices.Index(aurDependencies, pkg) != -1
-		})
-
-		result = append(result, aurDependencies...)
-		err = installRegularPackages(true, aurPackage.Depends...)
 
-		if err != nil {
-			return []string{}, nil
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
 		}
+		repository, name, _ := strings.Cut(fields[0], "/")
+		foundPackage := Package{Name: name, Version: fields[1], Repository: repository}
+		if groupsStart := strings.Index(line, " ("); groupsStart != -1 {
+			if groups, _, isClosed := strings.Cut(line[groupsStart+2:], ")"); isClosed {
+				foundPackage.Groups = strings.Fields(groups)
+			}
+		}
+		packages = append(packages, foundPackage)
 	}
-	return result, nil
+	return packages
 }
 
-func installPackageFromFile(packageName string, workingDirectory string) error {
-	const pacmanPackageFileExtension = ".tar.zst"
-	files, err := os.ReadDir(workingDirectory)
+func getFileOwner(root string, filePath string) (Package, error) {
+	ownerName, err := queryPacman(root, "-Qqo", filePath)
 	if err != nil {
-		return err
+		return Package{}, err
 	}
-
-	packageRegex := fmt.Sprintf("^%s.*%s$", packageName, pacmanPackageFileExtension)
-	packageFileIndex := slices.IndexFunc(files, func(entry os.DirEntry) bool {
-		matches, _ := regexp.Match(packageRegex, []byte(entry.Name()))
-		return matches && !entry.IsDir()
-	})
-
-	if packageFileIndex == -1 {
-		return errors.New("the AUR package wasn't built")
+	ownerName = strings.TrimSpace(ownerName)
+	if ownerName == "" {
+		return Package{}, fmt.Errorf("no package owns %s", filePath)
 	}
-
-	packageFilename := files[packageFileIndex].Name()
-
-	packageManagerCommand :=
-		exec.Command(packageManager, installFromFileOption, noConfirmOption, filepath.Join(workingDirectory, packageFilename))
-
-	userinfo.ChangeToRoot()
-	err = packageManagerCommand.Run()
-	if err != nil {
-		changeUserError := userinfo.ChangeToUser()
-		return errors.Join(err, changeUserError)
-	}
-	err = userinfo.ChangeToUser()
-	return err
+	return getPackage(root, ownerName)
 }
 
-func installAurPackages(packages []string, bar *progressbar.ProgressBar) error {
-	err := userinfo.ChangeToUser()
+// getPackageFiles returns files and directories (ending with "/") installed by the package
+func getPackageFiles(root string, name string) ([]string, error) {
+	fileList, err := queryPacman(root, "-Qlq", name)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return slices.DeleteFunc(strings.Split(fileList, "\n"), func(file string) bool {
+		return file == ""
+	}), nil
+}
 
-	cachePath := filepath.Join(
-		path.GetEnvWithDefaultValue("XDG_CACHE_HOME", defaultCacheLocation),
-		"spito")
-
-	err = path.ExpandTilde(&cachePath)
+// getAvailableUpdates uses the synchronized package databases, they aren't refreshed
+func getAvailableUpdates(root string) ([]Package, error) {
+	updatesOutput, err := queryPacman(root, "-Qu")
 	if err != nil {
-		return err
-	}
-	err = os.MkdirAll(cachePath, path.DirectoryPermissions)
-	if err != nil {
-		return err
+		return nil, err
 	}
+	return parseUpdates(updatesOutput), nil
+}
 
-	for _, pkg := range packages {
-		err = userinfo.ChangeToUser()
-		if err != nil {
-			return err
-		}
-		repoPath := filepath.Join(cachePath, pkg)
-		if doesExist, _ := path.PathExists(repoPath); doesExist {
-			err = os.RemoveAll(repoPath)
-			if err != nil {
-				return err
-			}
-		}
-
-		bar.Describe(fmt.Sprintf("Cloning AUR package %s...", pkg))
-		_, err = git.PlainClone(repoPath, false, &git.CloneOptions{
-			URL: fmt.Sprintf(aurCloneTemplate, pkg),
-		})
-		if err != nil {
-			return err
-		}
-
-		bar.Describe(fmt.Sprintf("Building AUR package %s...", pkg))
-		username, err := userinfo.GetRegularUser()
-		if err != nil {
-			return err
-		}
-		argv := []string{changeUserCommand, changeUserOption, username.Username, makepkgCommand}
-		makePkgCommand, err := os.StartProcess(changeUserCommand, argv, &os.ProcAttr{
-			Dir: repoPath,
-		})
-		if err != nil {
-			return err
-		}
-
-		_, err = makePkgCommand.Wait()
-		if err != nil {
-			return err
-		}
-
-		bar.Describe(fmt.Sprintf("Installing AUR package %s...", pkg))
-		userinfo.ChangeToRoot()
-		err = installPackageFromFile(pkg, repoPath)
-		if err != nil {
-			return err
+// parseUpdates parses output of pacman -Qu, e.g. "vim 9.0.2153-1 -> 9.1.0000-1"
+func parseUpdates(updatesOutput string) []Package {
+	var packages []Package
+	for _, line := range strings.Split(updatesOutput, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[2] != "->" {
+			continue
 		}
+		packages = append(packages, Package{Name: fields[0], Version: fields[3]})
 	}
-	_ = bar.Add(1)
-	err = userinfo.ChangeToUser()
-	return err
+	return packages
 }
 
-func installRegularPackages(neededOnly bool, packages ...string) error {
+func installRegularPackages(root string, neededOnly bool, packages ...string) error {
 
-	argv := []string{installCommand, noConfirmOption}
+	argv := withRoot(root, installCommand, noConfirmOption)
 	if neededOnly {
 		argv = append(argv, neededOption)
 	}
@@ -341,6 +335,10 @@ func installRegularPackages(neededOnly bool, packages ...string) error {
 }
 
 func InstallPackages(packageStrings ...string) error {
+	return installPackages("/", packageStrings...)
+}
+
+func installPackages(root string, packageStrings ...string) (err error) {
 
 	if isRoot, err := userinfo.IsRoot(); !isRoot || err != nil {
 		fmt.Println("[error] Please run this rule as root")
@@ -349,48 +347,36 @@ func InstallPackages(packageStrings ...string) error {
 
 	/* Determine packages to install/update */
 	var packagesToInstall []string //[]*C.char
+	constraints := make(map[string]VersionConstraint)
 	for _, packageString := range packageStrings {
-		packageName, version, _ := strings.Cut(packageString, "@")
-		packageToBeInstalled, err := GetPackage(packageName)
-
-		var expectedVersion string
-		if len(version) > 0 {
-			expectedVersion = version[1:]
-		} else {
-			expectedVersion = ""
+		packageName, constraint, err := ParsePackageString(packageString)
+		if err != nil {
+			return err
 		}
+		constraints[packageName] = constraint
 
-		doesPackageNeedToBeUpgraded := err == nil && packageToBeInstalled.Version < expectedVersion
+		installedPackage, err := getPackage(root, packageName)
 		isPackageNotInstalled := err != nil
+		doesPackageNeedToBeUpgraded := err == nil && !constraint.IsSatisfiedBy(PacmanVersions, installedPackage.Version)
 
-		if version == "" || version == "*" || isPackageNotInstalled || doesPackageNeedToBeUpgraded {
+		if constraint.Operator == VersionAny || isPackageNotInstalled || doesPackageNeedToBeUpgraded {
 			packagesToInstall = append(packagesToInstall, packageName /*C.CString(packageName)*/)
 		}
 	}
+	defer func() {
+		if err == nil {
+			err = checkInstalledVersions(root, constraints)
+		}
+	}()
 
-	/* Get list of AUR packages */
-	aurPackagesToInstall, err := getListOfAURPackages(packagesToInstall...)
-	if err != nil {
-		return err
-	}
-
-	/* Exclude AUR packages from the packagesToInstall slice */
-	packagesToInstall = slices.DeleteFunc(packagesToInstall, func(pkg string) bool {
-		return slices.Index(aurPackagesToInstall, pkg) != -1
-	})
-
+	/* Packages which aren't in the repositories are installed from the AUR */
+	packagesToInstall, aurPackagesToInstall := AurBackend.SplitPackages(root, packagesToInstall)
 	if len(aurPackagesToInstall) > 0 {
-		aurBar := progressbar.NewOptions(len(aurPackagesToInstall),
-			progressbar.OptionSetDescription("Installing AUR packages..."),
-			progressbar.OptionSetPredictTime(false),
-			progressbar.OptionSetElapsedTime(false),
-			progressbar.OptionShowCount(),
-		)
-		err = installAurPackages(aurPackagesToInstall, aurBar)
-		if err != nil {
-			return err
+		userinfo.ChangeToRoot()
+		err = AurBackend.Install(root, aurPackagesToInstall...)
+		if changeUserError := userinfo.ChangeToUser(); err != nil || changeUserError != nil {
+			return errors.Join(err, changeUserError)
 		}
-		fmt.Println()
 	}
 
 	if len(packagesToInstall) == 0 {
@@ -415,20 +401,124 @@ func InstallPackages(packageStrings ...string) error {
 			}
 		}
 	}()
-	err = installRegularPackages(false, packagesToInstall...)
+	err = installRegularPackages(root, false, packagesToInstall...)
 	finishInstallChan <- true
 	fmt.Println()
 
 	return err
 }
 
+// checkInstalledVersions returns an error if the package manager has installed a version which doesn't satisfy
+// the constraint, e.g. the repository doesn't contain new enough version yet
+func checkInstalledVersions(root string, constraints map[string]VersionConstraint) error {
+	for packageName, constraint := range constraints {
+		installedPackage, err := getPackage(root, packageName)
+		if err != nil {
+			return fmt.Errorf("package %s hasn't been installed: %w", packageName, err)
+		}
+		if !constraint.IsSatisfiedBy(PacmanVersions, installedPackage.Version) {
+			return fmt.Errorf("installed version %s of the package %s doesn't satisfy '%s'",
+				installedPackage.Version, packageName, constraint)
+		}
+	}
+	return nil
+}
+
 func RemovePackages(packagesToRemove ...string) error {
+	return removePackages("/", packagesToRemove...)
+}
+
+func removePackages(root string, packagesToRemove ...string) error {
 	if isRoot, err := userinfo.IsRoot(); !isRoot || err != nil {
 		fmt.Println("[error] Please run this rule as root")
 		os.Exit(1)
 	}
 
-	pacmanCommand := exec.Command(packageManager, removeCommand, noConfirmOption, strings.Join(packagesToRemove, " "))
+	pacmanCommand := exec.Command(packageManager, removeArguments(root, packagesToRemove...)...)
 	err := pacmanCommand.Run()
 	return err
 }
+
+// removeArguments returns the pacman arguments which remove all the packages in one transaction
+func removeArguments(root string, packagesToRemove ...string) []string {
+	return append(withRoot(root, removeCommand, noConfirmOption), packagesToRemove...)
+}
+
+// PacmanPackageManager manages packages using pacman and AUR.
+// If Root is set, it manages the system mounted there instead of this machine
+type PacmanPackageManager struct {
+	Root string
+}
+
+func (p PacmanPackageManager) GetPackage(name string) (Package, error) {
+	return getPackage(p.Root, name)
+}
+
+func (p PacmanPackageManager) ListInstalledPackages(filter PackageFilter) ([]Package, error) {
+	return listInstalledPackages(p.Root, filter)
+}
+
+func (p PacmanPackageManager) SearchPackages(term string) ([]Package, error) {
+	return searchPackages(p.Root, term)
+}
+
+func (p PacmanPackageManager) GetFileOwner(filePath string) (Package, error) {
+	return getFileOwner(p.Root, filePath)
+}
+
+func (p PacmanPackageManager) GetPackageFiles(name string) ([]string, error) {
+	return getPackageFiles(p.Root, name)
+}
+
+func (p PacmanPackageManager) GetAvailableUpdates() ([]Package, error) {
+	return getAvailableUpdates(p.Root)
+}
+
+func (p PacmanPackageManager) InstallPackages(packages ...string) error {
+	return installPackages(p.Root, packages...)
+}
+
+func (p PacmanPackageManager) RemovePackages(packages ...string) error {
+	return removePackages(p.Root, packages...)
+}
+
+func (p PacmanPackageManager) InstallPackageVersion(name string, version string) error {
+	return installPackageVersion(p.Root, name, version)
+}
+
+func (p PacmanPackageManager) HasKey(fingerprint string) (bool, error) {
+	return hasKey(p.Root, fingerprint)
+}
+
+func (p PacmanPackageManager) ImportKey(fingerprint string, source string) error {
+	return importKey(p.Root, fingerprint, source)
+}
+
+func (p PacmanPackageManager) RemoveKey(fingerprint string) error {
+	return removeKey(p.Root, fingerprint)
+}
+
+func (p PacmanPackageManager) SyncDatabases(repositories ...string) error {
+	return syncDatabases(p.Root, repositories...)
+}
+
+// installPackageVersion installs the package file of the given version from the pacman cache
+func installPackageVersion(root string, name string, version string) error {
+	if root == "" {
+		root = "/"
+	}
+
+	packageFiles, err := filepath.Glob(filepath.Join(root, pacmanCacheDirectory, fmt.Sprintf("%s-%s-*.pkg.tar.*", name, version)))
+	if err != nil {
+		return err
+	}
+	packageFiles = slices.DeleteFunc(packageFiles, func(packageFile string) bool {
+		return strings.HasSuffix(packageFile, ".sig")
+	})
+	if len(packageFiles) == 0 {
+		return fmt.Errorf("package %s %s is not in the pacman cache, cannot install it", name, version)
+	}
+
+	packageManagerCommand := exec.Command(packageManager, withRoot(root, installFromFileOption, noConfirmOption, packageFiles[0])...)
+	return packageManagerCommand.Run()
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"slices"
 	"testing"
 )
 
@@ -35,6 +36,72 @@ func testPackageInfo(packageName string, t *testing.T) {
 	}
 }
 
+func TestParsePackages(t *testing.T) {
+	packageInfoString := `Name            : vim
+Version         : 9.1.0000-1
+Description     : Vi Improved, a highly configurable, improved version of the vi text editor
+Depends On      : vim-runtime=9.1.0000-1  gpm  acl  glibc  libgcrypt  zlib
+Optional Deps   : python: Python language support
+                  ruby: Ruby language support
+Install Reason  : Explicitly installed
+
+Name            : xxd
+Version         : 9.1.0000-1
+Description     : Hexdump utility from vim
+Install Reason  : Installed as a dependency for another package
+
+`
+	packages := parsePackages(packageInfoString)
+	if len(packages) != 2 {
+		t.Fatalf("expected 2 packages, got %+v", packages)
+	}
+	if packages[0].Name != "vim" || !packages[0].IsExplicit() || len(packages[0].DependsOn) != 6 {
+		t.Errorf("vim wasn't parsed correctly: %+v", packages[0])
+	}
+	if packages[1].Name != "xxd" || packages[1].IsExplicit() {
+		t.Errorf("xxd wasn't parsed correctly: %+v", packages[1])
+	}
+}
+
+func TestParseSearchResults(t *testing.T) {
+	searchOutput := `extra/vim 9.1.0000-1 [installed: 9.0.2153-1]
+    Vi Improved, a highly configurable, improved version of the vi text editor
+extra/gvim 9.1.0000-1 (editors gui)
+    Vi Improved, a highly configurable, improved version of the vi text editor (with advanced features, such as a GUI)
+`
+	packages := parseSearchResults(searchOutput)
+	if len(packages) != 2 {
+		t.Fatalf("expected 2 packages, got %+v", packages)
+	}
+	if packages[0].Name != "vim" || packages[0].Repository != "extra" || packages[0].Version != "9.1.0000-1" ||
+		packages[0].Description != "Vi Improved, a highly configurable, improved version of the vi text editor" {
+		t.Errorf("vim wasn't parsed correctly: %+v", packages[0])
+	}
+	if packages[1].Name != "gvim" || !slices.Equal(packages[1].Groups, []string{"editors", "gui"}) {
+		t.Errorf("gvim wasn't parsed correctly: %+v", packages[1])
+	}
+}
+
+func TestParseUpdates(t *testing.T) {
+	packages := parseUpdates("vim 9.0.2153-1 -> 9.1.0000-1\nlinux 6.6.10.arch1-1 -> 6.7.arch1-1 [ignored]\n")
+	if len(packages) != 2 || packages[0].Name != "vim" || packages[0].Version != "9.1.0000-1" ||
+		packages[1].Name != "linux" || packages[1].Version != "6.7.arch1-1" {
+		t.Errorf("updates weren't parsed correctly: %+v", packages)
+	}
+}
+
+func TestRemoveArguments(t *testing.T) {
+	arguments := removeArguments("/", "vim", "xxd")
+	if !slices.Equal(arguments, []string{removeCommand, noConfirmOption, "vim", "xxd"}) {
+		t.Errorf("each package should be a separate argument, got %q", arguments)
+	}
+
+	arguments = removeArguments("/mnt", "vim", "xxd")
+	if !slices.Equal(arguments, []string{sysrootOption, "/mnt", removeCommand, noConfirmOption, "vim", "xxd"}) {
+		t.Errorf("packages should be removed from the root, got %q", arguments)
+	}
+}
+
 /*
 func TestInstallPackages(t *testing.T) {
 	err := InstallPackages("opentimer", "vim")
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Platform describes the system to which rules are applied, it's used by targeting decorators
+type Platform struct {
+	// Distro is the ID from os-release, e.g. arch, DistroLike contains distros it's based on
+	Distro     string
+	DistroLike []string
+	InitSystem InitSystem
+	// Arch uses names reported by uname, e.g. x86_64
+	Arch string
+}
+
+// PlatformBackend returns platform of the system, UseRoot replaces it when rules are applied to other root
+var PlatformBackend = func() (Platform, error) {
+	return GetPlatform("/")
+}
+
+var goArchToUname = map[string]string{
+	"amd64":   "x86_64",
+	"386":     "i686",
+	"arm64":   "aarch64",
+	"arm":     "armv7h",
+	"riscv64": "riscv64",
+}
+
+// GetPlatform detects platform of the system mounted in the given root
+func GetPlatform(root string) (Platform, error) {
+	platform := Platform{Arch: runtime.GOARCH}
+	if arch, ok := goArchToUname[runtime.GOARCH]; ok {
+		platform.Arch = arch
+	}
+
+	osRelease, err := readOsRelease(root)
+	if err != nil {
+		return Platform{}, err
+	}
+	platform.Distro = osRelease["ID"]
+	platform.DistroLike = strings.Fields(osRelease["ID_LIKE"])
+
+	if isAlternativeRoot(root) {
+		platform.InitSystem = getInitSystemInRoot(root)
+		return platform, nil
+	}
+
+	platform.InitSystem, err = GetInitSystem()
+	return platform, err
+}
+
+// readOsRelease parses os-release file, it returns empty map if the file doesn't exist
+func readOsRelease(root string) (map[string]string, error) {
+	osRelease := make(map[string]string)
+
+	file, err := os.Open(filepath.Join(root, "etc/os-release"))
+	if os.IsNotExist(err) {
+		file, err = os.Open(filepath.Join(root, "usr/lib/os-release"))
+	}
+	if os.IsNotExist(err) {
+		return osRelease, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found || strings.HasPrefix(key, "#") {
+			continue
+		}
+		osRelease[key] = strings.Trim(value, `"'`)
+	}
+
+	return osRelease, scanner.Err()
+}
+
+// getInitSystemInRoot guesses init system of the system which isn't running, based on installed binaries
+func getInitSystemInRoot(root string) InitSystem {
+	initBinaries := []struct {
+		initSystem InitSystem
+		paths      []string
+	}{
+		{SYSTEMD, []string{"usr/lib/systemd/systemd", "lib/systemd/systemd"}},
+		{S6, []string{"usr/bin/s6-rc", "bin/s6-rc"}},
+		{DINIT, []string{"usr/bin/dinit", "sbin/dinit"}},
+		{OPENRC, []string{"sbin/openrc", "usr/bin/openrc", "sbin/openrc-run", "usr/bin/openrc-run"}},
+		{RUNIT, []string{"sbin/runit", "usr/bin/runit"}},
+	}
+
+	for _, initBinary := range initBinaries {
+		for _, binaryPath := range initBinary.paths {
+			if _, err := os.Stat(filepath.Join(root, binaryPath)); err == nil {
+				return initBinary.initSystem
+			}
+		}
+	}
+	return UNKNOWN
+}
@@ -0,0 +1,385 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// PacmanConfigPath is the configuration of the package manager, repositories are changed in it through the VRCT
+const PacmanConfigPath = "/etc/pacman.conf"
+
+const (
+	pacmanKeyCommand = "pacman-key"
+	pacmanKeyringDir = "/etc/pacman.d/gnupg"
+	optionsSection   = "options"
+)
+
+var (
+	repositoryNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	sectionRegex        = regexp.MustCompile(`^\s*\[([^]]*)]\s*$`)
+	// Keys are identified by their fingerprint (40 hexadecimal characters) or long id (16 hexadecimal characters)
+	keyFingerprintRegex = regexp.MustCompile(`^([0-9A-F]{16}|[0-9A-F]{40})$`)
+)
+
+// Repository is a package repository in the configuration of the package manager
+type Repository struct {
+	Name    string
+	Servers []string
+	// Include is a file with the list of servers, e.g. a mirrorlist
+	Include  string
+	SigLevel string
+	Usage    string
+}
+
+// Validate checks that the repository can be written to the configuration without changing anything else
+func (r Repository) Validate() error {
+	if !repositoryNameRegex.MatchString(r.Name) || r.Name == optionsSection {
+		return fmt.Errorf("invalid repository name '%s'", r.Name)
+	}
+	if len(r.Servers) == 0 && r.Include == "" {
+		return fmt.Errorf("repository %s needs a server or a file with servers", r.Name)
+	}
+
+	for _, value := range append([]string{r.Include, r.SigLevel, r.Usage}, r.Servers...) {
+		if strings.ContainsAny(value, "\n\r") {
+			return fmt.Errorf("configuration of the repository %s cannot contain multiple lines", r.Name)
+		}
+	}
+	for _, server := range r.Servers {
+		if !strings.Contains(server, "://") {
+			return fmt.Errorf("server '%s' of the repository %s is not an url", server, r.Name)
+		}
+	}
+	if r.Include != "" && !filepath.IsAbs(r.Include) {
+		return fmt.Errorf("included file '%s' of the repository %s has to be an absolute path", r.Include, r.Name)
+	}
+	return nil
+}
+
+// String returns the section of pacman.conf describing the repository
+func (r Repository) String() string {
+	lines := []string{"[" + r.Name + "]"}
+	if r.SigLevel != "" {
+		lines = append(lines, "SigLevel = "+r.SigLevel)
+	}
+	if r.Usage != "" {
+		lines = append(lines, "Usage = "+r.Usage)
+	}
+	for _, server := range r.Servers {
+		lines = append(lines, "Server = "+server)
+	}
+	if r.Include != "" {
+		lines = append(lines, "Include = "+r.Include)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// ParseRepository parses the section of pacman.conf returned by Repository.String
+func ParseRepository(section string) (Repository, error) {
+	lines := strings.Split(strings.TrimSpace(section), "\n")
+	sectionName := sectionRegex.FindStringSubmatch(lines[0])
+	if sectionName == nil {
+		return Repository{}, fmt.Errorf("invalid repository section '%s'", lines[0])
+	}
+
+	repository, err := parseRepositoryLines(sectionName[1], lines[1:], true)
+	if err != nil {
+		return Repository{}, err
+	}
+	return repository, repository.Validate()
+}
+
+// parseRepositoryLines reads options of the repository, other options are rejected only if isStrict is true
+func parseRepositoryLines(name string, lines []string, isStrict bool) (Repository, error) {
+	repository := Repository{Name: name}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, "=")
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "Server":
+			repository.Servers = append(repository.Servers, value)
+		case "Include":
+			repository.Include = value
+		case "SigLevel":
+			repository.SigLevel = value
+		case "Usage":
+			repository.Usage = value
+		default:
+			if isStrict {
+				return Repository{}, fmt.Errorf("unknown option '%s' of the repository %s", line, name)
+			}
+		}
+	}
+	return repository, nil
+}
+
+type configSection struct {
+	name  string
+	lines []string
+}
+
+// splitPacmanConfig splits pacman.conf into sections, the first one contains lines before any section
+func splitPacmanConfig(config []byte) []configSection {
+	sections := []configSection{{}}
+	if len(config) == 0 {
+		return sections
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(config), "\n"), "\n") {
+		if sectionName := sectionRegex.FindStringSubmatch(line); sectionName != nil {
+			sections = append(sections, configSection{name: sectionName[1]})
+		}
+		sections[len(sections)-1].lines = append(sections[len(sections)-1].lines, line)
+	}
+	return sections
+}
+
+func joinPacmanConfig(sections []configSection) []byte {
+	var lines []string
+	for _, section := range sections {
+		lines = append(lines, section.lines...)
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// ParseConfigRepositories returns repositories configured in the content of pacman.conf
+func ParseConfigRepositories(config []byte) ([]Repository, error) {
+	var repositories []Repository
+	for _, section := range splitPacmanConfig(config)[1:] {
+		if section.name == optionsSection {
+			continue
+		}
+		repository, err := parseRepositoryLines(section.name, section.lines[1:], false)
+		if err != nil {
+			return nil, err
+		}
+		repositories = append(repositories, repository)
+	}
+	return repositories, nil
+}
+
+// AddConfigRepository returns the content of pacman.conf with the repository added at its end.
+// The repository with the same name is replaced in place, so its priority doesn't change
+func AddConfigRepository(config []byte, repository Repository) ([]byte, error) {
+	if err := repository.Validate(); err != nil {
+		return nil, err
+	}
+
+	sections := splitPacmanConfig(config)
+	repositoryLines := strings.Split(strings.TrimSuffix(repository.String(), "\n"), "\n")
+	sectionIndex := findSection(sections, repository.Name)
+	if sectionIndex == -1 {
+		lastSection := &sections[len(sections)-1]
+		if len(lastSection.lines) > 0 && strings.TrimSpace(lastSection.lines[len(lastSection.lines)-1]) != "" {
+			lastSection.lines = append(lastSection.lines, "")
+		}
+		sections = append(sections, configSection{name: repository.Name, lines: repositoryLines})
+	} else {
+		_, trailingLines := splitTrailingComments(sections[sectionIndex].lines)
+		sections[sectionIndex].lines = append(repositoryLines, trailingLines...)
+	}
+	return joinPacmanConfig(sections), nil
+}
+
+// RemoveConfigRepository returns the content of pacman.conf without the repository,
+// the content is returned unchanged if the repository isn't configured
+func RemoveConfigRepository(config []byte, name string) ([]byte, error) {
+	if name == optionsSection {
+		return nil, fmt.Errorf("invalid repository name '%s'", name)
+	}
+	sections := splitPacmanConfig(config)
+	sectionIndex := findSection(sections, name)
+	if sectionIndex == -1 {
+		return config, nil
+	}
+
+	// Comments following the repository usually belong to the next one, e.g. commented out testing repositories
+	_, trailingLines := splitTrailingComments(sections[sectionIndex].lines)
+	previousSection := &sections[sectionIndex-1]
+	previousSection.lines = append(previousSection.lines, trailingLines...)
+	if sectionIndex == len(sections)-1 {
+		// The empty line separating the repository from the previous one isn't needed anymore
+		previousSection.lines, _ = splitTrailingEmptyLines(previousSection.lines)
+	}
+	return joinPacmanConfig(slices.Delete(sections, sectionIndex, sectionIndex+1)), nil
+}
+
+// selectConfigRepositories returns the content of pacman.conf with its options and only the given repositories
+func selectConfigRepositories(config []byte, names ...string) ([]byte, error) {
+	sections := splitPacmanConfig(config)
+	for _, name := range names {
+		if name == optionsSection || findSection(sections, name) == -1 {
+			return nil, fmt.Errorf("repository %s isn't configured", name)
+		}
+	}
+
+	selectedSections := sections[:1]
+	for _, section := range sections[1:] {
+		if section.name == optionsSection || slices.Contains(names, section.name) {
+			selectedSections = append(selectedSections, section)
+		}
+	}
+	return joinPacmanConfig(selectedSections), nil
+}
+
+func findSection(sections []configSection, name string) int {
+	return slices.IndexFunc(sections, func(section configSection) bool {
+		return section.name == name
+	})
+}
+
+func splitTrailingEmptyLines(lines []string) ([]string, []string) {
+	end := len(lines)
+	for end > 1 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return lines[:end], lines[end:]
+}
+
+// splitTrailingComments returns lines of the section and comments or empty lines following them
+func splitTrailingComments(lines []string) ([]string, []string) {
+	end := len(lines)
+	for end > 1 {
+		line := strings.TrimSpace(lines[end-1])
+		if line != "" && !strings.HasPrefix(line, "#") {
+			break
+		}
+		end--
+	}
+	return lines[:end], lines[end:]
+}
+
+// NormalizeKeyFingerprint removes spaces from the fingerprint and returns an error if it isn't valid
+func NormalizeKeyFingerprint(fingerprint string) (string, error) {
+	normalized := strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+	if !keyFingerprintRegex.MatchString(normalized) {
+		return "", fmt.Errorf("invalid key fingerprint '%s'", fingerprint)
+	}
+	return normalized, nil
+}
+
+func runPacmanKey(root string, args ...string) error {
+	if root != "" && root != "/" {
+		args = append([]string{
+			"--gpgdir", filepath.Join(root, pacmanKeyringDir),
+			"--config", filepath.Join(root, PacmanConfigPath),
+		}, args...)
+	}
+	return runPackageManager(exec.Command(pacmanKeyCommand, args...))
+}
+
+func hasKey(root string, fingerprint string) (bool, error) {
+	fingerprint, err := NormalizeKeyFingerprint(fingerprint)
+	if err != nil {
+		return false, err
+	}
+	return runPacmanKey(root, "--list-keys", fingerprint) == nil, nil
+}
+
+// importKey adds the key to the keyring of the package manager and signs it locally, so packages signed by it are trusted.
+// The source is a keyserver (hkp:// or hkps://), an url or a path of the key file, the default keyserver is used if it's empty
+func importKey(root string, fingerprint string, source string) error {
+	fingerprint, err := NormalizeKeyFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case source == "":
+		err = runPacmanKey(root, "--recv-keys", fingerprint)
+	case strings.HasPrefix(source, "hkp://") || strings.HasPrefix(source, "hkps://"):
+		err = runPacmanKey(root, "--keyserver", source, "--recv-keys", fingerprint)
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		err = importKeyFromUrl(root, source)
+	case filepath.IsAbs(source):
+		err = runPacmanKey(root, "--add", source)
+	default:
+		return fmt.Errorf("source '%s' of the key %s has to be a keyserver, an url or an absolute path", source, fingerprint)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot import the key %s: %w", fingerprint, err)
+	}
+
+	// The key file could contain other keys, only the requested one is trusted
+	if isImported, _ := hasKey(root, fingerprint); !isImported {
+		return fmt.Errorf("key %s was not found in %s", fingerprint, source)
+	}
+	if err := runPacmanKey(root, "--lsign-key", fingerprint); err != nil {
+		return fmt.Errorf("cannot sign the key %s: %w", fingerprint, err)
+	}
+	return nil
+}
+
+func importKeyFromUrl(root string, keyUrl string) error {
+	response, err := http.Get(keyUrl)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot download %s: %s", keyUrl, response.Status)
+	}
+
+	keyFile, err := os.CreateTemp("", "spito-key-*.asc")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyFile.Name())
+
+	_, err = io.Copy(keyFile, response.Body)
+	if closeErr := keyFile.Close(); err != nil || closeErr != nil {
+		return errors.Join(err, closeErr)
+	}
+	return runPacmanKey(root, "--add", keyFile.Name())
+}
+
+func removeKey(root string, fingerprint string) error {
+	fingerprint, err := NormalizeKeyFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+	return runPacmanKey(root, "--delete", fingerprint)
+}
+
+// syncDatabases downloads package databases of the given repositories, e.g. after they have been added.
+// Databases of the other repositories aren't refreshed, because installing packages from them without upgrading
+// the whole system would leave it partially upgraded, so pacman reads a configuration with only these repositories
+func syncDatabases(root string, repositories ...string) error {
+	if len(repositories) == 0 {
+		return nil
+	}
+	config, err := os.ReadFile(filepath.Join(root, PacmanConfigPath))
+	if err != nil {
+		return err
+	}
+	config, err = selectConfigRepositories(config, repositories...)
+	if err != nil {
+		return err
+	}
+
+	// pacman reads the configuration after it changes its root, so the file is created inside the root
+	configFile, err := os.CreateTemp(filepath.Join(root, os.TempDir()), "spito-pacman-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(configFile.Name())
+	_, err = configFile.Write(config)
+	if closeErr := configFile.Close(); err != nil || closeErr != nil {
+		return errors.Join(err, closeErr)
+	}
+
+	configPath := filepath.Join(os.TempDir(), filepath.Base(configFile.Name()))
+	return runPackageManager(exec.Command(packageManager, withRoot(root, "-Sy", "--config", configPath)...))
+}
@@ -0,0 +1,135 @@
+package api
+
+import "testing"
+
+const testPacmanConfig = `# General options
+[options]
+HoldPkg = pacman glibc
+Architecture = auto
+
+[core]
+Include = /etc/pacman.d/mirrorlist
+
+[extra]
+Include = /etc/pacman.d/mirrorlist
+
+# An example of a custom package repository
+#[custom]
+#Server = file:///home/custompkgs
+`
+
+func TestRepositories(t *testing.T) {
+	config := []byte(testPacmanConfig)
+
+	repositories, err := ParseConfigRepositories(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repositories) != 2 || repositories[0].Name != "core" || repositories[1].Include != "/etc/pacman.d/mirrorlist" {
+		t.Fatalf("unexpected repositories: %+v", repositories)
+	}
+
+	chaoticAur := Repository{Name: "chaotic-aur", SigLevel: "Required DatabaseOptional", Include: "/etc/pacman.d/chaotic-mirrorlist"}
+	changedConfig, err := AddConfigRepository(config, chaoticAur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedConfig := testPacmanConfig + "\n[chaotic-aur]\nSigLevel = Required DatabaseOptional\nInclude = /etc/pacman.d/chaotic-mirrorlist\n"
+	if string(changedConfig) != expectedConfig {
+		t.Fatalf("repository should be added at the end, got:\n%s", changedConfig)
+	}
+
+	changedConfig, err = RemoveConfigRepository(changedConfig, "chaotic-aur")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(changedConfig) != testPacmanConfig {
+		t.Fatalf("removing added repository should restore the configuration, got:\n%s", changedConfig)
+	}
+
+	changedConfig, err = AddConfigRepository(config, Repository{Name: "core", Servers: []string{"https://mirror.example.com/$repo/os/$arch"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changedRepositories, err := ParseConfigRepositories(changedConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changedRepositories) != 2 || changedRepositories[0].Name != "core" || len(changedRepositories[0].Servers) != 1 {
+		t.Fatalf("replaced repository should keep its priority, got: %+v", changedRepositories)
+	}
+
+	extra := repositories[1]
+	changedConfig, err = AddConfigRepository(config, Repository{Name: "extra", Servers: []string{"https://mirror.example.com/$repo/os/$arch"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changedConfig, err = AddConfigRepository(changedConfig, extra); err != nil {
+		t.Fatal(err)
+	}
+	if string(changedConfig) != testPacmanConfig {
+		t.Fatalf("replaced repository should keep following comments, got:\n%s", changedConfig)
+	}
+
+	if changedConfig, err = AddConfigRepository(nil, chaoticAur); err != nil || string(changedConfig) != chaoticAur.String() {
+		t.Fatalf("repository should be added to the empty configuration, got:\n%s", changedConfig)
+	}
+
+	if _, err := RemoveConfigRepository(config, "options"); err == nil {
+		t.Fatal("options aren't a repository")
+	}
+	if changedConfig, err = RemoveConfigRepository(config, "custom"); err != nil || string(changedConfig) != testPacmanConfig {
+		t.Fatalf("commented out repository isn't configured, got:\n%s", changedConfig)
+	}
+}
+
+func TestSelectConfigRepositories(t *testing.T) {
+	config, err := selectConfigRepositories([]byte(testPacmanConfig), "extra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedConfig := "# General options\n[options]\nHoldPkg = pacman glibc\nArchitecture = auto\n\n" +
+		"[extra]\nInclude = /etc/pacman.d/mirrorlist\n\n# An example of a custom package repository\n#[custom]\n#Server = file:///home/custompkgs\n"
+	if string(config) != expectedConfig {
+		t.Fatalf("only options and the selected repository should be kept, got:\n%s", config)
+	}
+
+	if _, err := selectConfigRepositories([]byte(testPacmanConfig), "custom"); err == nil {
+		t.Fatal("repository which isn't configured cannot be selected")
+	}
+}
+
+func TestParseRepository(t *testing.T) {
+	repository := Repository{Name: "custom", SigLevel: "Optional TrustAll", Servers: []string{"file:///home/custompkgs", "https://example.com/$arch"}}
+	parsedRepository, err := ParseRepository(repository.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsedRepository.String() != repository.String() {
+		t.Fatalf("expected %+v, got %+v", repository, parsedRepository)
+	}
+
+	invalidSections := []string{
+		"custom\nServer = https://example.com",
+		"[options]\nInclude = /etc/pacman.d/mirrorlist",
+		"[custom]\nSigLevel = Never",
+		"[custom]\nServer = example.com",
+		"[custom]\nInclude = mirrorlist",
+		"[custom]\nServer = https://example.com\nCacheDir = /tmp",
+	}
+	for _, section := range invalidSections {
+		if _, err := ParseRepository(section); err == nil {
+			t.Errorf("section should be invalid:\n%s", section)
+		}
+	}
+}
+
+func TestNormalizeKeyFingerprint(t *testing.T) {
+	fingerprint, err := NormalizeKeyFingerprint("3056 5138 87b7 8aeb")
+	if err != nil || fingerprint != "3056513887B78AEB" {
+		t.Fatalf("unexpected fingerprint %s: %v", fingerprint, err)
+	}
+	if _, err := NormalizeKeyFingerprint("3056513887B78AE"); err == nil {
+		t.Fatal("fingerprint too short should be invalid")
+	}
+}
@@ -21,13 +21,7 @@ type Distro struct {
 type InitSystem string
 
 func (is InitSystem) String() string {
-	switch is {
-	case SYSTEMD:
-		return "systemd"
-	case RUNIT:
-		return "runit"
-	}
-	return ""
+	return string(is)
 }
 
 func isOpenRC() bool {
@@ -48,8 +42,10 @@ const (
 	SYSTEMD InitSystem = "systemd"
 	RUNIT   InitSystem = "runit"
 	OPENRC  InitSystem = "openrc"
+	S6      InitSystem = "s6"
+	DINIT   InitSystem = "dinit"
 	/*
-		lack of sysv, s6 and 66
+		lack of sysv and 66
 	*/
 	UNKNOWN InitSystem = ""
 )
@@ -84,6 +80,13 @@ func GetInitSystem() (InitSystem, error) {
 	if strings.Contains(processName, RUNIT.String()) {
 		return RUNIT, nil
 	}
+	// s6-linux-init replaces itself with the supervision tree
+	if strings.Contains(processName, "s6-svscan") {
+		return S6, nil
+	}
+	if strings.Contains(processName, DINIT.String()) {
+		return DINIT, nil
+	}
 	if strings.Contains(processName, "init") && isOpenRC() {
 		return OPENRC, nil
 	}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -19,3 +21,43 @@ func TestGetInitSystem(t *testing.T) {
 		t.Fatalf("ERROR! Couldn't detect your init system!")
 	}
 }
+
+func TestGetPlatformInRoot(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"etc/os-release":          "NAME=\"Manjaro Linux\"\nID=manjaro\nID_LIKE=\"arch\"\n",
+		"usr/lib/systemd/systemd": "",
+	}
+	for name, content := range files {
+		filePath := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	platform, err := GetPlatform(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if platform.Distro != "manjaro" || len(platform.DistroLike) != 1 || platform.DistroLike[0] != "arch" {
+		t.Fatalf("unexpected distro: %+v", platform)
+	}
+	if platform.InitSystem != SYSTEMD {
+		t.Fatalf("expected systemd, got '%s'", platform.InitSystem)
+	}
+
+	previousPlatform, previousPackageManager := PlatformBackend, PackageManagerBackend
+	previousInitManager, previousUserInitManager := InitManagerBackend, UserInitManagerBackend
+	defer func() {
+		PlatformBackend, PackageManagerBackend = previousPlatform, previousPackageManager
+		InitManagerBackend, UserInitManagerBackend = previousInitManager, previousUserInitManager
+	}()
+
+	UseRoot(root)
+	if platform, err := PlatformBackend(); err != nil || platform.Distro != "manjaro" {
+		t.Fatalf("UseRoot didn't switch the platform to the root, got: %+v, %v", platform, err)
+	}
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/avorty/spito/pkg/userinfo"
+)
+
+var (
+	ErrUserDaemonsInRoot      = errors.New("user daemons can only be changed on the running system")
+	ErrUserDaemonsUnsupported = errors.New("user daemons are supported only by systemd")
+)
+
+// UserCommandHelper is the hidden command of spito, which starts commands of the regular user
+// when spito is run with sudo, see RunUserCommand
+const UserCommandHelper = "run-as-user"
+
+// DaemonScope selects the service manager controlling the daemon
+type DaemonScope string
+
+const (
+	SystemScope DaemonScope = "system"
+	// UserScope daemons are services of the user who runs spito, even if spito is run with sudo
+	UserScope DaemonScope = "user"
+)
+
+// ParseDaemonScope returns the scope of the daemon, the system scope is used if it's empty
+func ParseDaemonScope(scope string) (DaemonScope, error) {
+	switch DaemonScope(scope) {
+	case "", SystemScope:
+		return SystemScope, nil
+	case UserScope:
+		return UserScope, nil
+	}
+	return SystemScope, fmt.Errorf("unknown daemon scope '%s', use system or user", scope)
+}
+
+// parseScopeArgument returns the scope given as the optional last argument of the daemon functions
+func parseScopeArgument(scopeArgs []string) (DaemonScope, error) {
+	switch len(scopeArgs) {
+	case 0:
+		return SystemScope, nil
+	case 1:
+		return ParseDaemonScope(scopeArgs[0])
+	}
+	return SystemScope, fmt.Errorf("expected a single daemon scope, got: %s", strings.Join(scopeArgs, ", "))
+}
+
+// SystemdUserInitManager controls services of the regular user's service manager (systemctl --user)
+type SystemdUserInitManager struct {
+	Root string
+}
+
+func (s SystemdUserInitManager) checkInitSystem() error {
+	if isAlternativeRoot(s.Root) {
+		return ErrUserDaemonsInRoot
+	}
+	initSystem, err := GetInitSystem()
+	if err != nil {
+		return err
+	}
+	if initSystem != SYSTEMD {
+		return ErrUserDaemonsUnsupported
+	}
+	return nil
+}
+
+// newUserCommand prepares systemctl or journalctl connected to the user bus of the regular user. If spito runs
+// as other user, e.g. with sudo, the command is started by the helper process as the regular user,
+// because the user bus accepts only its owner
+func newUserCommand(ctx context.Context, name string, args ...string) (*exec.Cmd, error) {
+	regularUser, err := userinfo.GetRegularUser()
+	if err != nil {
+		return nil, err
+	}
+	uid, gid, err := getIds(regularUser.Uid, regularUser.Gid)
+	if err != nil {
+		return nil, err
+	}
+
+	command := exec.CommandContext(ctx, name, args...)
+	if os.Getuid() != uid {
+		executable, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		helperArgs := append([]string{UserCommandHelper, strconv.Itoa(uid), strconv.Itoa(gid), name}, args...)
+		command = exec.CommandContext(ctx, executable, helperArgs...)
+	}
+
+	runtimeDir := fmt.Sprintf("/run/user/%d", uid)
+	command.Env = append(os.Environ(),
+		"LC_ALL=C",
+		"XDG_RUNTIME_DIR="+runtimeDir,
+		"DBUS_SESSION_BUS_ADDRESS=unix:path="+runtimeDir+"/bus",
+	)
+	return command, nil
+}
+
+// RunUserCommand is executed by the helper process started by spito run with sudo. It regains root privileges,
+// which spito drops, and starts the command as the regular user. Only this process raises its effective uid,
+// so rules evaluated by spito never run with root privileges.
+// Output and the exit code of the command are passed through
+func RunUserCommand(uid int, gid int, name string, args ...string) error {
+	if err := syscall.Seteuid(userinfo.RootEuid); err != nil {
+		return fmt.Errorf("cannot reach services of the user: %w", err)
+	}
+
+	command := exec.Command(name, args...)
+	command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
+	command.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+	}
+	return command.Run()
+}
+
+// executeUserCommand works like execute, but the command is executed as the regular user
+func executeUserCommand(ctx context.Context, name string, args ...string) (string, bool, error) {
+	command, err := newUserCommand(ctx, name, args...)
+	if err != nil {
+		return "", false, err
+	}
+
+	rawOutput, err := command.Output()
+	if _, ok := ctx.Deadline(); !ok {
+		return string(rawOutput), false, ctx.Err()
+	}
+	return strings.TrimSpace(string(rawOutput)), true, err
+}
+
+func runUserSystemctl(args ...string) error {
+	command, err := newUserCommand(context.Background(), "systemctl", append([]string{"--user"}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return errors.Join(err, errors.New(strings.TrimSpace(string(output))))
+	}
+	return nil
+}
+
+func (s SystemdUserInitManager) GetDaemon(daemonName string) (Daemon, error) {
+	if err := s.checkInitSystem(); err != nil {
+		return Daemon{}, err
+	}
+
+	daemonName = strings.TrimSpace(daemonName)
+	if err := validateDaemonName(daemonName); err != nil {
+		return Daemon{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), daemonQueryTimeout)
+	defer cancel()
+	journalctl := func(args ...string) (string, bool, error) {
+		return executeUserCommand(ctx, "journalctl", args...)
+	}
+	return getSystemdDaemon(daemonName, func(args ...string) (string, bool, error) {
+		return executeUserCommand(ctx, "systemctl", append([]string{"--user"}, args...)...)
+	}, getJournal("--user-unit", journalctl))
+}
+
+func (s SystemdUserInitManager) runCommand(command string, daemonName string) error {
+	if err := s.checkInitSystem(); err != nil {
+		return err
+	}
+	return runUserSystemctl(command, daemonName)
+}
+
+func (s SystemdUserInitManager) StartDaemon(daemonName string) error {
+	return s.runCommand("start", daemonName)
+}
+
+func (s SystemdUserInitManager) StopDaemon(daemonName string) error {
+	return s.runCommand("stop", daemonName)
+}
+
+func (s SystemdUserInitManager) RestartDaemon(daemonName string) error {
+	return s.runCommand("restart", daemonName)
+}
+
+func (s SystemdUserInitManager) EnableDaemon(daemonName string) error {
+	return s.runCommand("enable", daemonName)
+}
+
+func (s SystemdUserInitManager) DisableDaemon(daemonName string) error {
+	return s.runCommand("disable", daemonName)
+}
+
+func (s SystemdUserInitManager) MaskDaemon(daemonName string) error {
+	return s.runCommand("mask", daemonName)
+}
+
+func (s SystemdUserInitManager) UnmaskDaemon(daemonName string) error {
+	return s.runCommand("unmask", daemonName)
+}
+
+// ReloadDaemons does nothing in the alternative root, the user's systemd reads the units when the user logs in
+func (s SystemdUserInitManager) ReloadDaemons() error {
+	if isAlternativeRoot(s.Root) {
+		return nil
+	}
+	if err := s.checkInitSystem(); err != nil {
+		return err
+	}
+	return runUserSystemctl("daemon-reload")
+}
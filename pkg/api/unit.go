@@ -0,0 +1,265 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	daemontracker "github.com/avorty/spito/pkg"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+)
+
+var ErrUnitsUnsupported = errors.New("units can only be created for systemd")
+
+const (
+	systemUnitsDir = "/etc/systemd/system"
+	// User units are created for the user who runs spito, like user daemons are controlled for them
+	userUnitsDir = "~/.config/systemd/user"
+)
+
+// unitSections maps types of the units which can be created to sections describing them,
+// e.g. a timer requires the [Timer] section, which can't be used in a service
+var unitSections = map[string]string{
+	"service": "Service",
+	"socket":  "Socket",
+	"timer":   "Timer",
+	"path":    "Path",
+	"target":  "",
+}
+
+var (
+	unitNameRegex    = regexp.MustCompile(`^[A-Za-z0-9_:\\][A-Za-z0-9_.@:\\-]*\.([a-z]+)$`)
+	dropInNameRegex  = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+	sectionNameRegex = regexp.MustCompile(`^[A-Z][A-Za-z]*$|^X-[A-Za-z0-9-]+$`)
+	unitKeyRegex     = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$|^X-[A-Za-z0-9-]+$`)
+)
+
+// UnitEntry is a single assignment, keys like ExecStartPre can be assigned many times
+type UnitEntry struct {
+	Key   string
+	Value string
+}
+
+type UnitSection struct {
+	Name    string
+	Entries []UnitEntry
+}
+
+// UnitFile is the INI model of a systemd unit or drop-in. Unlike configs edited by api.fs,
+// it keeps repeated keys and empty assignments, which reset lists in drop-ins
+type UnitFile struct {
+	Sections []UnitSection
+}
+
+// NewUnitFile orders the sections as systemd documentation does: [Unit] first, [Install] last
+// and the type specific sections between them. Keys are sorted, the order of values is kept
+func NewUnitFile(sections map[string]map[string][]string) UnitFile {
+	sectionNames := make([]string, 0, len(sections))
+	for sectionName := range sections {
+		sectionNames = append(sectionNames, sectionName)
+	}
+	slices.SortFunc(sectionNames, func(a, b string) int {
+		if order := getSectionOrder(a) - getSectionOrder(b); order != 0 {
+			return order
+		}
+		return strings.Compare(a, b)
+	})
+
+	unit := UnitFile{}
+	for _, sectionName := range sectionNames {
+		section := UnitSection{Name: sectionName}
+
+		keys := make([]string, 0, len(sections[sectionName]))
+		for key := range sections[sectionName] {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			for _, value := range sections[sectionName][key] {
+				section.Entries = append(section.Entries, UnitEntry{Key: key, Value: value})
+			}
+		}
+		unit.Sections = append(unit.Sections, section)
+	}
+	return unit
+}
+
+func getSectionOrder(sectionName string) int {
+	switch sectionName {
+	case "Unit":
+		return 0
+	case "Install":
+		return 2
+	}
+	return 1
+}
+
+func (u UnitFile) getSection(sectionName string) (UnitSection, bool) {
+	for _, section := range u.Sections {
+		if section.Name == sectionName {
+			return section, true
+		}
+	}
+	return UnitSection{}, false
+}
+
+// String renders the unit file, values are written as they are, systemd doesn't need them quoted
+func (u UnitFile) String() string {
+	var builder strings.Builder
+	for i, section := range u.Sections {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		builder.WriteString("[" + section.Name + "]\n")
+		for _, entry := range section.Entries {
+			builder.WriteString(entry.Key + "=" + entry.Value + "\n")
+		}
+	}
+	return builder.String()
+}
+
+// Validate checks that the file can be used by the unit of given type. Type specific sections of other units
+// aren't allowed and new timers, sockets and paths have to describe what activates them
+func (u UnitFile) Validate(unitType string, isDropIn bool) error {
+	for _, section := range u.Sections {
+		if !sectionNameRegex.MatchString(section.Name) {
+			return fmt.Errorf("invalid section name '%s'", section.Name)
+		}
+		for otherType, typeSection := range unitSections {
+			if section.Name == typeSection && otherType != unitType {
+				return fmt.Errorf("section [%s] can't be used in a %s unit", section.Name, unitType)
+			}
+		}
+
+		for _, entry := range section.Entries {
+			if !unitKeyRegex.MatchString(entry.Key) {
+				return fmt.Errorf("invalid key '%s' in section [%s]", entry.Key, section.Name)
+			}
+			if strings.ContainsAny(entry.Value, "\n\r\x00") {
+				return fmt.Errorf("value of %s in section [%s] has to be a single line", entry.Key, section.Name)
+			}
+		}
+	}
+
+	typeSection := unitSections[unitType]
+	if _, ok := u.getSection(typeSection); !isDropIn && unitType != "service" && typeSection != "" && !ok {
+		return fmt.Errorf("%s unit requires the [%s] section", unitType, typeSection)
+	}
+	return nil
+}
+
+// parseUnitName returns the type of the unit, its name has to end with it, e.g. backup.timer
+func parseUnitName(unitName string) (string, error) {
+	match := unitNameRegex.FindStringSubmatch(unitName)
+	if match == nil {
+		return "", fmt.Errorf("invalid unit name '%s', it has to end with the unit type, e.g. backup.timer", unitName)
+	}
+	if _, ok := unitSections[match[1]]; !ok {
+		return "", fmt.Errorf("units of type '%s' can't be created, use service, socket, timer, path or target", match[1])
+	}
+	return match[1], nil
+}
+
+// IsSystemUnitPath returns true for units and drop-ins of the system scope, which rules with the daemons permission
+// can create without the files permission
+func IsSystemUnitPath(filePath string) bool {
+	return strings.HasPrefix(filepath.Clean(filePath), systemUnitsDir+"/")
+}
+
+// GetUnitPath returns where the unit of the scope is created, drop-ins are stored in <unit>.d directory next to it
+func GetUnitPath(unitName string, scope DaemonScope) string {
+	if scope == UserScope {
+		return filepath.Join(userUnitsDir, unitName)
+	}
+	return filepath.Join(systemUnitsDir, unitName)
+}
+
+// permissionChecker is implemented by init managers executing operations on behalf of the rule
+type permissionChecker interface {
+	CheckPermission(permission shared.Permission) error
+}
+
+// CreateUnit writes the unit file. Units are written when the changes are applied,
+// so daemon operations requested afterwards are executed once the init system has reloaded them
+func (s *DaemonApi) CreateUnit(unitName string, unit UnitFile, scope ...string) error {
+	unitType, err := parseUnitName(unitName)
+	if err != nil {
+		return err
+	}
+	if err := unit.Validate(unitType, false); err != nil {
+		return fmt.Errorf("invalid unit %s: %w", unitName, err)
+	}
+	return s.writeUnitFile(unitName, scope, func(daemonScope DaemonScope) string {
+		return GetUnitPath(unitName, daemonScope)
+	}, unit)
+}
+
+// CreateDropIn writes the drop-in overriding settings of the unit, it can extend units installed by packages,
+// e.g. sshd.service. Units without the type suffix are services
+func (s *DaemonApi) CreateDropIn(unitName string, dropInName string, dropIn UnitFile, scope ...string) error {
+	unitName = daemontracker.GetUnitName(unitName)
+	unitType, err := parseUnitName(unitName)
+	if err != nil {
+		return err
+	}
+	if !dropInNameRegex.MatchString(dropInName) {
+		return fmt.Errorf("invalid drop-in name '%s'", dropInName)
+	}
+	if err := dropIn.Validate(unitType, true); err != nil {
+		return fmt.Errorf("invalid drop-in %s of %s: %w", dropInName, unitName, err)
+	}
+	return s.writeUnitFile(unitName, scope, func(daemonScope DaemonScope) string {
+		return filepath.Join(GetUnitPath(unitName, daemonScope)+".d", strings.TrimSuffix(dropInName, ".conf")+".conf")
+	}, dropIn)
+}
+
+// writeUnitFile creates the file through the VRCT and records the change of the unit,
+// the init system of its scope is reloaded once after all the changes are applied
+func (s *DaemonApi) writeUnitFile(
+	unitName string,
+	scopeArgs []string,
+	getPath func(scope DaemonScope) string,
+	unit UnitFile,
+) error {
+	scope, err := parseScopeArgument(scopeArgs)
+	if err != nil {
+		return err
+	}
+	platform, err := PlatformBackend()
+	if err != nil {
+		return err
+	}
+	if platform.InitSystem != SYSTEMD {
+		return ErrUnitsUnsupported
+	}
+	if checker, ok := s.initManager(scope).(permissionChecker); ok && scope == SystemScope {
+		if err := checker.CheckPermission(shared.PermissionDaemons); err != nil {
+			return err
+		}
+	}
+
+	unitPath := getPath(scope)
+	if err := s.ImportLoopData.VRCT.Fs.CreateFile(unitPath, []byte(unit.String()), false); err != nil {
+		return err
+	}
+
+	change := vrctFs.UnitChange{Name: unitName, Path: unitPath, Rule: s.Rule}
+	tracker := &s.ImportLoopData.DaemonTracker
+	if scope == UserScope {
+		change.Scope = string(UserScope)
+		tracker = tracker.User()
+	}
+	s.ImportLoopData.VRCT.Fs.RecordUnitChange(change)
+
+	if tracker.ChangeUnit(unitName) {
+		s.ImportLoopData.DeferUnitReload(func() error {
+			return s.initManager(scope).ReloadDaemons()
+		})
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestNewUnitFile(t *testing.T) {
+	unit := NewUnitFile(map[string]map[string][]string{
+		"Install": {"WantedBy": {"timers.target"}},
+		"Timer":   {"OnCalendar": {"daily"}, "Persistent": {"true"}},
+		"Unit":    {"Description": {"Daily backup"}},
+	})
+
+	expected := "[Unit]\nDescription=Daily backup\n\n[Timer]\nOnCalendar=daily\nPersistent=true\n\n[Install]\nWantedBy=timers.target\n"
+	if unit.String() != expected {
+		t.Fatalf("unexpected unit:\n%s", unit.String())
+	}
+	if err := unit.Validate("timer", false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDropInResetsList(t *testing.T) {
+	dropIn := NewUnitFile(map[string]map[string][]string{
+		"Service": {"ExecStart": {"", "/usr/bin/sshd -D -p 2222"}},
+	})
+
+	expected := "[Service]\nExecStart=\nExecStart=/usr/bin/sshd -D -p 2222\n"
+	if dropIn.String() != expected {
+		t.Fatalf("unexpected drop-in:\n%s", dropIn.String())
+	}
+	if err := dropIn.Validate("service", true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateUnit(t *testing.T) {
+	invalidUnits := []struct {
+		unitType string
+		sections map[string]map[string][]string
+	}{
+		{"timer", map[string]map[string][]string{"Unit": {"Description": {"No timer section"}}}},
+		{"service", map[string]map[string][]string{"Timer": {"OnCalendar": {"daily"}}}},
+		{"path", map[string]map[string][]string{"Path": {"Path Changed": {"/etc"}}}},
+		{"service", map[string]map[string][]string{"Service": {"ExecStart": {"/bin/true\n[Install]"}}}},
+		{"service", map[string]map[string][]string{"service]\n[Unit": {}}},
+	}
+
+	for _, invalidUnit := range invalidUnits {
+		if err := NewUnitFile(invalidUnit.sections).Validate(invalidUnit.unitType, false); err == nil {
+			t.Errorf("%s unit should be invalid: %+v", invalidUnit.unitType, invalidUnit.sections)
+		}
+	}
+}
+
+func TestParseUnitName(t *testing.T) {
+	validNames := map[string]string{
+		"backup.timer":      "timer",
+		"backup-db.service": "service",
+		"docker.socket":     "socket",
+		"my_app.path":       "path",
+	}
+	for unitName, expectedType := range validNames {
+		unitType, err := parseUnitName(unitName)
+		if err != nil || unitType != expectedType {
+			t.Errorf("%s should be a %s unit, got: %s, %v", unitName, expectedType, unitType, err)
+		}
+	}
+
+	for _, unitName := range []string{"backup", "../backup.service", "-backup.service", "home.mount", "backup.timer/x"} {
+		if _, err := parseUnitName(unitName); err == nil {
+			t.Errorf("%s should be invalid", unitName)
+		}
+	}
+}
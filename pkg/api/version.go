@@ -0,0 +1,491 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// VersionScheme determines how versions of packages are ordered, it depends on the package manager of the distro
+type VersionScheme string
+
+const (
+	// PacmanVersions are compared like vercmp does, [epoch:]version[-pkgrel]
+	PacmanVersions VersionScheme = "pacman"
+	// DebianVersions are compared like dpkg does, [epoch:]upstream_version[-debian_revision]
+	DebianVersions VersionScheme = "deb"
+	// RpmVersions are compared like rpm does, [epoch:]version[-release]
+	RpmVersions VersionScheme = "rpm"
+)
+
+var (
+	debianDistros = []string{"debian", "ubuntu"}
+	rpmDistros    = []string{"fedora", "rhel", "centos", "suse", "opensuse", "mageia"}
+)
+
+// GetVersionScheme returns scheme used by the distro of the platform, pacman is the default
+func GetVersionScheme(platform Platform) VersionScheme {
+	distros := append([]string{platform.Distro}, platform.DistroLike...)
+	for _, distro := range distros {
+		if slices.Contains(debianDistros, distro) {
+			return DebianVersions
+		}
+		if slices.Contains(rpmDistros, distro) {
+			return RpmVersions
+		}
+	}
+	return PacmanVersions
+}
+
+// CompareVersions returns -1 if a is older than b, 0 if they are equal and 1 if a is newer
+func CompareVersions(scheme VersionScheme, a string, b string) int {
+	switch scheme {
+	case DebianVersions:
+		return compareDebianVersions(a, b)
+	case RpmVersions:
+		return compareRpmVersions(a, b)
+	default:
+		return comparePacmanVersions(a, b)
+	}
+}
+
+// splitEpochVersionRelease splits [epoch:]version[-release], hasRelease is false if the release is missing
+func splitEpochVersionRelease(fullVersion string) (epoch string, version string, release string, hasRelease bool) {
+	epoch, version = "0", fullVersion
+
+	digitsEnd := strings.IndexFunc(fullVersion, func(r rune) bool {
+		return !isDigit(byte(r))
+	})
+	if digitsEnd != -1 && fullVersion[digitsEnd] == ':' {
+		if digitsEnd > 0 {
+			epoch = fullVersion[:digitsEnd]
+		}
+		version = fullVersion[digitsEnd+1:]
+	}
+
+	if releaseStart := strings.LastIndex(version, "-"); releaseStart != -1 {
+		return epoch, version[:releaseStart], version[releaseStart+1:], true
+	}
+	return epoch, version, "", false
+}
+
+// comparePacmanVersions implements alpm_pkg_vercmp, release is compared only if both versions have it
+func comparePacmanVersions(a string, b string) int {
+	epochA, versionA, releaseA, hasReleaseA := splitEpochVersionRelease(a)
+	epochB, versionB, releaseB, hasReleaseB := splitEpochVersionRelease(b)
+
+	if result := alpmVercmp(epochA, epochB); result != 0 {
+		return result
+	}
+	if result := alpmVercmp(versionA, versionB); result != 0 || !hasReleaseA || !hasReleaseB {
+		return result
+	}
+	return alpmVercmp(releaseA, releaseB)
+}
+
+// alpmVercmp compares alternating numeric and alphabetic segments like rpmvercmp of pacman,
+// additionally a longer separator between segments makes the version newer
+func alpmVercmp(a string, b string) int {
+	if a == b {
+		return 0
+	}
+
+	one, two := 0, 0
+	segmentEnd1, segmentEnd2 := 0, 0
+	for one < len(a) && two < len(b) {
+		for one < len(a) && !isAlphanumeric(a[one]) {
+			one++
+		}
+		for two < len(b) && !isAlphanumeric(b[two]) {
+			two++
+		}
+		if one >= len(a) || two >= len(b) {
+			break
+		}
+
+		if separator1, separator2 := one-segmentEnd1, two-segmentEnd2; separator1 != separator2 {
+			return compareInts(separator1, separator2)
+		}
+
+		segment1, segment2, isNumeric := nextSegments(a[one:], b[two:])
+		if segment2 == "" {
+			if isNumeric {
+				return 1
+			}
+			return -1
+		}
+		if result := compareSegments(segment1, segment2, isNumeric); result != 0 {
+			return result
+		}
+
+		one += len(segment1)
+		two += len(segment2)
+		segmentEnd1, segmentEnd2 = one, two
+	}
+
+	if one >= len(a) && two >= len(b) {
+		return 0
+	}
+	// Remaining alphabetic segment never beats an empty string, e.g. 1.0alpha is older than 1.0
+	if (one >= len(a) && !isAlpha(b[two])) || (one < len(a) && isAlpha(a[one])) {
+		return -1
+	}
+	return 1
+}
+
+// compareRpmVersions implements rpmVersionCompare, release is compared only if both versions have it
+func compareRpmVersions(a string, b string) int {
+	epochA, versionA, releaseA, hasReleaseA := splitEpochVersionRelease(a)
+	epochB, versionB, releaseB, hasReleaseB := splitEpochVersionRelease(b)
+
+	if result := compareSegments(strings.TrimLeft(epochA, "0"), strings.TrimLeft(epochB, "0"), true); result != 0 {
+		return result
+	}
+	if result := rpmVercmp(versionA, versionB); result != 0 || !hasReleaseA || !hasReleaseB {
+		return result
+	}
+	return rpmVercmp(releaseA, releaseB)
+}
+
+// rpmVercmp is rpmvercmp of rpm, '~' sorts before anything and '^' sorts after the end of the version
+func rpmVercmp(a string, b string) int {
+	if a == b {
+		return 0
+	}
+
+	isSeparator := func(c byte) bool {
+		return !isAlphanumeric(c) && c != '~' && c != '^'
+	}
+
+	one, two := 0, 0
+	for one < len(a) || two < len(b) {
+		for one < len(a) && isSeparator(a[one]) {
+			one++
+		}
+		for two < len(b) && isSeparator(b[two]) {
+			two++
+		}
+
+		charA, charB := charAt(a, one), charAt(b, two)
+		if charA == '~' || charB == '~' {
+			if charA != '~' {
+				return 1
+			}
+			if charB != '~' {
+				return -1
+			}
+			one++
+			two++
+			continue
+		}
+		if charA == '^' || charB == '^' {
+			if one >= len(a) {
+				return -1
+			}
+			if two >= len(b) {
+				return 1
+			}
+			if charA != '^' {
+				return 1
+			}
+			if charB != '^' {
+				return -1
+			}
+			one++
+			two++
+			continue
+		}
+		if one >= len(a) || two >= len(b) {
+			break
+		}
+
+		segment1, segment2, isNumeric := nextSegments(a[one:], b[two:])
+		if segment2 == "" {
+			if isNumeric {
+				return 1
+			}
+			return -1
+		}
+		if result := compareSegments(segment1, segment2, isNumeric); result != 0 {
+			return result
+		}
+
+		one += len(segment1)
+		two += len(segment2)
+	}
+
+	if one >= len(a) && two >= len(b) {
+		return 0
+	}
+	if one >= len(a) {
+		return -1
+	}
+	return 1
+}
+
+// compareDebianVersions implements dpkg version ordering, missing revision is treated as empty
+func compareDebianVersions(a string, b string) int {
+	epochA, versionA, revisionA, _ := splitEpochVersionRelease(a)
+	epochB, versionB, revisionB, _ := splitEpochVersionRelease(b)
+
+	if result := compareSegments(strings.TrimLeft(epochA, "0"), strings.TrimLeft(epochB, "0"), true); result != 0 {
+		return result
+	}
+	if result := dpkgVerrevcmp(versionA, versionB); result != 0 {
+		return result
+	}
+	return dpkgVerrevcmp(revisionA, revisionB)
+}
+
+// dpkgOrder sorts '~' before the end of the version and letters before other characters
+func dpkgOrder(c byte) int {
+	switch {
+	case isDigit(c) || c == 0:
+		return 0
+	case isAlpha(c):
+		return int(c)
+	case c == '~':
+		return -1
+	default:
+		return int(c) + 256
+	}
+}
+
+func dpkgVerrevcmp(a string, b string) int {
+	one, two := 0, 0
+	for one < len(a) || two < len(b) {
+		for (one < len(a) && !isDigit(a[one])) || (two < len(b) && !isDigit(b[two])) {
+			orderA, orderB := dpkgOrder(charAt(a, one)), dpkgOrder(charAt(b, two))
+			if orderA != orderB {
+				return compareInts(orderA, orderB)
+			}
+			one++
+			two++
+		}
+
+		for charAt(a, one) == '0' {
+			one++
+		}
+		for charAt(b, two) == '0' {
+			two++
+		}
+
+		firstDifference := 0
+		for isDigit(charAt(a, one)) && isDigit(charAt(b, two)) {
+			if firstDifference == 0 {
+				firstDifference = compareInts(int(a[one]), int(b[two]))
+			}
+			one++
+			two++
+		}
+		if isDigit(charAt(a, one)) {
+			return 1
+		}
+		if isDigit(charAt(b, two)) {
+			return -1
+		}
+		if firstDifference != 0 {
+			return firstDifference
+		}
+	}
+	return 0
+}
+
+// nextSegments returns leading numeric or alphabetic segments of the versions, type is decided by the first one
+func nextSegments(a string, b string) (string, string, bool) {
+	isNumeric := isDigit(a[0])
+	matchesType := isAlpha
+	if isNumeric {
+		matchesType = isDigit
+	}
+
+	segmentEnd := func(version string) int {
+		end := 0
+		for end < len(version) && matchesType(version[end]) {
+			end++
+		}
+		return end
+	}
+	return a[:segmentEnd(a)], b[:segmentEnd(b)], isNumeric
+}
+
+// compareSegments compares numbers by their value and other segments as strings
+func compareSegments(segment1 string, segment2 string, isNumeric bool) int {
+	if isNumeric {
+		segment1, segment2 = strings.TrimLeft(segment1, "0"), strings.TrimLeft(segment2, "0")
+		if len(segment1) != len(segment2) {
+			return compareInts(len(segment1), len(segment2))
+		}
+	}
+	return strings.Compare(segment1, segment2)
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func charAt(s string, index int) byte {
+	if index < len(s) {
+		return s[index]
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isAlphanumeric(c byte) bool {
+	return isDigit(c) || isAlpha(c)
+}
+
+// Operators of version constraints
+const (
+	VersionAny            = ""
+	VersionEqual          = "="
+	VersionGreater        = ">"
+	VersionGreaterOrEqual = ">="
+	VersionLess           = "<"
+	VersionLessOrEqual    = "<="
+	// VersionCompatible accepts newer versions which differ only in the last component, e.g. ~1.2 accepts 1.9 but not 2.0
+	VersionCompatible = "~"
+)
+
+// Longer operators are matched first
+var versionOperators = []string{
+	VersionGreaterOrEqual, VersionLessOrEqual, VersionGreater, VersionLess, VersionEqual, VersionCompatible,
+}
+
+// VersionConstraint restricts acceptable versions of the package, e.g. ">=1.2"
+type VersionConstraint struct {
+	Operator string
+	Version  string
+}
+
+// ParseVersionConstraint parses the constraint, empty one and "*" accept any version
+// and version without an operator has to be equal
+func ParseVersionConstraint(constraint string) (VersionConstraint, error) {
+	constraint = strings.TrimSpace(constraint)
+	if constraint == "" || constraint == "*" {
+		return VersionConstraint{Operator: VersionAny}, nil
+	}
+
+	operator := VersionEqual
+	for _, versionOperator := range versionOperators {
+		if strings.HasPrefix(constraint, versionOperator) {
+			operator = versionOperator
+			constraint = strings.TrimPrefix(constraint, versionOperator)
+			break
+		}
+	}
+
+	version := strings.TrimSpace(constraint)
+	if version == "" || strings.ContainsAny(version, " <>=~*") {
+		return VersionConstraint{}, fmt.Errorf("invalid version constraint '%s%s'", operator, constraint)
+	}
+
+	versionConstraint := VersionConstraint{Operator: operator, Version: version}
+	if operator == VersionCompatible {
+		if _, err := versionConstraint.compatibleUpperBound(); err != nil {
+			return VersionConstraint{}, err
+		}
+	}
+	return versionConstraint, nil
+}
+
+func (c VersionConstraint) String() string {
+	if c.Operator == VersionAny {
+		return "*"
+	}
+	return c.Operator + c.Version
+}
+
+// IsSatisfiedBy returns true if the version is accepted by the constraint
+func (c VersionConstraint) IsSatisfiedBy(scheme VersionScheme, version string) bool {
+	if c.Operator == VersionAny {
+		return true
+	}
+
+	result := CompareVersions(scheme, version, c.Version)
+	switch c.Operator {
+	case VersionEqual:
+		return result == 0
+	case VersionGreater:
+		return result > 0
+	case VersionGreaterOrEqual:
+		return result >= 0
+	case VersionLess:
+		return result < 0
+	case VersionLessOrEqual:
+		return result <= 0
+	case VersionCompatible:
+		upperBound, err := c.compatibleUpperBound()
+		return err == nil && result >= 0 && CompareVersions(scheme, version, upperBound) < 0
+	default:
+		return false
+	}
+}
+
+// compatibleUpperBound returns the first incompatible version, e.g. 1.3 for ~1.2.4 and 2 for ~1
+func (c VersionConstraint) compatibleUpperBound() (string, error) {
+	epoch, version, _, _ := splitEpochVersionRelease(c.Version)
+	components := strings.Split(version, ".")
+	if len(components) > 1 {
+		components = components[:len(components)-1]
+	}
+
+	lastComponent, err := strconv.Atoi(components[len(components)-1])
+	if err != nil {
+		return "", fmt.Errorf("invalid version constraint '%s', compatible version has to end with a number", c)
+	}
+	components[len(components)-1] = strconv.Itoa(lastComponent + 1)
+
+	upperBound := strings.Join(components, ".")
+	if epoch != "0" {
+		upperBound = epoch + ":" + upperBound
+	}
+	return upperBound, nil
+}
+
+// ParsePackageString splits "name@constraint" used by the pkg api, e.g. "neovim@>=0.9"
+func ParsePackageString(packageString string) (string, VersionConstraint, error) {
+	name, constraint, _ := strings.Cut(packageString, "@")
+	versionConstraint, err := ParseVersionConstraint(constraint)
+	if err != nil {
+		return name, VersionConstraint{}, fmt.Errorf("package %s: %w", name, err)
+	}
+	return name, versionConstraint, nil
+}
+
+// PackageSatisfies returns true if the package is installed in a version accepted by the constraint,
+// versions are compared using scheme of the distro
+func PackageSatisfies(packageManager PackageManager, name string, constraint string) (bool, error) {
+	versionConstraint, err := ParseVersionConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+
+	installedPackage, err := packageManager.GetPackage(name)
+	if err != nil {
+		// Package which isn't installed doesn't satisfy any constraint
+		return false, nil
+	}
+
+	scheme := PacmanVersions
+	if platform, err := PlatformBackend(); err == nil {
+		scheme = GetVersionScheme(platform)
+	}
+	return versionConstraint.IsSatisfiedBy(scheme, installedPackage.Version), nil
+}
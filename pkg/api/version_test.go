@@ -0,0 +1,96 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		scheme   VersionScheme
+		a, b     string
+		expected int
+	}{
+		{PacmanVersions, "1.0", "1.0", 0},
+		{PacmanVersions, "1.10", "1.9", 1},
+		{PacmanVersions, "1.0a", "1.0", -1},
+		{PacmanVersions, "1.0.1", "1.0", 1},
+		{PacmanVersions, "1.0rc1", "1.0", -1},
+		{PacmanVersions, "1:1.0", "2.0", 1},
+		{PacmanVersions, "2.43.0-2", "2.43.0-1", 1},
+		{PacmanVersions, "2.43.0-2", "2.43.0", 0},
+		{PacmanVersions, "1..0", "1.0", 1},
+		{PacmanVersions, "0.9.8", "0.10.1", -1},
+		{DebianVersions, "1.0~rc1", "1.0", -1},
+		{DebianVersions, "1.0-1", "1.0", 1},
+		{DebianVersions, "1:0.1", "2.0", 1},
+		{DebianVersions, "1.0+dfsg-2ubuntu1", "1.0+dfsg-2", 1},
+		{DebianVersions, "1.0a", "1.0+", -1},
+		{DebianVersions, "007", "7", 0},
+		{RpmVersions, "1.0~rc1", "1.0", -1},
+		{RpmVersions, "1.0^git1", "1.0", 1},
+		{RpmVersions, "1.0^git1", "1.0.1", -1},
+		{RpmVersions, "1.0-1.fc39", "1.0-2.fc39", -1},
+		{RpmVersions, "2:1.0", "10.0", 1},
+		{RpmVersions, "1.0-3", "1.0", 0},
+	}
+
+	for _, testCase := range testCases {
+		if result := CompareVersions(testCase.scheme, testCase.a, testCase.b); result != testCase.expected {
+			t.Errorf("%s: comparing %s with %s returned %d instead of %d",
+				testCase.scheme, testCase.a, testCase.b, result, testCase.expected)
+		}
+		if result := CompareVersions(testCase.scheme, testCase.b, testCase.a); result != -testCase.expected {
+			t.Errorf("%s: comparing %s with %s returned %d instead of %d",
+				testCase.scheme, testCase.b, testCase.a, result, -testCase.expected)
+		}
+	}
+}
+
+func TestVersionConstraints(t *testing.T) {
+	testCases := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"", "1.0", true},
+		{"*", "1.0", true},
+		{">=1.2", "1.10-1", true},
+		{">=1.2", "1.1.9-1", false},
+		{"<2", "1.99", true},
+		{"<2", "2.0", false},
+		{"=1.2-1", "1.2-1", true},
+		{"=1.2", "1.2-3", true},
+		{"1.2", "1.3", false},
+		{"~1.2.4", "1.2.9", true},
+		{"~1.2.4", "1.3", false},
+		{"~1.2.4", "1.2.3", false},
+		{"~1", "1.9", true},
+		{"~1", "2.0", false},
+	}
+
+	for _, testCase := range testCases {
+		constraint, err := ParseVersionConstraint(testCase.constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := constraint.IsSatisfiedBy(PacmanVersions, testCase.version); result != testCase.expected {
+			t.Errorf("'%s' satisfied by %s should be %t", testCase.constraint, testCase.version, testCase.expected)
+		}
+	}
+
+	for _, invalidConstraint := range []string{">=", "=>1.0", "~beta", ">=1 <2"} {
+		if _, err := ParseVersionConstraint(invalidConstraint); err == nil {
+			t.Errorf("constraint '%s' should be rejected", invalidConstraint)
+		}
+	}
+}
+
+func TestParsePackageString(t *testing.T) {
+	name, constraint, err := ParsePackageString("neovim@>=0.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "neovim" || constraint != (VersionConstraint{Operator: VersionGreaterOrEqual, Version: "0.9"}) {
+		t.Fatalf("unexpected package %s with constraint %+v", name, constraint)
+	}
+}
@@ -1,17 +1,26 @@
 package daemon_tracker
 
 import (
-	"errors"
 	"fmt"
-	"os/exec"
+	"strings"
 )
 
+// DaemonTracker only records daemon operations to find conflicts between them,
+// operations themselves are executed by the init system backend
 type DaemonTracker struct {
 	startedDaemons   []string
 	stoppedDaemons   []string
 	restartedDaemons []string
 	enabledDaemons   []string
 	disabledDaemons  []string
+	maskedDaemons    []string
+	unmaskedDaemons  []string
+	// changedUnits are units whose files or drop-ins are written by the rules
+	changedUnits []string
+	// userDaemons tracks services of the user's service manager,
+	// they don't conflict with system daemons of the same name
+	userDaemons *DaemonTracker
+	isUserScope bool
 }
 
 func NewDaemonTracker() DaemonTracker {
@@ -19,84 +28,126 @@ func NewDaemonTracker() DaemonTracker {
 		startedDaemons:   make([]string, 0),
 		stoppedDaemons:   make([]string, 0),
 		restartedDaemons: make([]string, 0),
+		userDaemons:      &DaemonTracker{isUserScope: true},
 	}
 }
 
-func (daemonTracker *DaemonTracker) StartDaemon(daemonName string) error {
-	daemonTracker.startedDaemons = append(daemonTracker.startedDaemons, daemonName)
-
-	conflict := daemonTracker.FindConflicts()
-	if conflict != nil {
-		return conflict
+// User returns the tracker of the user's services
+func (daemonTracker *DaemonTracker) User() *DaemonTracker {
+	if daemonTracker.isUserScope {
+		return daemonTracker
+	}
+	if daemonTracker.userDaemons == nil {
+		daemonTracker.userDaemons = &DaemonTracker{isUserScope: true}
 	}
+	return daemonTracker.userDaemons
+}
+
+func (daemonTracker *DaemonTracker) StartDaemon(daemonName string) error {
+	daemonTracker.startedDaemons = append(daemonTracker.startedDaemons, GetUnitName(daemonName))
 
-	return runSystemdCommand("start", daemonName)
+	return daemonTracker.FindConflicts()
 }
 
 func (daemonTracker *DaemonTracker) StopDaemon(daemonName string) error {
-	daemonTracker.stoppedDaemons = append(daemonTracker.stoppedDaemons, daemonName)
+	daemonTracker.stoppedDaemons = append(daemonTracker.stoppedDaemons, GetUnitName(daemonName))
 
-	conflict := daemonTracker.FindConflicts()
-	if conflict != nil {
-		return conflict
-	}
-
-	return runSystemdCommand("stop", daemonName)
+	return daemonTracker.FindConflicts()
 }
 
 func (daemonTracker *DaemonTracker) RestartDaemon(daemonName string) error {
-	daemonTracker.restartedDaemons = append(daemonTracker.restartedDaemons, daemonName)
+	daemonTracker.restartedDaemons = append(daemonTracker.restartedDaemons, GetUnitName(daemonName))
 
-	conflict := daemonTracker.FindConflicts()
-	if conflict != nil {
-		return conflict
-	}
-
-	return runSystemdCommand("restart", daemonName)
+	return daemonTracker.FindConflicts()
 }
 
 func (daemonTracker *DaemonTracker) EnableDaemon(daemonName string) error {
-	daemonTracker.enabledDaemons = append(daemonTracker.enabledDaemons, daemonName)
+	daemonTracker.enabledDaemons = append(daemonTracker.enabledDaemons, GetUnitName(daemonName))
 
-	conflict := daemonTracker.FindConflicts()
-	if conflict != nil {
-		return conflict
-	}
-
-	return runSystemdCommand("enable", daemonName)
+	return daemonTracker.FindConflicts()
 }
 
 func (daemonTracker *DaemonTracker) DisableDaemon(daemonName string) error {
-	daemonTracker.disabledDaemons = append(daemonTracker.disabledDaemons, daemonName)
+	daemonTracker.disabledDaemons = append(daemonTracker.disabledDaemons, GetUnitName(daemonName))
 
-	conflict := daemonTracker.FindConflicts()
-	if conflict != nil {
-		return conflict
-	}
+	return daemonTracker.FindConflicts()
+}
+
+func (daemonTracker *DaemonTracker) MaskDaemon(daemonName string) error {
+	daemonTracker.maskedDaemons = append(daemonTracker.maskedDaemons, GetUnitName(daemonName))
+
+	return daemonTracker.FindConflicts()
+}
+
+func (daemonTracker *DaemonTracker) UnmaskDaemon(daemonName string) error {
+	daemonTracker.unmaskedDaemons = append(daemonTracker.unmaskedDaemons, GetUnitName(daemonName))
 
-	return runSystemdCommand("disable", daemonName)
+	return daemonTracker.FindConflicts()
+}
+
+// ChangeUnit records the unit whose file is written, it returns true for the first unit of the scope,
+// so the init system is reloaded only once
+func (daemonTracker *DaemonTracker) ChangeUnit(unitName string) bool {
+	isFirst := len(daemonTracker.changedUnits) == 0
+	daemonTracker.changedUnits = append(daemonTracker.changedUnits, unitName)
+	return isFirst
 }
 
 // FindConflicts returns a boolean indicating if there are any conflicts and a string with more details
 func (daemonTracker *DaemonTracker) FindConflicts() error {
+	daemonKind := "daemon"
+	if daemonTracker.isUserScope {
+		daemonKind = "user daemon"
+	}
+
 	haveMutual, mutualElement := haveMutualElement(daemonTracker.startedDaemons, daemonTracker.stoppedDaemons)
 	if haveMutual {
-		return fmt.Errorf("conflict: trying to start and stop at the same time %s daemon", mutualElement)
+		return fmt.Errorf("conflict: trying to start and stop at the same time %s %s", mutualElement, daemonKind)
 	}
 
 	haveMutual, mutualElement = haveMutualElement(daemonTracker.restartedDaemons, daemonTracker.stoppedDaemons)
 	if haveMutual {
-		return fmt.Errorf("conflict: trying to restart and stop at the same time %s daemon", mutualElement)
+		return fmt.Errorf("conflict: trying to restart and stop at the same time %s %s", mutualElement, daemonKind)
 	}
 
 	haveMutual, mutualElement = haveMutualElement(daemonTracker.enabledDaemons, daemonTracker.disabledDaemons)
 	if haveMutual {
-		return fmt.Errorf("conflict: trying to enable and disable at the same time %s daemon", mutualElement)
+		return fmt.Errorf("conflict: trying to enable and disable at the same time %s %s", mutualElement, daemonKind)
+	}
+
+	haveMutual, mutualElement = haveMutualElement(daemonTracker.maskedDaemons, daemonTracker.unmaskedDaemons)
+	if haveMutual {
+		return fmt.Errorf("conflict: trying to mask and unmask at the same time %s %s", mutualElement, daemonKind)
+	}
+
+	// Masked daemon can't be started or enabled anymore
+	haveMutual, mutualElement = haveMutualElement(daemonTracker.maskedDaemons, daemonTracker.startedDaemons)
+	if haveMutual {
+		return fmt.Errorf("conflict: trying to mask and start at the same time %s %s", mutualElement, daemonKind)
+	}
+
+	haveMutual, mutualElement = haveMutualElement(daemonTracker.maskedDaemons, daemonTracker.restartedDaemons)
+	if haveMutual {
+		return fmt.Errorf("conflict: trying to mask and restart at the same time %s %s", mutualElement, daemonKind)
+	}
+
+	haveMutual, mutualElement = haveMutualElement(daemonTracker.maskedDaemons, daemonTracker.enabledDaemons)
+	if haveMutual {
+		return fmt.Errorf("conflict: trying to mask and enable at the same time %s %s", mutualElement, daemonKind)
 	}
 
 	return nil
 }
 
+// GetUnitName returns the full name of the systemd unit, names without the type suffix are services,
+// so e.g. sshd and sshd.service are the same daemon
+func GetUnitName(daemonName string) string {
+	if !strings.Contains(daemonName, ".") {
+		return daemonName + ".service"
+	}
+	return daemonName
+}
+
 // haveMutualElement returns bool and mutual element if exist
 func haveMutualElement(list1, list2 []string) (bool, string) {
 	// Create a map to store the elements of the first array
@@ -116,13 +167,3 @@ func haveMutualElement(list1, list2 []string) (bool, string) {
 
 	return false, ""
 }
-
-func runSystemdCommand(args ...string) error {
-	cmd := exec.Command("systemctl", args...)
-	output, err := cmd.Output()
-	if err != nil {
-		outputMsgError := errors.New(string(output))
-		return errors.Join(err, outputMsgError)
-	}
-	return nil
-}
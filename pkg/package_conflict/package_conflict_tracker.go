@@ -2,56 +2,202 @@ package package_conflict
 
 import (
 	"fmt"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"slices"
+	"strings"
 )
 
+// PackageRequest is a package which the rule wants to install or remove
+type PackageRequest struct {
+	// Package is the name of the package, required version may follow it after "@" when it's installed
+	Package string
+	Rule    vrctFs.Rule
+}
+
+// Name returns the name of the package without the required version
+func (r PackageRequest) Name() string {
+	name, _, _ := strings.Cut(r.Package, "@")
+	return name
+}
+
+// Version returns the required version of the package, it's empty if any version can be installed
+func (r PackageRequest) Version() string {
+	_, version, _ := strings.Cut(r.Package, "@")
+	return version
+}
+
+// RepositoryRequest is a repository which the rule wants to add or remove
+type RepositoryRequest struct {
+	Name string
+	// Configuration describes the added repository, it's empty if the repository is removed
+	Configuration string
+	Rule          vrctFs.Rule
+}
+
+func (r RepositoryRequest) IsRemoved() bool {
+	return r.Configuration == ""
+}
+
+// KeyRequest is a signing key which the rule wants to import
+type KeyRequest struct {
+	Fingerprint string
+	// Source is a keyserver, an url or a path of the key file
+	Source string
+	Rule   vrctFs.Rule
+}
+
+// PackageConflictTracker collects packages, repositories and keys requested by all the executed rules,
+// they are changed together when changes are applied
 type PackageConflictTracker struct {
-	packagesInstalled map[string]bool
-	packagesRemoved   map[string]bool
+	packagesInstalled map[string]PackageRequest
+	packagesRemoved   map[string]PackageRequest
+	repositories      map[string]RepositoryRequest
+	keys              map[string]KeyRequest
 }
 
 func NewPackageConflictTracker() PackageConflictTracker {
 	return PackageConflictTracker{
-		packagesInstalled: make(map[string]bool),
-		packagesRemoved:   make(map[string]bool),
+		packagesInstalled: make(map[string]PackageRequest),
+		packagesRemoved:   make(map[string]PackageRequest),
+		repositories:      make(map[string]RepositoryRequest),
+		keys:              make(map[string]KeyRequest),
 	}
 }
 
-func (packageTracker PackageConflictTracker) AddPackage(packageName string) error {
+func (packageTracker PackageConflictTracker) AddPackage(packageString string, rule vrctFs.Rule) error {
+	request := PackageRequest{Package: packageString, Rule: rule}
 
-	if _, isPackageUninstalled := packageTracker.packagesRemoved[packageName]; isPackageUninstalled {
-		return fmt.Errorf("[PACKAGE_CONFLICT] the package %s is required to be uninstalled by a dependency", packageName)
+	if _, isPackageUninstalled := packageTracker.packagesRemoved[request.Name()]; isPackageUninstalled {
+		return fmt.Errorf("[PACKAGE_CONFLICT] the package %s is required to be uninstalled by a dependency", request.Name())
+	}
+	if previousRequest, isRequested := packageTracker.packagesInstalled[request.Name()]; isRequested {
+		previousVersion := previousRequest.Version()
+		if previousVersion != "" && request.Version() != "" && previousVersion != request.Version() {
+			return fmt.Errorf("[PACKAGE_CONFLICT] the package %s is required in version %s by a dependency", request.Name(), previousVersion)
+		}
+		// Request without the version doesn't replace the required one
+		if request.Version() == "" {
+			return nil
+		}
 	}
 
-	packageTracker.packagesInstalled[packageName] = true
+	packageTracker.packagesInstalled[request.Name()] = request
 	return nil
 }
 
-func (packageTracker PackageConflictTracker) RemovePackage(packageName string) error {
+func (packageTracker PackageConflictTracker) RemovePackage(packageName string, rule vrctFs.Rule) error {
 
 	if _, isPackageInstalled := packageTracker.packagesInstalled[packageName]; isPackageInstalled {
 		return fmt.Errorf("[PACKAGE_CONFLICT] the package %s is required to be installed by a dependency", packageName)
 	}
 
-	packageTracker.packagesRemoved[packageName] = true
+	packageTracker.packagesRemoved[packageName] = PackageRequest{Package: packageName, Rule: rule}
+	return nil
+}
+
+// AddRepository requests the repository described by the configuration, the same repository
+// can be requested by several rules only if all of them describe it the same way
+func (packageTracker PackageConflictTracker) AddRepository(name string, configuration string, rule vrctFs.Rule) error {
+	if previousRequest, isRequested := packageTracker.repositories[name]; isRequested {
+		if previousRequest.IsRemoved() {
+			return fmt.Errorf("[REPOSITORY_CONFLICT] the repository %s is required to be removed by a dependency", name)
+		}
+		if previousRequest.Configuration != configuration {
+			return fmt.Errorf("[REPOSITORY_CONFLICT] the repository %s is configured differently by a dependency", name)
+		}
+	}
+
+	packageTracker.repositories[name] = RepositoryRequest{Name: name, Configuration: configuration, Rule: rule}
 	return nil
 }
 
+func (packageTracker PackageConflictTracker) RemoveRepository(name string, rule vrctFs.Rule) error {
+	if previousRequest, isRequested := packageTracker.repositories[name]; isRequested && !previousRequest.IsRemoved() {
+		return fmt.Errorf("[REPOSITORY_CONFLICT] the repository %s is required to be added by a dependency", name)
+	}
+
+	packageTracker.repositories[name] = RepositoryRequest{Name: name, Rule: rule}
+	return nil
+}
+
+// ImportKey requests the key, it's imported only once even if more rules request it
+func (packageTracker PackageConflictTracker) ImportKey(fingerprint string, source string, rule vrctFs.Rule) {
+	if _, isRequested := packageTracker.keys[fingerprint]; !isRequested {
+		packageTracker.keys[fingerprint] = KeyRequest{Fingerprint: fingerprint, Source: source, Rule: rule}
+	}
+}
+
+// HasRequests returns true if any package, repository or key is waiting to be changed
+func (packageTracker PackageConflictTracker) HasRequests() bool {
+	return len(packageTracker.packagesInstalled) > 0 || len(packageTracker.packagesRemoved) > 0 ||
+		len(packageTracker.repositories) > 0 || len(packageTracker.keys) > 0
+}
+
 func (packageTracker PackageConflictTracker) GetPackagesToInstall() []string {
-	result := make([]string, len(packageTracker.packagesInstalled))
-	i := 0
-	for packageName := range packageTracker.packagesInstalled {
-		result[i] = packageName
-		i++
+	return getPackages(packageTracker.packagesInstalled)
+}
+
+func (packageTracker PackageConflictTracker) GetPackagesToRemove() []string {
+	return getPackages(packageTracker.packagesRemoved)
+}
+
+// GetInstallRequests returns packages to install sorted by their names
+func (packageTracker PackageConflictTracker) GetInstallRequests() []PackageRequest {
+	return getSortedRequests(packageTracker.packagesInstalled)
+}
+
+// GetRemoveRequests returns packages to remove sorted by their names
+func (packageTracker PackageConflictTracker) GetRemoveRequests() []PackageRequest {
+	return getSortedRequests(packageTracker.packagesRemoved)
+}
+
+// GetRepositoryRequests returns repositories to add or remove sorted by their names
+func (packageTracker PackageConflictTracker) GetRepositoryRequests() []RepositoryRequest {
+	result := make([]RepositoryRequest, 0, len(packageTracker.repositories))
+	for _, request := range packageTracker.repositories {
+		result = append(result, request)
 	}
+	slices.SortFunc(result, func(a, b RepositoryRequest) int {
+		return strings.Compare(a.Name, b.Name)
+	})
 	return result
 }
 
-func (packageTracker PackageConflictTracker) GetPackagesToRemove() []string {
-	result := make([]string, len(packageTracker.packagesRemoved))
-	i := 0
-	for packageName := range packageTracker.packagesRemoved {
-		result[i] = packageName
-		i++
+// GetKeyRequests returns keys to import sorted by their fingerprints
+func (packageTracker PackageConflictTracker) GetKeyRequests() []KeyRequest {
+	result := make([]KeyRequest, 0, len(packageTracker.keys))
+	for _, request := range packageTracker.keys {
+		result = append(result, request)
+	}
+	slices.SortFunc(result, func(a, b KeyRequest) int {
+		return strings.Compare(a.Fingerprint, b.Fingerprint)
+	})
+	return result
+}
+
+// Clear forgets all requests, e.g. after they have been executed
+func (packageTracker PackageConflictTracker) Clear() {
+	clear(packageTracker.packagesInstalled)
+	clear(packageTracker.packagesRemoved)
+	clear(packageTracker.repositories)
+	clear(packageTracker.keys)
+}
+
+func getPackages(requests map[string]PackageRequest) []string {
+	result := make([]string, 0, len(requests))
+	for _, request := range getSortedRequests(requests) {
+		result = append(result, request.Package)
+	}
+	return result
+}
+
+func getSortedRequests(requests map[string]PackageRequest) []PackageRequest {
+	result := make([]PackageRequest, 0, len(requests))
+	for _, request := range requests {
+		result = append(result, request)
 	}
+	slices.SortFunc(result, func(a, b PackageRequest) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
 	return result
 }
@@ -0,0 +1,382 @@
+package privileged
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/userinfo"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+const (
+	pkexecCommand      = "pkexec"
+	HelperCommand      = "helper"
+	helperStartTimeout = 2 * time.Minute
+	helperPollInterval = 100 * time.Millisecond
+)
+
+// Client sends privileged operations to the helper
+type Client struct {
+	SocketPath string
+	// StartHelper is called when nothing listens on the socket yet
+	StartHelper func(socketPath string) error
+}
+
+func NewClient(socketPath string) *Client {
+	return &Client{
+		SocketPath:  socketPath,
+		StartHelper: StartHelperWithPkexec,
+	}
+}
+
+// NewClientIfUnprivileged returns nil when spito is run as root, so it performs privileged operations by itself
+func NewClientIfUnprivileged() *Client {
+	if isRoot, err := userinfo.IsRoot(); isRoot || err != nil {
+		return nil
+	}
+	return NewClient(DefaultSocketPath(os.Getuid()))
+}
+
+func (c *Client) Call(request Request) (Response, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return Response{}, err
+	}
+
+	var response Response
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return Response{}, fmt.Errorf("invalid response of the privileged helper: %w", err)
+	}
+	if response.Error != "" {
+		return response, errors.New(response.Error)
+	}
+	return response, nil
+}
+
+func (c *Client) connect() (net.Conn, error) {
+	conn, err := net.Dial("unix", c.SocketPath)
+	if err == nil || c.StartHelper == nil {
+		return conn, err
+	}
+
+	if err := c.StartHelper(c.SocketPath); err != nil {
+		return nil, err
+	}
+	return net.Dial("unix", c.SocketPath)
+}
+
+// ApplyVRCT implements vrct.Applier
+func (c *Client) ApplyVRCT(
+	mergedFiles []vrctFs.MergedFile,
+	root string,
+	rulesHistory []vrctFs.Rule,
+	packageChanges []vrctFs.PackageChange,
+	daemonChanges []vrctFs.DaemonChange,
+	unitChanges []vrctFs.UnitChange,
+	appliedRevertNum *int,
+) (int, []string, error) {
+	// Ensure the directory exists and is owned by the user, the helper only adds its revert steps there
+	if _, err := vrctFs.GetSerializedRevertStepsDir(); err != nil {
+		return 0, nil, err
+	}
+
+	request := Request{
+		Operation:      OperationApply,
+		Rules:          rulesHistory,
+		Root:           root,
+		Files:          mergedFiles,
+		PackageChanges: packageChanges,
+		DaemonChanges:  daemonChanges,
+		UnitChanges:    unitChanges,
+	}
+	if appliedRevertNum != nil {
+		request.RevertNumber, request.Continues = *appliedRevertNum, true
+	}
+
+	response, err := c.Call(request)
+	return response.RevertNumber, response.ChangedFiles, err
+}
+
+// AddDaemonChanges implements vrct.Applier
+func (c *Client) AddDaemonChanges(
+	revertNum int, root string, rulesHistory []vrctFs.Rule, daemonChanges []vrctFs.DaemonChange,
+) error {
+	_, err := c.Call(Request{
+		Operation:     OperationAddDaemonChanges,
+		Rules:         rulesHistory,
+		Root:          root,
+		RevertNumber:  revertNum,
+		DaemonChanges: daemonChanges,
+	})
+	return err
+}
+
+// RevertApplied implements vrct.Applier
+func (c *Client) RevertApplied(revertNum int) error {
+	_, err := c.RevertFiles(revertNum)
+	return err
+}
+
+// RevertFiles restores files changed by the helper, returned response contains rules, package, daemon and unit changes,
+// which have to be reverted
+func (c *Client) RevertFiles(revertNum int) (Response, error) {
+	return c.Call(Request{
+		Operation:    OperationRevert,
+		RevertNumber: revertNum,
+	})
+}
+
+// StartHelperWithPkexec asks the user for authorization and waits until the helper listens on the socket
+func StartHelperWithPkexec(socketPath string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// pkexec doesn't pass the environment, so AUR configuration is passed as flags.
+	// The helper listens on the default socket of the user who has started it
+	argv := []string{executable, HelperCommand}
+	if aurHelper := os.Getenv(api.AurHelperEnv); aurHelper != "" {
+		argv = append(argv, "--aur-helper", aurHelper)
+	}
+	if reviewCommand := os.Getenv(api.AurReviewCommandEnv); reviewCommand != "" {
+		argv = append(argv, "--aur-review-command", reviewCommand)
+	}
+
+	command := exec.Command(pkexecCommand, argv...)
+	command.Stdin = os.Stdin
+	command.Stderr = os.Stderr
+	if err := command.Start(); err != nil {
+		return fmt.Errorf("cannot start the privileged helper with %s: %w", pkexecCommand, err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- command.Wait()
+	}()
+
+	timeout := time.After(helperStartTimeout)
+	for {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("the privileged helper has exited: %v", err)
+		case <-timeout:
+			return errors.New("the privileged helper hasn't started in time")
+		case <-time.After(helperPollInterval):
+			if conn, err := net.Dial("unix", socketPath); err == nil {
+				return conn.Close()
+			}
+		}
+	}
+}
+
+// RuleBackend implements api.PackageManager and api.InitManager for the rule executed by a regular user.
+// Queries are answered by the given backends, changes are performed by the helper
+type RuleBackend struct {
+	Client *Client
+	Rule   vrctFs.Rule
+	Root   string
+	// Permissions declared by the rule are checked before asking the helper, so it isn't started for nothing
+	Permissions    []shared.Permission
+	PackageManager api.PackageManager
+	InitManager    api.InitManager
+}
+
+func (b RuleBackend) GetPackage(name string) (api.Package, error) {
+	return b.PackageManager.GetPackage(name)
+}
+
+func (b RuleBackend) ListInstalledPackages(filter api.PackageFilter) ([]api.Package, error) {
+	return b.PackageManager.ListInstalledPackages(filter)
+}
+
+func (b RuleBackend) SearchPackages(term string) ([]api.Package, error) {
+	return b.PackageManager.SearchPackages(term)
+}
+
+func (b RuleBackend) GetFileOwner(filePath string) (api.Package, error) {
+	return b.PackageManager.GetFileOwner(filePath)
+}
+
+func (b RuleBackend) GetPackageFiles(name string) ([]string, error) {
+	return b.PackageManager.GetPackageFiles(name)
+}
+
+func (b RuleBackend) GetAvailableUpdates() ([]api.Package, error) {
+	return b.PackageManager.GetAvailableUpdates()
+}
+
+func (b RuleBackend) InstallPackages(packages ...string) error {
+	return b.call(OperationInstallPackages, packages...)
+}
+
+func (b RuleBackend) RemovePackages(packages ...string) error {
+	return b.call(OperationRemovePackages, packages...)
+}
+
+func (b RuleBackend) InstallPackageVersion(name string, version string) error {
+	return b.call(OperationInstallPackageVersion, name, version)
+}
+
+func (b RuleBackend) HasKey(fingerprint string) (bool, error) {
+	return b.PackageManager.HasKey(fingerprint)
+}
+
+func (b RuleBackend) ImportKey(fingerprint string, source string) error {
+	if source == "" {
+		return b.call(OperationImportKey, fingerprint)
+	}
+	return b.call(OperationImportKey, fingerprint, source)
+}
+
+func (b RuleBackend) RemoveKey(fingerprint string) error {
+	return b.call(OperationRemoveKey, fingerprint)
+}
+
+func (b RuleBackend) SyncDatabases(repositories ...string) error {
+	return b.call(OperationSyncDatabases, repositories...)
+}
+
+func (b RuleBackend) GetDaemon(daemonName string) (api.Daemon, error) {
+	return b.InitManager.GetDaemon(daemonName)
+}
+
+func (b RuleBackend) StartDaemon(daemonName string) error {
+	return b.call(OperationStartDaemon, daemonName)
+}
+
+func (b RuleBackend) StopDaemon(daemonName string) error {
+	return b.call(OperationStopDaemon, daemonName)
+}
+
+func (b RuleBackend) RestartDaemon(daemonName string) error {
+	return b.call(OperationRestartDaemon, daemonName)
+}
+
+func (b RuleBackend) EnableDaemon(daemonName string) error {
+	return b.call(OperationEnableDaemon, daemonName)
+}
+
+func (b RuleBackend) DisableDaemon(daemonName string) error {
+	return b.call(OperationDisableDaemon, daemonName)
+}
+
+func (b RuleBackend) MaskDaemon(daemonName string) error {
+	return b.call(OperationMaskDaemon, daemonName)
+}
+
+func (b RuleBackend) UnmaskDaemon(daemonName string) error {
+	return b.call(OperationUnmaskDaemon, daemonName)
+}
+
+func (b RuleBackend) ReloadDaemons() error {
+	return b.call(OperationReloadDaemons)
+}
+
+// CheckPermission returns an error if the rule doesn't declare the permission
+func (b RuleBackend) CheckPermission(permission shared.Permission) error {
+	if !slices.Contains(b.Permissions, permission) {
+		return newPermissionError(b.Rule, permission)
+	}
+	return nil
+}
+
+func (b RuleBackend) call(operation Operation, arguments ...string) error {
+	if err := b.CheckPermission(requiredPermissions[operation]); err != nil {
+		return err
+	}
+
+	_, err := b.Client.Call(Request{
+		Operation: operation,
+		Rules:     []vrctFs.Rule{b.Rule},
+		Root:      b.Root,
+		Arguments: arguments,
+	})
+	return err
+}
+
+// TransactionBackend implements api.PackageManager for packages requested by several rules,
+// they are installed or removed by a single request and the helper checks permissions of all the rules
+type TransactionBackend struct {
+	Client         *Client
+	Rules          []vrctFs.Rule
+	Root           string
+	PackageManager api.PackageManager
+}
+
+func (b TransactionBackend) GetPackage(name string) (api.Package, error) {
+	return b.PackageManager.GetPackage(name)
+}
+
+func (b TransactionBackend) ListInstalledPackages(filter api.PackageFilter) ([]api.Package, error) {
+	return b.PackageManager.ListInstalledPackages(filter)
+}
+
+func (b TransactionBackend) SearchPackages(term string) ([]api.Package, error) {
+	return b.PackageManager.SearchPackages(term)
+}
+
+func (b TransactionBackend) GetFileOwner(filePath string) (api.Package, error) {
+	return b.PackageManager.GetFileOwner(filePath)
+}
+
+func (b TransactionBackend) GetPackageFiles(name string) ([]string, error) {
+	return b.PackageManager.GetPackageFiles(name)
+}
+
+func (b TransactionBackend) GetAvailableUpdates() ([]api.Package, error) {
+	return b.PackageManager.GetAvailableUpdates()
+}
+
+func (b TransactionBackend) InstallPackages(packages ...string) error {
+	return b.call(OperationInstallPackages, packages...)
+}
+
+func (b TransactionBackend) RemovePackages(packages ...string) error {
+	return b.call(OperationRemovePackages, packages...)
+}
+
+func (b TransactionBackend) InstallPackageVersion(name string, version string) error {
+	return b.call(OperationInstallPackageVersion, name, version)
+}
+
+func (b TransactionBackend) HasKey(fingerprint string) (bool, error) {
+	return b.PackageManager.HasKey(fingerprint)
+}
+
+func (b TransactionBackend) ImportKey(fingerprint string, source string) error {
+	if source == "" {
+		return b.call(OperationImportKey, fingerprint)
+	}
+	return b.call(OperationImportKey, fingerprint, source)
+}
+
+func (b TransactionBackend) RemoveKey(fingerprint string) error {
+	return b.call(OperationRemoveKey, fingerprint)
+}
+
+func (b TransactionBackend) SyncDatabases(repositories ...string) error {
+	return b.call(OperationSyncDatabases, repositories...)
+}
+
+func (b TransactionBackend) call(operation Operation, arguments ...string) error {
+	_, err := b.Client.Call(Request{
+		Operation: operation,
+		Rules:     b.Rules,
+		Root:      b.Root,
+		Arguments: arguments,
+	})
+	return err
+}
@@ -0,0 +1,482 @@
+package privileged
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/userinfo"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	SocketDirectory = "/run/spito"
+	// StagingDirectory keeps applied files until they are moved to the real filesystem, only root can access it
+	StagingDirectory = "/run/spito/staging"
+	// socketUmask creates the socket accessible only by its owner, before anybody can connect to it
+	socketUmask        = 0177
+	requestReadTimeout = 30 * time.Second
+	// revertStepsPermissions keep backups of privileged files readable only by root
+	revertStepsPermissions = 0600
+)
+
+// PermissionResolver returns permissions declared by the rule, it reads them from the ruleset verified by the helper
+// instead of trusting the rule evaluator
+type PermissionResolver func(rule vrctFs.Rule) ([]shared.Permission, error)
+
+// Helper runs with root privileges and performs only operations, which rules executed
+// by the unprivileged rule evaluator are allowed to ask for
+type Helper struct {
+	// AllowedUid is the user whose requests are accepted besides root
+	AllowedUid         int
+	ResolvePermissions PermissionResolver
+	// StagingDirectory is the directory in which every apply stages its files in a new subdirectory
+	StagingDirectory string
+	// NewBackends returns package and init managers managing the system mounted in the root directory
+	NewBackends func(root string) (api.PackageManager, api.InitManager)
+	// IdleTimeout stops Serve when no request has been received for this time, zero means never
+	IdleTimeout time.Duration
+	InfoApi     shared.InfoInterface
+
+	// resolvedPermissions are resolved once per rule, because the ruleset is fetched to resolve them
+	resolvedPermissions map[vrctFs.Rule][]shared.Permission
+}
+
+func DefaultSocketPath(uid int) string {
+	return filepath.Join(SocketDirectory, fmt.Sprintf("helper-%d.sock", uid))
+}
+
+// Listen creates the socket, which can be opened only by the given user and root
+func Listen(socketPath string, uid int) (*net.UnixListener, error) {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	previousUmask := syscall.Umask(socketUmask)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	syscall.Umask(previousUmask)
+	if err != nil {
+		return nil, err
+	}
+	if os.Geteuid() == userinfo.RootEuid {
+		if err := os.Chown(socketPath, uid, -1); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// Serve handles requests one at a time until the listener is closed or the idle timeout passes
+func (h *Helper) Serve(listener *net.UnixListener) error {
+	for {
+		if h.IdleTimeout > 0 {
+			if err := listener.SetDeadline(time.Now().Add(h.IdleTimeout)); err != nil {
+				return err
+			}
+		}
+
+		conn, err := listener.AcceptUnix()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		h.handleConnection(conn)
+	}
+}
+
+func (h *Helper) handleConnection(conn *net.UnixConn) {
+	defer conn.Close()
+
+	var response Response
+	var request Request
+	_ = conn.SetReadDeadline(time.Now().Add(requestReadTimeout))
+
+	uid, err := getPeerUid(conn)
+	if err == nil && uid != h.AllowedUid && uid != userinfo.RootEuid {
+		err = fmt.Errorf("user %d is not allowed to use the helper", uid)
+	}
+	if err == nil {
+		err = json.NewDecoder(conn).Decode(&request)
+	}
+
+	if err != nil {
+		response = errorResponse(err)
+	} else {
+		response = h.Execute(request)
+	}
+
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		h.InfoApi.Error("cannot send response of the privileged helper:", err.Error())
+	}
+}
+
+// Execute validates the request against permissions of its rules and performs it
+func (h *Helper) Execute(request Request) Response {
+	if err := request.validate(); err != nil {
+		return errorResponse(err)
+	}
+	if err := h.checkPermissions(request); err != nil {
+		return errorResponse(err)
+	}
+	h.InfoApi.Debug("Executing privileged operation", string(request.Operation), request.Arguments)
+
+	response, err := h.execute(request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return response
+}
+
+func (h *Helper) execute(request Request) (Response, error) {
+	switch request.Operation {
+	case OperationApply:
+		return h.applyVRCT(request)
+	case OperationRevert:
+		return revertFiles(request.RevertNumber)
+	case OperationAddDaemonChanges:
+		return Response{}, h.addDaemonChanges(request)
+	}
+
+	packageManager, initManager := h.NewBackends(request.Root)
+	var operationFn func(name string) error
+
+	switch request.Operation {
+	case OperationInstallPackages:
+		return Response{}, packageManager.InstallPackages(request.Arguments...)
+	case OperationRemovePackages:
+		return Response{}, packageManager.RemovePackages(request.Arguments...)
+	case OperationInstallPackageVersion:
+		return Response{}, packageManager.InstallPackageVersion(request.Arguments[0], request.Arguments[1])
+	case OperationImportKey:
+		var source string
+		if len(request.Arguments) == 2 {
+			source = request.Arguments[1]
+		}
+		return Response{}, packageManager.ImportKey(request.Arguments[0], source)
+	case OperationRemoveKey:
+		return Response{}, packageManager.RemoveKey(request.Arguments[0])
+	case OperationSyncDatabases:
+		return Response{}, packageManager.SyncDatabases(request.Arguments...)
+	case OperationReloadDaemons:
+		return Response{}, initManager.ReloadDaemons()
+	case OperationStartDaemon:
+		operationFn = initManager.StartDaemon
+	case OperationStopDaemon:
+		operationFn = initManager.StopDaemon
+	case OperationRestartDaemon:
+		operationFn = initManager.RestartDaemon
+	case OperationEnableDaemon:
+		operationFn = initManager.EnableDaemon
+	case OperationDisableDaemon:
+		operationFn = initManager.DisableDaemon
+	case OperationMaskDaemon:
+		operationFn = initManager.MaskDaemon
+	case OperationUnmaskDaemon:
+		operationFn = initManager.UnmaskDaemon
+	}
+
+	for _, daemonName := range request.Arguments {
+		if err := operationFn(daemonName); err != nil {
+			return Response{}, err
+		}
+	}
+	return Response{}, nil
+}
+
+// checkPermissions requires every rule to declare the permission. Changes of all rules executed together
+// are applied at once, so each applied file and daemon change is checked against the rules which have made it.
+// Revert is allowed only for changes applied by the helper, so they were checked already
+func (h *Helper) checkPermissions(request Request) error {
+	switch request.Operation {
+	case OperationRevert:
+		return nil
+	case OperationApply, OperationAddDaemonChanges:
+		return h.checkChangePermissions(request)
+	}
+
+	permission := requiredPermissions[request.Operation]
+	for _, rule := range request.Rules {
+		if err := h.checkPermission(rule, permission); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkChangePermissions requires the files permission from every rule which has created the applied file,
+// units of the system scope can be created with the daemons permission instead. Daemon changes require
+// the daemons permission from the rule which has changed the daemon
+func (h *Helper) checkChangePermissions(request Request) error {
+	var filePaths []string
+	for _, file := range request.Files {
+		if file.IsDir {
+			continue
+		}
+		if len(file.Rules) == 0 {
+			return fmt.Errorf("applied file %s wasn't created by any rule", file.Path)
+		}
+		for _, rule := range file.Rules {
+			err := h.checkChangePermission(request, rule, shared.PermissionFiles)
+			if err != nil && api.IsSystemUnitPath(filepath.Join("/", file.Path)) {
+				err = h.checkChangePermission(request, rule, shared.PermissionDaemons)
+			}
+			if err != nil {
+				return fmt.Errorf("cannot apply %s: %w", file.Path, err)
+			}
+		}
+		filePaths = append(filePaths, file.Path)
+	}
+
+	// Directories are created only for the applied files
+	for _, file := range request.Files {
+		isParent := slices.ContainsFunc(filePaths, func(filePath string) bool {
+			return strings.HasPrefix(filePath, file.Path+"/")
+		})
+		if file.IsDir && !isParent {
+			return fmt.Errorf("applied directory %s doesn't contain any applied file", file.Path)
+		}
+	}
+
+	for _, change := range request.DaemonChanges {
+		if err := h.checkChangePermission(request, change.Rule, shared.PermissionDaemons); err != nil {
+			return fmt.Errorf("cannot save the state of the daemon %s: %w", change.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkChangePermission checks the rule which has made the change, it has to be one of the request's rules
+func (h *Helper) checkChangePermission(request Request, rule vrctFs.Rule, permission shared.Permission) error {
+	if !slices.Contains(request.Rules, rule) {
+		return fmt.Errorf("rule %s wasn't executed by the request", describeRule(rule))
+	}
+	return h.checkPermission(rule, permission)
+}
+
+func (h *Helper) checkPermission(rule vrctFs.Rule, permission shared.Permission) error {
+	permissions, err := h.resolvePermissions(rule)
+	if err != nil {
+		return fmt.Errorf("cannot read permissions of the rule %s: %w", describeRule(rule), err)
+	}
+	if !slices.Contains(permissions, permission) {
+		return newPermissionError(rule, permission)
+	}
+	return nil
+}
+
+func (h *Helper) resolvePermissions(rule vrctFs.Rule) ([]shared.Permission, error) {
+	if permissions, ok := h.resolvedPermissions[rule]; ok {
+		return permissions, nil
+	}
+
+	permissions, err := h.ResolvePermissions(rule)
+	if err != nil {
+		return nil, err
+	}
+	if h.resolvedPermissions == nil {
+		h.resolvedPermissions = map[vrctFs.Rule][]shared.Permission{}
+	}
+	h.resolvedPermissions[rule] = permissions
+	return permissions, nil
+}
+
+// applyVRCT stages the files merged by the rule evaluator in a new directory of the helper, so the request
+// cannot point it to files prepared by anybody else, and applies them
+func (h *Helper) applyVRCT(request Request) (Response, error) {
+	if err := os.MkdirAll(h.StagingDirectory, 0700); err != nil {
+		return Response{}, err
+	}
+	stagingDir, err := os.MkdirTemp(h.StagingDirectory, "apply-")
+	if err != nil {
+		return Response{}, err
+	}
+	defer func() {
+		_ = os.RemoveAll(stagingDir)
+	}()
+
+	fsVRCT, err := vrctFs.NewStagingFsVRCT(request.Root, stagingDir)
+	if err != nil {
+		return Response{}, err
+	}
+	if request.Continues {
+		if err := continueRevertSteps(&fsVRCT, request.RevertNumber); err != nil {
+			return Response{}, err
+		}
+	}
+
+	for _, change := range request.PackageChanges {
+		fsVRCT.RecordPackageChange(change)
+	}
+	for _, change := range request.DaemonChanges {
+		fsVRCT.RecordDaemonChange(change)
+	}
+	for _, change := range request.UnitChanges {
+		fsVRCT.RecordUnitChange(change)
+	}
+
+	revertNum, err := fsVRCT.ApplyMergedFiles(request.Files, stagingDir, request.Rules)
+	if err != nil {
+		return Response{}, errors.Join(err, fsVRCT.RevertFiles())
+	}
+
+	revertStepsPath, err := vrctFs.GetSerializedRevertStepsPath(revertNum)
+	if err != nil {
+		return Response{}, err
+	}
+	if err := os.Chmod(revertStepsPath, revertStepsPermissions); err != nil {
+		return Response{}, err
+	}
+
+	return Response{
+		RevertNumber: revertNum,
+		ChangedFiles: fsVRCT.ChangedFiles(),
+	}, nil
+}
+
+// continueRevertSteps adds the applied files to the revert steps of the files applied by the helper before,
+// e.g. configuration of the package manager applied before packages are installed
+func continueRevertSteps(fsVRCT *vrctFs.VRCTFs, revertNum int) error {
+	isPrivileged, err := IsRevertPrivileged(revertNum)
+	if err != nil {
+		return err
+	}
+	if !isPrivileged {
+		return fmt.Errorf("changes number %d weren't applied by the privileged helper", revertNum)
+	}
+	return fsVRCT.ContinueRevertSteps(revertNum)
+}
+
+// addDaemonChanges saves states of the daemons in the revert steps applied by the helper,
+// they are unpacked in its staging directory like applied files
+func (h *Helper) addDaemonChanges(request Request) error {
+	isPrivileged, err := IsRevertPrivileged(request.RevertNumber)
+	if err != nil {
+		return err
+	}
+	if !isPrivileged {
+		return fmt.Errorf("changes number %d weren't applied by the privileged helper", request.RevertNumber)
+	}
+
+	if err := os.MkdirAll(h.StagingDirectory, 0700); err != nil {
+		return err
+	}
+	stagingDir, err := os.MkdirTemp(h.StagingDirectory, "daemons-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(stagingDir)
+	}()
+
+	revertSteps := vrctFs.RevertSteps{RevertTempDir: filepath.Join(stagingDir, "revert")}
+	if err := revertSteps.AddSerializedDaemonChanges(request.RevertNumber, request.DaemonChanges); err != nil {
+		return err
+	}
+
+	revertStepsPath, err := vrctFs.GetSerializedRevertStepsPath(request.RevertNumber)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(revertStepsPath, revertStepsPermissions)
+}
+
+func revertFiles(revertNum int) (Response, error) {
+	isPrivileged, err := IsRevertPrivileged(revertNum)
+	if err != nil {
+		return Response{}, err
+	}
+	if !isPrivileged {
+		return Response{}, fmt.Errorf("changes number %d weren't applied by the privileged helper", revertNum)
+	}
+
+	revertSteps, err := vrctFs.NewRevertSteps()
+	if err != nil {
+		return Response{}, err
+	}
+	defer func() {
+		_ = revertSteps.DeleteRuntimeTemp()
+	}()
+
+	if err := revertSteps.Deserialize(revertNum); err != nil {
+		return Response{}, err
+	}
+	if err := revertSteps.RevertFiles(); err != nil {
+		return Response{}, err
+	}
+
+	return Response{
+		RevertNumber:   revertNum,
+		ChangedFiles:   revertSteps.ChangedFiles(),
+		Rules:          revertSteps.RulesToRevert,
+		Root:           revertSteps.Root,
+		PackageChanges: revertSteps.PackageChanges,
+		DaemonChanges:  revertSteps.DaemonChanges,
+		UnitChanges:    revertSteps.UnitChanges,
+	}, nil
+}
+
+// IsRevertPrivileged returns true if the changes were applied by the helper, so only it can revert them
+func IsRevertPrivileged(revertNum int) (bool, error) {
+	revertStepsPath, err := vrctFs.GetSerializedRevertStepsPath(revertNum)
+	if err != nil {
+		return false, err
+	}
+
+	fileInfo, err := os.Stat(revertStepsPath)
+	if err != nil {
+		return false, err
+	}
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	return ok && stat.Uid == userinfo.RootEuid, nil
+}
+
+func getPeerUid(conn *net.UnixConn) (int, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var credentials *syscall.Ucred
+	var credentialsErr error
+	err = rawConn.Control(func(fd uintptr) {
+		credentials, credentialsErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if credentialsErr != nil {
+		return 0, credentialsErr
+	}
+	return int(credentials.Uid), nil
+}
+
+func errorResponse(err error) Response {
+	return Response{Error: err.Error()}
+}
+
+func newPermissionError(rule vrctFs.Rule, permission shared.Permission) error {
+	return fmt.Errorf("rule %s doesn't declare '%s' permission", describeRule(rule), permission)
+}
+
+func describeRule(rule vrctFs.Rule) string {
+	if rule.IsScript {
+		return "from " + rule.Url
+	}
+	return rule.Url + "@" + rule.NameOrScript
+}
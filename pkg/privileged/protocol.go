@@ -0,0 +1,130 @@
+package privileged
+
+import (
+	"fmt"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"path/filepath"
+)
+
+// Operation is performed by the helper with root privileges
+type Operation string
+
+const (
+	OperationApply           Operation = "apply"
+	OperationRevert          Operation = "revert"
+	OperationInstallPackages Operation = "installPackages"
+	OperationRemovePackages  Operation = "removePackages"
+	// OperationInstallPackageVersion takes the package name and its version as arguments
+	OperationInstallPackageVersion Operation = "installPackageVersion"
+	// OperationImportKey takes the key fingerprint and optionally its source
+	OperationImportKey     Operation = "importKey"
+	OperationRemoveKey     Operation = "removeKey"
+	OperationSyncDatabases Operation = "syncDatabases"
+	OperationStartDaemon   Operation = "startDaemon"
+	OperationStopDaemon    Operation = "stopDaemon"
+	OperationRestartDaemon Operation = "restartDaemon"
+	OperationEnableDaemon  Operation = "enableDaemon"
+	OperationDisableDaemon Operation = "disableDaemon"
+	OperationMaskDaemon    Operation = "maskDaemon"
+	OperationUnmaskDaemon  Operation = "unmaskDaemon"
+	// OperationReloadDaemons makes the init system read units written by the rules
+	OperationReloadDaemons Operation = "reloadDaemons"
+	// OperationAddDaemonChanges saves states of daemons changed after apply in the revert steps of the applied changes
+	OperationAddDaemonChanges Operation = "addDaemonChanges"
+)
+
+// requiredPermissions maps operations to the permission, which the rule has to declare to ask for them
+var requiredPermissions = map[Operation]shared.Permission{
+	OperationApply:                 shared.PermissionFiles,
+	OperationInstallPackages:       shared.PermissionPackages,
+	OperationRemovePackages:        shared.PermissionPackages,
+	OperationInstallPackageVersion: shared.PermissionPackages,
+	OperationImportKey:             shared.PermissionPackages,
+	OperationRemoveKey:             shared.PermissionPackages,
+	OperationSyncDatabases:         shared.PermissionPackages,
+	OperationStartDaemon:           shared.PermissionDaemons,
+	OperationStopDaemon:            shared.PermissionDaemons,
+	OperationRestartDaemon:         shared.PermissionDaemons,
+	OperationEnableDaemon:          shared.PermissionDaemons,
+	OperationDisableDaemon:         shared.PermissionDaemons,
+	OperationMaskDaemon:            shared.PermissionDaemons,
+	OperationUnmaskDaemon:          shared.PermissionDaemons,
+	OperationReloadDaemons:         shared.PermissionDaemons,
+	OperationAddDaemonChanges:      shared.PermissionDaemons,
+}
+
+// Request is sent by the rule evaluator as a single json object per connection
+type Request struct {
+	Operation Operation `json:"operation"`
+	// Rules asked for the operation, the helper checks their permissions by itself
+	Rules []vrctFs.Rule `json:"rules"`
+	// Root is the directory treated as "/" of the managed system
+	Root string `json:"root"`
+	// Arguments are names of packages, repositories or daemons and fingerprints of keys
+	Arguments []string `json:"arguments,omitempty"`
+	// Files are applied changes merged by the rule evaluator, the helper stages them in its own directory
+	Files        []vrctFs.MergedFile `json:"files,omitempty"`
+	RevertNumber int                 `json:"revertNumber,omitempty"`
+	// Continues is set when the applied files are added to the revert steps of the files applied before with RevertNumber
+	Continues bool `json:"continues,omitempty"`
+	// PackageChanges, DaemonChanges and UnitChanges are saved in the revert steps of applied changes
+	PackageChanges []vrctFs.PackageChange `json:"packageChanges,omitempty"`
+	DaemonChanges  []vrctFs.DaemonChange  `json:"daemonChanges,omitempty"`
+	UnitChanges    []vrctFs.UnitChange    `json:"unitChanges,omitempty"`
+}
+
+// Response is returned for every request, Error is empty if the operation has succeeded
+type Response struct {
+	Error        string   `json:"error,omitempty"`
+	RevertNumber int      `json:"revertNumber,omitempty"`
+	ChangedFiles []string `json:"changedFiles,omitempty"`
+	// Rules, Root, PackageChanges, DaemonChanges and UnitChanges describe reverted changes
+	Rules          []vrctFs.Rule          `json:"rules,omitempty"`
+	Root           string                 `json:"root,omitempty"`
+	PackageChanges []vrctFs.PackageChange `json:"packageChanges,omitempty"`
+	DaemonChanges  []vrctFs.DaemonChange  `json:"daemonChanges,omitempty"`
+	UnitChanges    []vrctFs.UnitChange    `json:"unitChanges,omitempty"`
+}
+
+// validate checks the request before permissions of its rules are resolved
+func (r Request) validate() error {
+	if r.Operation == OperationRevert {
+		// Root and rules are read from the revert steps
+		return nil
+	}
+
+	if _, ok := requiredPermissions[r.Operation]; !ok {
+		return fmt.Errorf("unknown operation: %s", r.Operation)
+	}
+	if !filepath.IsAbs(r.Root) {
+		return fmt.Errorf("root has to be an absolute path, got: '%s'", r.Root)
+	}
+	if len(r.Rules) == 0 {
+		return fmt.Errorf("operation %s has to be requested by a rule", r.Operation)
+	}
+	for _, rule := range r.Rules {
+		if rule.IsScript {
+			return fmt.Errorf("operation %s cannot be requested by a rule file, only by rules of rulesets", r.Operation)
+		}
+	}
+	if r.Operation == OperationInstallPackageVersion && len(r.Arguments) != 2 {
+		return fmt.Errorf("operation %s takes the package name and its version", r.Operation)
+	}
+	if r.Operation == OperationImportKey && (len(r.Arguments) == 0 || len(r.Arguments) > 2) {
+		return fmt.Errorf("operation %s takes the key fingerprint and optionally its source", r.Operation)
+	}
+	if (r.Operation == OperationReloadDaemons || r.Operation == OperationAddDaemonChanges) && len(r.Arguments) != 0 {
+		return fmt.Errorf("operation %s takes no arguments", r.Operation)
+	}
+	if r.Operation == OperationRemoveKey && len(r.Arguments) != 1 {
+		return fmt.Errorf("operation %s takes a single argument", r.Operation)
+	}
+
+	for _, argument := range r.Arguments {
+		if argument == "" || argument[0] == '-' {
+			return fmt.Errorf("invalid argument: '%s'", argument)
+		}
+	}
+	return nil
+}
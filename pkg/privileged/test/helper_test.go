@@ -0,0 +1,304 @@
+package test
+
+import (
+	"github.com/avorty/spito/cmd/cmdApi"
+	"github.com/avorty/spito/internal/tester"
+	"github.com/avorty/spito/pkg/api"
+	"github.com/avorty/spito/pkg/privileged"
+	"github.com/avorty/spito/pkg/shared"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var editorRule = vrctFs.Rule{Url: "helper-ruleset", NameOrScript: "editor"}
+var desktopRule = vrctFs.Rule{Url: "helper-ruleset", NameOrScript: "desktop"}
+var configRule = vrctFs.Rule{Url: "helper-ruleset", NameOrScript: "config"}
+
+// declaredPermissions are read by the helper, the rule evaluator can't change them
+var declaredPermissions = map[string][]shared.Permission{
+	"editor":  {shared.PermissionPackages},
+	"desktop": {shared.PermissionPackages, shared.PermissionDaemons},
+	"config":  {shared.PermissionFiles},
+}
+
+func startHelper(t *testing.T) (*privileged.Client, *tester.FakePackageManager, *tester.FakeInitManager) {
+	socketPath := filepath.Join(t.TempDir(), "helper.sock")
+	listener, err := privileged.Listen(socketPath, os.Getuid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	packageManager, initManager := tester.NewFakePackageManager(), tester.NewFakeInitManager()
+	initManager.Daemons["sddm"] = api.Daemon{Name: "sddm"}
+	initManager.Daemons["sshd"] = api.Daemon{Name: "sshd"}
+	helper := privileged.Helper{
+		AllowedUid: os.Getuid(),
+		ResolvePermissions: func(rule vrctFs.Rule) ([]shared.Permission, error) {
+			return declaredPermissions[rule.NameOrScript], nil
+		},
+		StagingDirectory: t.TempDir(),
+		NewBackends: func(root string) (api.PackageManager, api.InitManager) {
+			return packageManager, initManager
+		},
+		InfoApi: cmdApi.InfoApi{},
+	}
+	go func() {
+		_ = helper.Serve(listener)
+	}()
+
+	return &privileged.Client{SocketPath: socketPath}, packageManager, initManager
+}
+
+func newRuleBackend(client *privileged.Client, rule vrctFs.Rule) privileged.RuleBackend {
+	return privileged.RuleBackend{
+		Client: client,
+		Rule:   rule,
+		Root:   "/",
+		// The rule evaluator may be compromised by the rule, so it claims every permission
+		Permissions:    shared.AllPermissions,
+		PackageManager: tester.NewFakePackageManager(),
+		InitManager:    tester.NewFakeInitManager(),
+	}
+}
+
+func TestHelperPerformsPermittedOperations(t *testing.T) {
+	client, packageManager, initManager := startHelper(t)
+
+	if err := newRuleBackend(client, editorRule).InstallPackages("neovim"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := packageManager.GetPackage("neovim"); err != nil {
+		t.Fatal("package should be installed by the helper:", err)
+	}
+
+	if err := newRuleBackend(client, desktopRule).EnableDaemon("sddm"); err != nil {
+		t.Fatal(err)
+	}
+	if daemon, err := initManager.GetDaemon("sddm"); err != nil || !daemon.IsEnabled {
+		t.Fatalf("daemon should be enabled by the helper: %+v, %v", daemon, err)
+	}
+}
+
+func TestHelperRejectsUndeclaredOperations(t *testing.T) {
+	client, _, initManager := startHelper(t)
+
+	err := newRuleBackend(client, editorRule).StartDaemon("sshd")
+	if err == nil || !strings.Contains(err.Error(), "doesn't declare 'daemons' permission") {
+		t.Fatalf("starting daemon without permission should fail, got: %v", err)
+	}
+	if daemon, _ := initManager.GetDaemon("sshd"); daemon.IsActive {
+		t.Fatal("daemon shouldn't be started")
+	}
+
+	err = newRuleBackend(client, desktopRule).InstallPackages("--overwrite=*")
+	if err == nil || !strings.Contains(err.Error(), "invalid argument") {
+		t.Fatalf("options shouldn't be passed to the package manager, got: %v", err)
+	}
+
+	files := []vrctFs.MergedFile{{Path: "etc/editor.conf", Content: []byte("editor=nvim\n"), Rules: []vrctFs.Rule{editorRule}}}
+	_, _, err = client.ApplyVRCT(files, t.TempDir(), []vrctFs.Rule{editorRule}, nil, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "doesn't declare 'files' permission") {
+		t.Fatalf("applying changes without permission should fail, got: %v", err)
+	}
+}
+
+func TestHelperChecksRulesOfEachAppliedFile(t *testing.T) {
+	client, _, _ := startHelper(t)
+	root := t.TempDir()
+	rules := []vrctFs.Rule{configRule, editorRule, desktopRule}
+
+	// The config rule declares the files permission, but it doesn't allow its dependencies to change files
+	files := []vrctFs.MergedFile{
+		{Path: "etc", IsDir: true},
+		{Path: "etc/config.conf", Content: []byte("a=b\n"), Rules: []vrctFs.Rule{configRule}},
+		{Path: "etc/editor.conf", Content: []byte("editor=nvim\n"), Rules: []vrctFs.Rule{configRule, editorRule}},
+	}
+	_, _, err := client.ApplyVRCT(files, root, rules, nil, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "rule helper-ruleset@editor doesn't declare 'files' permission") {
+		t.Fatalf("file created by the rule without permission shouldn't be applied, got: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "etc/config.conf")); !os.IsNotExist(err) {
+		t.Fatalf("no file should be applied, got: %v", err)
+	}
+
+	unownedFiles := []vrctFs.MergedFile{{Path: "etc/unowned.conf", Content: []byte("a=b\n")}}
+	_, _, err = client.ApplyVRCT(unownedFiles, root, rules, nil, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "wasn't created by any rule") {
+		t.Fatalf("file without rules shouldn't be applied, got: %v", err)
+	}
+
+	emptyDirs := []vrctFs.MergedFile{{Path: "opt", IsDir: true}}
+	_, _, err = client.ApplyVRCT(emptyDirs, root, rules, nil, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "doesn't contain any applied file") {
+		t.Fatalf("directory without applied files shouldn't be created, got: %v", err)
+	}
+
+	otherRule := vrctFs.Rule{Url: "helper-ruleset", NameOrScript: "other"}
+	otherFiles := []vrctFs.MergedFile{{Path: "other.conf", Content: []byte("a=b\n"), Rules: []vrctFs.Rule{otherRule}}}
+	_, _, err = client.ApplyVRCT(otherFiles, root, rules, nil, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "wasn't executed by the request") {
+		t.Fatalf("file of the rule outside of the request shouldn't be applied, got: %v", err)
+	}
+
+	// Units of the system scope are created by rules with the daemons permission
+	unitFiles := []vrctFs.MergedFile{
+		{Path: "etc/systemd/system/sddm.service.d/theme.conf", Content: []byte("[Service]\n"), Rules: []vrctFs.Rule{desktopRule}},
+	}
+	if _, _, err := client.ApplyVRCT(unitFiles, root, rules, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	unitFiles[0].Path = "etc/desktop.conf"
+	_, _, err = client.ApplyVRCT(unitFiles, root, rules, nil, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "doesn't declare 'files' permission") {
+		t.Fatalf("daemons permission should allow only units, got: %v", err)
+	}
+}
+
+func TestHelperRejectsRuleFiles(t *testing.T) {
+	client, packageManager, _ := startHelper(t)
+
+	// The helper cannot verify permissions declared by a script sent by the rule evaluator
+	forgedRule := vrctFs.Rule{Url: "/tmp", NameOrScript: "#![permissions(packages)]", IsScript: true}
+	err := newRuleBackend(client, forgedRule).InstallPackages("neovim")
+	if err == nil || !strings.Contains(err.Error(), "cannot be requested by a rule file") {
+		t.Fatalf("rule file shouldn't be allowed to use the helper, got: %v", err)
+	}
+	if _, err := packageManager.GetPackage("neovim"); err == nil {
+		t.Fatal("package shouldn't be installed")
+	}
+}
+
+func TestHelperAppliesStagedFiles(t *testing.T) {
+	client, _, _ := startHelper(t)
+	root := t.TempDir()
+
+	files := []vrctFs.MergedFile{
+		{Path: "etc", IsDir: true},
+		{Path: "etc/editor.conf", Content: []byte("editor=nvim\n"), Rules: []vrctFs.Rule{configRule}},
+	}
+	_, changedFiles, err := client.ApplyVRCT(files, root, []vrctFs.Rule{configRule}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(root, "etc/editor.conf"))
+	if err != nil || string(content) != "editor=nvim\n" {
+		t.Fatalf("file hasn't been applied: '%s', %v", string(content), err)
+	}
+	if len(changedFiles) != 1 || changedFiles[0] != "/etc/editor.conf" {
+		t.Fatalf("unexpected changed files: %v", changedFiles)
+	}
+
+	escapingFiles := []vrctFs.MergedFile{{Path: "../escaped.conf", Content: []byte("oops"), Rules: []vrctFs.Rule{configRule}}}
+	_, _, err = client.ApplyVRCT(escapingFiles, root, []vrctFs.Rule{configRule}, nil, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid path") {
+		t.Fatalf("files outside of the root shouldn't be applied, got: %v", err)
+	}
+}
+
+func TestHelperAddsDaemonChangesToItsRevertSteps(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("revert steps of the helper are recognized by their root owner")
+	}
+	client, _, _ := startHelper(t)
+	root := t.TempDir()
+
+	files := []vrctFs.MergedFile{{Path: "desktop.conf", Content: []byte("session=plasma\n"), Rules: []vrctFs.Rule{configRule}}}
+	rules := []vrctFs.Rule{configRule, desktopRule}
+	revertNum, _, err := client.ApplyVRCT(files, root, rules, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The desktop rule declares the daemons permission, but the config rule has changed the daemon
+	forgedChanges := []vrctFs.DaemonChange{{Name: "sddm.service", Rule: configRule}}
+	err = client.AddDaemonChanges(revertNum, root, rules, forgedChanges)
+	if err == nil || !strings.Contains(err.Error(), "rule helper-ruleset@config doesn't declare 'daemons' permission") {
+		t.Fatalf("daemon changes shouldn't be added without the daemons permission, got: %v", err)
+	}
+	changes := []vrctFs.DaemonChange{{Name: "sddm.service", Rule: desktopRule}}
+	if err := client.AddDaemonChanges(revertNum, root, rules, changes); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := client.RevertFiles(revertNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.DaemonChanges) != 1 || response.DaemonChanges[0].Name != "sddm.service" {
+		t.Fatalf("added daemon changes should be reverted with the files, got: %+v", response.DaemonChanges)
+	}
+}
+
+func TestHelperContinuesRevertStepsOfAppliedFiles(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("revert steps of the helper are recognized by their root owner")
+	}
+	client, _, _ := startHelper(t)
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "pacman.conf"), []byte("[core]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configFiles := []vrctFs.MergedFile{{Path: "pacman.conf", Content: []byte("[core]\n\n[chaotic-aur]\n"), Rules: []vrctFs.Rule{configRule}}}
+	revertNum, _, err := client.ApplyVRCT(configFiles, root, []vrctFs.Rule{configRule}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := append(configFiles, vrctFs.MergedFile{Path: "editor.conf", Content: []byte("editor=nvim\n"), Rules: []vrctFs.Rule{configRule}})
+	continuedRevertNum, changedFiles, err := client.ApplyVRCT(files, root, []vrctFs.Rule{configRule}, nil, nil, nil, &revertNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if continuedRevertNum != revertNum || len(changedFiles) != 2 {
+		t.Fatalf("changes should be added to the revert steps %d, got %d with changed files %v", revertNum, continuedRevertNum, changedFiles)
+	}
+
+	if _, err := client.RevertFiles(revertNum); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(root, "pacman.conf"))
+	if err != nil || string(content) != "[core]\n" {
+		t.Fatalf("file applied before should be restored: '%s', %v", string(content), err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "editor.conf")); !os.IsNotExist(err) {
+		t.Fatalf("created file should be removed, got: %v", err)
+	}
+}
+
+func TestSocketIsCreatedPrivate(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "helper.sock")
+	listener, err := privileged.Listen(socketPath, os.Getuid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	fileInfo, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if permissions := fileInfo.Mode().Perm(); permissions != 0600 {
+		t.Fatalf("socket should be accessible only by its owner, got %o", permissions)
+	}
+}
+
+func TestRuleBackendChecksDeclaredPermissions(t *testing.T) {
+	ruleBackend := privileged.RuleBackend{
+		Client:      &privileged.Client{SocketPath: filepath.Join(t.TempDir(), "not-running.sock")},
+		Rule:        editorRule,
+		Root:        "/",
+		Permissions: []shared.Permission{shared.PermissionPackages},
+	}
+
+	err := ruleBackend.RestartDaemon("sshd")
+	if err == nil || !strings.Contains(err.Error(), "doesn't declare 'daemons' permission") {
+		t.Fatalf("helper shouldn't be asked for undeclared operation, got: %v", err)
+	}
+}
@@ -17,6 +17,15 @@ type RuleConfigLayout struct {
 	Environment bool
 	Sudo        bool
 	Options     []option.Option
+	// Distros, InitSystems and Architectures limit platforms to which the rule applies, empty means any
+	Distros       []string
+	InitSystems   []string
+	Architectures []string
+	// Conflicts and Replaces contain "ruleset@rule" references, rule name alone points to the same ruleset
+	Conflicts []string `yaml:"conflicts"`
+	Replaces  []string `yaml:"replaces"`
+	// Permissions limit operations, which the privileged helper performs for the rule
+	Permissions []Permission `yaml:"permissions"`
 }
 
 type ConfigFileLayout struct {
@@ -38,6 +47,9 @@ func (s ConfigFileLayout) GetRuleConf(ruleName string) (RuleConfigLayout, error)
 			Path:        ruleConfYaml.Path,
 			Unsafe:      ruleConfYaml.Unsafe,
 			Description: ruleConfYaml.Description,
+			Conflicts:   ruleConfYaml.Conflicts,
+			Replaces:    ruleConfYaml.Replaces,
+			Permissions: ruleConfYaml.Permissions,
 		}, nil
 	}
 	return RuleConfigLayout{}, errors.New(fmt.Sprintf("cannot find rule named: '%s' in the config file", ruleName))
@@ -0,0 +1,81 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/avorty/spito/pkg/path"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var LogFilePath = filepath.Join(LocalStateSpitoPath, "logs", "spito.log")
+
+const (
+	maxLogFileSize = 1 << 20
+	// logFileBackups is the number of rotated files kept next to the log file, e.g. spito.log.1
+	logFileBackups = 3
+)
+
+// LogFile appends log entries as json lines and rotates the file when it grows too big
+type LogFile struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func OpenLogFile(logFilePath string) (*LogFile, error) {
+	if err := os.MkdirAll(filepath.Dir(logFilePath), path.DirectoryPermissions); err != nil {
+		return nil, err
+	}
+	return &LogFile{path: logFilePath}, nil
+}
+
+func (f *LogFile) Write(entry LogEntry) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.rotateIfNeeded(); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, path.FilePermissions)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *LogFile) rotateIfNeeded() error {
+	fileInfo, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fileInfo.Size() < maxLogFileSize {
+		return nil
+	}
+
+	for i := logFileBackups - 1; i > 0; i-- {
+		err := os.Rename(getBackupPath(f.path, i), getBackupPath(f.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(f.path, getBackupPath(f.path, 1))
+}
+
+func getBackupPath(logFilePath string, num int) string {
+	return fmt.Sprintf("%s.%d", logFilePath, num)
+}
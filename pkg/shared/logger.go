@@ -0,0 +1,142 @@
+package shared
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelLog
+	LevelImportant
+	LevelWarn
+	LevelError
+)
+
+// logLevelNames are the same as names of the InfoInterface methods
+var logLevelNames = map[LogLevel]string{
+	LevelDebug:     "debug",
+	LevelLog:       "log",
+	LevelImportant: "important",
+	LevelWarn:      "warn",
+	LevelError:     "error",
+}
+
+func (l LogLevel) String() string {
+	return logLevelNames[l]
+}
+
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if levelName == strings.ToLower(name) {
+			return level, nil
+		}
+	}
+	return LevelLog, fmt.Errorf("unknown log level '%s', use debug, log, important, warn or error", name)
+}
+
+// LogEntry is a single message printed by spito or by the rule
+type LogEntry struct {
+	Time  time.Time `json:"time"`
+	Level LogLevel  `json:"level"`
+	// Rule is "ruleset@rule" reference or path of the rule file which printed the message
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+// EntryPrinter is implemented by outputs which show the rule printing the message in a separate field,
+// other outputs get the rule as a prefix of the message
+type EntryPrinter interface {
+	PrintEntry(entry LogEntry)
+}
+
+// Logger filters messages by level, writes all of them to the log file and passes them to the Output
+type Logger struct {
+	Output InfoInterface
+	Level  LogLevel
+	// File is optional
+	File *LogFile
+	// Rule is attached to every message, see WithRuleContext
+	Rule string
+}
+
+func (l Logger) Log(args ...any) {
+	l.print(LevelLog, args)
+}
+
+func (l Logger) Debug(args ...any) {
+	l.print(LevelDebug, args)
+}
+
+func (l Logger) Error(args ...any) {
+	l.print(LevelError, args)
+}
+
+func (l Logger) Warn(args ...any) {
+	l.print(LevelWarn, args)
+}
+
+func (l Logger) Important(args ...any) {
+	l.print(LevelImportant, args)
+}
+
+func (l Logger) print(level LogLevel, args []any) {
+	entry := LogEntry{
+		Time:    time.Now(),
+		Level:   level,
+		Rule:    l.Rule,
+		Message: JoinMessage(args...),
+	}
+
+	if l.File != nil {
+		// Logs are only a help for debugging, failing to write them shouldn't stop the rule
+		_ = l.File.Write(entry)
+	}
+	if level < l.Level || l.Output == nil {
+		return
+	}
+
+	if printer, ok := l.Output.(EntryPrinter); ok {
+		printer.PrintEntry(entry)
+		return
+	}
+	if entry.Rule != "" {
+		args = append([]any{"[" + entry.Rule + "]"}, args...)
+	}
+
+	switch level {
+	case LevelDebug:
+		l.Output.Debug(args...)
+	case LevelLog:
+		l.Output.Log(args...)
+	case LevelImportant:
+		l.Output.Important(args...)
+	case LevelWarn:
+		l.Output.Warn(args...)
+	default:
+		l.Output.Error(args...)
+	}
+}
+
+// WithRuleContext returns InfoInterface which attributes messages to the given rule,
+// so output of dependencies executed in the middle of the rule can be distinguished
+func WithRuleContext(infoApi InfoInterface, rule string) InfoInterface {
+	logger, ok := infoApi.(Logger)
+	if !ok {
+		logger = Logger{Output: infoApi, Level: LevelDebug}
+	}
+	logger.Rule = rule
+	return logger
+}
+
+// JoinMessage joins arguments passed to InfoInterface methods the same way as fmt.Println does
+func JoinMessage(args ...any) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
@@ -0,0 +1,103 @@
+package shared
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type recordingInfoApi struct {
+	messages *[]string
+}
+
+func (r recordingInfoApi) record(level string, args []any) {
+	*r.messages = append(*r.messages, level+" "+JoinMessage(args...))
+}
+
+func (r recordingInfoApi) Log(args ...any)       { r.record("log", args) }
+func (r recordingInfoApi) Debug(args ...any)     { r.record("debug", args) }
+func (r recordingInfoApi) Error(args ...any)     { r.record("error", args) }
+func (r recordingInfoApi) Warn(args ...any)      { r.record("warn", args) }
+func (r recordingInfoApi) Important(args ...any) { r.record("important", args) }
+
+func TestLoggerFiltersAndAttributes(t *testing.T) {
+	var messages []string
+	logFile, err := OpenLogFile(filepath.Join(t.TempDir(), "logs", "spito.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var infoApi InfoInterface = Logger{
+		Output: recordingInfoApi{messages: &messages},
+		Level:  LevelWarn,
+		File:   logFile,
+	}
+	infoApi.Debug("hidden debug")
+	infoApi.Log("hidden log")
+	WithRuleContext(infoApi, "desktop@sddm").Warn("display manager", "is replaced")
+
+	expected := []string{"warn [desktop@sddm] display manager is replaced"}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected %v, got %v", expected, messages)
+	}
+
+	file, err := os.Open(logFile.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []map[string]any
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := make(map[string]any)
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("all messages should be written to the log file, got %d", len(entries))
+	}
+	if entries[0]["level"] != "debug" || entries[2]["rule"] != "desktop@sddm" || entries[2]["time"] == nil {
+		t.Fatalf("unexpected log entries: %v", entries)
+	}
+}
+
+func TestLogFileRotation(t *testing.T) {
+	logFilePath := filepath.Join(t.TempDir(), "spito.log")
+	if err := os.WriteFile(logFilePath, make([]byte, maxLogFileSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logFile, err := OpenLogFile(logFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := logFile.Write(LogEntry{Level: LevelLog, Message: "after rotation"}); err != nil {
+		t.Fatal(err)
+	}
+
+	backupInfo, err := os.Stat(logFilePath + ".1")
+	if err != nil || backupInfo.Size() != maxLogFileSize {
+		t.Fatalf("the full log file should be moved to the backup: %v", err)
+	}
+	content, err := os.ReadFile(logFilePath)
+	if err != nil || !strings.Contains(string(content), "after rotation") {
+		t.Fatalf("new log file should contain only the new entry, got: %s", content)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := ParseLogLevel("WARN")
+	if err != nil || level != LevelWarn {
+		t.Fatalf("expected warn level, got %v (%v)", level, err)
+	}
+	if _, err := ParseLogLevel("trace"); err == nil {
+		t.Fatal("unknown level should be rejected")
+	}
+}
@@ -0,0 +1,51 @@
+package shared
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Permission allows the rule to ask the privileged helper for operations, which require root privileges
+type Permission string
+
+const (
+	// PermissionFiles allows changing files not owned by the user, e.g. in /etc
+	PermissionFiles    Permission = "files"
+	PermissionPackages Permission = "packages"
+	PermissionDaemons  Permission = "daemons"
+)
+
+var AllPermissions = []Permission{PermissionFiles, PermissionPackages, PermissionDaemons}
+
+func ParsePermission(name string) (Permission, error) {
+	permission := Permission(name)
+	if !slices.Contains(AllPermissions, permission) {
+		return "", fmt.Errorf("unknown permission: %s, expected one of: %v", name, AllPermissions)
+	}
+	return permission, nil
+}
+
+// RequiresPrivileges returns true if the rule performs operations, which require root privileges
+func (c RuleConfigLayout) RequiresPrivileges() bool {
+	return c.Sudo || len(c.Permissions) > 0
+}
+
+// HasPermission returns true if the rule declared the permission. Sudo rules,
+// which don't declare permissions explicitly, are granted all of them
+func (c RuleConfigLayout) HasPermission(permission Permission) bool {
+	if c.Sudo && len(c.Permissions) == 0 {
+		return true
+	}
+	return slices.Contains(c.Permissions, permission)
+}
+
+// GetPermissions returns all permissions granted to the rule
+func (c RuleConfigLayout) GetPermissions() []Permission {
+	var permissions []Permission
+	for _, permission := range AllPermissions {
+		if c.HasPermission(permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
@@ -5,6 +5,9 @@ type Rule struct {
 	NameOrScript string
 	IsScript     bool
 	isInProgress bool
+	// Conflicts and Replaces contain normalized "ruleset@rule" references declared by the rule
+	Conflicts []string
+	Replaces  []string
 }
 
 func (r Rule) GetIdentifier() string {
@@ -35,4 +38,16 @@ func (r RulesHistory) Push(url string, nameOrScript string, isInProgress, isScri
 func (r RulesHistory) SetProgress(url string, nameOrScript string, isInProgress bool) {
 	rule := r[url+nameOrScript]
 	rule.isInProgress = isInProgress
+	r[url+nameOrScript] = rule
+}
+
+func (r RulesHistory) Remove(url string, nameOrScript string) {
+	delete(r, url+nameOrScript)
+}
+
+func (r RulesHistory) SetRelations(url string, nameOrScript string, conflicts, replaces []string) {
+	rule := r[url+nameOrScript]
+	rule.Conflicts = conflicts
+	rule.Replaces = replaces
+	r[url+nameOrScript] = rule
 }
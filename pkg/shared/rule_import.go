@@ -4,6 +4,7 @@ import (
 	daemon_tracker "github.com/avorty/spito/pkg"
 	"github.com/avorty/spito/pkg/package_conflict"
 	"github.com/avorty/spito/pkg/vrct"
+	"github.com/avorty/spito/pkg/vrct/vrctFs"
 	"github.com/godbus/dbus/v5"
 )
 
@@ -17,6 +18,39 @@ type ImportLoopData struct {
 	DaemonTracker  daemon_tracker.DaemonTracker
 	DbusConn       *dbus.Conn
 	GuiMode        bool
+	// Simulated is true when changes are never applied to the real system (e.g. in `spito test`),
+	// so rules requiring root privileges can be executed by a regular user
+	Simulated bool
+	// CurrentRule is the rule being executed, privileged operations are performed on its behalf
+	CurrentRule vrctFs.Rule
+	// DeferredDaemonOperations are executed once the changes of the rules are applied,
+	// so daemons of failed rules are never changed
+	DeferredDaemonOperations []func() error
+}
+
+// DeferDaemonOperation executes the operation when the changes are applied, after the package transaction,
+// e.g. service of the package can be enabled only once it's installed. Operations requested after units
+// are executed after the units are written together with other files
+func (i *ImportLoopData) DeferDaemonOperation(operation func() error) {
+	i.DeferredDaemonOperations = append(i.DeferredDaemonOperations, operation)
+}
+
+// DeferUnitReload executes the reload of the init system before all the deferred daemon operations,
+// so they can already use the written units
+func (i *ImportLoopData) DeferUnitReload(reload func() error) {
+	i.DeferredDaemonOperations = append([]func() error{reload}, i.DeferredDaemonOperations...)
+}
+
+// ExecuteDeferredDaemonOperations executes the operations in the order they were requested, after reloads of the init systems
+func (i *ImportLoopData) ExecuteDeferredDaemonOperations() error {
+	deferredOperations := i.DeferredDaemonOperations
+	i.DeferredDaemonOperations = nil
+	for _, operation := range deferredOperations {
+		if err := operation(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (i *ImportLoopData) DeleteRuntimeTemp() error {
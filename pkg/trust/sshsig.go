@@ -0,0 +1,106 @@
+package trust
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"hash"
+)
+
+// Implementation of the ssh signature format used by git (see PROTOCOL.sshsig in openssh sources)
+
+const (
+	sshSignatureMagic     = "SSHSIG"
+	sshSignatureVersion   = 1
+	sshSignaturePemType   = "SSH SIGNATURE"
+	gitSignatureNamespace = "git"
+)
+
+var ErrInvalidSshSignature = errors.New("invalid ssh signature")
+
+type sshSignatureBlob struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// verifySshSignature returns public key which created given armored signature of the message
+func verifySshSignature(armoredSignature, message []byte, namespace string) (ssh.PublicKey, error) {
+	pemBlock, _ := pem.Decode(armoredSignature)
+	if pemBlock == nil || pemBlock.Type != sshSignaturePemType {
+		return nil, fmt.Errorf("%w: it's not armored ssh signature", ErrInvalidSshSignature)
+	}
+
+	rawBlob, isMagicPrefixed := bytes.CutPrefix(pemBlock.Bytes, []byte(sshSignatureMagic))
+	if !isMagicPrefixed {
+		return nil, fmt.Errorf("%w: missing magic preamble", ErrInvalidSshSignature)
+	}
+
+	var blob sshSignatureBlob
+	if err := ssh.Unmarshal(rawBlob, &blob); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSshSignature, err.Error())
+	}
+
+	if blob.Version != sshSignatureVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidSshSignature, blob.Version)
+	}
+	if blob.Namespace != namespace {
+		return nil, fmt.Errorf("%w: expected namespace '%s', got '%s'", ErrInvalidSshSignature, namespace, blob.Namespace)
+	}
+
+	publicKey, err := ssh.ParsePublicKey(blob.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSshSignature, err.Error())
+	}
+
+	signature := ssh.Signature{}
+	if err := ssh.Unmarshal(blob.Signature, &signature); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSshSignature, err.Error())
+	}
+
+	messageHash, err := hashMessage(blob.HashAlgorithm, message)
+	if err != nil {
+		return nil, err
+	}
+
+	signedData := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignedData{
+		Namespace:     blob.Namespace,
+		Reserved:      blob.Reserved,
+		HashAlgorithm: blob.HashAlgorithm,
+		Hash:          messageHash,
+	})...)
+
+	if err := publicKey.Verify(signedData, &signature); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSshSignature, err.Error())
+	}
+	return publicKey, nil
+}
+
+func hashMessage(hashAlgorithm string, message []byte) ([]byte, error) {
+	var hasher hash.Hash
+	switch hashAlgorithm {
+	case "sha256":
+		hasher = sha256.New()
+	case "sha512":
+		hasher = sha512.New()
+	default:
+		return nil, fmt.Errorf("%w: unsupported hash algorithm '%s'", ErrInvalidSshSignature, hashAlgorithm)
+	}
+
+	hasher.Write(message)
+	return hasher.Sum(nil), nil
+}
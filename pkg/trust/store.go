@@ -0,0 +1,231 @@
+package trust
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/avorty/spito/pkg/path"
+	"github.com/avorty/spito/pkg/shared"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type KeyType string
+
+const (
+	SshKey     KeyType = "ssh"
+	OpenPGPKey KeyType = "openpgp"
+)
+
+const pgpPublicKeyHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+
+var DefaultStorePath = filepath.Join(shared.LocalStateSpitoPath, "trust.json")
+
+var ErrKeyNotFound = errors.New("key with given fingerprint is not trusted")
+
+type TrustedKey struct {
+	Fingerprint string  `json:"fingerprint"`
+	Type        KeyType `json:"type"`
+	// Key is an authorized_keys line for ssh keys or an armored public key for openpgp keys
+	Key     string `json:"key"`
+	Comment string `json:"comment,omitempty"`
+	// IsExplicit is true for keys added by the user, keys of rulesets trusted on first use aren't explicit
+	IsExplicit bool `json:"explicit,omitempty"`
+}
+
+// Store keeps trusted keys and pins every ruleset to the fingerprint of the key which signed it
+type Store struct {
+	Keys []TrustedKey      `json:"keys"`
+	Pins map[string]string `json:"pins"`
+	path string
+}
+
+func LoadStore(storePath string) (*Store, error) {
+	store := Store{
+		Pins: make(map[string]string),
+		path: storePath,
+	}
+
+	if err := path.CreateIfNotExists(storePath, "{}"); err != nil {
+		return nil, err
+	}
+
+	rawStore, err := os.ReadFile(storePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(rawStore, &store); err != nil {
+		return nil, fmt.Errorf("trust store %s is corrupted: %w", storePath, err)
+	}
+	if store.Pins == nil {
+		store.Pins = make(map[string]string)
+	}
+
+	return &store, nil
+}
+
+func (s *Store) Save() error {
+	rawStore, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, rawStore, path.FilePermissions)
+}
+
+// AddKey parses either ssh public key (in authorized_keys format) or armored openpgp public key
+// and adds it to explicitly trusted keys. Openpgp key ring may contain many keys, so it returns slice
+func (s *Store) AddKey(rawKey []byte) ([]TrustedKey, error) {
+	var newKeys []TrustedKey
+	var err error
+
+	if bytes.Contains(rawKey, []byte(pgpPublicKeyHeader)) {
+		newKeys, err = parseOpenPGPKeys(rawKey)
+	} else {
+		var key TrustedKey
+		key, err = parseSshKey(rawKey)
+		newKeys = []TrustedKey{key}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range newKeys {
+		newKeys[i].IsExplicit = true
+		s.Trust(newKeys[i])
+	}
+	return newKeys, nil
+}
+
+// Trust adds the key, if it's already trusted, it can only become explicit
+func (s *Store) Trust(key TrustedKey) {
+	keyIndex := slices.IndexFunc(s.Keys, func(trustedKey TrustedKey) bool {
+		return trustedKey.Fingerprint == key.Fingerprint
+	})
+	if keyIndex != -1 {
+		s.Keys[keyIndex].IsExplicit = s.Keys[keyIndex].IsExplicit || key.IsExplicit
+		return
+	}
+	s.Keys = append(s.Keys, key)
+}
+
+// HasExplicitKeys returns true if the user has added keys, then rulesets aren't trusted on first use anymore
+func (s *Store) HasExplicitKeys() bool {
+	return slices.ContainsFunc(s.Keys, func(key TrustedKey) bool {
+		return key.IsExplicit
+	})
+}
+
+// RemoveKey removes key and every pin which used it
+func (s *Store) RemoveKey(fingerprint string) error {
+	keyIndex := slices.IndexFunc(s.Keys, func(key TrustedKey) bool {
+		return key.Fingerprint == fingerprint
+	})
+	if keyIndex == -1 {
+		return ErrKeyNotFound
+	}
+	s.Keys = slices.Delete(s.Keys, keyIndex, keyIndex+1)
+
+	for rulesetIdentifier, pinnedFingerprint := range s.Pins {
+		if pinnedFingerprint == fingerprint {
+			delete(s.Pins, rulesetIdentifier)
+		}
+	}
+	return nil
+}
+
+func (s *Store) FindKey(fingerprint string) (TrustedKey, error) {
+	for _, key := range s.Keys {
+		if key.Fingerprint == fingerprint {
+			return key, nil
+		}
+	}
+	return TrustedKey{}, ErrKeyNotFound
+}
+
+func (s *Store) Pin(rulesetIdentifier, fingerprint string) {
+	s.Pins[rulesetIdentifier] = fingerprint
+}
+
+func (s *Store) Unpin(rulesetIdentifier string) {
+	delete(s.Pins, rulesetIdentifier)
+}
+
+func (s *Store) GetPin(rulesetIdentifier string) (string, bool) {
+	fingerprint, ok := s.Pins[rulesetIdentifier]
+	return fingerprint, ok
+}
+
+// openPGPKeyRing joins all trusted openpgp keys, so they can be used to verify signatures
+func (s *Store) openPGPKeyRing() (openpgp.EntityList, error) {
+	var keyRing openpgp.EntityList
+	for _, key := range s.Keys {
+		if key.Type != OpenPGPKey {
+			continue
+		}
+
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key.Key))
+		if err != nil {
+			return nil, fmt.Errorf("trusted key %s is corrupted: %w", key.Fingerprint, err)
+		}
+		keyRing = append(keyRing, entities...)
+	}
+	return keyRing, nil
+}
+
+func parseSshKey(rawKey []byte) (TrustedKey, error) {
+	publicKey, comment, _, _, err := ssh.ParseAuthorizedKey(rawKey)
+	if err != nil {
+		return TrustedKey{}, fmt.Errorf("cannot parse ssh public key: %w", err)
+	}
+
+	return newSshTrustedKey(publicKey, comment), nil
+}
+
+func newSshTrustedKey(publicKey ssh.PublicKey, comment string) TrustedKey {
+	return TrustedKey{
+		Fingerprint: ssh.FingerprintSHA256(publicKey),
+		Type:        SshKey,
+		Key:         strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))),
+		Comment:     comment,
+	}
+}
+
+func parseOpenPGPKeys(rawKey []byte) ([]TrustedKey, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(rawKey))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse openpgp public key: %w", err)
+	}
+
+	var keys []TrustedKey
+	for _, entity := range entities {
+		var armoredKey bytes.Buffer
+		if err := serializeArmoredEntity(entity, &armoredKey); err != nil {
+			return nil, err
+		}
+
+		var comment string
+		for identityName := range entity.Identities {
+			comment = identityName
+			break
+		}
+
+		keys = append(keys, TrustedKey{
+			Fingerprint: openPGPFingerprint(entity),
+			Type:        OpenPGPKey,
+			Key:         armoredKey.String(),
+			Comment:     comment,
+		})
+	}
+	return keys, nil
+}
+
+func openPGPFingerprint(entity *openpgp.Entity) string {
+	return strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint))
+}
@@ -0,0 +1,248 @@
+package trust
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func signSsh(t *testing.T, signer ssh.Signer, message []byte, namespace string) []byte {
+	messageHash := sha512.Sum512(message)
+	signedData := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignedData{
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Hash:          messageHash[:],
+	})...)
+
+	signature, err := signer.Sign(rand.Reader, signedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignatureBlob{
+		Version:       sshSignatureVersion,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(signature),
+	})...)
+
+	return pem.EncodeToMemory(&pem.Block{Type: sshSignaturePemType, Bytes: blob})
+}
+
+func newSshSigner(t *testing.T) ssh.Signer {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestVerifySshSignature(t *testing.T) {
+	signer := newSshSigner(t)
+	message := []byte("tree 1234\n\nsigned commit\n")
+	signature := signSsh(t, signer, message, gitSignatureNamespace)
+
+	publicKey, err := verifySshSignature(signature, message, gitSignatureNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(publicKey.Marshal(), signer.PublicKey().Marshal()) {
+		t.Fatal("returned public key is different than the signing one")
+	}
+
+	_, err = verifySshSignature(signature, []byte("tree 1234\n\ntampered commit\n"), gitSignatureNamespace)
+	if !errors.Is(err, ErrInvalidSshSignature) {
+		t.Fatalf("tampered message was accepted, err: %v", err)
+	}
+
+	_, err = verifySshSignature(signSsh(t, signer, message, "file"), message, gitSignatureNamespace)
+	if !errors.Is(err, ErrInvalidSshSignature) {
+		t.Fatalf("signature from other namespace was accepted, err: %v", err)
+	}
+}
+
+func commitToNewRepo(t *testing.T, signKey *openpgp.Entity) *git.Repository {
+	repoPath := t.TempDir()
+	repo, err := git.PlainInit(repoPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(repoPath, "spito.yml"), []byte("repo_url: test\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Add("spito.yml"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = worktree.Commit("init", &git.CommitOptions{
+		Author:  &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		SignKey: signKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestVerifyHeadUnsigned(t *testing.T) {
+	store := &Store{Pins: make(map[string]string)}
+	repo := commitToNewRepo(t, nil)
+
+	if _, err := VerifyHead(repo, store); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected ErrUnsigned, got: %v", err)
+	}
+}
+
+func TestVerifyHeadOpenPGP(t *testing.T) {
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := commitToNewRepo(t, entity)
+
+	store := &Store{Pins: make(map[string]string)}
+	if _, err := VerifyHead(repo, store); !errors.Is(err, ErrUnknownSigner) {
+		t.Fatalf("expected ErrUnknownSigner, got: %v", err)
+	}
+
+	var armoredKey bytes.Buffer
+	if err := serializeArmoredEntity(entity, &armoredKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddKey(armoredKey.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	signerKey, err := VerifyHead(repo, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signerKey.Fingerprint != openPGPFingerprint(entity) {
+		t.Fatalf("expected fingerprint %s, got %s", openPGPFingerprint(entity), signerKey.Fingerprint)
+	}
+}
+
+func TestVerifyCommitWhichIsNotCheckedOut(t *testing.T) {
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := commitToNewRepo(t, entity)
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedHash := head.Hash()
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = worktree.Commit("unsigned", &git.CommitOptions{
+		Author:            &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		AllowEmptyCommits: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var armoredKey bytes.Buffer
+	if err := serializeArmoredEntity(entity, &armoredKey); err != nil {
+		t.Fatal(err)
+	}
+	store := &Store{Pins: make(map[string]string)}
+	if _, err := store.AddKey(armoredKey.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := VerifyHead(repo, store); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected ErrUnsigned for the checked out commit, got: %v", err)
+	}
+	if _, err := VerifyCommit(repo, signedHash, store); err != nil {
+		t.Fatalf("signed commit should be verified, got: %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "trust.json")
+	store, err := LoadStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := newSshSigner(t)
+	keys, err := store.AddKey(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := keys[0].Fingerprint
+	store.Pin("github.com/avorty/test", fingerprint)
+
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = LoadStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned, _ := store.GetPin("github.com/avorty/test"); pinned != fingerprint {
+		t.Fatalf("pin wasn't saved, got '%s'", pinned)
+	}
+
+	if err := store.RemoveKey(fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if _, isPinned := store.GetPin("github.com/avorty/test"); isPinned {
+		t.Fatal("removing key didn't remove its pin")
+	}
+	if err := store.RemoveKey(fingerprint); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got: %v", err)
+	}
+}
+
+func TestExplicitKeys(t *testing.T) {
+	store, err := LoadStore(filepath.Join(t.TempDir(), "trust.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := newSshSigner(t)
+	store.Trust(newSshTrustedKey(signer.PublicKey(), "trusted on first use"))
+	if store.HasExplicitKeys() {
+		t.Fatal("key trusted on first use shouldn't be explicit")
+	}
+
+	keys, err := store.AddKey(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Keys) != 1 || !store.Keys[0].IsExplicit || !keys[0].IsExplicit {
+		t.Fatalf("adding already trusted key should make it explicit, got: %+v", store.Keys)
+	}
+	if !store.HasExplicitKeys() {
+		t.Fatal("store should have explicit keys")
+	}
+}
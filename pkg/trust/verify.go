@@ -0,0 +1,131 @@
+package trust
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgpErrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"io"
+	"strings"
+)
+
+const (
+	pgpSignatureHeader = "-----BEGIN PGP SIGNATURE-----"
+	sshSignatureHeader = "-----BEGIN SSH SIGNATURE-----"
+)
+
+var (
+	ErrUnsigned      = errors.New("revision is not signed")
+	ErrUnknownSigner = errors.New("revision is signed with an unknown openpgp key")
+)
+
+// VerifyHead verifies signature of the currently checked out commit, see VerifyCommit
+func VerifyHead(repo *git.Repository, store *Store) (TrustedKey, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return TrustedKey{}, err
+	}
+	return VerifyCommit(repo, head.Hash(), store)
+}
+
+// VerifyCommit verifies signature of the commit, e.g. fetched before it's checked out. If the commit itself
+// is not signed, signed annotated tag pointing to it is accepted instead. Returns key which created the signature
+func VerifyCommit(repo *git.Repository, hash plumbing.Hash, store *Store) (TrustedKey, error) {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return TrustedKey{}, err
+	}
+
+	if commit.PGPSignature != "" {
+		return verifySignature(commit.PGPSignature, commit.EncodeWithoutSignature, store)
+	}
+
+	tags, err := repo.TagObjects()
+	if err != nil {
+		return TrustedKey{}, err
+	}
+	defer tags.Close()
+
+	signerKey := TrustedKey{}
+	verificationErr := ErrUnsigned
+	err = tags.ForEach(func(tag *object.Tag) error {
+		if tag.Target != hash || tag.PGPSignature == "" {
+			return nil
+		}
+		signerKey, verificationErr = verifySignature(tag.PGPSignature, tag.EncodeWithoutSignature, store)
+		if verificationErr == nil {
+			return io.EOF // stop iterating, we've found valid signature
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, io.EOF) {
+		return TrustedKey{}, err
+	}
+
+	return signerKey, verificationErr
+}
+
+func verifySignature(signature string, encodeWithoutSignature func(plumbing.EncodedObject) error, store *Store) (TrustedKey, error) {
+	encodedObject := &plumbing.MemoryObject{}
+	if err := encodeWithoutSignature(encodedObject); err != nil {
+		return TrustedKey{}, err
+	}
+
+	payloadReader, err := encodedObject.Reader()
+	if err != nil {
+		return TrustedKey{}, err
+	}
+	payload, err := io.ReadAll(payloadReader)
+	if err != nil {
+		return TrustedKey{}, err
+	}
+
+	switch {
+	case strings.HasPrefix(signature, sshSignatureHeader):
+		publicKey, err := verifySshSignature([]byte(signature), payload, gitSignatureNamespace)
+		if err != nil {
+			return TrustedKey{}, err
+		}
+		return newSshTrustedKey(publicKey, ""), nil
+	case strings.HasPrefix(signature, pgpSignatureHeader):
+		return verifyOpenPGPSignature(signature, payload, store)
+	default:
+		return TrustedKey{}, fmt.Errorf("unsupported signature format")
+	}
+}
+
+func verifyOpenPGPSignature(signature string, payload []byte, store *Store) (TrustedKey, error) {
+	keyRing, err := store.openPGPKeyRing()
+	if err != nil {
+		return TrustedKey{}, err
+	}
+	if len(keyRing) == 0 {
+		return TrustedKey{}, ErrUnknownSigner
+	}
+
+	entity, err := openpgp.CheckArmoredDetachedSignature(
+		keyRing, strings.NewReader(string(payload)), strings.NewReader(signature), nil)
+	if errors.Is(err, pgpErrors.ErrUnknownIssuer) {
+		return TrustedKey{}, ErrUnknownSigner
+	}
+	if err != nil {
+		return TrustedKey{}, fmt.Errorf("invalid openpgp signature: %w", err)
+	}
+
+	return store.FindKey(openPGPFingerprint(entity))
+}
+
+func serializeArmoredEntity(entity *openpgp.Entity, writer io.Writer) error {
+	armorWriter, err := armor.Encode(writer, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return err
+	}
+	if err := entity.Serialize(armorWriter); err != nil {
+		return err
+	}
+	return armorWriter.Close()
+}
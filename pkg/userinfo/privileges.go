@@ -6,11 +6,15 @@ import (
 	"os/exec"
 	"os/user"
 	"strconv"
+	"strings"
 	"syscall"
 )
 
 const RootEuid = 0
 
+// regularUid is set by the privileged helper to the user who has started it, -1 means the user who has logged in
+var regularUid = -1
+
 func ChangeToRoot() {
 	err := syscall.Seteuid(RootEuid)
 	if err != nil {
@@ -19,7 +23,15 @@ func ChangeToRoot() {
 	}
 }
 
+// SetRegularUser makes GetRegularUser return the user with the given uid
+func SetRegularUser(uid int) {
+	regularUid = uid
+}
+
 func GetRegularUser() (*user.User, error) {
+	if regularUid >= 0 {
+		return user.LookupId(strconv.Itoa(regularUid))
+	}
 
 	lognameCommand := exec.Command("logname")
 	username, err := lognameCommand.Output()
@@ -36,6 +48,22 @@ func GetRegularUser() (*user.User, error) {
 	return userObject, nil
 }
 
+// GetParentUid returns the real uid of the parent process. pkexec replaces itself with the started program,
+// so the parent of the privileged helper is the process which has asked for it
+func GetParentUid() (int, error) {
+	status, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", os.Getppid()))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(status), "\n") {
+		if ids, ok := strings.CutPrefix(line, "Uid:"); ok && len(strings.Fields(ids)) > 0 {
+			return strconv.Atoi(strings.Fields(ids)[0])
+		}
+	}
+	return 0, fmt.Errorf("cannot find the uid of the parent process %d", os.Getppid())
+}
+
 func IsRoot() (bool, error) {
 	currentUser, err := user.Current()
 	return currentUser.Username == "root", err
@@ -2,14 +2,45 @@ package vrct
 
 import (
 	"github.com/avorty/spito/pkg/vrct/vrctFs"
+	"path/filepath"
 )
 
+// Applier applies merged changes of the VRCT to the real filesystem in place of this process,
+// e.g. the privileged helper changes files which the user isn't allowed to change
+type Applier interface {
+	// ApplyVRCT adds the revert steps to the ones of appliedRevertNum, if files were applied before with it
+	ApplyVRCT(
+		mergedFiles []vrctFs.MergedFile,
+		root string,
+		rulesHistory []vrctFs.Rule,
+		packageChanges []vrctFs.PackageChange,
+		daemonChanges []vrctFs.DaemonChange,
+		unitChanges []vrctFs.UnitChange,
+		appliedRevertNum *int,
+	) (revertNum int, changedFiles []string, err error)
+	// AddDaemonChanges saves states of the daemons changed after the changes were applied in their revert steps
+	AddDaemonChanges(revertNum int, root string, rulesHistory []vrctFs.Rule, daemonChanges []vrctFs.DaemonChange) error
+	// RevertApplied restores files applied before the other changes, e.g. when the package transaction has failed
+	RevertApplied(revertNum int) error
+}
+
 type RuleVRCT struct {
 	Fs vrctFs.VRCTFs
+	// Applier is used by Apply if it's set
+	Applier Applier
+
+	appliedFiles []string
+	// appliedRevertNum is the number of revert steps of the files applied by the Applier before Apply
+	appliedRevertNum *int
 }
 
 func NewRuleVRCT() (*RuleVRCT, error) {
-	fsVRCT, err := vrctFs.NewFsVRCT()
+	return NewRuleVRCTWithRoot("/")
+}
+
+// NewRuleVRCTWithRoot creates VRCT which treats given directory as the root of the real filesystem
+func NewRuleVRCTWithRoot(root string) (*RuleVRCT, error) {
+	fsVRCT, err := vrctFs.NewFsVRCTWithRoot(root)
 	if err != nil {
 		return nil, err
 	}
@@ -23,10 +54,86 @@ func (v RuleVRCT) DeleteRuntimeTemp() error {
 	return v.Fs.DeleteRuntimeTemp()
 }
 
-func (v RuleVRCT) Apply(rulesHistory []vrctFs.Rule) (int, error) {
-	return v.Fs.Apply(rulesHistory, true)
+func (v *RuleVRCT) Apply(rulesHistory []vrctFs.Rule) (int, error) {
+	if v.Applier == nil {
+		return v.Fs.Apply(rulesHistory, true)
+	}
+
+	mergedFiles, err := v.Fs.MergedFiles()
+	if err != nil {
+		return 0, err
+	}
+	revertNum, changedFiles, err := v.Applier.ApplyVRCT(
+		mergedFiles, v.Fs.Root(), rulesHistory, v.Fs.PackageChanges(), v.Fs.DaemonChanges(), v.Fs.UnitChanges(), v.appliedRevertNum,
+	)
+	// Files applied before are saved or reverted by the Applier together with the other changes
+	v.appliedRevertNum = nil
+	v.appliedFiles = changedFiles
+	return revertNum, err
+}
+
+// ApplyFile applies the file before the other changes, e.g. configuration which the package manager needs
+// before packages are installed. Apply saves its revert steps together with the other changes
+func (v *RuleVRCT) ApplyFile(filePath string, rulesHistory []vrctFs.Rule) error {
+	if v.Applier == nil {
+		return v.Fs.ApplyFile(filePath)
+	}
+
+	content, err := v.Fs.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	relativePath, err := filepath.Rel("/", filePath)
+	if err != nil {
+		return err
+	}
+
+	mergedFiles := []vrctFs.MergedFile{{Path: relativePath, Content: content, Rules: v.Fs.FileRules(filePath)}}
+	revertNum, _, err := v.Applier.ApplyVRCT(mergedFiles, v.Fs.Root(), rulesHistory, nil, nil, nil, v.appliedRevertNum)
+	if err != nil {
+		// The Applier reverts files applied before together with the failed ones
+		v.appliedRevertNum = nil
+		return err
+	}
+	v.appliedRevertNum = &revertNum
+	return nil
+}
+
+// AddDaemonChanges saves states of the daemons changed after Apply, so they are restored with the applied changes
+func (v *RuleVRCT) AddDaemonChanges(revertNum int, rulesHistory []vrctFs.Rule, daemonChanges []vrctFs.DaemonChange) error {
+	if v.Applier != nil {
+		return v.Applier.AddDaemonChanges(revertNum, v.Fs.Root(), rulesHistory, daemonChanges)
+	}
+
+	revertSteps, err := vrctFs.NewRevertSteps()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = revertSteps.DeleteRuntimeTemp()
+	}()
+	return revertSteps.AddSerializedDaemonChanges(revertNum, daemonChanges)
+}
+
+// ChangedFiles returns paths of the files changed by the last Apply
+func (v *RuleVRCT) ChangedFiles() []string {
+	if v.Applier != nil {
+		return v.appliedFiles
+	}
+	return v.Fs.ChangedFiles()
 }
 
-func (v RuleVRCT) Revert() error {
-	return v.Revert()
+// Revert restores files changed by the failed Apply or applied before it, the Applier reverts
+// the failed Apply by itself
+func (v *RuleVRCT) Revert() error {
+	if v.Applier == nil {
+		return v.Fs.RevertFiles()
+	}
+	if v.appliedRevertNum == nil {
+		return nil
+	}
+
+	revertNum := *v.appliedRevertNum
+	v.appliedRevertNum = nil
+	return v.Applier.RevertApplied(revertNum)
 }
@@ -27,9 +27,7 @@ func (v *VRCTFs) CreateConfig(filePath string, content []byte, optionalKeys []by
 		return err
 	}
 
-	filePrototype := FilePrototype{
-		FileType: fileType,
-	}
+	filePrototype := v.newFilePrototype(fileType)
 	err = filePrototype.Read(v.virtualFSPath, filePath)
 	if err != nil {
 		return err
@@ -44,8 +42,11 @@ func (v *VRCTFs) CreateConfig(filePath string, content []byte, optionalKeys []by
 		return err
 	}
 
-	err = filePrototype.AddNewLayer(prototypeLayer, false)
-	return err
+	if err := filePrototype.AddNewLayer(prototypeLayer, false); err != nil {
+		return err
+	}
+	v.addCurrentFileRule(filePath)
+	return nil
 }
 
 func (v *VRCTFs) UpdateConfig(filePath string, content []byte, optionalKeys []byte, isOptional bool, fileType FileType) error {
@@ -60,9 +61,7 @@ func (v *VRCTFs) UpdateConfig(filePath string, content []byte, optionalKeys []by
 		return err
 	}
 
-	filePrototype := FilePrototype{
-		FileType: fileType,
-	}
+	filePrototype := v.newFilePrototype(fileType)
 	err = filePrototype.Read(v.virtualFSPath, filePath)
 	if err != nil {
 		return err
@@ -72,7 +71,7 @@ func (v *VRCTFs) UpdateConfig(filePath string, content []byte, optionalKeys []by
 		return errors.New("trying to create file, where it's config type")
 	}
 
-	originalContent, err := os.ReadFile(filePath)
+	originalContent, err := os.ReadFile(v.realPath(filePath))
 	if err != nil {
 		return err
 	}
@@ -92,6 +91,9 @@ func (v *VRCTFs) UpdateConfig(filePath string, content []byte, optionalKeys []by
 		return err
 	}
 
-	err = filePrototype.AddNewLayer(prototypeLayer, false)
-	return err
+	if err := filePrototype.AddNewLayer(prototypeLayer, false); err != nil {
+		return err
+	}
+	v.addCurrentFileRule(filePath)
+	return nil
 }
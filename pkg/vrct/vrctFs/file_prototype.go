@@ -18,6 +18,7 @@ type FilePrototype struct {
 	OriginalFileIncluded bool
 	Path                 string `bson:"-"`
 	Name                 string `bson:"-"`
+	root                 string
 }
 
 func (p *FilePrototype) getDestinationPath() string {
@@ -29,7 +30,7 @@ func (p *FilePrototype) getDestinationPath() string {
 	firstSlashIndex := strings.Index(newPath, "/")
 	newPath = newPath[firstSlashIndex:]
 
-	return strings.TrimSuffix(newPath, ".prototype.bson")
+	return filepath.Join(p.root, strings.TrimSuffix(newPath, ".prototype.bson"))
 }
 
 func (p *FilePrototype) getVirtualPath() string {
@@ -92,7 +93,7 @@ func (p *FilePrototype) Read(vrctPrefix string, realPath string) error {
 	file, err := os.ReadFile(p.getVirtualPath())
 
 	if os.IsNotExist(err) {
-		_, err := os.Stat(realPath)
+		_, err := os.Stat(filepath.Join(p.root, realPath))
 		p.RealFileExists = !os.IsNotExist(err)
 
 		return p.Save()
@@ -32,9 +32,7 @@ func (v *VRCTFs) CreateFile(filePath string, content []byte, isOptional bool) er
 		return err
 	}
 
-	filePrototype := FilePrototype{
-		FileType: TextFile,
-	}
+	filePrototype := v.newFilePrototype(TextFile)
 	err = filePrototype.Read(v.virtualFSPath, filePath)
 	if err != nil {
 		return err
@@ -52,8 +50,11 @@ func (v *VRCTFs) CreateFile(filePath string, content []byte, isOptional bool) er
 		return err
 	}
 
-	err = filePrototype.AddNewLayer(prototypeLayer, false)
-	return err
+	if err := filePrototype.AddNewLayer(prototypeLayer, false); err != nil {
+		return err
+	}
+	v.addCurrentFileRule(filePath)
+	return nil
 }
 
 func (v *VRCTFs) ReadFile(filePath string) ([]byte, error) {
@@ -64,10 +65,10 @@ func (v *VRCTFs) ReadFile(filePath string) ([]byte, error) {
 		return nil, err
 	}
 
-	filePrototype := FilePrototype{}
+	filePrototype := v.newFilePrototype(TextFile)
 	err = filePrototype.Read(v.virtualFSPath, filePath)
 	if err != nil {
-		file, err := os.ReadFile(filePath)
+		file, err := os.ReadFile(v.realPath(filePath))
 		if err != nil {
 			return nil, os.ErrNotExist
 		}
@@ -101,7 +102,7 @@ func (v *VRCTFs) Stat(filePath string) (os.FileInfo, error) {
 			return nil, err
 		}
 	} else {
-		filePrototype := FilePrototype{}
+		filePrototype := v.newFilePrototype(TextFile)
 		if err := filePrototype.Read(v.virtualFSPath, filePath); err != nil {
 			return nil, err
 		}
@@ -119,7 +120,7 @@ func (v *VRCTFs) Stat(filePath string) (os.FileInfo, error) {
 		}, nil
 	}
 
-	fileStat, err := os.Stat(filePath)
+	fileStat, err := os.Stat(v.realPath(filePath))
 	if err != nil {
 		return nil, err
 	}
@@ -142,7 +143,7 @@ func (v *VRCTFs) ReadDir(dirPath string) ([]os.DirEntry, error) {
 		return nil, err
 	}
 
-	realFsEntries, err := os.ReadDir(dirPath)
+	realFsEntries, err := os.ReadDir(v.realPath(dirPath))
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return nil, err
@@ -3,10 +3,12 @@ package vrctFs
 import (
 	"fmt"
 	"github.com/BaderBC/targz"
+	daemontracker "github.com/avorty/spito/pkg"
 	"github.com/avorty/spito/pkg/path"
 	"gopkg.in/mgo.v2/bson"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -31,6 +33,15 @@ func GetSerializedRevertStepsDir() (string, error) {
 	return dir, err
 }
 
+// GetSerializedRevertStepsPath returns path of the archive with revert steps of the given number
+func GetSerializedRevertStepsPath(revertNum int) (string, error) {
+	dir, err := GetSerializedRevertStepsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("%d.tar.gz", revertNum)), nil
+}
+
 type RevertStep struct {
 	Path   string `bson:"Path"`
 	Action int    `bson:"Action"`
@@ -38,10 +49,58 @@ type RevertStep struct {
 	OldContentPath string `bson:"OldContentPath"`
 }
 
+// Package changes recorded in the revert steps
+const (
+	PackageInstalled = iota
+	PackageUpgraded
+	PackageRemoved
+	// KeyImported change is named by the fingerprint of the key
+	KeyImported
+)
+
+// PackageChange describes package installed, upgraded or removed by the rule,
+// keys imported before packages are installed are recorded as package changes too
+type PackageChange struct {
+	Name   string `json:"name" bson:"Name"`
+	Action int    `json:"action" bson:"Action"`
+	// Version is the version installed before the change, it's empty for newly installed packages
+	Version string `json:"version,omitempty" bson:"Version"`
+	// Explicit is true if the removed package had been installed explicitly, not as a dependency
+	Explicit bool `json:"explicit,omitempty" bson:"Explicit"`
+	Rule     Rule `json:"rule" bson:"Rule"`
+}
+
+// DaemonChange describes the state of the daemon before the rule has changed it for the first time
+type DaemonChange struct {
+	Name       string `json:"name" bson:"Name"`
+	WasActive  bool   `json:"wasActive" bson:"WasActive"`
+	WasEnabled bool   `json:"wasEnabled" bson:"WasEnabled"`
+	WasMasked  bool   `json:"wasMasked,omitempty" bson:"WasMasked"`
+	// Scope is "user" for services of the user's service manager, system daemons have it empty
+	Scope string `json:"scope,omitempty" bson:"Scope"`
+	Rule  Rule   `json:"rule" bson:"Rule"`
+}
+
+// UnitChange describes systemd unit or drop-in written by the rule, the init system of its scope is reloaded
+// after the file is reverted
+type UnitChange struct {
+	Name string `json:"name" bson:"Name"`
+	Path string `json:"path" bson:"Path"`
+	// Scope is "user" for units of the user's service manager, like in DaemonChange
+	Scope string `json:"scope,omitempty" bson:"Scope"`
+	Rule  Rule   `json:"rule" bson:"Rule"`
+}
+
 type RevertSteps struct {
 	Steps         []RevertStep `bson:"Steps"`
 	RulesToRevert []Rule       `bson:"RulesToRevert"`
-	RevertTempDir string       `bson:"-"`
+	// PackageChanges are reverted in the reverse order
+	PackageChanges []PackageChange `bson:"PackageChanges"`
+	DaemonChanges  []DaemonChange  `bson:"DaemonChanges"`
+	UnitChanges    []UnitChange    `bson:"UnitChanges"`
+	// Root is the directory in which the changes were applied, paths of the steps are relative to it
+	Root          string `bson:"Root"`
+	RevertTempDir string `bson:"-"`
 }
 
 func NewRevertSteps() (RevertSteps, error) {
@@ -67,6 +126,31 @@ func (r *RevertSteps) RemoveFile(path string) {
 	})
 }
 
+func (r *RevertSteps) AddPackageChange(change PackageChange) {
+	r.PackageChanges = append(r.PackageChanges, change)
+}
+
+// AddDaemonChange saves the state of the daemon unless it has been changed before, even under the full name of its unit,
+// so revert restores the state from before all the changes
+func (r *RevertSteps) AddDaemonChange(change DaemonChange) {
+	for _, daemonChange := range r.DaemonChanges {
+		if daemontracker.GetUnitName(daemonChange.Name) == daemontracker.GetUnitName(change.Name) && daemonChange.Scope == change.Scope {
+			return
+		}
+	}
+	r.DaemonChanges = append(r.DaemonChanges, change)
+}
+
+// AddUnitChange records the unit file unless it has been written before
+func (r *RevertSteps) AddUnitChange(change UnitChange) {
+	for _, unitChange := range r.UnitChanges {
+		if unitChange.Path == change.Path {
+			return
+		}
+	}
+	r.UnitChanges = append(r.UnitChanges, change)
+}
+
 func (r *RevertSteps) RemoveDirAll(path string) {
 	r.Steps = append(r.Steps, RevertStep{
 		Path:   path,
@@ -75,7 +159,7 @@ func (r *RevertSteps) RemoveDirAll(path string) {
 }
 
 func (r *RevertSteps) BackupOldContent(path string) error {
-	oldFile, err := os.ReadFile(path)
+	oldFile, err := os.ReadFile(filepath.Join(r.Root, path))
 	if os.IsNotExist(err) {
 		return nil
 	}
@@ -107,42 +191,70 @@ func (r *RevertSteps) BackupOldContent(path string) error {
 	return nil
 }
 
-func (r *RevertStep) Apply() error {
+// Apply reverts the step inside given root directory
+func (r *RevertStep) Apply(root string) error {
+	realPath := filepath.Join(root, r.Path)
+
 	switch r.Action {
 	case removeFile:
-		return os.Remove(r.Path)
+		return os.Remove(realPath)
 	case removeDirAll:
-		return os.RemoveAll(r.Path)
+		return os.RemoveAll(realPath)
 	case replaceContent:
 		if r.OldContentPath == "" {
 			return fmt.Errorf("oldContentPath cannot be null if RevertStep action is: \"replaceContent\"\n")
 		}
-		err := os.RemoveAll(r.Path)
+		err := os.RemoveAll(realPath)
 		if err != nil {
 			return err
 		}
 
-		return MoveFile(r.OldContentPath, r.Path)
+		return MoveFile(r.OldContentPath, realPath)
 	default:
 		return fmt.Errorf("unknown RevertStep action: %d\n", r.Action)
 	}
 }
 
 func (r *RevertSteps) Apply(revertFn func(rule Rule) error) error {
-	for _, step := range r.Steps {
-		if err := step.Apply(); err != nil {
+	if err := r.RevertFiles(); err != nil {
+		return err
+	}
+	return r.RevertRules(revertFn)
+}
+
+// RevertFiles restores files changed by the applied changes in the reverse order,
+// so files are restored before the directories created for them are removed
+func (r *RevertSteps) RevertFiles() error {
+	for i := len(r.Steps) - 1; i >= 0; i-- {
+		if err := r.Steps[i].Apply(r.Root); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// RevertRules calls revert function for each applied rule
+func (r *RevertSteps) RevertRules(revertFn func(rule Rule) error) error {
 	for _, rule := range r.RulesToRevert {
 		if err := revertFn(rule); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
+// ChangedFiles returns paths of the files which were created or modified by applied changes
+func (r *RevertSteps) ChangedFiles() []string {
+	var changedFiles []string
+	for _, step := range r.Steps {
+		// Files applied before the other changes are backed up once again by Apply
+		if (step.Action == removeFile || step.Action == replaceContent) && !slices.Contains(changedFiles, step.Path) {
+			changedFiles = append(changedFiles, step.Path)
+		}
+	}
+	return changedFiles
+}
+
 func (r *RevertSteps) DeleteRuntimeTemp() error {
 	return os.RemoveAll(r.RevertTempDir)
 }
@@ -157,47 +269,75 @@ type Rule struct {
 func (r *RevertSteps) Serialize(rulesToRevert []Rule) (int, error) {
 	r.RulesToRevert = rulesToRevert
 
-	outBson, err := bson.Marshal(r)
+	revertNums, err := GetRevertNums()
 	if err != nil {
 		return 0, err
 	}
 
+	largestRevertNum := -1
+	for _, num := range revertNums {
+		if num > largestRevertNum {
+			largestRevertNum = num
+		}
+	}
+
+	revertNum := largestRevertNum + 1
+	return revertNum, r.serializeAs(revertNum)
+}
+
+// AddSerializedDaemonChanges adds changes of daemons to the serialized revert steps. Daemons are changed
+// after the changes are applied, so their previous states are known only once the revert steps are saved
+func (r *RevertSteps) AddSerializedDaemonChanges(revertNum int, changes []DaemonChange) error {
+	if err := r.Deserialize(revertNum); err != nil {
+		return err
+	}
+	for _, change := range changes {
+		r.AddDaemonChange(change)
+	}
+	return r.serializeAs(revertNum)
+}
+
+func (r *RevertSteps) serializeAs(revertNum int) error {
+	outBson, err := bson.Marshal(r)
+	if err != nil {
+		return err
+	}
+
 	err = os.WriteFile(filepath.Join(r.RevertTempDir, revertStepsBsonName), outBson, os.ModePerm)
 	if err != nil {
-		return 0, err
+		return err
+	}
+
+	serializedRevertStepsPath, err := GetSerializedRevertStepsPath(revertNum)
+	if err != nil {
+		return err
 	}
+	return targz.Compress(filepath.Join(r.RevertTempDir, "*"), serializedRevertStepsPath)
+}
 
+// GetRevertNums returns numbers of serialized changes which can be reverted
+func GetRevertNums() ([]int, error) {
 	serializedRevertStepsDir, err := GetSerializedRevertStepsDir()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	dirEntries, err := os.ReadDir(serializedRevertStepsDir)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	largestRevertNum := -1
+	var revertNums []int
 	for _, entry := range dirEntries {
 		entryName := strings.TrimSuffix(entry.Name(), ".tar.gz")
 		num, err := strconv.Atoi(entryName)
 		if err != nil {
 			continue
 		}
-
-		if num > largestRevertNum {
-			largestRevertNum = num
-		}
+		revertNums = append(revertNums, num)
 	}
 
-	revertNum := largestRevertNum + 1
-
-	err = targz.Compress(
-		filepath.Join(r.RevertTempDir, "*"),
-		filepath.Join(serializedRevertStepsDir, fmt.Sprintf("%d.tar.gz", revertNum)),
-	)
-
-	return revertNum, err
+	return revertNums, nil
 }
 
 func (r *RevertSteps) Deserialize(revertNum int) error {
@@ -210,13 +350,12 @@ func (r *RevertSteps) Deserialize(revertNum int) error {
 		return err
 	}
 
-	revertStepsDir, err := GetSerializedRevertStepsDir()
+	revertTarGzPath, err := GetSerializedRevertStepsPath(revertNum)
 	if err != nil {
 		return err
 	}
 
 	revertNumDir := filepath.Join(r.RevertTempDir, strconv.Itoa(revertNum))
-	revertTarGzPath := filepath.Join(revertStepsDir, fmt.Sprintf("%d.tar.gz", revertNum))
 
 	if err = targz.Extract(revertTarGzPath, revertNumDir); err != nil {
 		return err
@@ -249,6 +388,13 @@ func (r *RevertSteps) Deserialize(revertNum int) error {
 	}
 	r.RevertTempDir = revertTempDir
 
+	// Old contents are unpacked in the current directory, they could have been backed up in another one
+	for i, step := range r.Steps {
+		if step.OldContentPath != "" {
+			r.Steps[i].OldContentPath = filepath.Join(revertTempDir, filepath.Base(step.OldContentPath))
+		}
+	}
+
 	return os.Remove(bsonPath)
 }
 
@@ -138,3 +138,106 @@ func revertFsChanges(t *testing.T, testFilePath string, revertNum int) {
 		t.Fatalf("Failed to properly revert %s file content", testFilePath)
 	}
 }
+
+func TestCreatingFileInRoot(t *testing.T) {
+	rootPath, err := os.MkdirTemp("/tmp", "spito-test-root-")
+	if err != nil {
+		t.Fatal("Failed to create temporary root directory\n", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(rootPath)
+	}()
+
+	ruleVrct, err := vrct.NewRuleVRCTWithRoot(rootPath)
+	if err != nil {
+		t.Fatal("Failed to Create VRCT instance")
+	}
+	fsVrct := &ruleVrct.Fs
+
+	defer func() {
+		if err := ruleVrct.DeleteRuntimeTemp(); err != nil {
+			t.Fatal("Failed to remove temporary VRCT files", err.Error())
+		}
+	}()
+
+	// Path inside the root, it mustn't be touched on the host
+	testFilePath := "/spito-root-test/etc/file.txt"
+	realFilePath := filepath.Join(rootPath, testFilePath)
+
+	if err := os.MkdirAll(filepath.Dir(realFilePath), os.ModePerm); err != nil {
+		t.Fatal("Failed to create test directory in the root\n", err.Error())
+	}
+	if err := os.WriteFile(realFilePath, []byte(originalContent), os.ModePerm); err != nil {
+		t.Fatal("Failed to create test file in the root\n", err.Error())
+	}
+
+	file, err := fsVrct.ReadFile(testFilePath)
+	if err != nil || string(file) != originalContent {
+		t.Fatalf("VRCT doesn't read files from the root, got: \"%s\", err: %v", string(file), err)
+	}
+
+	if err := fsVrct.CreateFile(testFilePath, []byte(newContent), false); err != nil {
+		t.Fatal("Failed to create file "+testFilePath+"\n", err)
+	}
+
+	revertNum, err := fsVrct.Apply([]vrctFs.Rule{}, true)
+	if err != nil {
+		t.Fatal("Failed to apply VRCT\n", err)
+	}
+
+	if _, err := os.Stat(testFilePath); !os.IsNotExist(err) {
+		t.Fatalf("VRCT has written %s outside of the root", testFilePath)
+	}
+
+	file, err = os.ReadFile(realFilePath)
+	if err != nil || string(file) != newContent {
+		t.Fatalf("Failed to properly merge %s file content into the root, got: \"%s\"", realFilePath, string(file))
+	}
+
+	revertFsChanges(t, realFilePath, revertNum)
+}
+
+func TestMergedFilesHaveTheirRules(t *testing.T) {
+	ruleVrct, err := vrct.NewRuleVRCTWithRoot(t.TempDir())
+	if err != nil {
+		t.Fatal("Failed to Create VRCT instance")
+	}
+	fsVrct := &ruleVrct.Fs
+	defer func() {
+		_ = ruleVrct.DeleteRuntimeTemp()
+	}()
+
+	configRule := vrctFs.Rule{Url: "ruleset", NameOrScript: "config"}
+	dependencyRule := vrctFs.Rule{Url: "ruleset", NameOrScript: "dependency"}
+
+	fsVrct.SetCurrentRule(&configRule)
+	if err := fsVrct.CreateFile("/etc/config.conf", []byte(newContent), true); err != nil {
+		t.Fatal(err)
+	}
+	fsVrct.SetCurrentRule(&dependencyRule)
+	if err := fsVrct.CreateFile("/etc/config.conf", []byte(newContent), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsVrct.CreateFile("/etc/dependency.conf", []byte(newContent), false); err != nil {
+		t.Fatal(err)
+	}
+	fsVrct.SetCurrentRule(nil)
+
+	mergedFiles, err := fsVrct.MergedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileRules := map[string][]vrctFs.Rule{}
+	for _, mergedFile := range mergedFiles {
+		fileRules[mergedFile.Path] = mergedFile.Rules
+	}
+	if rules := fileRules["etc/config.conf"]; len(rules) != 2 || rules[0] != configRule || rules[1] != dependencyRule {
+		t.Fatalf("file should be owned by both rules, got: %+v", rules)
+	}
+	if rules := fileRules["etc/dependency.conf"]; len(rules) != 1 || rules[0] != dependencyRule {
+		t.Fatalf("file should be owned only by the dependency, got: %+v", rules)
+	}
+	if rules, ok := fileRules["etc"]; !ok || len(rules) != 0 {
+		t.Fatalf("directory should be merged without rules, got: %+v", rules)
+	}
+}
@@ -1,9 +1,12 @@
 package vrctFs
 
 import (
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -13,6 +16,14 @@ const VirtualFilePostfix = ".prototype.bson"
 type VRCTFs struct {
 	virtualFSPath string
 	revertSteps   RevertSteps
+	// root is the directory which is treated as "/" of the real filesystem
+	root string
+	// continuedRevertNum is set when Apply adds its revert steps to the serialized ones
+	continuedRevertNum *int
+	// currentRule has created files since SetCurrentRule was called, it's nil outside rules
+	currentRule *Rule
+	// fileRules are rules which have created each file, the privileged helper checks their permissions
+	fileRules map[string][]Rule
 }
 
 func MoveFile(source string, destination string) error {
@@ -53,11 +64,23 @@ func MoveFile(source string, destination string) error {
 }
 
 func NewFsVRCT() (VRCTFs, error) {
-	err := os.MkdirAll(VirtualFsPathPrefix, os.ModePerm)
+	return NewFsVRCTWithRoot("/")
+}
+
+// NewFsVRCTWithRoot creates VRCT which operates on the real filesystem mounted in the given root directory
+// instead of "/", e.g. on a freshly bootstrapped system image
+func NewFsVRCTWithRoot(root string) (VRCTFs, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return VRCTFs{}, err
+	}
+
+	err = os.MkdirAll(VirtualFsPathPrefix, os.ModePerm)
 	revertSteps, err := NewRevertSteps()
 	if err != nil {
 		return VRCTFs{}, nil
 	}
+	revertSteps.Root = root
 
 	err = os.MkdirAll(VirtualFsPathPrefix, os.ModePerm)
 	if err != nil {
@@ -72,9 +95,58 @@ func NewFsVRCT() (VRCTFs, error) {
 	return VRCTFs{
 		virtualFSPath: dir,
 		revertSteps:   revertSteps,
+		root:          root,
+		fileRules:     map[string][]Rule{},
 	}, nil
 }
 
+func (v *VRCTFs) Root() string {
+	return v.root
+}
+
+// SetCurrentRule makes the rule own files created from now on, nil stops recording them
+func (v *VRCTFs) SetCurrentRule(rule *Rule) {
+	v.currentRule = rule
+}
+
+// CurrentRule returns the rule set by SetCurrentRule
+func (v *VRCTFs) CurrentRule() *Rule {
+	return v.currentRule
+}
+
+// AddFileRule records the rule as one of the rules which have created the file
+func (v *VRCTFs) AddFileRule(filePath string, rule Rule) {
+	if v.fileRules == nil {
+		v.fileRules = map[string][]Rule{}
+	}
+	if !slices.Contains(v.fileRules[filePath], rule) {
+		v.fileRules[filePath] = append(v.fileRules[filePath], rule)
+	}
+}
+
+// FileRules returns rules which have created the file
+func (v *VRCTFs) FileRules(filePath string) []Rule {
+	return v.fileRules[filePath]
+}
+
+func (v *VRCTFs) addCurrentFileRule(filePath string) {
+	if v.currentRule != nil {
+		v.AddFileRule(filePath, *v.currentRule)
+	}
+}
+
+// realPath returns location of the given absolute path inside the real filesystem root
+func (v *VRCTFs) realPath(filePath string) string {
+	return filepath.Join(v.root, filePath)
+}
+
+func (v *VRCTFs) newFilePrototype(fileType FileType) FilePrototype {
+	return FilePrototype{
+		FileType: fileType,
+		root:     v.root,
+	}
+}
+
 func (v *VRCTFs) DeleteRuntimeTemp() error {
 	if err := v.revertSteps.DeleteRuntimeTemp(); err != nil {
 		return err
@@ -95,42 +167,217 @@ func (v *VRCTFs) Apply(rulesHistory []Rule, serializeRevertSteps bool) (int, err
 		return 0, err
 	}
 
-	if err := v.mergeToRealFs(mergeDir); err != nil {
+	revertNum, err := v.applyMergeDir(mergeDir, rulesHistory, serializeRevertSteps)
+	if err != nil {
 		return 0, err
 	}
+	return revertNum, os.RemoveAll(mergeDir)
+}
+
+func (v *VRCTFs) applyMergeDir(mergeDir string, rulesHistory []Rule, serializeRevertSteps bool) (int, error) {
+	if err := v.mergeToRealFs(mergeDir, "/"); err != nil {
+		return 0, err
+	}
+
+	if !serializeRevertSteps {
+		return 0, nil
+	}
+	if v.continuedRevertNum == nil {
+		return v.revertSteps.Serialize(rulesHistory)
+	}
+	v.revertSteps.RulesToRevert = rulesHistory
+	return *v.continuedRevertNum, v.revertSteps.serializeAs(*v.continuedRevertNum)
+}
+
+// ApplyFile applies the file before the other changes, e.g. configuration which the package manager needs
+// before packages are installed. Its previous content is backed up in the revert steps saved by Apply
+func (v *VRCTFs) ApplyFile(filePath string) error {
+	content, err := v.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	mergeDir, err := os.MkdirTemp("/tmp", "spito-fs-vrct-merge")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(mergeDir)
+	}()
+
+	mergedFilePath := filepath.Join(mergeDir, filePath)
+	if err := os.MkdirAll(filepath.Dir(mergedFilePath), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(mergedFilePath, content, os.ModePerm); err != nil {
+		return err
+	}
+	return v.mergeToRealFs(mergeDir, "/")
+}
+
+// MergedFile is a file or a directory of the VRCT with its final content, Path is relative to the root
+type MergedFile struct {
+	Path    string `json:"path"`
+	IsDir   bool   `json:"isDir,omitempty"`
+	Content []byte `json:"content,omitempty"`
+	// Rules have created the file, directories are created only for the files inside them
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// MergedFiles returns final content of the changed files, so another process can apply them
+// without reading the VRCT directory of this one
+func (v *VRCTFs) MergedFiles() ([]MergedFile, error) {
+	mergeDir, err := os.MkdirTemp("/tmp", "spito-fs-vrct-merge")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(mergeDir)
+	}()
+
+	if err := mergePrototypes(v.virtualFSPath, mergeDir); err != nil {
+		return nil, err
+	}
 
-	var revertNum int
-	if serializeRevertSteps {
-		revertNum, err = v.revertSteps.Serialize(rulesHistory)
+	var mergedFiles []MergedFile
+	err = filepath.WalkDir(mergeDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || filePath == mergeDir {
+			return err
+		}
+		relativePath, err := filepath.Rel(mergeDir, filePath)
 		if err != nil {
+			return err
+		}
+
+		mergedFile := MergedFile{Path: relativePath, IsDir: entry.IsDir()}
+		if !mergedFile.IsDir {
+			if mergedFile.Content, err = os.ReadFile(filePath); err != nil {
+				return err
+			}
+			mergedFile.Rules = v.FileRules(filepath.Join("/", relativePath))
+		}
+		mergedFiles = append(mergedFiles, mergedFile)
+		return nil
+	})
+	return mergedFiles, err
+}
+
+// NewStagingFsVRCT creates VRCT without a virtual filesystem, which applies files merged by another process.
+// They are staged and backed up in the given directory, so it has to be accessible only by this process
+func NewStagingFsVRCT(root string, stagingDir string) (VRCTFs, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return VRCTFs{}, err
+	}
+
+	revertTempDir := filepath.Join(stagingDir, "revert")
+	if err := os.Mkdir(revertTempDir, 0700); err != nil {
+		return VRCTFs{}, err
+	}
+
+	return VRCTFs{
+		revertSteps: RevertSteps{Root: root, RevertTempDir: revertTempDir},
+		root:        root,
+	}, nil
+}
+
+// ContinueRevertSteps makes Apply add its revert steps to the serialized ones of the given number,
+// e.g. of the files applied before the package transaction
+func (v *VRCTFs) ContinueRevertSteps(revertNum int) error {
+	if err := v.revertSteps.Deserialize(revertNum); err != nil {
+		return err
+	}
+	if v.revertSteps.Root != v.root {
+		return fmt.Errorf("changes number %d were applied in another root: %s", revertNum, v.revertSteps.Root)
+	}
+	v.continuedRevertNum = &revertNum
+	return nil
+}
+
+// ApplyMergedFiles writes the files into the staging directory and applies them like Apply does
+func (v *VRCTFs) ApplyMergedFiles(mergedFiles []MergedFile, stagingDir string, rulesHistory []Rule) (int, error) {
+	mergeDir := filepath.Join(stagingDir, "merged")
+	if err := os.Mkdir(mergeDir, 0700); err != nil {
+		return 0, err
+	}
+
+	for _, mergedFile := range mergedFiles {
+		if !filepath.IsLocal(mergedFile.Path) {
+			return 0, fmt.Errorf("invalid path of the merged file: '%s'", mergedFile.Path)
+		}
+		stagedPath := filepath.Join(mergeDir, mergedFile.Path)
+		if mergedFile.IsDir {
+			if err := os.MkdirAll(stagedPath, os.ModePerm); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(stagedPath), os.ModePerm); err != nil {
+			return 0, err
+		}
+		if err := os.WriteFile(stagedPath, mergedFile.Content, os.ModePerm); err != nil {
 			return 0, err
 		}
 	}
 
-	return revertNum, os.RemoveAll(mergeDir)
+	return v.applyMergeDir(mergeDir, rulesHistory, true)
+}
+
+// RecordPackageChange saves the package change, so it's reverted together with the files
+func (v *VRCTFs) RecordPackageChange(change PackageChange) {
+	v.revertSteps.AddPackageChange(change)
+}
+
+// PackageChanges returns package changes recorded since the VRCT was created
+func (v *VRCTFs) PackageChanges() []PackageChange {
+	return v.revertSteps.PackageChanges
+}
+
+// RecordDaemonChange saves the previous state of the daemon, so it's restored together with the files
+func (v *VRCTFs) RecordDaemonChange(change DaemonChange) {
+	v.revertSteps.AddDaemonChange(change)
+}
+
+// DaemonChanges returns daemon states recorded since the VRCT was created
+func (v *VRCTFs) DaemonChanges() []DaemonChange {
+	return v.revertSteps.DaemonChanges
+}
+
+// RecordUnitChange saves the unit file written by the rule, so the init system is reloaded when it's reverted
+func (v *VRCTFs) RecordUnitChange(change UnitChange) {
+	v.revertSteps.AddUnitChange(change)
+}
+
+// UnitChanges returns unit files recorded since the VRCT was created
+func (v *VRCTFs) UnitChanges() []UnitChange {
+	return v.revertSteps.UnitChanges
+}
+
+// ChangedFiles returns paths of the files changed in the real filesystem by Apply
+func (v *VRCTFs) ChangedFiles() []string {
+	return v.revertSteps.ChangedFiles()
 }
 
 func (v *VRCTFs) Revert(fn func(rule Rule) error) error {
 	return v.revertSteps.Apply(fn)
 }
 
-func (v *VRCTFs) mergeToRealFs(mergeDirPath string) error {
-	splitMergePath := strings.Split(mergeDirPath, "/")[3:]
-	destPath := strings.Join(splitMergePath, "/")
-	if len(destPath) != 0 {
-		destPath = "/" + destPath
-	}
+// RevertFiles restores files changed by Apply so far, e.g. when it has failed
+func (v *VRCTFs) RevertFiles() error {
+	return v.revertSteps.RevertFiles()
+}
 
+// mergeToRealFs moves the merged files into destPath inside the root
+func (v *VRCTFs) mergeToRealFs(mergeDirPath string, destPath string) error {
 	entries, err := os.ReadDir(mergeDirPath)
 	if err != nil {
 		return err
 	}
 
 	for _, entry := range entries {
-		if destPath == "" {
-			destPath = "/"
-		}
-		realFsEntryPath := filepath.Join(destPath, entry.Name())
+		// targetPath is the path inside the root, revert steps are saved using it
+		targetPath := filepath.Join(destPath, entry.Name())
+		realFsEntryPath := v.realPath(targetPath)
 		mergeDirEntryPath := filepath.Join(mergeDirPath, entry.Name())
 
 		doesRealFsEntryExists, err := pathExists(realFsEntryPath)
@@ -141,29 +388,32 @@ func (v *VRCTFs) mergeToRealFs(mergeDirPath string) error {
 		if entry.IsDir() {
 			// If originally dir does not exist, then revert should delete it
 			if !doesRealFsEntryExists {
-				v.revertSteps.RemoveDirAll(realFsEntryPath)
+				v.revertSteps.RemoveDirAll(targetPath)
 			}
 			if err := os.MkdirAll(realFsEntryPath, os.ModePerm); err != nil {
 				return err
 			}
-			if err := v.mergeToRealFs(mergeDirEntryPath); err != nil {
+			if err := v.mergeToRealFs(mergeDirEntryPath, targetPath); err != nil {
 				return err
 			}
 			continue
 		}
 
-		filePrototype := FilePrototype{}
-		err = filePrototype.Read(v.virtualFSPath, realFsEntryPath)
-		if err != nil {
-			return err
+		filePrototype := v.newFilePrototype(TextFile)
+		// VRCT applying files merged by another process doesn't have prototypes
+		hasPrototype := v.virtualFSPath != ""
+		if hasPrototype {
+			if err := filePrototype.Read(v.virtualFSPath, targetPath); err != nil {
+				return err
+			}
 		}
 
 		if doesRealFsEntryExists {
-			if err := v.revertSteps.BackupOldContent(realFsEntryPath); err != nil {
+			if err := v.revertSteps.BackupOldContent(targetPath); err != nil {
 				return err
 			}
 		} else {
-			v.revertSteps.RemoveFile(realFsEntryPath)
+			v.revertSteps.RemoveFile(targetPath)
 		}
 
 		err = os.Remove(realFsEntryPath)
@@ -177,6 +427,9 @@ func (v *VRCTFs) mergeToRealFs(mergeDirPath string) error {
 			return err
 		}
 
+		if !hasPrototype {
+			continue
+		}
 		if err := filePrototype.Save(); err != nil {
 			return err
 		}